@@ -0,0 +1,61 @@
+package api
+
+// Driver selects the SQL dialect of a namespace's database
+type Driver string
+
+const (
+	DriverPostgres Driver = "postgres"
+	DriverMySQL    Driver = "mysql"
+	// DriverSQLite keeps a namespace in a local SQLite file, for development without a database server
+	DriverSQLite Driver = "sqlite"
+)
+
+// Drivers lists the supported drivers
+var Drivers = []Driver{DriverPostgres, DriverMySQL, DriverSQLite}
+
+// DefaultPort returns the port a driver's servers listen on by default, or 0 for drivers without a server
+func (d Driver) DefaultPort() int {
+	switch d {
+	case DriverMySQL:
+		return 3306
+	case DriverSQLite:
+		return 0
+	default:
+		return 5432
+	}
+}
+
+// Networked reports whether the driver connects to a database server, as opposed to opening a local file
+func (d Driver) Networked() bool {
+	return d != DriverSQLite
+}
+
+// SSLMode values accepted for a namespace connection, matching libpq's sslmode
+var SSLModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// NamespaceConnection says where the database backing a namespace lives. Namespaces without one are databases of the
+// same name on the cluster given by the DB_* environment variables.
+type NamespaceConnection struct {
+	Namespace string `json:"namespace" db:"namespace"`
+	// Driver defaults to postgres
+	Driver Driver `json:"driver" db:"driver"`
+	Host   string `json:"host" db:"host"`
+	Port   int    `json:"port" db:"port"`
+	// Database defaults to the namespace's name. For sqlite it's the path of the database file, defaulting to
+	// <namespace>.db, with relative paths resolved against GOMAD_SQLITE_DIR.
+	Database string `json:"database" db:"database"`
+	// Schema is put first on the search_path of the namespace's connections, if set. Postgres only.
+	Schema string `json:"schema,omitempty" db:"schema"`
+	User   string `json:"user" db:"username"`
+	// CredentialsRef names where the password is read from when connecting: env:NAME for an environment variable
+	// named GOMAD_CREDENTIAL_*, or file:/path for a file inside GOMAD_CREDENTIALS_DIR, such as a mounted secret.
+	// Passwords themselves are never stored.
+	CredentialsRef string `json:"credentialsRef,omitempty" db:"credentials_ref"`
+	SSLMode        string `json:"sslMode,omitempty" db:"ssl_mode"`
+	// SSLRootCert is the path of the CA certificate used to verify the server with verify-ca and verify-full
+	SSLRootCert string `json:"sslRootCert,omitempty" db:"ssl_root_cert"`
+}
+
+type NamespaceConnectionList struct {
+	Connections []*NamespaceConnection `json:"connections"`
+}
@@ -0,0 +1,44 @@
+package api
+
+import "time"
+
+// EventType says what happened to a migration
+type EventType string
+
+const (
+	// EventMigrationIngested is published when a pushed migration is stored for a namespace
+	EventMigrationIngested EventType = "migration.ingested"
+	// EventMigrationStarted is published when a migration starts executing
+	EventMigrationStarted EventType = "migration.started"
+	// EventStatementFinished is published after each statement an execution sends, successful or not
+	EventStatementFinished EventType = "statement.finished"
+	// EventNotice is published for each notice or warning the database raises during an execution
+	EventNotice             EventType = "notice"
+	EventMigrationSucceeded EventType = "migration.succeeded"
+	EventMigrationFailed    EventType = "migration.failed"
+)
+
+// EventTypes lists the types of event published about migrations
+var EventTypes = []EventType{
+	EventMigrationIngested, EventMigrationStarted, EventStatementFinished, EventNotice, EventMigrationSucceeded,
+	EventMigrationFailed,
+}
+
+// Event is something that happened to a migration. Only the fields relevant to its type are set.
+type Event struct {
+	// ID increases with every event published, so clients can tell whether they missed any
+	ID          uint64          `json:"id"`
+	Type        EventType       `json:"type"`
+	Namespace   string          `json:"namespace"`
+	MigrationID uint64          `json:"migrationId"`
+	At          time.Time       `json:"at"`
+	Status      MigrationStatus `json:"status,omitempty"`
+	// Reason explains a status change, such as why an execution failed
+	Reason    string            `json:"reason,omitempty"`
+	Statement *StatementResult  `json:"statement,omitempty"`
+	Message   *ExecutionMessage `json:"message,omitempty"`
+	Error     *ExecutionError   `json:"error,omitempty"`
+	// TraceParent is the W3C trace context of the work that raised the event, linking whatever handles it to the same
+	// trace
+	TraceParent string `json:"traceparent,omitempty"`
+}
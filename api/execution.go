@@ -0,0 +1,96 @@
+package api
+
+import "time"
+
+// ExecutionAttempt records one try at running a migration against its namespace's database. Lock timeout retries
+// are attempts of their own.
+type ExecutionAttempt struct {
+	ID          uint64 `json:"id"`
+	MigrationID uint64 `json:"migrationId"`
+	// Number counts the migration's attempts from 1
+	Number     int       `json:"number"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	// Identity is the database user the migration ran as
+	Identity string `json:"identity,omitempty"`
+	// Host is the gomad instance that ran the migration
+	Host string `json:"host,omitempty"`
+	// SQL is the migration's DDL as sent to the database, with template variables substituted
+	SQL string `json:"sql"`
+	// Statements lists every statement sent, session settings included, up to and including a failed one
+	Statements []*StatementResult `json:"statements"`
+	// Messages are the notices and warnings the database raised while the migration ran
+	Messages []*ExecutionMessage `json:"messages,omitempty"`
+	Error    *ExecutionError     `json:"error,omitempty"`
+
+	observer AttemptObserver
+}
+
+// AttemptObserver follows an execution attempt while it runs
+type AttemptObserver interface {
+	StatementFinished(statement *StatementResult)
+	MessageRaised(message *ExecutionMessage)
+}
+
+// Observe has the observer told about each statement and message added to the attempt from now on
+func (a *ExecutionAttempt) Observe(observer AttemptObserver) {
+	a.observer = observer
+}
+
+// AddStatement appends how a statement went to the attempt
+func (a *ExecutionAttempt) AddStatement(sql string, took time.Duration, rowsAffected int64, failed bool) {
+	statement := &StatementResult{
+		SQL:          sql,
+		DurationMs:   float64(took.Microseconds()) / 1000,
+		RowsAffected: rowsAffected,
+		Failed:       failed,
+	}
+	a.Statements = append(a.Statements, statement)
+	if a.observer != nil {
+		a.observer.StatementFinished(statement)
+	}
+}
+
+// AddMessage appends a notice or warning raised by the database to the attempt
+func (a *ExecutionAttempt) AddMessage(message *ExecutionMessage) {
+	a.Messages = append(a.Messages, message)
+	if a.observer != nil {
+		a.observer.MessageRaised(message)
+	}
+}
+
+// StatementResult is how a single statement of an execution attempt went
+type StatementResult struct {
+	SQL          string  `json:"sql"`
+	DurationMs   float64 `json:"durationMs"`
+	RowsAffected int64   `json:"rowsAffected"`
+	Failed       bool    `json:"failed,omitempty"`
+}
+
+// ExecutionMessage is a notice or warning raised by the database
+type ExecutionMessage struct {
+	// Severity is as reported by the database, such as NOTICE or WARNING
+	Severity string `json:"severity"`
+	Code     string `json:"code,omitempty"`
+	Message  string `json:"message"`
+	Detail   string `json:"detail,omitempty"`
+	Hint     string `json:"hint,omitempty"`
+}
+
+// ExecutionError is the error that ended a failed execution attempt, with whatever detail the database gave
+type ExecutionError struct {
+	Message string `json:"message"`
+	// SQLState is the SQLSTATE error code, where the database reports one
+	SQLState string `json:"sqlState,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Hint     string `json:"hint,omitempty"`
+	// Position is the 1-based character offset of the error in Statement, or 0 if unknown
+	Position  int    `json:"position,omitempty"`
+	Statement string `json:"statement,omitempty"`
+}
+
+// ExecutionAttemptList lists a migration's execution attempts, oldest first
+type ExecutionAttemptList struct {
+	MigrationID uint64              `json:"migrationId"`
+	Attempts    []*ExecutionAttempt `json:"attempts"`
+}
@@ -0,0 +1,45 @@
+package api
+
+// LintSeverity controls how a lint finding affects execution
+type LintSeverity string
+
+const (
+	LintSeverityOff     LintSeverity = "off"
+	LintSeverityWarning LintSeverity = "warning"
+	LintSeverityError   LintSeverity = "error"
+)
+
+// LintFinding describes a risky statement found in a migration
+type LintFinding struct {
+	Rule      string       `json:"rule"`
+	Severity  LintSeverity `json:"severity"`
+	Message   string       `json:"message"`
+	Statement string       `json:"statement"`
+}
+
+type LintRequest struct {
+	// SQL is the content of a migration file, including migration headers
+	SQL string `json:"sql"`
+}
+
+type MigrationLintResult struct {
+	Namespace string        `json:"namespace"`
+	Comment   string        `json:"comment"`
+	Signature uint64        `json:"signature"`
+	Findings  []LintFinding `json:"findings"`
+}
+
+type LintResponse struct {
+	Results   []MigrationLintResult `json:"results"`
+	HasErrors bool                  `json:"hasErrors"`
+}
+
+// HasLintErrors reports whether any of the findings would block execution
+func HasLintErrors(findings []LintFinding) bool {
+	for _, finding := range findings {
+		if finding.Severity == LintSeverityError {
+			return true
+		}
+	}
+	return false
+}
@@ -1,27 +1,157 @@
 package api
 
-import "time"
+import (
+	"slices"
+	"time"
+)
+
+// SessionSettings holds the session safety timeouts applied with SET LOCAL before a migration's DDL runs.
+// Values use Go duration syntax (e.g. "5s", "500ms"); an empty value leaves the server default in place.
+type SessionSettings struct {
+	LockTimeout                     string `json:"lockTimeout,omitempty" db:"lockTimeout"`
+	StatementTimeout                string `json:"statementTimeout,omitempty" db:"statementTimeout"`
+	IdleInTransactionSessionTimeout string `json:"idleInTransactionSessionTimeout,omitempty" db:"idleInTransactionSessionTimeout"`
+}
+
+// Merge returns the settings in s with any empty values filled in from defaults
+func (s SessionSettings) Merge(defaults SessionSettings) SessionSettings {
+	if s.LockTimeout == "" {
+		s.LockTimeout = defaults.LockTimeout
+	}
+	if s.StatementTimeout == "" {
+		s.StatementTimeout = defaults.StatementTimeout
+	}
+	if s.IdleInTransactionSessionTimeout == "" {
+		s.IdleInTransactionSessionTimeout = defaults.IdleInTransactionSessionTimeout
+	}
+	return s
+}
 
 type MigrationCommonFields struct {
-	Namespace string    `json:"namespace" db:"namespace"`
-	User      string    `json:"user" db:"user"`
-	Comment   string    `json:"comment" db:"comment"`
-	DDL       string    `json:"ddl" db:"ddl"`
-	CreatedAt time.Time `json:"createdAt" db:"createdAt"`
+	Namespace string          `json:"namespace" db:"namespace"`
+	User      string          `json:"user" db:"user"`
+	Comment   string          `json:"comment" db:"comment"`
+	DDL       string          `json:"ddl" db:"ddl"`
+	CreatedAt time.Time       `json:"createdAt" db:"createdAt"`
+	Settings  SessionSettings `json:"settings"`
+	// Includes lists the repository files pulled into the DDL by include directives
+	Includes []string `json:"includes,omitempty" db:"includes"`
+	// Repo is the repository the migration was pushed to
+	Repo string `json:"repo,omitempty" db:"repo"`
 }
 
+// AllNamespaces is used as a migration header's namespace to target every namespace in the migration's environments
+const AllNamespaces = "*"
+
 // MigrationProto represents a migration object before it's been inserted into the database
 type MigrationProto struct {
 	MigrationCommonFields
 	ShouldSkip bool   `db:"shouldSkip"`
 	Signature  uint64 `db:"id"`
+	// Environments limits the migration to namespaces tagged with one of these environments. Empty means any.
+	Environments []string `json:"environments,omitempty"`
+}
+
+// InitialStatus is the status a migration is created with
+func (p *MigrationProto) InitialStatus() MigrationStatus {
+	if p.ShouldSkip {
+		return MigrationStatusSkipped
+	}
+	return MigrationStatusPending
 }
 
 // Migration represents a database migration record
 type Migration struct {
 	MigrationCommonFields
-	ID          uint64    `json:"id" db:"id"`
-	CompletedAt time.Time `json:"completedAt" db:"completedAt"`
+	ID uint64 `json:"id" db:"id"`
+	// RenderedDDL is the DDL with template variables substituted, as it was executed
+	RenderedDDL string          `json:"renderedDdl,omitempty" db:"renderedDdl"`
+	CompletedAt time.Time       `json:"completedAt" db:"completedAt"`
+	Status      MigrationStatus `json:"status" db:"status"`
+	// Skipped is set for migrations whose header marks them as not to be run
+	Skipped bool `json:"skipped,omitempty" db:"shouldSkip"`
+}
+
+// MigrationStatus is where a migration is in its lifecycle
+type MigrationStatus string
+
+const (
+	MigrationStatusPending MigrationStatus = "pending"
+	// MigrationStatusAwaitingApproval is a migration held back until someone approves or rejects it
+	MigrationStatusAwaitingApproval MigrationStatus = "awaiting-approval"
+	MigrationStatusRunning          MigrationStatus = "running"
+	MigrationStatusSucceeded        MigrationStatus = "succeeded"
+	// MigrationStatusFailed is a migration whose last execution returned an error or was interrupted. It may have
+	// been partially applied on databases without transactional DDL.
+	MigrationStatusFailed  MigrationStatus = "failed"
+	MigrationStatusSkipped MigrationStatus = "skipped"
+	// MigrationStatusRolledBack is a succeeded migration whose changes have since been reverted
+	MigrationStatusRolledBack MigrationStatus = "rolled-back"
+	// MigrationStatusDrifted is a succeeded migration whose changes no longer match the database's schema
+	MigrationStatusDrifted MigrationStatus = "drifted"
+)
+
+// MigrationStatuses lists every migration status
+var MigrationStatuses = []MigrationStatus{
+	MigrationStatusPending, MigrationStatusAwaitingApproval, MigrationStatusRunning, MigrationStatusSucceeded,
+	MigrationStatusFailed, MigrationStatusSkipped, MigrationStatusRolledBack, MigrationStatusDrifted,
+}
+
+// migrationTransitions lists the statuses each status may move to
+var migrationTransitions = map[MigrationStatus][]MigrationStatus{
+	MigrationStatusPending:          {MigrationStatusAwaitingApproval, MigrationStatusRunning, MigrationStatusSkipped},
+	MigrationStatusAwaitingApproval: {MigrationStatusPending, MigrationStatusSkipped},
+	MigrationStatusRunning:          {MigrationStatusSucceeded, MigrationStatusFailed},
+	MigrationStatusSucceeded:        {MigrationStatusRolledBack, MigrationStatusDrifted},
+	MigrationStatusFailed:           {MigrationStatusRunning, MigrationStatusSkipped},
+	MigrationStatusSkipped:          {MigrationStatusPending},
+	MigrationStatusRolledBack:       {MigrationStatusRunning},
+	MigrationStatusDrifted:          {MigrationStatusSucceeded, MigrationStatusRolledBack},
+}
+
+// CanTransitionTo reports whether a migration in status s may move to status to
+func (s MigrationStatus) CanTransitionTo(to MigrationStatus) bool {
+	return slices.Contains(migrationTransitions[s], to)
+}
+
+// MigrationStatusChange is an entry in a migration's status history
+type MigrationStatusChange struct {
+	// From is empty for the status a migration was created with
+	From      MigrationStatus `json:"from,omitempty" db:"fromStatus"`
+	To        MigrationStatus `json:"to" db:"toStatus"`
+	Reason    string          `json:"reason,omitempty" db:"reason"`
+	ChangedAt time.Time       `json:"changedAt" db:"changedAt"`
+}
+
+// MigrationHistory lists a migration's status changes, oldest first
+type MigrationHistory struct {
+	MigrationID uint64                   `json:"migrationId"`
+	Changes     []*MigrationStatusChange `json:"changes"`
+}
+
+const (
+	// DefaultLockRetries is used for namespaces without saved settings
+	DefaultLockRetries = 3
+	// MaxLockRetries bounds how many times a migration may be retried after a lock timeout
+	MaxLockRetries = 10
+)
+
+// NamespaceSettings holds the per-namespace defaults used when executing migrations
+type NamespaceSettings struct {
+	Namespace string          `json:"namespace" db:"namespace"`
+	Session   SessionSettings `json:"session"`
+	// LockRetries is the number of times a migration is retried after hitting lock_timeout
+	LockRetries int `json:"lockRetries" db:"lock_retries"`
+	// Environment tags the namespace (e.g. dev, staging, prod) for migrations that target environments
+	Environment string `json:"environment,omitempty" db:"environment"`
+	// LintRules overrides the default severity of lint rules by rule name
+	LintRules map[string]LintSeverity `json:"lintRules,omitempty" db:"lint_rules"`
+}
+
+// NamespaceVariables holds the values substituted for {{ name }} placeholders in a namespace's migrations
+type NamespaceVariables struct {
+	Namespace string            `json:"namespace"`
+	Variables map[string]string `json:"variables"`
 }
 
 type NamespaceList struct {
@@ -30,4 +160,24 @@ type NamespaceList struct {
 
 type MigrationList struct {
 	Migrations []*Migration `json:"migrations"`
+	// NextCursor is passed back as MigrationListOptions.Cursor to fetch the next page. It's empty on the last page.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// MigrationListOptions filters, orders and pages a namespace's migrations. Zero values leave a filter unset.
+type MigrationListOptions struct {
+	// Statuses matches migrations in any of the statuses
+	Statuses []MigrationStatus
+	User     string
+	Repo     string
+	// Search matches migrations whose comment contains it, ignoring case
+	Search string
+	// CreatedFrom and CreatedTo bound when migrations were created. CreatedFrom is inclusive, CreatedTo exclusive.
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// Descending lists the newest migrations first instead of the oldest
+	Descending bool
+	// Cursor continues from the page that returned it, and must be used with the same filters and order
+	Cursor string
+	Limit  int
 }
@@ -0,0 +1,61 @@
+package api
+
+import "slices"
+
+// SinkKind selects how a notification sink delivers events
+type SinkKind string
+
+const (
+	// SinkWebhook POSTs each event as JSON, signed with the sink's secret
+	SinkWebhook SinkKind = "webhook"
+	// SinkSlack posts a message to a Slack-compatible incoming webhook
+	SinkSlack SinkKind = "slack"
+	// SinkEmail sends a message over SMTP
+	SinkEmail SinkKind = "email"
+)
+
+// SinkKinds lists the supported sink kinds
+var SinkKinds = []SinkKind{SinkWebhook, SinkSlack, SinkEmail}
+
+// EventTest is the type of the event sent by a sink's test-send
+const EventTest EventType = "test"
+
+// NotificationSink is somewhere migration events are sent, for the namespaces and event types it subscribes to
+type NotificationSink struct {
+	Name string   `json:"name" db:"name"`
+	Kind SinkKind `json:"kind" db:"kind"`
+	// Namespaces limits the sink to events of these namespaces. Empty means every namespace.
+	Namespaces []string `json:"namespaces,omitempty" db:"namespaces"`
+	// Events lists the event types sent to the sink
+	Events []EventType `json:"events" db:"events"`
+	// URL is where webhook and slack sinks post to
+	URL string `json:"url,omitempty" db:"url"`
+	// Secret signs the body of webhook deliveries with HMAC-SHA256. It is never returned by the API; HasSecret
+	// reports whether one is set.
+	Secret    string `json:"secret,omitempty" db:"secret"`
+	HasSecret bool   `json:"hasSecret"`
+	// SMTPHost and SMTPPort are the mail server of email sinks. The port defaults to 587.
+	SMTPHost string   `json:"smtpHost,omitempty" db:"smtp_host"`
+	SMTPPort int      `json:"smtpPort,omitempty" db:"smtp_port"`
+	From     string   `json:"from,omitempty" db:"smtp_from"`
+	To       []string `json:"to,omitempty" db:"smtp_to"`
+	// Username authenticates with the mail server, if set, using the password named by CredentialsRef as with
+	// namespace connections
+	Username       string `json:"username,omitempty" db:"smtp_username"`
+	CredentialsRef string `json:"credentialsRef,omitempty" db:"credentials_ref"`
+}
+
+// Subscribed reports whether the event should be sent to the sink. Test events go to every sink.
+func (s *NotificationSink) Subscribed(event *Event) bool {
+	if event.Type == EventTest {
+		return true
+	}
+	if len(s.Namespaces) > 0 && !slices.Contains(s.Namespaces, event.Namespace) {
+		return false
+	}
+	return slices.Contains(s.Events, event.Type)
+}
+
+type NotificationSinkList struct {
+	Sinks []*NotificationSink `json:"sinks"`
+}
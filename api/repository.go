@@ -0,0 +1,33 @@
+package api
+
+// FileSource selects how gomad reads migration files from a repository
+type FileSource string
+
+const (
+	// FileSourceGitHub reads files through the GitHub REST API
+	FileSourceGitHub FileSource = "github"
+	// FileSourceMirror reads files from a bare mirror of the repository kept on local disk
+	FileSourceMirror FileSource = "mirror"
+)
+
+// RepositoryConfig holds per-repository settings for fetching migration files
+type RepositoryConfig struct {
+	RepoName string     `json:"repoName" db:"repo_name"`
+	Source   FileSource `json:"source" db:"source"`
+	// RemoteURL is the git remote mirrored by the mirror source, as an https, ssh or file URL. Defaults to the
+	// repository on github.com.
+	RemoteURL string `json:"remoteUrl,omitempty" db:"remote_url"`
+	// APIBaseURL is the GitHub API used by the github source, e.g. https://github.example.com/api/v3 for
+	// GitHub Enterprise Server. Defaults to GITHUB_API_URL or https://api.github.com.
+	APIBaseURL string `json:"apiBaseUrl,omitempty" db:"api_base_url"`
+	// Token authenticates API requests for this repository instead of the server-wide credentials, which are only
+	// sent to the default API. It is never returned by the API; HasToken reports whether one is set.
+	Token    string `json:"token,omitempty" db:"token"`
+	HasToken bool   `json:"hasToken"`
+	// CABundle holds PEM encoded certificates trusted in addition to the system roots when calling the API
+	CABundle string `json:"caBundle,omitempty" db:"ca_bundle"`
+}
+
+type RepositoryConfigList struct {
+	Repositories []*RepositoryConfig `json:"repositories"`
+}
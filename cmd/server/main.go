@@ -3,25 +3,96 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
+	"github.com/dfryer1193/gomad/internal/data/repository/postgres"
+	"github.com/dfryer1193/gomad/internal/data/repository/storage"
+	"github.com/dfryer1193/gomad/internal/data/schema"
+	"github.com/dfryer1193/gomad/internal/events"
+	"github.com/dfryer1193/gomad/internal/metrics"
+	"github.com/dfryer1193/gomad/internal/notifications"
 	"github.com/dfryer1193/gomad/internal/rest"
+	"github.com/dfryer1193/gomad/internal/rest/handlers"
+	"github.com/dfryer1193/gomad/internal/rest/managers"
+	"github.com/dfryer1193/gomad/internal/tracing"
 	"github.com/dfryer1193/mjolnir/router"
 	"github.com/rs/zerolog/log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 )
 
+const (
+	// shutdownGracePeriod is how long in-flight requests, migrations included, get to finish after a shutdown signal
+	shutdownGracePeriod = 20 * time.Second
+	// interruptGracePeriod is how long migrations still running after the grace period get to record that they were
+	// interrupted
+	interruptGracePeriod = 5 * time.Second
+	// notificationGracePeriod is how long notifications still being delivered, retries included, get to finish
+	notificationGracePeriod = 10 * time.Second
+	// tracingGracePeriod is how long the spans still buffered get to be exported
+	tracingGracePeriod = 5 * time.Second
+)
+
 func main() {
+	importLegacy := flag.Bool("import-legacy", false,
+		"copy data from the legacy migrations and secrets databases into the metadata database, then exit")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if storage.GetBackend() == storage.BackendMemory {
+		log.Warn().Msg("Using in-memory storage, nothing will be kept after the server stops")
+	} else if err := schema.Bootstrap(ctx); err != nil {
+		log.Fatal().Err(err).Msg("Failed to migrate gomad's schema")
+	}
+
+	if *importLegacy {
+		if storage.GetBackend() != storage.BackendPostgres {
+			log.Fatal().Msg("Legacy data can only be imported with the postgres storage backend")
+		}
+
+		stats, err := postgres.ImportLegacy(ctx)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to import legacy data")
+		}
+
+		log.Info().Interface("imported", stats).Msg("Imported legacy data")
+		return
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to set up tracing")
+	}
+
+	metrics.Register(
+		metrics.NewMigrationStatusCollector(storage.GetMigrationRepository()),
+		metrics.NewPoolCollector(poolStats(storage.GetBackend())),
+	)
+
 	r := router.New()
 
 	rest.SetupRoutes(r)
 
+	// Requests get a context of their own, cancelled only once the grace period has passed, so a shutdown lets running
+	// migrations finish when it can
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
 	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", 80),
-		Handler: r,
+		Addr:        fmt.Sprintf(":%d", 80),
+		Handler:     rest.WithEventStreams(r),
+		BaseContext: func(net.Listener) context.Context { return requestCtx },
 	}
+	// Event streams never finish on their own, so they're ended as soon as shutdown starts
+	srv.RegisterOnShutdown(handlers.GetEventHandler().Close)
+
+	notifications.GetNotifier().Start()
 
 	go func() {
 		log.Info().Msg("Starting server on port :" + fmt.Sprint(80))
@@ -30,17 +101,62 @@ func main() {
 		}
 	}()
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
-	<-quit
+	<-ctx.Done()
+	// A second signal stops the server immediately
+	stop()
 
 	log.Info().Msg("Shutting down server...")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownGracePeriod)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal().Err(err).Msg("Failed to shutdown server")
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Warn().Err(err).Msg("Requests still running after the grace period, cancelling them")
+	}
+	cancelRequests()
+
+	// Cancelled migrations mark themselves failed, noting the interruption, before returning
+	waitCtx, cancelWait := context.WithTimeout(context.Background(), interruptGracePeriod)
+	defer cancelWait()
+
+	if err := managers.GetMigrationsManager().Wait(waitCtx); err != nil {
+		log.Error().Err(err).Msg("Migrations were still running when the server stopped")
+	}
+
+	// Nothing publishes events once migrations have stopped, so notifications of how they ended can be sent
+	events.GetBus().Close()
+	notifyCtx, cancelNotify := context.WithTimeout(context.Background(), notificationGracePeriod)
+	defer cancelNotify()
+
+	if err := notifications.GetNotifier().Shutdown(notifyCtx); err != nil {
+		log.Warn().Err(err).Msg("Notifications were still being delivered when the server stopped")
+	}
+
+	// Nothing reads or writes gomad's data any more
+	if storage.GetBackend() == storage.BackendPostgres {
+		postgres.GetMetadataPool().Close()
+	}
+
+	tracingCtx, cancelTracing := context.WithTimeout(context.Background(), tracingGracePeriod)
+	defer cancelTracing()
+
+	if err := shutdownTracing(tracingCtx); err != nil {
+		log.Warn().Err(err).Msg("Failed to export the remaining spans")
 	}
 
 	log.Info().Msg("Server stopped")
 }
+
+// poolStats returns a func snapshotting gomad's pgx pools for /metrics. The metadata pool is only there when gomad's
+// data is kept in postgres.
+func poolStats(backend storage.Backend) func() []metrics.PoolStats {
+	return func() []metrics.PoolStats {
+		pools := make([]metrics.PoolStats, 0)
+		if backend == storage.BackendPostgres {
+			pools = append(pools, metrics.PoolStats{Pool: "metadata", Stat: postgres.GetMetadataPool().Stat()})
+		}
+		for namespace, stat := range postgres.TargetPoolStats() {
+			pools = append(pools, metrics.PoolStats{Pool: "target", Namespace: namespace, Stat: stat})
+		}
+		return pools
+	}
+}
@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/dfryer1193/gomad/internal/data/repository"
+)
+
+// databaseRepository tracks database names only; nothing is ever created on a server
+type databaseRepository struct {
+	mu        sync.RWMutex
+	databases map[string]string
+}
+
+var (
+	dbRepo       repository.DatabaseRepository
+	databaseOnce sync.Once
+)
+
+func GetDatabaseRepository() repository.DatabaseRepository {
+	databaseOnce.Do(func() {
+		dbRepo = NewDatabaseRepository()
+	})
+
+	return dbRepo
+}
+
+func NewDatabaseRepository() repository.DatabaseRepository {
+	return &databaseRepository{databases: make(map[string]string)}
+}
+
+func (r *databaseRepository) CreateDatabase(ctx context.Context, dbName string, owner string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.databases[dbName]; ok {
+		return fmt.Errorf("database %s already exists", dbName)
+	}
+
+	r.databases[dbName] = owner
+	return nil
+}
+
+func (r *databaseRepository) DatabaseExists(ctx context.Context, dbName string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.databases[dbName]
+	return ok, nil
+}
+
+func (r *databaseRepository) ListDatabases(ctx context.Context) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	databases := make([]string, 0, len(r.databases))
+	for dbName := range r.databases {
+		databases = append(databases, dbName)
+	}
+	slices.Sort(databases)
+
+	return databases, nil
+}
+
+func (r *databaseRepository) Close() {}
@@ -0,0 +1,81 @@
+package memory
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+)
+
+type executionAttemptRepository struct {
+	mu       sync.RWMutex
+	nextID   uint64
+	attempts map[uint64][]*api.ExecutionAttempt
+}
+
+var (
+	attemptsRepo repository.ExecutionAttemptRepository
+	attemptsOnce sync.Once
+)
+
+func GetExecutionAttemptRepository() repository.ExecutionAttemptRepository {
+	attemptsOnce.Do(func() {
+		attemptsRepo = NewExecutionAttemptRepository()
+	})
+
+	return attemptsRepo
+}
+
+func NewExecutionAttemptRepository() repository.ExecutionAttemptRepository {
+	return &executionAttemptRepository{attempts: make(map[uint64][]*api.ExecutionAttempt)}
+}
+
+func (r *executionAttemptRepository) RecordAttempt(ctx context.Context, attempt *api.ExecutionAttempt) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	attempt.ID = r.nextID
+	attempt.Number = len(r.attempts[attempt.MigrationID]) + 1
+
+	stored := *attempt
+	r.attempts[attempt.MigrationID] = append(r.attempts[attempt.MigrationID], &stored)
+
+	return nil
+}
+
+func (r *executionAttemptRepository) ListAttempts(ctx context.Context, migrationID uint64) ([]*api.ExecutionAttempt, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	attempts := make([]*api.ExecutionAttempt, 0, len(r.attempts[migrationID]))
+	for _, attempt := range r.attempts[migrationID] {
+		copied := *attempt
+		attempts = append(attempts, &copied)
+	}
+
+	return attempts, nil
+}
+
+func (r *executionAttemptRepository) Close() {}
+
+func (r *executionAttemptRepository) snapshot() func() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	nextID := r.nextID
+	attempts := maps.Clone(r.attempts)
+	for id, list := range attempts {
+		attempts[id] = slices.Clone(list)
+	}
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.nextID = nextID
+		r.attempts = attempts
+	}
+}
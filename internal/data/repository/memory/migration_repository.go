@@ -0,0 +1,245 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+)
+
+type migrationRepository struct {
+	mu sync.RWMutex
+	// migrations is kept in insertion order, which breaks ties between migrations created at the same time
+	migrations []*api.Migration
+	byID       map[uint64]*api.Migration
+	history    map[uint64][]*api.MigrationStatusChange
+}
+
+var (
+	migrationRepo repository.MigrationRepository
+	migrationOnce sync.Once
+)
+
+func GetMigrationRepository() repository.MigrationRepository {
+	migrationOnce.Do(func() {
+		migrationRepo = NewMigrationRepository()
+	})
+
+	return migrationRepo
+}
+
+func NewMigrationRepository() repository.MigrationRepository {
+	return &migrationRepository{
+		byID:    make(map[uint64]*api.Migration),
+		history: make(map[uint64][]*api.MigrationStatusChange),
+	}
+}
+
+func (r *migrationRepository) GetFilteredBySignature(ctx context.Context, signatures []uint64) ([]*api.Migration, error) {
+	return r.filter(func(m *api.Migration) bool { return slices.Contains(signatures, m.ID) }), nil
+}
+
+func (r *migrationRepository) ListForNamespace(ctx context.Context, query repository.MigrationQuery) ([]*api.Migration, error) {
+	search := strings.ToLower(query.Search)
+	migrations := r.filter(func(m *api.Migration) bool {
+		return m.Namespace == query.Namespace &&
+			(len(query.Statuses) == 0 || slices.Contains(query.Statuses, m.Status)) &&
+			(query.User == "" || m.User == query.User) &&
+			(query.Repo == "" || m.Repo == query.Repo) &&
+			(search == "" || strings.Contains(strings.ToLower(m.Comment), search)) &&
+			(query.CreatedFrom.IsZero() || !m.CreatedAt.Before(query.CreatedFrom)) &&
+			(query.CreatedTo.IsZero() || m.CreatedAt.Before(query.CreatedTo))
+	})
+
+	// filter orders by creation time alone, so ties are broken by id to match the postgres keyset
+	slices.SortStableFunc(migrations, func(a, b *api.Migration) int { return compareCursor(a, cursorOf(b)) })
+	if query.Descending {
+		slices.Reverse(migrations)
+	}
+
+	if query.After != nil {
+		start := slices.IndexFunc(migrations, func(m *api.Migration) bool {
+			order := compareCursor(m, *query.After)
+			return (!query.Descending && order > 0) || (query.Descending && order < 0)
+		})
+		if start < 0 {
+			start = len(migrations)
+		}
+		migrations = migrations[start:]
+	}
+
+	if query.Limit > 0 && len(migrations) > query.Limit {
+		migrations = migrations[:query.Limit]
+	}
+
+	return migrations, nil
+}
+
+func cursorOf(m *api.Migration) repository.MigrationCursor {
+	return repository.MigrationCursor{CreatedAt: m.CreatedAt, ID: m.ID}
+}
+
+// compareCursor orders a migration against a listing position by creation time, then id
+func compareCursor(m *api.Migration, cursor repository.MigrationCursor) int {
+	if order := m.CreatedAt.Compare(cursor.CreatedAt); order != 0 {
+		return order
+	}
+	return cmp.Compare(m.ID, cursor.ID)
+}
+
+func (r *migrationRepository) GetById(ctx context.Context, id uint64) (*api.Migration, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	m, ok := r.byID[id]
+	if !ok {
+		return nil, nil
+	}
+
+	return cloneMigration(m), nil
+}
+
+// BulkInsert adds the migrations all at once. As with the postgres COPY, a migration whose id is already taken fails
+// the whole batch.
+func (r *migrationRepository) BulkInsert(ctx context.Context, migrations []*api.MigrationProto) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	seen := make(map[uint64]bool, len(migrations))
+	for _, m := range migrations {
+		if _, ok := r.byID[m.Signature]; ok || seen[m.Signature] {
+			return fmt.Errorf("failed to bulk insert migrations: duplicate migration id %d", m.Signature)
+		}
+		seen[m.Signature] = true
+	}
+
+	for _, proto := range migrations {
+		m := &api.Migration{
+			MigrationCommonFields: proto.MigrationCommonFields,
+			ID:                    proto.Signature,
+			Status:                proto.InitialStatus(),
+			Skipped:               proto.ShouldSkip,
+		}
+		m = cloneMigration(m)
+		r.migrations = append(r.migrations, m)
+		r.byID[m.ID] = m
+		r.history[m.ID] = []*api.MigrationStatusChange{{To: m.Status, Reason: "created", ChangedAt: m.CreatedAt}}
+	}
+
+	return nil
+}
+
+func (r *migrationRepository) TransitionStatus(ctx context.Context, transition repository.StatusTransition) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.byID[transition.ID]
+	if !ok {
+		return fmt.Errorf("no migration with id %d", transition.ID)
+	}
+	if m.Status != transition.From {
+		return fmt.Errorf("%w: migration %d is %s, not %s", repository.ErrStatusConflict, transition.ID, m.Status, transition.From)
+	}
+
+	m.Status = transition.To
+	if transition.To == api.MigrationStatusSucceeded {
+		m.CompletedAt = transition.At
+	}
+	if transition.RenderedDDL != "" {
+		m.RenderedDDL = transition.RenderedDDL
+	}
+	r.history[m.ID] = append(r.history[m.ID], &api.MigrationStatusChange{
+		From:      transition.From,
+		To:        transition.To,
+		Reason:    transition.Reason,
+		ChangedAt: transition.At,
+	})
+
+	return nil
+}
+
+func (r *migrationRepository) GetStatusHistory(ctx context.Context, id uint64) ([]*api.MigrationStatusChange, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	changes := make([]*api.MigrationStatusChange, 0, len(r.history[id]))
+	for _, change := range r.history[id] {
+		clone := *change
+		changes = append(changes, &clone)
+	}
+
+	return changes, nil
+}
+
+func (r *migrationRepository) CountByStatus(ctx context.Context) ([]*repository.MigrationStatusCount, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	counts := make([]*repository.MigrationStatusCount, 0)
+	byKey := make(map[[2]string]*repository.MigrationStatusCount)
+	for _, m := range r.migrations {
+		key := [2]string{m.Namespace, string(m.Status)}
+		count, ok := byKey[key]
+		if !ok {
+			count = &repository.MigrationStatusCount{Namespace: m.Namespace, Status: m.Status}
+			byKey[key] = count
+			counts = append(counts, count)
+		}
+		count.Count++
+	}
+
+	return counts, nil
+}
+
+func (r *migrationRepository) Close() {}
+
+// filter returns copies of the matching migrations, ordered by when they were created
+func (r *migrationRepository) filter(match func(m *api.Migration) bool) []*api.Migration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	migrations := make([]*api.Migration, 0)
+	for _, m := range r.migrations {
+		if match(m) {
+			migrations = append(migrations, cloneMigration(m))
+		}
+	}
+	slices.SortStableFunc(migrations, func(a, b *api.Migration) int { return a.CreatedAt.Compare(b.CreatedAt) })
+
+	return migrations
+}
+
+// cloneMigration copies a migration so callers can't modify the stored one
+func cloneMigration(m *api.Migration) *api.Migration {
+	clone := *m
+	clone.Includes = slices.Clone(m.Includes)
+	return &clone
+}
+
+func (r *migrationRepository) snapshot() func() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	migrations := make([]*api.Migration, len(r.migrations))
+	byID := make(map[uint64]*api.Migration, len(r.byID))
+	for idx, m := range r.migrations {
+		migrations[idx] = cloneMigration(m)
+		byID[m.ID] = migrations[idx]
+	}
+	// Entries are never modified, so only the slices need copying
+	history := make(map[uint64][]*api.MigrationStatusChange, len(r.history))
+	for id, changes := range r.history {
+		history[id] = slices.Clone(changes)
+	}
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.migrations, r.byID, r.history = migrations, byID, history
+	}
+}
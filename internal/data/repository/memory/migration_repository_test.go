@@ -0,0 +1,145 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+)
+
+func proto(signature uint64, namespace string, createdAt time.Time) *api.MigrationProto {
+	return &api.MigrationProto{
+		MigrationCommonFields: api.MigrationCommonFields{
+			Namespace: namespace,
+			DDL:       "CREATE TABLE t (id INT);",
+			CreatedAt: createdAt,
+			Includes:  []string{"db/shared.sql"},
+		},
+		Signature: signature,
+	}
+}
+
+func TestMigrationRepository(t *testing.T) {
+	repo := NewMigrationRepository()
+	now := time.Now()
+
+	err := repo.BulkInsert(context.Background(), []*api.MigrationProto{
+		proto(3, "ns1", now.Add(time.Minute)),
+		proto(1, "ns1", now),
+		proto(2, "ns2", now),
+		// Ids are 64-bit signatures, so the largest ones have to round trip too
+		proto(^uint64(0), "ns1", now),
+	})
+	if err != nil {
+		t.Fatalf("BulkInsert() error = %v", err)
+	}
+
+	migrations, err := repo.ListForNamespace(context.Background(), repository.MigrationQuery{Namespace: "ns1"})
+	if err != nil {
+		t.Fatalf("ListForNamespace() error = %v", err)
+	}
+	if len(migrations) != 3 || migrations[0].ID != 1 || migrations[1].ID != ^uint64(0) || migrations[2].ID != 3 {
+		t.Errorf("ListForNamespace() = %+v, expected ids 1, max, 3 ordered by creation", migrations)
+	}
+
+	filtered, err := repo.GetFilteredBySignature(context.Background(), []uint64{2, 3, 99})
+	if err != nil || len(filtered) != 2 || filtered[0].ID != 2 || filtered[1].ID != 3 {
+		t.Errorf("GetFilteredBySignature() = %+v, %v", filtered, err)
+	}
+
+	// A duplicate id fails the whole batch, like the postgres COPY
+	if err := repo.BulkInsert(context.Background(), []*api.MigrationProto{proto(4, "ns1", now), proto(1, "ns1", now)}); err == nil {
+		t.Errorf("expected duplicate id to fail the batch")
+	}
+	if m, _ := repo.GetById(context.Background(), 4); m != nil {
+		t.Errorf("expected no migration from the failed batch to be stored")
+	}
+
+	// Returned migrations are copies
+	migrations[0].Includes[0] = "changed"
+	migrations[0].DDL = "changed"
+	stored, _ := repo.GetById(context.Background(), 1)
+	if stored.DDL == "changed" || stored.Includes[0] == "changed" {
+		t.Errorf("expected changes to a returned migration not to reach the repository")
+	}
+
+	if stored.Status != api.MigrationStatusPending {
+		t.Errorf("expected inserted migration to be pending, got %s", stored.Status)
+	}
+
+	completedAt := now.Add(time.Hour)
+	transitions := []repository.StatusTransition{
+		{ID: 1, From: api.MigrationStatusPending, To: api.MigrationStatusRunning, At: now, RenderedDDL: "CREATE TABLE rendered (id INT);"},
+		{ID: 1, From: api.MigrationStatusRunning, To: api.MigrationStatusSucceeded, Reason: "done", At: completedAt},
+	}
+	for _, transition := range transitions {
+		if err := repo.TransitionStatus(context.Background(), transition); err != nil {
+			t.Fatalf("TransitionStatus(%s) error = %v", transition.To, err)
+		}
+	}
+	stored, _ = repo.GetById(context.Background(), 1)
+	if stored.Status != api.MigrationStatusSucceeded || !stored.CompletedAt.Equal(completedAt) || stored.RenderedDDL != "CREATE TABLE rendered (id INT);" {
+		t.Errorf("GetById() after TransitionStatus() = %+v", stored)
+	}
+
+	history, err := repo.GetStatusHistory(context.Background(), 1)
+	if err != nil || len(history) != 3 {
+		t.Fatalf("GetStatusHistory() = %+v, %v", history, err)
+	}
+	if history[0].From != "" || history[0].To != api.MigrationStatusPending || history[2].Reason != "done" || !history[2].ChangedAt.Equal(completedAt) {
+		t.Errorf("GetStatusHistory() = %+v, %+v, %+v", history[0], history[1], history[2])
+	}
+
+	// A transition from a status the migration has left is a conflict, and leaves no trace
+	err = repo.TransitionStatus(context.Background(), repository.StatusTransition{ID: 1, From: api.MigrationStatusRunning, To: api.MigrationStatusFailed})
+	if !errors.Is(err, repository.ErrStatusConflict) {
+		t.Errorf("expected a status conflict, got %v", err)
+	}
+	if history, _ = repo.GetStatusHistory(context.Background(), 1); len(history) != 3 {
+		t.Errorf("expected the conflicting transition not to be recorded, got %d changes", len(history))
+	}
+
+	if err := repo.TransitionStatus(context.Background(), repository.StatusTransition{ID: 99, To: api.MigrationStatusRunning}); err == nil {
+		t.Errorf("expected error moving unknown migration")
+	}
+	if m, err := repo.GetById(context.Background(), 99); m != nil || err != nil {
+		t.Errorf("GetById(99) = %+v, %v, expected nil", m, err)
+	}
+}
+
+func TestMigrationRepositoryCountByStatus(t *testing.T) {
+	repo := NewMigrationRepository()
+	now := time.Now()
+
+	err := repo.BulkInsert(context.Background(), []*api.MigrationProto{
+		proto(1, "ns1", now), proto(2, "ns1", now), proto(3, "ns1", now), proto(4, "ns2", now),
+	})
+	if err != nil {
+		t.Fatalf("BulkInsert() error = %v", err)
+	}
+	err = repo.TransitionStatus(context.Background(), repository.StatusTransition{ID: 2, From: api.MigrationStatusPending, To: api.MigrationStatusRunning, At: now})
+	if err != nil {
+		t.Fatalf("TransitionStatus() error = %v", err)
+	}
+
+	counts, err := repo.CountByStatus(context.Background())
+	if err != nil {
+		t.Fatalf("CountByStatus() error = %v", err)
+	}
+	got := make(map[string]int64)
+	for _, count := range counts {
+		got[count.Namespace+"/"+string(count.Status)] = count.Count
+	}
+	want := map[string]int64{"ns1/pending": 2, "ns1/running": 1, "ns2/pending": 1}
+	if len(got) != len(want) {
+		t.Fatalf("CountByStatus() = %v, want %v", got, want)
+	}
+	for key, count := range want {
+		if got[key] != count {
+			t.Errorf("CountByStatus()[%s] = %d, want %d", key, got[key], count)
+		}
+	}
+}
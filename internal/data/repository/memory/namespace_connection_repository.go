@@ -0,0 +1,101 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+)
+
+type namespaceConnectionRepository struct {
+	mu    sync.RWMutex
+	conns map[string]*api.NamespaceConnection
+}
+
+var (
+	connectionRepo repository.NamespaceConnectionRepository
+	connectionOnce sync.Once
+)
+
+func GetNamespaceConnectionRepository() repository.NamespaceConnectionRepository {
+	connectionOnce.Do(func() {
+		connectionRepo = NewNamespaceConnectionRepository()
+	})
+
+	return connectionRepo
+}
+
+func NewNamespaceConnectionRepository() repository.NamespaceConnectionRepository {
+	return &namespaceConnectionRepository{conns: make(map[string]*api.NamespaceConnection)}
+}
+
+// GetConnection returns the connection registered for a namespace, or nil if it has none
+func (r *namespaceConnectionRepository) GetConnection(ctx context.Context, namespace string) (*api.NamespaceConnection, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	conn, ok := r.conns[namespace]
+	if !ok {
+		return nil, nil
+	}
+
+	clone := *conn
+	return &clone, nil
+}
+
+func (r *namespaceConnectionRepository) ListConnections(ctx context.Context) ([]*api.NamespaceConnection, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	conns := make([]*api.NamespaceConnection, 0, len(r.conns))
+	for _, conn := range r.conns {
+		clone := *conn
+		conns = append(conns, &clone)
+	}
+	slices.SortFunc(conns, func(a, b *api.NamespaceConnection) int { return strings.Compare(a.Namespace, b.Namespace) })
+
+	return conns, nil
+}
+
+func (r *namespaceConnectionRepository) UpsertConnection(ctx context.Context, conn *api.NamespaceConnection) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	clone := *conn
+	r.conns[conn.Namespace] = &clone
+	return nil
+}
+
+func (r *namespaceConnectionRepository) DeleteConnection(ctx context.Context, namespace string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.conns[namespace]; !ok {
+		return false, nil
+	}
+	delete(r.conns, namespace)
+
+	return true, nil
+}
+
+func (r *namespaceConnectionRepository) Close() {}
+
+func (r *namespaceConnectionRepository) snapshot() func() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	conns := make(map[string]*api.NamespaceConnection, len(r.conns))
+	for namespace, conn := range r.conns {
+		clone := *conn
+		conns[namespace] = &clone
+	}
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.conns = conns
+	}
+}
@@ -0,0 +1,94 @@
+package memory
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+)
+
+type namespaceSettingsRepository struct {
+	mu       sync.RWMutex
+	settings map[string]*api.NamespaceSettings
+}
+
+var (
+	settingsRepo repository.NamespaceSettingsRepository
+	settingsOnce sync.Once
+)
+
+func GetNamespaceSettingsRepository() repository.NamespaceSettingsRepository {
+	settingsOnce.Do(func() {
+		settingsRepo = NewNamespaceSettingsRepository()
+	})
+
+	return settingsRepo
+}
+
+func NewNamespaceSettingsRepository() repository.NamespaceSettingsRepository {
+	return &namespaceSettingsRepository{settings: make(map[string]*api.NamespaceSettings)}
+}
+
+// GetSettings returns the settings for a namespace, or the defaults if none have been saved
+func (r *namespaceSettingsRepository) GetSettings(ctx context.Context, namespace string) (*api.NamespaceSettings, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	settings, ok := r.settings[namespace]
+	if !ok {
+		return &api.NamespaceSettings{Namespace: namespace, LockRetries: api.DefaultLockRetries}, nil
+	}
+
+	return cloneSettings(settings), nil
+}
+
+func (r *namespaceSettingsRepository) UpsertSettings(ctx context.Context, settings *api.NamespaceSettings) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.settings[settings.Namespace] = cloneSettings(settings)
+	return nil
+}
+
+// ListNamespacesByEnvironment returns the namespaces tagged with any of the environments
+func (r *namespaceSettingsRepository) ListNamespacesByEnvironment(ctx context.Context, environments []string) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	namespaces := make([]string, 0)
+	for namespace, settings := range r.settings {
+		if settings.Environment != "" && slices.Contains(environments, settings.Environment) {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	slices.Sort(namespaces)
+
+	return namespaces, nil
+}
+
+func (r *namespaceSettingsRepository) Close() {}
+
+func cloneSettings(settings *api.NamespaceSettings) *api.NamespaceSettings {
+	clone := *settings
+	clone.LintRules = maps.Clone(settings.LintRules)
+	return &clone
+}
+
+func (r *namespaceSettingsRepository) snapshot() func() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	settings := make(map[string]*api.NamespaceSettings, len(r.settings))
+	for namespace, s := range r.settings {
+		settings[namespace] = cloneSettings(s)
+	}
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.settings = settings
+	}
+}
@@ -0,0 +1,41 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/dfryer1193/gomad/api"
+)
+
+func TestNamespaceSettingsRepository(t *testing.T) {
+	repo := NewNamespaceSettingsRepository()
+
+	settings, err := repo.GetSettings(context.Background(), "ns1")
+	if err != nil || settings.LockRetries != api.DefaultLockRetries || settings.Namespace != "ns1" {
+		t.Errorf("GetSettings() for unsaved namespace = %+v, %v, expected defaults", settings, err)
+	}
+
+	saved := &api.NamespaceSettings{
+		Namespace:   "ns1",
+		Environment: "prod",
+		LockRetries: 5,
+		LintRules:   map[string]api.LintSeverity{"drop-table": api.LintSeverityOff},
+	}
+	for _, s := range []*api.NamespaceSettings{saved, {Namespace: "ns2", Environment: "dev"}, {Namespace: "ns3"}} {
+		if err := repo.UpsertSettings(context.Background(), s); err != nil {
+			t.Fatalf("UpsertSettings() error = %v", err)
+		}
+	}
+	saved.LintRules["drop-table"] = api.LintSeverityError
+
+	settings, _ = repo.GetSettings(context.Background(), "ns1")
+	if settings.LockRetries != 5 || settings.LintRules["drop-table"] != api.LintSeverityOff {
+		t.Errorf("GetSettings() = %+v", settings)
+	}
+
+	namespaces, err := repo.ListNamespacesByEnvironment(context.Background(), []string{"prod", "dev"})
+	if err != nil || !slices.Equal(namespaces, []string{"ns1", "ns2"}) {
+		t.Errorf("ListNamespacesByEnvironment() = %v, %v", namespaces, err)
+	}
+}
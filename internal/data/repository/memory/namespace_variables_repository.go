@@ -0,0 +1,85 @@
+package memory
+
+import (
+	"context"
+	"maps"
+	"sync"
+
+	"github.com/dfryer1193/gomad/internal/data/repository"
+)
+
+type namespaceVariablesRepository struct {
+	mu        sync.RWMutex
+	variables map[string]map[string]string
+}
+
+var (
+	variablesRepo repository.NamespaceVariablesRepository
+	variablesOnce sync.Once
+)
+
+func GetNamespaceVariablesRepository() repository.NamespaceVariablesRepository {
+	variablesOnce.Do(func() {
+		variablesRepo = NewNamespaceVariablesRepository()
+	})
+
+	return variablesRepo
+}
+
+func NewNamespaceVariablesRepository() repository.NamespaceVariablesRepository {
+	return &namespaceVariablesRepository{variables: make(map[string]map[string]string)}
+}
+
+func (r *namespaceVariablesRepository) GetVariables(ctx context.Context, namespace string) (map[string]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	vars := maps.Clone(r.variables[namespace])
+	if vars == nil {
+		vars = make(map[string]string)
+	}
+
+	return vars, nil
+}
+
+func (r *namespaceVariablesRepository) SetVariable(ctx context.Context, namespace string, name string, value string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.variables[namespace] == nil {
+		r.variables[namespace] = make(map[string]string)
+	}
+	r.variables[namespace][name] = value
+
+	return nil
+}
+
+func (r *namespaceVariablesRepository) DeleteVariable(ctx context.Context, namespace string, name string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.variables[namespace][name]; !ok {
+		return false, nil
+	}
+	delete(r.variables[namespace], name)
+
+	return true, nil
+}
+
+func (r *namespaceVariablesRepository) Close() {}
+
+func (r *namespaceVariablesRepository) snapshot() func() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	variables := make(map[string]map[string]string, len(r.variables))
+	for namespace, vars := range r.variables {
+		variables[namespace] = maps.Clone(vars)
+	}
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.variables = variables
+	}
+}
@@ -0,0 +1,91 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+)
+
+type notificationSinkRepository struct {
+	mu    sync.RWMutex
+	sinks map[string]*api.NotificationSink
+}
+
+var (
+	sinkRepo repository.NotificationSinkRepository
+	sinkOnce sync.Once
+)
+
+func GetNotificationSinkRepository() repository.NotificationSinkRepository {
+	sinkOnce.Do(func() {
+		sinkRepo = NewNotificationSinkRepository()
+	})
+
+	return sinkRepo
+}
+
+func NewNotificationSinkRepository() repository.NotificationSinkRepository {
+	return &notificationSinkRepository{sinks: make(map[string]*api.NotificationSink)}
+}
+
+// GetSink returns the sink with the name, or nil if there's none
+func (r *notificationSinkRepository) GetSink(ctx context.Context, name string) (*api.NotificationSink, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sink, ok := r.sinks[name]
+	if !ok {
+		return nil, nil
+	}
+
+	return cloneNotificationSink(sink), nil
+}
+
+func (r *notificationSinkRepository) ListSinks(ctx context.Context) ([]*api.NotificationSink, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sinks := make([]*api.NotificationSink, 0, len(r.sinks))
+	for _, sink := range r.sinks {
+		sinks = append(sinks, cloneNotificationSink(sink))
+	}
+	slices.SortFunc(sinks, func(a, b *api.NotificationSink) int { return strings.Compare(a.Name, b.Name) })
+
+	return sinks, nil
+}
+
+func (r *notificationSinkRepository) UpsertSink(ctx context.Context, sink *api.NotificationSink) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sinks[sink.Name] = cloneNotificationSink(sink)
+	return nil
+}
+
+func (r *notificationSinkRepository) DeleteSink(ctx context.Context, name string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.sinks[name]; !ok {
+		return false, nil
+	}
+	delete(r.sinks, name)
+
+	return true, nil
+}
+
+func (r *notificationSinkRepository) Close() {}
+
+// cloneNotificationSink copies a sink, recomputing HasSecret as the postgres repository does when reading one
+func cloneNotificationSink(sink *api.NotificationSink) *api.NotificationSink {
+	clone := *sink
+	clone.Namespaces = slices.Clone(sink.Namespaces)
+	clone.Events = slices.Clone(sink.Events)
+	clone.To = slices.Clone(sink.To)
+	clone.HasSecret = clone.Secret != ""
+	return &clone
+}
@@ -0,0 +1,104 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+)
+
+type repositoryConfigRepository struct {
+	mu      sync.RWMutex
+	configs map[string]*api.RepositoryConfig
+}
+
+var (
+	repoConfigRepo repository.RepositoryConfigRepository
+	repoConfigOnce sync.Once
+)
+
+func GetRepositoryConfigRepository() repository.RepositoryConfigRepository {
+	repoConfigOnce.Do(func() {
+		repoConfigRepo = NewRepositoryConfigRepository()
+	})
+
+	return repoConfigRepo
+}
+
+func NewRepositoryConfigRepository() repository.RepositoryConfigRepository {
+	return &repositoryConfigRepository{configs: make(map[string]*api.RepositoryConfig)}
+}
+
+// GetConfig returns the config for a repository, or nil if it has none
+func (r *repositoryConfigRepository) GetConfig(ctx context.Context, repoName string) (*api.RepositoryConfig, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	config, ok := r.configs[repoName]
+	if !ok {
+		return nil, nil
+	}
+
+	return cloneRepositoryConfig(config), nil
+}
+
+func (r *repositoryConfigRepository) ListConfigs(ctx context.Context) ([]*api.RepositoryConfig, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	configs := make([]*api.RepositoryConfig, 0, len(r.configs))
+	for _, config := range r.configs {
+		configs = append(configs, cloneRepositoryConfig(config))
+	}
+	slices.SortFunc(configs, func(a, b *api.RepositoryConfig) int { return strings.Compare(a.RepoName, b.RepoName) })
+
+	return configs, nil
+}
+
+func (r *repositoryConfigRepository) UpsertConfig(ctx context.Context, config *api.RepositoryConfig) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.configs[config.RepoName] = cloneRepositoryConfig(config)
+	return nil
+}
+
+func (r *repositoryConfigRepository) DeleteConfig(ctx context.Context, repoName string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.configs[repoName]; !ok {
+		return false, nil
+	}
+	delete(r.configs, repoName)
+
+	return true, nil
+}
+
+func (r *repositoryConfigRepository) Close() {}
+
+// cloneRepositoryConfig copies a config, recomputing HasToken as the postgres repository does when reading one
+func cloneRepositoryConfig(config *api.RepositoryConfig) *api.RepositoryConfig {
+	clone := *config
+	clone.HasToken = clone.Token != ""
+	return &clone
+}
+
+func (r *repositoryConfigRepository) snapshot() func() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	configs := make(map[string]*api.RepositoryConfig, len(r.configs))
+	for repoName, config := range r.configs {
+		configs[repoName] = cloneRepositoryConfig(config)
+	}
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.configs = configs
+	}
+}
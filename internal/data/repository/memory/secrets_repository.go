@@ -0,0 +1,71 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sync"
+
+	"github.com/dfryer1193/gomad/internal/data/repository"
+)
+
+type secretRepository struct {
+	mu      sync.RWMutex
+	secrets map[string]string
+}
+
+var (
+	secretsRepo repository.SecretRepository
+	secretsOnce sync.Once
+)
+
+func GetSecretsRepository() repository.SecretRepository {
+	secretsOnce.Do(func() {
+		secretsRepo = NewSecretsRepository()
+	})
+
+	return secretsRepo
+}
+
+func NewSecretsRepository() repository.SecretRepository {
+	return &secretRepository{secrets: make(map[string]string)}
+}
+
+// InsertSecret stores a repository's webhook secret. Like the postgres table, a repository can only have one.
+func (r *secretRepository) InsertSecret(ctx context.Context, repoName string, secret string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.secrets[repoName]; ok {
+		return "", fmt.Errorf("repository %s already has a secret", repoName)
+	}
+
+	r.secrets[repoName] = secret
+	return secret, nil
+}
+
+func (r *secretRepository) GetSecret(ctx context.Context, repoName string) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	secret, ok := r.secrets[repoName]
+	if !ok {
+		return "", fmt.Errorf("no secret for repository %s", repoName)
+	}
+
+	return secret, nil
+}
+
+func (r *secretRepository) Close() {}
+
+func (r *secretRepository) snapshot() func() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	secrets := maps.Clone(r.secrets)
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.secrets = secrets
+	}
+}
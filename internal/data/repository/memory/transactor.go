@@ -0,0 +1,71 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/dfryer1193/gomad/internal/data/repository"
+)
+
+// snapshotter is implemented by every memory repository. snapshot copies the repository's contents and returns a
+// func that puts them back.
+type snapshotter interface {
+	snapshot() func()
+}
+
+// transactor runs transactions one at a time, undoing a failed one by restoring snapshots of the repositories taken
+// before it started. Writes made outside of a transaction while one is failing are undone along with it.
+type transactor struct {
+	mu    sync.Mutex
+	repos repository.Repositories
+}
+
+var (
+	memoryTransactor repository.Transactor
+	transactorOnce   sync.Once
+)
+
+func GetTransactor() repository.Transactor {
+	transactorOnce.Do(func() {
+		memoryTransactor = NewTransactor(repository.Repositories{
+			Migrations:           GetMigrationRepository(),
+			ExecutionAttempts:    GetExecutionAttemptRepository(),
+			Secrets:              GetSecretsRepository(),
+			NamespaceSettings:    GetNamespaceSettingsRepository(),
+			NamespaceVariables:   GetNamespaceVariablesRepository(),
+			RepositoryConfigs:    GetRepositoryConfigRepository(),
+			NamespaceConnections: GetNamespaceConnectionRepository(),
+		})
+	})
+
+	return memoryTransactor
+}
+
+// NewTransactor returns a transactor over the given memory repositories
+func NewTransactor(repos repository.Repositories) repository.Transactor {
+	return &transactor{repos: repos}
+}
+
+func (t *transactor) InTx(_ context.Context, fn func(repos repository.Repositories) error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	restores := make([]func(), 0, 7)
+	for _, repo := range []any{
+		t.repos.Migrations, t.repos.ExecutionAttempts, t.repos.Secrets, t.repos.NamespaceSettings, t.repos.NamespaceVariables,
+		t.repos.RepositoryConfigs, t.repos.NamespaceConnections,
+	} {
+		if s, ok := repo.(snapshotter); ok {
+			restores = append(restores, s.snapshot())
+		}
+	}
+
+	if err := fn(t.repos); err != nil {
+		for _, restore := range restores {
+			restore()
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+)
+
+func newTestRepositories() repository.Repositories {
+	return repository.Repositories{
+		Migrations:           NewMigrationRepository(),
+		Secrets:              NewSecretsRepository(),
+		NamespaceSettings:    NewNamespaceSettingsRepository(),
+		NamespaceVariables:   NewNamespaceVariablesRepository(),
+		RepositoryConfigs:    NewRepositoryConfigRepository(),
+		NamespaceConnections: NewNamespaceConnectionRepository(),
+	}
+}
+
+func TestTransactorRollsBack(t *testing.T) {
+	repos := newTestRepositories()
+	tx := NewTransactor(repos)
+
+	if err := repos.NamespaceVariables.SetVariable(context.Background(), "ns1", "schema", "app"); err != nil {
+		t.Fatalf("SetVariable() error = %v", err)
+	}
+
+	failure := errors.New("failed halfway")
+	err := tx.InTx(context.Background(), func(repos repository.Repositories) error {
+		if _, err := repos.Secrets.InsertSecret(context.Background(), "org/repo", "secret"); err != nil {
+			return err
+		}
+		if err := repos.Migrations.BulkInsert(context.Background(), []*api.MigrationProto{{Signature: 1}}); err != nil {
+			return err
+		}
+		if err := repos.NamespaceVariables.SetVariable(context.Background(), "ns1", "schema", "changed"); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("InTx() error = %v, expected the function's error", err)
+	}
+
+	if _, err := repos.Secrets.GetSecret(context.Background(), "org/repo"); err == nil {
+		t.Errorf("expected secret from the failed transaction to be rolled back")
+	}
+	if m, _ := repos.Migrations.GetById(context.Background(), 1); m != nil {
+		t.Errorf("expected migration from the failed transaction to be rolled back")
+	}
+	if vars, _ := repos.NamespaceVariables.GetVariables(context.Background(), "ns1"); vars["schema"] != "app" {
+		t.Errorf("expected variable to be restored, got %v", vars)
+	}
+
+	err = tx.InTx(context.Background(), func(repos repository.Repositories) error {
+		_, err := repos.Secrets.InsertSecret(context.Background(), "org/repo", "secret")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("InTx() error = %v", err)
+	}
+	if secret, err := repos.Secrets.GetSecret(context.Background(), "org/repo"); err != nil || secret != "secret" {
+		t.Errorf("GetSecret() = %q, %v after a committed transaction", secret, err)
+	}
+}
@@ -0,0 +1,477 @@
+package mysql
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+	"github.com/dfryer1193/gomad/internal/data/utils"
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+// lockWaitTimeout is the MySQL error raised when lock_wait_timeout or innodb_lock_wait_timeout expires
+const lockWaitTimeout = 1205
+
+// defaultNamedLockWait is how long a migration waits for another migration in the same namespace to finish when no
+// lock timeout is configured
+const defaultNamedLockWait = 30 * time.Second
+
+// systemDatabases are created by MySQL itself and never back a namespace
+var systemDatabases = []string{"information_schema", "mysql", "performance_schema", "sys"}
+
+type targetDriver struct {
+	open func(cfg *mysqldriver.Config) (*sql.DB, error)
+
+	mu  sync.Mutex
+	dbs map[string]*namespaceDB
+}
+
+// namespaceDB is a namespace's connection pool along with the DSN it was opened with, so the pool can be replaced when
+// the namespace's connection changes
+type namespaceDB struct {
+	db  *sql.DB
+	dsn string
+}
+
+var (
+	targetDrv  *targetDriver
+	targetOnce sync.Once
+)
+
+// GetTargetDriver returns the driver for namespaces backed by MySQL or MariaDB
+func GetTargetDriver() repository.TargetDriver {
+	targetOnce.Do(func() {
+		targetDrv = &targetDriver{
+			open: openDB,
+			dbs:  make(map[string]*namespaceDB),
+		}
+	})
+
+	return targetDrv
+}
+
+func openDB(cfg *mysqldriver.Config) (*sql.DB, error) {
+	connector, err := mysqldriver.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(connector), nil
+}
+
+// ExecuteMigration runs the DDL one statement at a time on a single session. MySQL commits implicitly around every
+// DDL statement, so a migration can't be rolled back: if a statement fails, the ones before it stay applied and the
+// error says how many there were. Migrations in the same namespace are serialized with a GET_LOCK named lock.
+func (d *targetDriver) ExecuteMigration(ctx context.Context, conn *api.NamespaceConnection, ddl string, settings api.SessionSettings, attempt *api.ExecutionAttempt) error {
+	db, err := d.getDB(conn)
+	if err != nil {
+		return err
+	}
+
+	session, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to namespace %s: %w", conn.Namespace, err)
+	}
+	defer session.Close()
+	attempt.Identity = conn.User
+
+	lockWait := defaultNamedLockWait
+	if settings.LockTimeout != "" {
+		if lockWait, err = time.ParseDuration(settings.LockTimeout); err != nil {
+			return fmt.Errorf("invalid lock_timeout %q: %w", settings.LockTimeout, err)
+		}
+	}
+
+	release, err := acquireNamedLock(ctx, session, "gomad:"+conn.Namespace, lockWait)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	statements, reset, err := sessionStatements(settings)
+	if err != nil {
+		return err
+	}
+
+	// The session goes back to the pool afterwards, so the settings are put back to their defaults, even if ctx was
+	// cancelled
+	defer func() {
+		for _, stmt := range reset {
+			session.ExecContext(context.WithoutCancel(ctx), stmt)
+		}
+	}()
+
+	for _, stmt := range statements {
+		if err := execStatement(ctx, session, stmt, attempt); err != nil {
+			return fmt.Errorf("failed to apply session setting %q: %w", stmt, err)
+		}
+	}
+
+	ddlStatements := utils.SplitStatements(ddl)
+	for idx, stmt := range ddlStatements {
+		if err := execStatement(ctx, session, stmt, attempt); err != nil {
+			// Retrying reruns the whole migration, so a lock timeout is only retryable while nothing has committed
+			if idx == 0 {
+				err = wrapExecError(err)
+			}
+			return fmt.Errorf("statement %d of %d failed, %d earlier statements were committed: %w",
+				idx+1, len(ddlStatements), idx, err)
+		}
+	}
+
+	return nil
+}
+
+// execStatement runs a statement of a migration, recording how it went in the attempt
+func execStatement(ctx context.Context, session *sql.Conn, stmt string, attempt *api.ExecutionAttempt) error {
+	start := time.Now()
+	result, err := session.ExecContext(ctx, stmt)
+	took := time.Since(start)
+
+	var rows int64
+	if err == nil {
+		rows, _ = result.RowsAffected()
+	}
+	attempt.AddStatement(stmt, took, rows, err != nil)
+	if err != nil {
+		attempt.Error = executionError(err, stmt)
+	}
+
+	return err
+}
+
+// executionError describes a failed statement, with the server's error number and SQLSTATE when it reported them
+func executionError(err error, stmt string) *api.ExecutionError {
+	execErr := &api.ExecutionError{Message: err.Error(), Statement: stmt}
+
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		execErr.Message = mysqlErr.Message
+		execErr.Detail = fmt.Sprintf("MySQL error %d", mysqlErr.Number)
+		if mysqlErr.SQLState != [5]byte{} {
+			execErr.SQLState = string(mysqlErr.SQLState[:])
+		}
+	}
+
+	return execErr
+}
+
+func (d *targetDriver) EstimateTableRows(ctx context.Context, conn *api.NamespaceConnection, table string) (int64, repository.TableEstimate, error) {
+	db, err := d.getDB(conn)
+	if err != nil {
+		return 0, repository.TableMissing, err
+	}
+
+	database, name := conn.Database, table
+	if before, after, ok := strings.Cut(table, "."); ok {
+		database, name = before, after
+	}
+
+	query := `SELECT COALESCE(TABLE_ROWS, -1) FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?`
+	var rows int64
+	err = db.QueryRowContext(ctx, query, unquote(database), unquote(name)).Scan(&rows)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, repository.TableMissing, nil
+	}
+	if err != nil {
+		return 0, repository.TableMissing, fmt.Errorf("failed to estimate rows for table %s: %w", table, err)
+	}
+
+	// Views and tables without statistics have no row count
+	if rows < 0 {
+		return 0, repository.TableSizeUnknown, nil
+	}
+
+	return rows, repository.TableSizeKnown, nil
+}
+
+func (d *targetDriver) DatabaseExists(ctx context.Context, conn *api.NamespaceConnection) (bool, error) {
+	databases, err := d.listDatabases(ctx, conn)
+	if err != nil {
+		return false, err
+	}
+
+	for _, database := range databases {
+		if database == conn.Database {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (d *targetDriver) CreateDatabase(ctx context.Context, conn *api.NamespaceConnection) error {
+	server, err := d.openServer(conn)
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+
+	if _, err := server.ExecContext(ctx, "CREATE DATABASE "+quoteIdentifier(conn.Database)); err != nil {
+		return fmt.Errorf("failed to create database %s: %w", conn.Database, err)
+	}
+
+	return nil
+}
+
+func (d *targetDriver) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for namespace, db := range d.dbs {
+		db.db.Close()
+		delete(d.dbs, namespace)
+	}
+}
+
+// listDatabases lists the databases on the namespace's server, leaving out MySQL's own
+func (d *targetDriver) listDatabases(ctx context.Context, conn *api.NamespaceConnection) ([]string, error) {
+	server, err := d.openServer(conn)
+	if err != nil {
+		return nil, err
+	}
+	defer server.Close()
+
+	rows, err := server.QueryContext(ctx, "SHOW DATABASES")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query databases: %w", err)
+	}
+	defer rows.Close()
+
+	databases := make([]string, 0)
+	for rows.Next() {
+		var database string
+		if err := rows.Scan(&database); err != nil {
+			return nil, fmt.Errorf("failed to scan database name: %w", err)
+		}
+		if !isSystemDatabase(database) {
+			databases = append(databases, database)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating database rows: %w", err)
+	}
+
+	return databases, nil
+}
+
+// getDB returns the pool for a namespace's database, replacing it if the namespace's connection or credentials have
+// changed since it was opened
+func (d *targetDriver) getDB(conn *api.NamespaceConnection) (*sql.DB, error) {
+	cfg, err := BuildConfig(conn)
+	if err != nil {
+		return nil, err
+	}
+	dsn := cfg.FormatDSN() + "|" + conn.SSLMode + "|" + conn.SSLRootCert
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if existing, ok := d.dbs[conn.Namespace]; ok {
+		if existing.dsn == dsn {
+			return existing.db, nil
+		}
+		existing.db.Close()
+		delete(d.dbs, conn.Namespace)
+	}
+
+	db, err := d.open(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open connection pool for namespace %s: %w", conn.Namespace, err)
+	}
+
+	d.dbs[conn.Namespace] = &namespaceDB{db: db, dsn: dsn}
+	return db, nil
+}
+
+// openServer opens a connection to the namespace's server without selecting a database
+func (d *targetDriver) openServer(conn *api.NamespaceConnection) (*sql.DB, error) {
+	server := *conn
+	server.Database = ""
+
+	cfg, err := BuildConfig(&server)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := d.open(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to server of namespace %s: %w", conn.Namespace, err)
+	}
+	return db, nil
+}
+
+// BuildConfig converts a namespace connection into a MySQL driver config, reading its password from the credentials
+// reference. sslMode maps onto the driver's TLS options: require encrypts without verifying, verify-ca checks the
+// server's certificate against the root CA, and verify-full also checks its host name.
+func BuildConfig(conn *api.NamespaceConnection) (*mysqldriver.Config, error) {
+	password, err := utils.ResolveCredentials(conn.CredentialsRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve credentials for namespace %s: %w", conn.Namespace, err)
+	}
+
+	port := conn.Port
+	if port == 0 {
+		port = api.DriverMySQL.DefaultPort()
+	}
+
+	cfg := mysqldriver.NewConfig()
+	cfg.User = conn.User
+	cfg.Passwd = password
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(conn.Host, strconv.Itoa(port))
+	cfg.DBName = conn.Database
+	cfg.ParseTime = true
+	cfg.Timeout = 10 * time.Second
+
+	switch conn.SSLMode {
+	case "", "disable":
+	case "allow", "prefer":
+		cfg.TLSConfig = "preferred"
+	case "require":
+		cfg.TLSConfig = "skip-verify"
+	case "verify-ca", "verify-full":
+		tlsConfig, err := verifyingTLSConfig(conn)
+		if err != nil {
+			return nil, err
+		}
+		cfg.TLS = tlsConfig
+	default:
+		return nil, fmt.Errorf("unsupported sslMode %q", conn.SSLMode)
+	}
+
+	return cfg, nil
+}
+
+func verifyingTLSConfig(conn *api.NamespaceConnection) (*tls.Config, error) {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+
+	if conn.SSLRootCert != "" {
+		pem, err := os.ReadFile(conn.SSLRootCert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sslRootCert for namespace %s: %w", conn.Namespace, err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("sslRootCert for namespace %s contains no PEM certificates", conn.Namespace)
+		}
+	}
+
+	if conn.SSLMode == "verify-full" {
+		return &tls.Config{RootCAs: roots, ServerName: conn.Host}, nil
+	}
+
+	// verify-ca checks the certificate chain but not the host name, which the standard verification can't skip alone
+	return &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return errors.New("server presented no certificate")
+			}
+			intermediates := x509.NewCertPool()
+			for _, cert := range state.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+			return err
+		},
+	}, nil
+}
+
+// acquireNamedLock takes a GET_LOCK lock on the session, returning a func that releases it
+func acquireNamedLock(ctx context.Context, session *sql.Conn, name string, wait time.Duration) (func(), error) {
+	seconds := int(math.Ceil(wait.Seconds()))
+
+	var acquired sql.NullInt64
+	if err := session.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", name, seconds).Scan(&acquired); err != nil {
+		return nil, fmt.Errorf("failed to acquire lock %s: %w", name, err)
+	}
+
+	if !acquired.Valid {
+		return nil, fmt.Errorf("failed to acquire lock %s", name)
+	}
+	if acquired.Int64 != 1 {
+		return nil, fmt.Errorf("%w: another migration holds lock %s", repository.ErrLockTimeout, name)
+	}
+
+	return func() {
+		session.ExecContext(context.WithoutCancel(ctx), "DO RELEASE_LOCK(?)", name)
+	}, nil
+}
+
+// sessionStatements converts the configured timeouts into SET SESSION statements, along with the statements that
+// restore their defaults. lock_timeout bounds waits for both metadata and row locks, in whole seconds.
+// statement_timeout maps to max_execution_time, which MySQL only enforces for SELECTs, and
+// idle_in_transaction_session_timeout has no MySQL equivalent.
+func sessionStatements(settings api.SessionSettings) ([]string, []string, error) {
+	var statements, reset []string
+
+	if settings.LockTimeout != "" {
+		d, err := time.ParseDuration(settings.LockTimeout)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid lock_timeout %q: %w", settings.LockTimeout, err)
+		}
+		seconds := max(int(math.Ceil(d.Seconds())), 1)
+		statements = append(statements,
+			fmt.Sprintf("SET SESSION lock_wait_timeout = %d", seconds),
+			fmt.Sprintf("SET SESSION innodb_lock_wait_timeout = %d", seconds),
+		)
+		reset = append(reset,
+			"SET SESSION lock_wait_timeout = DEFAULT",
+			"SET SESSION innodb_lock_wait_timeout = DEFAULT",
+		)
+	}
+
+	if settings.StatementTimeout != "" {
+		d, err := time.ParseDuration(settings.StatementTimeout)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid statement_timeout %q: %w", settings.StatementTimeout, err)
+		}
+		statements = append(statements, fmt.Sprintf("SET SESSION max_execution_time = %d", d.Milliseconds()))
+		reset = append(reset, "SET SESSION max_execution_time = DEFAULT")
+	}
+
+	return statements, reset, nil
+}
+
+// wrapExecError marks lock wait timeouts with repository.ErrLockTimeout so callers can retry them
+func wrapExecError(err error) error {
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == lockWaitTimeout {
+		return fmt.Errorf("%w: %w", repository.ErrLockTimeout, err)
+	}
+
+	return err
+}
+
+func isSystemDatabase(database string) bool {
+	for _, system := range systemDatabases {
+		if strings.EqualFold(database, system) {
+			return true
+		}
+	}
+	return false
+}
+
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func unquote(name string) string {
+	return strings.ReplaceAll(strings.Trim(name, "`"), "``", "`")
+}
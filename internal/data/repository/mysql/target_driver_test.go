@@ -0,0 +1,322 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+// fakeServer is an in-process stand-in for a MySQL server. It records every statement it's sent and answers the
+// handful of queries the driver makes.
+type fakeServer struct {
+	mu         sync.Mutex
+	statements []string
+	lockHeld   bool
+	databases  []string
+	tableRows  map[string]int64
+	failOn     string
+	failErr    error
+}
+
+func (s *fakeServer) Connect(context.Context) (driver.Conn, error) { return &fakeConn{server: s}, nil }
+func (s *fakeServer) Driver() driver.Driver                        { return nil }
+
+func (s *fakeServer) open(*mysqldriver.Config) (*sql.DB, error) {
+	return sql.OpenDB(s), nil
+}
+
+func (s *fakeServer) executed() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.statements...)
+}
+
+func (s *fakeServer) exec(query string, args []driver.NamedValue) (driver.Rows, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statements = append(s.statements, query)
+
+	if s.failOn != "" && strings.Contains(query, s.failOn) {
+		return nil, s.failErr
+	}
+
+	switch {
+	case strings.HasPrefix(query, "SELECT GET_LOCK"):
+		if s.lockHeld {
+			return &fakeRows{columns: []string{"lock"}, values: [][]driver.Value{{int64(0)}}}, nil
+		}
+		s.lockHeld = true
+		return &fakeRows{columns: []string{"lock"}, values: [][]driver.Value{{int64(1)}}}, nil
+	case strings.HasPrefix(query, "DO RELEASE_LOCK"):
+		s.lockHeld = false
+	case query == "SHOW DATABASES":
+		rows := &fakeRows{columns: []string{"Database"}}
+		for _, database := range s.databases {
+			rows.values = append(rows.values, []driver.Value{database})
+		}
+		return rows, nil
+	case strings.HasPrefix(query, "CREATE DATABASE"):
+		s.databases = append(s.databases, strings.Trim(strings.TrimPrefix(query, "CREATE DATABASE "), "`"))
+	case strings.Contains(query, "information_schema.TABLES"):
+		rows := &fakeRows{columns: []string{"TABLE_ROWS"}}
+		if count, ok := s.tableRows[args[0].Value.(string)+"."+args[1].Value.(string)]; ok {
+			rows.values = [][]driver.Value{{count}}
+		}
+		return rows, nil
+	}
+
+	return &fakeRows{}, nil
+}
+
+type fakeConn struct {
+	server *fakeServer
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if _, err := c.server.exec(query, args); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.server.exec(query, args)
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func newTestDriver(server *fakeServer) *targetDriver {
+	return &targetDriver{open: server.open, dbs: make(map[string]*namespaceDB)}
+}
+
+var testConn = &api.NamespaceConnection{
+	Namespace: "billing",
+	Driver:    api.DriverMySQL,
+	Host:      "db.internal",
+	Database:  "billing",
+	User:      "gomad",
+}
+
+func TestExecuteMigration(t *testing.T) {
+	server := &fakeServer{}
+	d := newTestDriver(server)
+
+	ddl := "CREATE TABLE `order` (id INT);\nALTER TABLE `order` ADD COLUMN note TEXT DEFAULT ';'"
+	attempt := &api.ExecutionAttempt{}
+	err := d.ExecuteMigration(context.Background(), testConn, ddl, api.SessionSettings{LockTimeout: "2500ms", StatementTimeout: "1m"}, attempt)
+	if err != nil {
+		t.Fatalf("ExecuteMigration() error = %v", err)
+	}
+
+	expected := []string{
+		"SELECT GET_LOCK(?, ?)",
+		"SET SESSION lock_wait_timeout = 3",
+		"SET SESSION innodb_lock_wait_timeout = 3",
+		"SET SESSION max_execution_time = 60000",
+		"CREATE TABLE `order` (id INT)",
+		"ALTER TABLE `order` ADD COLUMN note TEXT DEFAULT ';'",
+		"SET SESSION lock_wait_timeout = DEFAULT",
+		"SET SESSION innodb_lock_wait_timeout = DEFAULT",
+		"SET SESSION max_execution_time = DEFAULT",
+		"DO RELEASE_LOCK(?)",
+	}
+	if got := server.executed(); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("executed statements:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+	if server.lockHeld {
+		t.Errorf("expected named lock to be released")
+	}
+
+	// The attempt records what the migration sent, not the lock and reset statements around it
+	if attempt.Identity != "gomad" || len(attempt.Statements) != 5 || attempt.Statements[3].SQL != expected[4] || attempt.Error != nil {
+		t.Errorf("attempt = %+v", attempt)
+	}
+}
+
+func TestExecuteMigrationLockHeld(t *testing.T) {
+	server := &fakeServer{lockHeld: true}
+	d := newTestDriver(server)
+
+	err := d.ExecuteMigration(context.Background(), testConn, "CREATE TABLE t (id INT)", api.SessionSettings{}, &api.ExecutionAttempt{})
+	if !errors.Is(err, repository.ErrLockTimeout) {
+		t.Fatalf("expected ErrLockTimeout, got %v", err)
+	}
+	for _, stmt := range server.executed() {
+		if strings.HasPrefix(stmt, "CREATE TABLE") {
+			t.Errorf("expected no DDL while another migration holds the lock")
+		}
+	}
+}
+
+func TestExecuteMigrationPartialFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		failOn string
+		err    error
+		// failedAt is the 1-based statement expected to fail
+		failedAt    int
+		lockTimeout bool
+	}{
+		{name: "syntax error", failOn: "CREATE INDEX", err: &mysqldriver.MySQLError{Number: 1064, Message: "syntax error"}, failedAt: 2},
+		// The first statement already committed, so retrying would run it twice
+		{name: "lock wait timeout after a commit", failOn: "CREATE INDEX", err: &mysqldriver.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}, failedAt: 2},
+		{name: "lock wait timeout before any commit", failOn: "CREATE TABLE", err: &mysqldriver.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}, failedAt: 1, lockTimeout: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &fakeServer{failOn: tt.failOn, failErr: tt.err}
+			d := newTestDriver(server)
+
+			attempt := &api.ExecutionAttempt{}
+			err := d.ExecuteMigration(context.Background(), testConn, "CREATE TABLE t (id INT); CREATE INDEX t_id ON t (id); DROP TABLE u", api.SessionSettings{}, attempt)
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+			want := fmt.Sprintf("statement %d of 3 failed, %d earlier statements were committed", tt.failedAt, tt.failedAt-1)
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("error = %v", err)
+			}
+			if errors.Is(err, repository.ErrLockTimeout) != tt.lockTimeout {
+				t.Errorf("errors.Is(err, ErrLockTimeout) = %v, expected %v", !tt.lockTimeout, tt.lockTimeout)
+			}
+			if server.lockHeld {
+				t.Errorf("expected named lock to be released after a failure")
+			}
+			if len(attempt.Statements) != tt.failedAt || !attempt.Statements[tt.failedAt-1].Failed {
+				t.Errorf("expected the failed statement to end the attempt, got %+v", attempt.Statements)
+			}
+			if attempt.Error == nil || !strings.HasPrefix(attempt.Error.Statement, tt.failOn) || attempt.Error.Message != tt.err.(*mysqldriver.MySQLError).Message {
+				t.Errorf("attempt error = %+v", attempt.Error)
+			}
+		})
+	}
+}
+
+func TestEstimateTableRows(t *testing.T) {
+	server := &fakeServer{tableRows: map[string]int64{"billing.invoices": 1200, "reporting.totals": 7, "billing.view": -1}}
+	d := newTestDriver(server)
+
+	tests := []struct {
+		table    string
+		rows     int64
+		estimate repository.TableEstimate
+	}{
+		{table: "invoices", rows: 1200, estimate: repository.TableSizeKnown},
+		{table: "`reporting`.`totals`", rows: 7, estimate: repository.TableSizeKnown},
+		{table: "view", estimate: repository.TableSizeUnknown},
+		{table: "missing", estimate: repository.TableMissing},
+	}
+
+	for _, tt := range tests {
+		rows, estimate, err := d.EstimateTableRows(context.Background(), testConn, tt.table)
+		if err != nil {
+			t.Fatalf("EstimateTableRows(%s) error = %v", tt.table, err)
+		}
+		if rows != tt.rows || estimate != tt.estimate {
+			t.Errorf("EstimateTableRows(%s) = %d, %v, expected %d, %v", tt.table, rows, estimate, tt.rows, tt.estimate)
+		}
+	}
+}
+
+func TestCreateDatabase(t *testing.T) {
+	server := &fakeServer{databases: []string{"information_schema", "mysql", "reporting"}}
+	d := newTestDriver(server)
+
+	exists, err := d.DatabaseExists(context.Background(), testConn)
+	if err != nil || exists {
+		t.Fatalf("DatabaseExists() = %v, %v before creating it", exists, err)
+	}
+
+	if err := d.CreateDatabase(context.Background(), testConn); err != nil {
+		t.Fatalf("CreateDatabase() error = %v", err)
+	}
+
+	exists, err = d.DatabaseExists(context.Background(), testConn)
+	if err != nil || !exists {
+		t.Errorf("DatabaseExists() = %v, %v after creating it", exists, err)
+	}
+
+	system := *testConn
+	system.Database = "mysql"
+	if exists, _ := d.DatabaseExists(context.Background(), &system); exists {
+		t.Errorf("expected system databases to be ignored")
+	}
+}
+
+func TestBuildConfig(t *testing.T) {
+	t.Setenv("GOMAD_CREDENTIAL_BILLING", "hunter2")
+
+	tests := []struct {
+		name    string
+		conn    api.NamespaceConnection
+		addr    string
+		tls     string
+		custom  bool
+		wantErr bool
+	}{
+		{name: "defaults", conn: api.NamespaceConnection{Host: "db.internal"}, addr: "db.internal:3306"},
+		{name: "prefer", conn: api.NamespaceConnection{Host: "db.internal", Port: 3307, SSLMode: "prefer"}, addr: "db.internal:3307", tls: "preferred"},
+		{name: "require", conn: api.NamespaceConnection{Host: "db.internal", SSLMode: "require"}, addr: "db.internal:3306", tls: "skip-verify"},
+		{name: "verify-full", conn: api.NamespaceConnection{Host: "db.internal", SSLMode: "verify-full"}, addr: "db.internal:3306", custom: true},
+		{name: "missing root cert", conn: api.NamespaceConnection{Host: "db.internal", SSLMode: "verify-ca", SSLRootCert: "/does/not/exist.pem"}, wantErr: true},
+		{name: "unknown ssl mode", conn: api.NamespaceConnection{Host: "db.internal", SSLMode: "always"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := tt.conn
+			conn.Namespace, conn.User, conn.Database, conn.CredentialsRef = "billing", "gomad", "billing", "env:GOMAD_CREDENTIAL_BILLING"
+
+			cfg, err := BuildConfig(&conn)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("BuildConfig() error = %v", err)
+			}
+
+			if cfg.Addr != tt.addr || cfg.User != "gomad" || cfg.Passwd != "hunter2" || cfg.DBName != "billing" {
+				t.Errorf("BuildConfig() = %+v", cfg)
+			}
+			if cfg.TLSConfig != tt.tls {
+				t.Errorf("TLSConfig = %q, expected %q", cfg.TLSConfig, tt.tls)
+			}
+			if (cfg.TLS != nil) != tt.custom {
+				t.Errorf("custom TLS config = %v, expected %v", cfg.TLS != nil, tt.custom)
+			}
+		})
+	}
+}
@@ -4,15 +4,14 @@ import (
 	"context"
 	"fmt"
 	"github.com/dfryer1193/gomad/internal/data/repository"
-	"github.com/dfryer1193/gomad/internal/data/utils"
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/rs/zerolog/log"
 	"sync"
 )
 
+// databaseRepository manages the default cluster's databases through the metadata pool, since pg_database is shared
+// by every database in a cluster
 type databaseRepository struct {
-	pool *pgxpool.Pool
+	db querier
 }
 
 var (
@@ -22,23 +21,14 @@ var (
 
 func GetDatabaseRepository() repository.DatabaseRepository {
 	databaseOnce.Do(func() {
-		connString, err := utils.BuildConnectionString("postgres")
-		if err != nil {
-			log.Fatal().Err(err).Msg("failed to build connection string for database management")
-		}
-
-		pool, err := pgxpool.New(context.Background(), connString)
-		if err != nil {
-			log.Fatal().Err(err).Msg("failed to create connection pool for database management")
-		}
-		dbRepo = &databaseRepository{pool: pool}
+		dbRepo = &databaseRepository{db: GetMetadataPool()}
 	})
 
 	return dbRepo
 }
 
-func (r *databaseRepository) CreateDatabase(dbName string, owner string) error {
-	exists, err := r.DatabaseExists(dbName)
+func (r *databaseRepository) CreateDatabase(ctx context.Context, dbName string, owner string) error {
+	exists, err := r.DatabaseExists(ctx, dbName)
 	if err != nil {
 		return fmt.Errorf("failed to check database existence: %w", err)
 	}
@@ -52,7 +42,7 @@ func (r *databaseRepository) CreateDatabase(dbName string, owner string) error {
 		query += fmt.Sprintf(" OWNER %s", pgx.Identifier{owner}.Sanitize())
 	}
 
-	_, err = r.pool.Exec(context.Background(), query)
+	_, err = r.db.Exec(ctx, query)
 	if err != nil {
 		return fmt.Errorf("failed to create database: %w", err)
 	}
@@ -60,13 +50,13 @@ func (r *databaseRepository) CreateDatabase(dbName string, owner string) error {
 	return nil
 }
 
-func (r *databaseRepository) DatabaseExists(dbName string) (bool, error) {
+func (r *databaseRepository) DatabaseExists(ctx context.Context, dbName string) (bool, error) {
 	var exists bool
 	query := `SELECT EXISTS(
         SELECT 1 FROM pg_database WHERE datname = $1
     )`
 
-	err := r.pool.QueryRow(context.Background(), query, dbName).Scan(&exists)
+	err := r.db.QueryRow(ctx, query, dbName).Scan(&exists)
 	if err != nil {
 		return false, fmt.Errorf("failed to check database existence: %w", err)
 	}
@@ -74,10 +64,10 @@ func (r *databaseRepository) DatabaseExists(dbName string) (bool, error) {
 	return exists, nil
 }
 
-func (r *databaseRepository) ListDatabases() ([]string, error) {
+func (r *databaseRepository) ListDatabases(ctx context.Context) ([]string, error) {
 	query := `SELECT datname FROM pg_database WHERE datistemplate = false`
 
-	rows, err := r.pool.Query(context.Background(), query)
+	rows, err := r.db.Query(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query databases: %w", err)
 	}
@@ -99,6 +89,4 @@ func (r *databaseRepository) ListDatabases() ([]string, error) {
 	return databases, nil
 }
 
-func (r *databaseRepository) Close() {
-	r.pool.Close()
-}
+func (r *databaseRepository) Close() {}
@@ -0,0 +1,109 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+)
+
+type executionAttemptRepository struct {
+	db querier
+}
+
+var (
+	attemptsRepo *executionAttemptRepository
+	attemptsOnce sync.Once
+)
+
+func GetExecutionAttemptRepository() repository.ExecutionAttemptRepository {
+	attemptsOnce.Do(func() {
+		attemptsRepo = &executionAttemptRepository{db: GetMetadataPool()}
+	})
+
+	return attemptsRepo
+}
+
+// RecordAttempt numbers the attempt after the migration's previous ones. A migration's attempts never overlap, since
+// only one execution can hold it in running, so counting them is safe.
+func (r *executionAttemptRepository) RecordAttempt(ctx context.Context, attempt *api.ExecutionAttempt) error {
+	statements := attempt.Statements
+	if statements == nil {
+		statements = []*api.StatementResult{}
+	}
+	messages := attempt.Messages
+	if messages == nil {
+		messages = []*api.ExecutionMessage{}
+	}
+
+	query := `
+		INSERT INTO execution_attempts
+			(migrationId, number, startedAt, finishedAt, identity, host, sql, statements, messages, error)
+		SELECT $1, COUNT(*) + 1, $2, $3, $4, $5, $6, $7, $8, $9
+		FROM execution_attempts
+		WHERE migrationId = $1
+		RETURNING id, number`
+
+	err := r.db.QueryRow(ctx, query,
+		attempt.MigrationID,
+		attempt.StartedAt,
+		attempt.FinishedAt,
+		nullIfEmpty(attempt.Identity),
+		nullIfEmpty(attempt.Host),
+		attempt.SQL,
+		statements,
+		messages,
+		attempt.Error,
+	).Scan(&attempt.ID, &attempt.Number)
+	if err != nil {
+		return fmt.Errorf("failed to record execution attempt of migration %d: %w", attempt.MigrationID, err)
+	}
+
+	return nil
+}
+
+func (r *executionAttemptRepository) ListAttempts(ctx context.Context, migrationID uint64) ([]*api.ExecutionAttempt, error) {
+	query := `
+		SELECT id, migrationId, number, startedAt, finishedAt, COALESCE(identity, ''), COALESCE(host, ''), sql,
+			statements, messages, error
+		FROM execution_attempts
+		WHERE migrationId = $1
+		ORDER BY number ASC`
+	rows, err := r.db.Query(ctx, query, migrationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch execution attempts of migration %d: %w", migrationID, err)
+	}
+	defer rows.Close()
+
+	attempts := make([]*api.ExecutionAttempt, 0)
+	for rows.Next() {
+		attempt := &api.ExecutionAttempt{}
+		err := rows.Scan(
+			&attempt.ID,
+			&attempt.MigrationID,
+			&attempt.Number,
+			&attempt.StartedAt,
+			&attempt.FinishedAt,
+			&attempt.Identity,
+			&attempt.Host,
+			&attempt.SQL,
+			&attempt.Statements,
+			&attempt.Messages,
+			&attempt.Error,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan execution attempt of migration %d: %w", migrationID, err)
+		}
+		attempts = append(attempts, attempt)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating execution attempts of migration %d: %w", migrationID, err)
+	}
+
+	return attempts, nil
+}
+
+func (r *executionAttemptRepository) Close() {}
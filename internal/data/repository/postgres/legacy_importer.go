@@ -0,0 +1,291 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+	"github.com/dfryer1193/gomad/internal/data/utils"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrMetadataNotEmpty is returned when importing the legacy layout into a metadata database that already holds data
+var ErrMetadataNotEmpty = errors.New("metadata database is not empty")
+
+// legacyVersions are the schema versions the legacy migrations and secrets databases were left at by the last
+// release that used them. Older databases need to be upgraded by that release before they can be imported.
+var legacyVersions = map[string]int{"migrations": 6, "secrets": 1}
+
+// metadataTables lists the tables the importer fills, which must all be empty beforehand
+var metadataTables = []string{
+	"migrations", "migration_status_history", "webhook_secrets", "namespace_settings", "namespace_variables",
+	"repositories", "namespace_connections",
+}
+
+// legacyMigrationColumns matches migrationColumns for the legacy migrations table, which predates statuses and repo
+const legacyMigrationColumns = `id, namespace, "user", comment, ddl, COALESCE(renderedDdl, ''), createdAt, completedAt,
+		CASE WHEN completedAt IS NOT NULL THEN 'succeeded' WHEN shouldSkip THEN 'skipped' ELSE 'pending' END,
+		shouldSkip, '', COALESCE(lockTimeout, ''), COALESCE(statementTimeout, ''),
+		COALESCE(idleInTransactionSessionTimeout, ''), COALESCE(includes, '{}')`
+
+// ImportStats counts the rows copied by ImportLegacy
+type ImportStats struct {
+	Migrations           int
+	Secrets              int
+	NamespaceSettings    int
+	NamespaceVariables   int
+	RepositoryConfigs    int
+	NamespaceConnections int
+}
+
+// legacyData is everything read from the legacy databases
+type legacyData struct {
+	migrations  []*api.MigrationProto
+	completed   []*api.Migration
+	secrets     map[string]string
+	settings    []*api.NamespaceSettings
+	variables   map[string]map[string]string
+	configs     []*api.RepositoryConfig
+	connections []*api.NamespaceConnection
+}
+
+// ImportLegacy copies gomad's data from the legacy layout, where migrations and secrets were kept in separate
+// databases named migrations and secrets, into the metadata database. The copy is a single transaction and is
+// refused if the metadata database already holds data, so it can safely be rerun after a failure.
+func ImportLegacy(ctx context.Context) (*ImportStats, error) {
+	data, err := readLegacyData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &ImportStats{}
+	pool := GetMetadataPool()
+	err = pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		for _, table := range metadataTables {
+			var hasRows bool
+			if err := tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM `+pgx.Identifier{table}.Sanitize()+`)`).Scan(&hasRows); err != nil {
+				return fmt.Errorf("failed to check metadata table %s: %w", table, err)
+			}
+			if hasRows {
+				return fmt.Errorf("%w: table %s has rows", ErrMetadataNotEmpty, table)
+			}
+		}
+
+		return writeLegacyData(ctx, repositoriesOn(tx), data, stats)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+func readLegacyData(ctx context.Context) (*legacyData, error) {
+	migrationsDB, err := connectLegacy(ctx, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer migrationsDB.Close(context.Background())
+
+	secretsDB, err := connectLegacy(ctx, "secrets")
+	if err != nil {
+		return nil, err
+	}
+	defer secretsDB.Close(context.Background())
+
+	data := &legacyData{}
+	if data.migrations, data.completed, err = readLegacyMigrations(ctx, migrationsDB); err != nil {
+		return nil, err
+	}
+
+	settingsRepo := &namespaceSettingsRepository{db: migrationsDB}
+	namespaces, err := queryStrings(ctx, migrationsDB, `SELECT namespace FROM namespace_settings ORDER BY namespace`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list legacy namespace settings: %w", err)
+	}
+	for _, namespace := range namespaces {
+		settings, err := settingsRepo.GetSettings(ctx, namespace)
+		if err != nil {
+			return nil, err
+		}
+		data.settings = append(data.settings, settings)
+	}
+
+	variablesRepo := &namespaceVariablesRepository{db: migrationsDB}
+	namespaces, err = queryStrings(ctx, migrationsDB, `SELECT DISTINCT namespace FROM namespace_variables ORDER BY namespace`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list legacy namespace variables: %w", err)
+	}
+	data.variables = make(map[string]map[string]string, len(namespaces))
+	for _, namespace := range namespaces {
+		if data.variables[namespace], err = variablesRepo.GetVariables(ctx, namespace); err != nil {
+			return nil, err
+		}
+	}
+
+	if data.configs, err = (&repositoryConfigRepository{db: migrationsDB}).ListConfigs(ctx); err != nil {
+		return nil, err
+	}
+
+	if data.connections, err = (&namespaceConnectionRepository{db: migrationsDB}).ListConnections(ctx); err != nil {
+		return nil, err
+	}
+
+	if data.secrets, err = readLegacySecrets(ctx, secretsDB); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// connectLegacy connects to a legacy database on the default cluster, checking it's at the version the importer
+// reads
+func connectLegacy(ctx context.Context, database string) (*pgx.Conn, error) {
+	connString, err := utils.BuildConnectionString(database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build connection string for legacy database %s: %w", database, err)
+	}
+
+	conn, err := pgx.Connect(ctx, connString)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to legacy database %s: %w", database, err)
+	}
+
+	var version int
+	err = conn.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM gomad_schema_version`).Scan(&version)
+	if err != nil {
+		conn.Close(context.Background())
+		return nil, fmt.Errorf("failed to read schema version of legacy database %s: %w", database, err)
+	}
+
+	if version != legacyVersions[database] {
+		conn.Close(context.Background())
+		return nil, fmt.Errorf("legacy database %s is at schema version %d, but the importer reads version %d",
+			database, version, legacyVersions[database])
+	}
+
+	return conn, nil
+}
+
+// readLegacyMigrations returns every legacy migration as it was inserted, along with the ones that have completed
+func readLegacyMigrations(ctx context.Context, db querier) ([]*api.MigrationProto, []*api.Migration, error) {
+	migrations, err := (&migrationRepository{db: db}).queryMigrations(ctx,
+		`SELECT `+legacyMigrationColumns+` FROM migrations ORDER BY createdAt ASC`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read legacy migrations: %w", err)
+	}
+
+	protos := make([]*api.MigrationProto, 0, len(migrations))
+	completed := make([]*api.Migration, 0)
+	for _, m := range migrations {
+		protos = append(protos, &api.MigrationProto{
+			MigrationCommonFields: m.MigrationCommonFields,
+			Signature:             m.ID,
+			ShouldSkip:            m.Skipped,
+		})
+		if !m.CompletedAt.IsZero() {
+			completed = append(completed, m)
+		}
+	}
+
+	return protos, completed, nil
+}
+
+func readLegacySecrets(ctx context.Context, db querier) (map[string]string, error) {
+	rows, err := db.Query(ctx, `SELECT repo_name, secret FROM webhook_secrets`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read legacy webhook secrets: %w", err)
+	}
+	defer rows.Close()
+
+	secrets := make(map[string]string)
+	for rows.Next() {
+		var repoName, secret string
+		if err := rows.Scan(&repoName, &secret); err != nil {
+			return nil, fmt.Errorf("failed to scan legacy webhook secret: %w", err)
+		}
+		secrets[repoName] = secret
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating legacy webhook secret rows: %w", err)
+	}
+
+	return secrets, nil
+}
+
+// writeLegacyData stores the legacy data through the metadata repositories, counting what was written
+func writeLegacyData(ctx context.Context, repos repository.Repositories, data *legacyData, stats *ImportStats) error {
+	if err := repos.Migrations.BulkInsert(ctx, data.migrations); err != nil {
+		return err
+	}
+	for _, m := range data.completed {
+		// BulkInsert gave the migration its initial status, which has to be moved on from
+		from := api.MigrationStatusPending
+		if m.Skipped {
+			from = api.MigrationStatusSkipped
+		}
+		err := repos.Migrations.TransitionStatus(ctx, repository.StatusTransition{
+			ID:          m.ID,
+			From:        from,
+			To:          api.MigrationStatusSucceeded,
+			Reason:      "completed before the import from the legacy databases",
+			At:          m.CompletedAt,
+			RenderedDDL: m.RenderedDDL,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	stats.Migrations = len(data.migrations)
+
+	for repoName, secret := range data.secrets {
+		if _, err := repos.Secrets.InsertSecret(ctx, repoName, secret); err != nil {
+			return fmt.Errorf("failed to import secret for repository %s: %w", repoName, err)
+		}
+	}
+	stats.Secrets = len(data.secrets)
+
+	for _, settings := range data.settings {
+		if err := repos.NamespaceSettings.UpsertSettings(ctx, settings); err != nil {
+			return err
+		}
+	}
+	stats.NamespaceSettings = len(data.settings)
+
+	for namespace, vars := range data.variables {
+		for name, value := range vars {
+			if err := repos.NamespaceVariables.SetVariable(ctx, namespace, name, value); err != nil {
+				return err
+			}
+			stats.NamespaceVariables++
+		}
+	}
+
+	for _, config := range data.configs {
+		if err := repos.RepositoryConfigs.UpsertConfig(ctx, config); err != nil {
+			return err
+		}
+	}
+	stats.RepositoryConfigs = len(data.configs)
+
+	for _, conn := range data.connections {
+		if err := repos.NamespaceConnections.UpsertConnection(ctx, conn); err != nil {
+			return err
+		}
+	}
+	stats.NamespaceConnections = len(data.connections)
+
+	return nil
+}
+
+func queryStrings(ctx context.Context, db querier, query string) ([]string, error) {
+	rows, err := db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
@@ -0,0 +1,74 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+	"github.com/dfryer1193/gomad/internal/data/repository/memory"
+)
+
+func TestWriteLegacyData(t *testing.T) {
+	repos := repository.Repositories{
+		Migrations:           memory.NewMigrationRepository(),
+		Secrets:              memory.NewSecretsRepository(),
+		NamespaceSettings:    memory.NewNamespaceSettingsRepository(),
+		NamespaceVariables:   memory.NewNamespaceVariablesRepository(),
+		RepositoryConfigs:    memory.NewRepositoryConfigRepository(),
+		NamespaceConnections: memory.NewNamespaceConnectionRepository(),
+	}
+
+	createdAt := time.Now().Add(-time.Hour)
+	completedAt := time.Now()
+	done := &api.Migration{
+		MigrationCommonFields: api.MigrationCommonFields{Namespace: "ns1", DDL: "CREATE TABLE {{ t }} (id INT);", CreatedAt: createdAt},
+		ID:                    1,
+		RenderedDDL:           "CREATE TABLE users (id INT);",
+		CompletedAt:           completedAt,
+	}
+	data := &legacyData{
+		migrations: []*api.MigrationProto{
+			{MigrationCommonFields: done.MigrationCommonFields, Signature: 1},
+			{MigrationCommonFields: api.MigrationCommonFields{Namespace: "ns1", CreatedAt: createdAt}, Signature: 2},
+		},
+		completed:   []*api.Migration{done},
+		secrets:     map[string]string{"org/repo": "secret"},
+		settings:    []*api.NamespaceSettings{{Namespace: "ns1", Environment: "prod", LockRetries: 4}},
+		variables:   map[string]map[string]string{"ns1": {"t": "users", "schema": "app"}},
+		configs:     []*api.RepositoryConfig{{RepoName: "org/repo", Source: api.FileSourceMirror}},
+		connections: []*api.NamespaceConnection{{Namespace: "ns1", Driver: api.DriverPostgres, Host: "db"}},
+	}
+
+	stats := &ImportStats{}
+	if err := writeLegacyData(context.Background(), repos, data, stats); err != nil {
+		t.Fatalf("writeLegacyData(context.Background(), ) error = %v", err)
+	}
+
+	expected := ImportStats{Migrations: 2, Secrets: 1, NamespaceSettings: 1, NamespaceVariables: 2, RepositoryConfigs: 1, NamespaceConnections: 1}
+	if *stats != expected {
+		t.Errorf("stats = %+v, expected %+v", *stats, expected)
+	}
+
+	migration, _ := repos.Migrations.GetById(context.Background(), 1)
+	if migration == nil || migration.Status != api.MigrationStatusSucceeded || !migration.CompletedAt.Equal(completedAt) || migration.RenderedDDL != done.RenderedDDL {
+		t.Errorf("expected completed migration to keep its completion, got %+v", migration)
+	}
+	if pending, _ := repos.Migrations.GetById(context.Background(), 2); pending == nil || pending.Status != api.MigrationStatusPending {
+		t.Errorf("expected pending migration to stay pending, got %+v", pending)
+	}
+
+	if secret, err := repos.Secrets.GetSecret(context.Background(), "org/repo"); err != nil || secret != "secret" {
+		t.Errorf("GetSecret() = %q, %v", secret, err)
+	}
+	if settings, _ := repos.NamespaceSettings.GetSettings(context.Background(), "ns1"); settings.Environment != "prod" || settings.LockRetries != 4 {
+		t.Errorf("GetSettings() = %+v", settings)
+	}
+	if config, _ := repos.RepositoryConfigs.GetConfig(context.Background(), "org/repo"); config == nil || config.Source != api.FileSourceMirror {
+		t.Errorf("GetConfig() = %+v", config)
+	}
+	if conn, _ := repos.NamespaceConnections.GetConnection(context.Background(), "ns1"); conn == nil || conn.Host != "db" {
+		t.Errorf("GetConnection() = %+v", conn)
+	}
+}
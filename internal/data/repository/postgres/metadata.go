@@ -0,0 +1,91 @@
+package postgres
+
+import (
+	"context"
+	"sync"
+
+	"github.com/dfryer1193/gomad/internal/data/repository"
+	"github.com/dfryer1193/gomad/internal/data/utils"
+	"github.com/dfryer1193/gomad/internal/tracing"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+// querier is satisfied by both the metadata pool and a transaction on it, so the same repositories can run on
+// their own or as part of a transaction spanning several of them. Begin on a transaction starts a savepoint.
+type querier interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+}
+
+var (
+	metadataPool *pgxpool.Pool
+	metadataOnce sync.Once
+)
+
+// GetMetadataPool returns the pool shared by every repository of gomad's own data. The repositories' Close methods
+// leave it open; the server closes it once at shutdown.
+func GetMetadataPool() *pgxpool.Pool {
+	metadataOnce.Do(func() {
+		connString, err := utils.BuildMetadataConnectionString()
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to build connection string for metadata database")
+		}
+
+		config, err := pgxpool.ParseConfig(connString)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to parse connection string for metadata database")
+		}
+		// Every repository query gets a span of its own
+		config.ConnConfig.Tracer = tracing.QueryTracer{}
+
+		pool, err := pgxpool.NewWithConfig(context.Background(), config)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to create connection pool for metadata database")
+		}
+		metadataPool = pool
+	})
+
+	return metadataPool
+}
+
+type transactor struct {
+	pool *pgxpool.Pool
+}
+
+var (
+	metadataTransactor *transactor
+	transactorOnce     sync.Once
+)
+
+func GetTransactor() repository.Transactor {
+	transactorOnce.Do(func() {
+		metadataTransactor = &transactor{pool: GetMetadataPool()}
+	})
+
+	return metadataTransactor
+}
+
+func (t *transactor) InTx(ctx context.Context, fn func(repos repository.Repositories) error) error {
+	return pgx.BeginFunc(ctx, t.pool, func(tx pgx.Tx) error {
+		return fn(repositoriesOn(tx))
+	})
+}
+
+// repositoriesOn returns the metadata repositories bound to db
+func repositoriesOn(db querier) repository.Repositories {
+	return repository.Repositories{
+		Migrations:           &migrationRepository{db: db},
+		ExecutionAttempts:    &executionAttemptRepository{db: db},
+		Secrets:              &secretRepository{db: db},
+		NamespaceSettings:    &namespaceSettingsRepository{db: db},
+		NamespaceVariables:   &namespaceVariablesRepository{db: db},
+		RepositoryConfigs:    &repositoryConfigRepository{db: db},
+		NamespaceConnections: &namespaceConnectionRepository{db: db},
+	}
+}
@@ -2,18 +2,23 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/dfryer1193/gomad/api"
 	"github.com/dfryer1193/gomad/internal/data/repository"
-	"github.com/dfryer1193/gomad/internal/data/utils"
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/rs/zerolog/log"
+	"strings"
 	"sync"
+	"time"
 )
 
+// migrationColumns lists the columns scanned by queryMigrations, in scan order
+const migrationColumns = `id, namespace, "user", comment, ddl, COALESCE(renderedDdl, ''), createdAt, completedAt,
+		status, shouldSkip, COALESCE(repo, ''), COALESCE(lockTimeout, ''), COALESCE(statementTimeout, ''),
+		COALESCE(idleInTransactionSessionTimeout, ''), COALESCE(includes, '{}')`
+
 type migrationRepository struct {
-	pool *pgxpool.Pool
+	db querier
 }
 
 var (
@@ -23,32 +28,23 @@ var (
 
 func GetMigrationRepository() repository.MigrationRepository {
 	migrationOnce.Do(func() {
-		connString, err := utils.BuildConnectionString("migrations")
-		if err != nil {
-			log.Fatal().Err(err).Msg("failed to build connection string for migrations database")
-		}
-
-		pool, err := pgxpool.New(context.Background(), connString)
-		if err != nil {
-			log.Fatal().Err(err).Msg("failed to create connection pool for migrations database")
-		}
-		migrationRepo = &migrationRepository{pool: pool}
+		migrationRepo = &migrationRepository{db: GetMetadataPool()}
 	})
 	return migrationRepo
 }
 
-func (r *migrationRepository) GetFilteredBySignature(signatures []uint64) ([]*api.Migration, error) {
+func (r *migrationRepository) GetFilteredBySignature(ctx context.Context, signatures []uint64) ([]*api.Migration, error) {
 	if len(signatures) == 0 {
 		return []*api.Migration{}, nil
 	}
 
 	query := `
-		SELECT id, namespace, "user", comment, ddl, completedAt
+		SELECT ` + migrationColumns + `
 		FROM migrations
 		WHERE id = ANY($1)
-		ORDER BY Created ASC`
+		ORDER BY createdAt ASC, id ASC`
 
-	migrations, err := r.queryMigrations(query, signatures)
+	migrations, err := r.queryMigrations(ctx, query, signatures)
 	if err != nil {
 		return nil, err
 	}
@@ -56,26 +52,78 @@ func (r *migrationRepository) GetFilteredBySignature(signatures []uint64) ([]*ap
 	return migrations, nil
 }
 
-func (r *migrationRepository) GetAllForNamespace(namespace string) ([]*api.Migration, error) {
-	query := `
-		SELECT id, namespace, "user", comment, ddl, completedAt
-		FROM managers
-		WHERE namespace = $1
-		ORDER BY Created ASC`
-	migrations, err := r.queryMigrations(query, namespace)
+func (r *migrationRepository) ListForNamespace(ctx context.Context, query repository.MigrationQuery) ([]*api.Migration, error) {
+	sql, args := buildListQuery(query)
+	migrations, err := r.queryMigrations(ctx, sql, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch migrations with query %s: %w", query, err)
+		return nil, fmt.Errorf("failed to list migrations for namespace %s: %w", query.Namespace, err)
 	}
 
 	return migrations, nil
 }
 
-func (r *migrationRepository) GetById(id uint64) (*api.Migration, error) {
+// buildListQuery renders a migration query as SQL. Pages are read by keyset on (createdAt, id), which the
+// migrations_namespace_created_idx index and its status, user and repo counterparts serve.
+func buildListQuery(query repository.MigrationQuery) (string, []any) {
+	args := []any{query.Namespace}
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	conditions := []string{"namespace = $1"}
+	if len(query.Statuses) > 0 {
+		statuses := make([]string, 0, len(query.Statuses))
+		for _, status := range query.Statuses {
+			statuses = append(statuses, string(status))
+		}
+		conditions = append(conditions, "status = ANY("+arg(statuses)+")")
+	}
+	if query.User != "" {
+		conditions = append(conditions, `"user" = `+arg(query.User))
+	}
+	if query.Repo != "" {
+		conditions = append(conditions, "repo = "+arg(query.Repo))
+	}
+	if query.Search != "" {
+		conditions = append(conditions, "comment ILIKE '%' || "+arg(escapeLike(query.Search))+" || '%'")
+	}
+	if !query.CreatedFrom.IsZero() {
+		conditions = append(conditions, "createdAt >= "+arg(query.CreatedFrom))
+	}
+	if !query.CreatedTo.IsZero() {
+		conditions = append(conditions, "createdAt < "+arg(query.CreatedTo))
+	}
+
+	direction, comparison := "ASC", ">"
+	if query.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if query.After != nil {
+		conditions = append(conditions, fmt.Sprintf("(createdAt, id) %s (%s, %s)",
+			comparison, arg(query.After.CreatedAt), arg(query.After.ID)))
+	}
+
+	sql := `SELECT ` + migrationColumns + ` FROM migrations WHERE ` + strings.Join(conditions, " AND ") +
+		fmt.Sprintf(" ORDER BY createdAt %s, id %s", direction, direction)
+	if query.Limit > 0 {
+		sql += " LIMIT " + arg(query.Limit)
+	}
+
+	return sql, args
+}
+
+// escapeLike escapes LIKE's wildcards so s is matched literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func (r *migrationRepository) GetById(ctx context.Context, id uint64) (*api.Migration, error) {
 	query := `
-		SELECT id, namespace, "user", comment, ddl, completedAt
-		FROM managers
+		SELECT ` + migrationColumns + `
+		FROM migrations
 		WHERE id = $1`
-	migrations, err := r.queryMigrations(query, id)
+	migrations, err := r.queryMigrations(ctx, query, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch migrations with query %s: %w", query, err)
 	}
@@ -91,13 +139,18 @@ func (r *migrationRepository) GetById(id uint64) (*api.Migration, error) {
 	return migrations[0], nil
 }
 
-func (r *migrationRepository) BulkInsert(migrations []*api.MigrationProto) error {
+func (r *migrationRepository) BulkInsert(ctx context.Context, migrations []*api.MigrationProto) error {
 	if len(migrations) == 0 {
 		return nil
 	}
 
-	columns := []string{"id", "namespace", "user", "comment", "ddl", "createdAt", "shouldSkip"}
+	// CopyFrom quotes column names, so they must match the lowercase names postgres folds the schema's to
+	columns := []string{
+		"id", "namespace", "user", "comment", "ddl", "createdat", "shouldskip",
+		"locktimeout", "statementtimeout", "idleintransactionsessiontimeout", "includes", "repo", "status",
+	}
 	rows := make([][]any, len(migrations))
+	history := make([][]any, len(migrations))
 
 	for i, m := range migrations {
 		rows[i] = []any{
@@ -108,29 +161,125 @@ func (r *migrationRepository) BulkInsert(migrations []*api.MigrationProto) error
 			m.DDL,
 			m.CreatedAt,
 			m.ShouldSkip,
+			nullIfEmpty(m.Settings.LockTimeout),
+			nullIfEmpty(m.Settings.StatementTimeout),
+			nullIfEmpty(m.Settings.IdleInTransactionSessionTimeout),
+			m.Includes,
+			nullIfEmpty(m.Repo),
+			string(m.InitialStatus()),
 		}
+		history[i] = []any{m.Signature, string(m.InitialStatus()), "created", m.CreatedAt}
 	}
 
 	// Use CopyFrom for efficient bulk insert
-	_, err := r.pool.CopyFrom(
-		context.Background(),
-		pgx.Identifier{"migrations"},
-		columns,
-		pgx.CopyFromRows(rows),
-	)
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{"migrations"}, columns, pgx.CopyFromRows(rows)); err != nil {
+			return fmt.Errorf("failed to bulk insert migrations: %w", err)
+		}
+
+		historyColumns := []string{"migrationid", "tostatus", "reason", "changedat"}
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{"migration_status_history"}, historyColumns, pgx.CopyFromRows(history)); err != nil {
+			return fmt.Errorf("failed to record status of inserted migrations: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func (r *migrationRepository) TransitionStatus(ctx context.Context, transition repository.StatusTransition) error {
+	// The update and the history entry are a single statement, so neither is written without the other
+	query := `
+		WITH updated AS (
+			UPDATE migrations
+			SET status = $3,
+				completedAt = CASE WHEN $3 = 'succeeded' THEN $5 ELSE completedAt END,
+				renderedDdl = COALESCE($6, renderedDdl)
+			WHERE id = $1 AND status = $2
+			RETURNING id
+		)
+		INSERT INTO migration_status_history (migrationId, fromStatus, toStatus, reason, changedAt)
+		SELECT id, $2, $3, $4, $5 FROM updated`
+	tag, err := r.db.Exec(ctx, query, transition.ID, string(transition.From), string(transition.To),
+		nullIfEmpty(transition.Reason), transition.At, nullIfEmpty(transition.RenderedDDL))
 	if err != nil {
-		return fmt.Errorf("failed to bulk insert migrations: %w", err)
+		return fmt.Errorf("failed to move migration %d to %s: %w", transition.ID, transition.To, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		var status *string
+		err := r.db.QueryRow(ctx, `SELECT status FROM migrations WHERE id = $1`, transition.ID).Scan(&status)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("no migration with id %d", transition.ID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch status of migration %d: %w", transition.ID, err)
+		}
+		return fmt.Errorf("%w: migration %d is %s, not %s", repository.ErrStatusConflict, transition.ID, *status, transition.From)
 	}
 
 	return nil
 }
 
-func (r *migrationRepository) Close() {
-	r.pool.Close()
+func (r *migrationRepository) GetStatusHistory(ctx context.Context, id uint64) ([]*api.MigrationStatusChange, error) {
+	query := `
+		SELECT COALESCE(fromStatus, ''), toStatus, COALESCE(reason, ''), changedAt
+		FROM migration_status_history
+		WHERE migrationId = $1
+		ORDER BY id ASC`
+	rows, err := r.db.Query(ctx, query, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch status history of migration %d: %w", id, err)
+	}
+	defer rows.Close()
+
+	changes := make([]*api.MigrationStatusChange, 0)
+	for rows.Next() {
+		change := &api.MigrationStatusChange{}
+		if err := rows.Scan(&change.From, &change.To, &change.Reason, &change.ChangedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan status history of migration %d: %w", id, err)
+		}
+		changes = append(changes, change)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating status history of migration %d: %w", id, err)
+	}
+
+	return changes, nil
+}
+
+func (r *migrationRepository) CountByStatus(ctx context.Context) ([]*repository.MigrationStatusCount, error) {
+	query := `
+		SELECT namespace, status, COUNT(*)
+		FROM migrations
+		GROUP BY namespace, status
+		ORDER BY namespace, status`
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count migrations by status: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make([]*repository.MigrationStatusCount, 0)
+	for rows.Next() {
+		count := &repository.MigrationStatusCount{}
+		if err := rows.Scan(&count.Namespace, &count.Status, &count.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan migration counts: %w", err)
+		}
+		counts = append(counts, count)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating migration counts: %w", err)
+	}
+
+	return counts, nil
 }
 
-func (r *migrationRepository) queryMigrations(query string, args ...any) ([]*api.Migration, error) {
-	rows, err := r.pool.Query(context.Background(), query, args...)
+func (r *migrationRepository) Close() {}
+
+func (r *migrationRepository) queryMigrations(ctx context.Context, query string, args ...any) ([]*api.Migration, error) {
+	rows, err := r.db.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -139,17 +288,30 @@ func (r *migrationRepository) queryMigrations(query string, args ...any) ([]*api
 	var migrations []*api.Migration
 	for rows.Next() {
 		m := &api.Migration{}
+		var completedAt *time.Time
 		err := rows.Scan(
 			&m.ID,
 			&m.Namespace,
 			&m.User,
 			&m.Comment,
 			&m.DDL,
-			&m.CompletedAt,
+			&m.RenderedDDL,
+			&m.CreatedAt,
+			&completedAt,
+			&m.Status,
+			&m.Skipped,
+			&m.Repo,
+			&m.Settings.LockTimeout,
+			&m.Settings.StatementTimeout,
+			&m.Settings.IdleInTransactionSessionTimeout,
+			&m.Includes,
 		)
 		if err != nil {
 			return nil, err
 		}
+		if completedAt != nil {
+			m.CompletedAt = *completedAt
+		}
 		migrations = append(migrations, m)
 	}
 
@@ -159,3 +321,11 @@ func (r *migrationRepository) queryMigrations(query string, args ...any) ([]*api
 
 	return migrations, nil
 }
+
+// nullIfEmpty maps empty strings to NULL so optional columns stay unset
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,75 @@
+package postgres
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+)
+
+func TestBuildListQuery(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	after := &repository.MigrationCursor{CreatedAt: from, ID: 42}
+
+	testCases := []struct {
+		name         string
+		query        repository.MigrationQuery
+		wantClauses  []string
+		wantArgs     []any
+		missingWords []string
+	}{
+		{
+			name:         "namespace only",
+			query:        repository.MigrationQuery{Namespace: "ns1"},
+			wantClauses:  []string{"WHERE namespace = $1 ORDER BY createdAt ASC, id ASC"},
+			wantArgs:     []any{"ns1"},
+			missingWords: []string{"LIMIT"},
+		},
+		{
+			name: "every filter, descending after a cursor",
+			query: repository.MigrationQuery{
+				Namespace:   "ns1",
+				Statuses:    []api.MigrationStatus{api.MigrationStatusPending, api.MigrationStatusSkipped},
+				User:        "alice",
+				Repo:        "org/schema",
+				Search:      "100%_done",
+				CreatedFrom: from,
+				Descending:  true,
+				After:       after,
+				Limit:       51,
+			},
+			wantClauses: []string{
+				"status = ANY($2)",
+				`"user" = $3`,
+				"repo = $4",
+				"comment ILIKE '%' || $5 || '%'",
+				"createdAt >= $6",
+				"(createdAt, id) < ($7, $8)",
+				"ORDER BY createdAt DESC, id DESC LIMIT $9",
+			},
+			wantArgs: []any{"ns1", []string{"pending", "skipped"}, "alice", "org/schema", `100\%\_done`, from, from, uint64(42), 51},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sql, args := buildListQuery(tc.query)
+			for _, clause := range tc.wantClauses {
+				if !strings.Contains(sql, clause) {
+					t.Errorf("expected %q in %s", clause, sql)
+				}
+			}
+			for _, word := range tc.missingWords {
+				if strings.Contains(sql, word) {
+					t.Errorf("expected no %q in %s", word, sql)
+				}
+			}
+			if !reflect.DeepEqual(args, tc.wantArgs) {
+				t.Errorf("args = %v, want %v", args, tc.wantArgs)
+			}
+		})
+	}
+}
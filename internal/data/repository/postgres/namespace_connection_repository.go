@@ -0,0 +1,138 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+	"github.com/jackc/pgx/v5"
+)
+
+// namespaceConnectionColumns lists the columns scanned by scanNamespaceConnection, in scan order
+const namespaceConnectionColumns = `namespace, driver, host, port, database, COALESCE(schema, ''), username,
+	COALESCE(credentials_ref, ''), COALESCE(ssl_mode, ''), COALESCE(ssl_root_cert, '')`
+
+type namespaceConnectionRepository struct {
+	db querier
+}
+
+var (
+	connectionRepo *namespaceConnectionRepository
+	connectionOnce sync.Once
+)
+
+func GetNamespaceConnectionRepository() repository.NamespaceConnectionRepository {
+	connectionOnce.Do(func() {
+		connectionRepo = &namespaceConnectionRepository{db: GetMetadataPool()}
+	})
+
+	return connectionRepo
+}
+
+// GetConnection returns the connection registered for a namespace, or nil if it has none
+func (r *namespaceConnectionRepository) GetConnection(ctx context.Context, namespace string) (*api.NamespaceConnection, error) {
+	query := `SELECT ` + namespaceConnectionColumns + ` FROM namespace_connections WHERE namespace = $1`
+	conn, err := scanNamespaceConnection(r.db.QueryRow(ctx, query, namespace))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch connection for namespace %s: %w", namespace, err)
+	}
+
+	return conn, nil
+}
+
+func (r *namespaceConnectionRepository) ListConnections(ctx context.Context) ([]*api.NamespaceConnection, error) {
+	query := `SELECT ` + namespaceConnectionColumns + ` FROM namespace_connections ORDER BY namespace`
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query namespace connections: %w", err)
+	}
+	defer rows.Close()
+
+	conns := make([]*api.NamespaceConnection, 0)
+	for rows.Next() {
+		conn, err := scanNamespaceConnection(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan namespace connection: %w", err)
+		}
+		conns = append(conns, conn)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating namespace connection rows: %w", err)
+	}
+
+	return conns, nil
+}
+
+func (r *namespaceConnectionRepository) UpsertConnection(ctx context.Context, conn *api.NamespaceConnection) error {
+	query := `
+		INSERT INTO namespace_connections
+			(namespace, host, port, database, schema, username, credentials_ref, ssl_mode, ssl_root_cert, driver)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (namespace) DO UPDATE SET
+			driver = EXCLUDED.driver,
+			host = EXCLUDED.host,
+			port = EXCLUDED.port,
+			database = EXCLUDED.database,
+			schema = EXCLUDED.schema,
+			username = EXCLUDED.username,
+			credentials_ref = EXCLUDED.credentials_ref,
+			ssl_mode = EXCLUDED.ssl_mode,
+			ssl_root_cert = EXCLUDED.ssl_root_cert`
+
+	_, err := r.db.Exec(ctx, query,
+		conn.Namespace,
+		conn.Host,
+		conn.Port,
+		conn.Database,
+		nullIfEmpty(conn.Schema),
+		conn.User,
+		nullIfEmpty(conn.CredentialsRef),
+		nullIfEmpty(conn.SSLMode),
+		nullIfEmpty(conn.SSLRootCert),
+		conn.Driver,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save connection for namespace %s: %w", conn.Namespace, err)
+	}
+
+	return nil
+}
+
+func (r *namespaceConnectionRepository) DeleteConnection(ctx context.Context, namespace string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM namespace_connections WHERE namespace = $1`, namespace)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete connection for namespace %s: %w", namespace, err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *namespaceConnectionRepository) Close() {}
+
+func scanNamespaceConnection(row pgx.Row) (*api.NamespaceConnection, error) {
+	conn := &api.NamespaceConnection{}
+	err := row.Scan(
+		&conn.Namespace,
+		&conn.Driver,
+		&conn.Host,
+		&conn.Port,
+		&conn.Database,
+		&conn.Schema,
+		&conn.User,
+		&conn.CredentialsRef,
+		&conn.SSLMode,
+		&conn.SSLRootCert,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
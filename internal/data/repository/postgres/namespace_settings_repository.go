@@ -0,0 +1,114 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+	"github.com/jackc/pgx/v5"
+)
+
+type namespaceSettingsRepository struct {
+	db querier
+}
+
+var (
+	settingsRepo *namespaceSettingsRepository
+	settingsOnce sync.Once
+)
+
+func GetNamespaceSettingsRepository() repository.NamespaceSettingsRepository {
+	settingsOnce.Do(func() {
+		settingsRepo = &namespaceSettingsRepository{db: GetMetadataPool()}
+	})
+
+	return settingsRepo
+}
+
+// GetSettings returns the settings for a namespace, or the defaults if none have been saved
+func (r *namespaceSettingsRepository) GetSettings(ctx context.Context, namespace string) (*api.NamespaceSettings, error) {
+	query := `
+		SELECT COALESCE(lock_timeout, ''), COALESCE(statement_timeout, ''),
+			COALESCE(idle_in_transaction_session_timeout, ''), lock_retries, COALESCE(lint_rules, '{}'::jsonb),
+			COALESCE(environment, '')
+		FROM namespace_settings
+		WHERE namespace = $1`
+
+	settings := &api.NamespaceSettings{Namespace: namespace}
+	err := r.db.QueryRow(ctx, query, namespace).Scan(
+		&settings.Session.LockTimeout,
+		&settings.Session.StatementTimeout,
+		&settings.Session.IdleInTransactionSessionTimeout,
+		&settings.LockRetries,
+		&settings.LintRules,
+		&settings.Environment,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &api.NamespaceSettings{Namespace: namespace, LockRetries: api.DefaultLockRetries}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch settings for namespace %s: %w", namespace, err)
+	}
+
+	return settings, nil
+}
+
+func (r *namespaceSettingsRepository) UpsertSettings(ctx context.Context, settings *api.NamespaceSettings) error {
+	query := `
+		INSERT INTO namespace_settings
+			(namespace, lock_timeout, statement_timeout, idle_in_transaction_session_timeout, lock_retries, lint_rules,
+			environment)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (namespace) DO UPDATE SET
+			lock_timeout = EXCLUDED.lock_timeout,
+			statement_timeout = EXCLUDED.statement_timeout,
+			idle_in_transaction_session_timeout = EXCLUDED.idle_in_transaction_session_timeout,
+			lock_retries = EXCLUDED.lock_retries,
+			lint_rules = EXCLUDED.lint_rules,
+			environment = EXCLUDED.environment`
+
+	_, err := r.db.Exec(ctx, query,
+		settings.Namespace,
+		nullIfEmpty(settings.Session.LockTimeout),
+		nullIfEmpty(settings.Session.StatementTimeout),
+		nullIfEmpty(settings.Session.IdleInTransactionSessionTimeout),
+		settings.LockRetries,
+		settings.LintRules,
+		nullIfEmpty(settings.Environment),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save settings for namespace %s: %w", settings.Namespace, err)
+	}
+
+	return nil
+}
+
+// ListNamespacesByEnvironment returns the namespaces tagged with any of the environments
+func (r *namespaceSettingsRepository) ListNamespacesByEnvironment(ctx context.Context, environments []string) ([]string, error) {
+	query := `SELECT namespace FROM namespace_settings WHERE environment = ANY($1) ORDER BY namespace`
+	rows, err := r.db.Query(ctx, query, environments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query namespaces for environments %v: %w", environments, err)
+	}
+	defer rows.Close()
+
+	namespaces := make([]string, 0)
+	for rows.Next() {
+		var namespace string
+		if err := rows.Scan(&namespace); err != nil {
+			return nil, fmt.Errorf("failed to scan namespace: %w", err)
+		}
+		namespaces = append(namespaces, namespace)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating namespace rows: %w", err)
+	}
+
+	return namespaces, nil
+}
+
+func (r *namespaceSettingsRepository) Close() {}
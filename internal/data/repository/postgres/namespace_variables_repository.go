@@ -0,0 +1,74 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/dfryer1193/gomad/internal/data/repository"
+)
+
+type namespaceVariablesRepository struct {
+	db querier
+}
+
+var (
+	variablesRepo *namespaceVariablesRepository
+	variablesOnce sync.Once
+)
+
+func GetNamespaceVariablesRepository() repository.NamespaceVariablesRepository {
+	variablesOnce.Do(func() {
+		variablesRepo = &namespaceVariablesRepository{db: GetMetadataPool()}
+	})
+
+	return variablesRepo
+}
+
+func (r *namespaceVariablesRepository) GetVariables(ctx context.Context, namespace string) (map[string]string, error) {
+	query := `SELECT name, value FROM namespace_variables WHERE namespace = $1`
+	rows, err := r.db.Query(ctx, query, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query variables for namespace %s: %w", namespace, err)
+	}
+	defer rows.Close()
+
+	vars := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan variable: %w", err)
+		}
+		vars[name] = value
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating variable rows: %w", err)
+	}
+
+	return vars, nil
+}
+
+func (r *namespaceVariablesRepository) SetVariable(ctx context.Context, namespace string, name string, value string) error {
+	query := `
+		INSERT INTO namespace_variables (namespace, name, value) VALUES ($1, $2, $3)
+		ON CONFLICT (namespace, name) DO UPDATE SET value = EXCLUDED.value`
+	_, err := r.db.Exec(ctx, query, namespace, name, value)
+	if err != nil {
+		return fmt.Errorf("failed to set variable %s for namespace %s: %w", name, namespace, err)
+	}
+
+	return nil
+}
+
+func (r *namespaceVariablesRepository) DeleteVariable(ctx context.Context, namespace string, name string) (bool, error) {
+	query := `DELETE FROM namespace_variables WHERE namespace = $1 AND name = $2`
+	tag, err := r.db.Exec(ctx, query, namespace, name)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete variable %s for namespace %s: %w", name, namespace, err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *namespaceVariablesRepository) Close() {}
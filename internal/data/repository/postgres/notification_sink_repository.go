@@ -0,0 +1,165 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+	"github.com/jackc/pgx/v5"
+)
+
+// notificationSinkColumns lists the columns scanned by scanNotificationSink, in scan order
+const notificationSinkColumns = `name, kind, namespaces, events, COALESCE(url, ''), COALESCE(secret, ''),
+	COALESCE(smtp_host, ''), COALESCE(smtp_port, 0), COALESCE(smtp_from, ''), COALESCE(smtp_to, '{}'),
+	COALESCE(smtp_username, ''), COALESCE(credentials_ref, '')`
+
+type notificationSinkRepository struct {
+	db querier
+}
+
+var (
+	sinkRepo *notificationSinkRepository
+	sinkOnce sync.Once
+)
+
+func GetNotificationSinkRepository() repository.NotificationSinkRepository {
+	sinkOnce.Do(func() {
+		sinkRepo = &notificationSinkRepository{db: GetMetadataPool()}
+	})
+
+	return sinkRepo
+}
+
+// GetSink returns the sink with the name, or nil if there's none
+func (r *notificationSinkRepository) GetSink(ctx context.Context, name string) (*api.NotificationSink, error) {
+	query := `SELECT ` + notificationSinkColumns + ` FROM notification_sinks WHERE name = $1`
+	sink, err := scanNotificationSink(r.db.QueryRow(ctx, query, name))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch notification sink %s: %w", name, err)
+	}
+
+	return sink, nil
+}
+
+func (r *notificationSinkRepository) ListSinks(ctx context.Context) ([]*api.NotificationSink, error) {
+	query := `SELECT ` + notificationSinkColumns + ` FROM notification_sinks ORDER BY name`
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notification sinks: %w", err)
+	}
+	defer rows.Close()
+
+	sinks := make([]*api.NotificationSink, 0)
+	for rows.Next() {
+		sink, err := scanNotificationSink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan notification sink: %w", err)
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating notification sink rows: %w", err)
+	}
+
+	return sinks, nil
+}
+
+func (r *notificationSinkRepository) UpsertSink(ctx context.Context, sink *api.NotificationSink) error {
+	query := `
+		INSERT INTO notification_sinks
+			(name, kind, namespaces, events, url, secret, smtp_host, smtp_port, smtp_from, smtp_to, smtp_username,
+			credentials_ref)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (name) DO UPDATE SET
+			kind = EXCLUDED.kind,
+			namespaces = EXCLUDED.namespaces,
+			events = EXCLUDED.events,
+			url = EXCLUDED.url,
+			secret = EXCLUDED.secret,
+			smtp_host = EXCLUDED.smtp_host,
+			smtp_port = EXCLUDED.smtp_port,
+			smtp_from = EXCLUDED.smtp_from,
+			smtp_to = EXCLUDED.smtp_to,
+			smtp_username = EXCLUDED.smtp_username,
+			credentials_ref = EXCLUDED.credentials_ref`
+
+	events := make([]string, 0, len(sink.Events))
+	for _, event := range sink.Events {
+		events = append(events, string(event))
+	}
+	namespaces := sink.Namespaces
+	if namespaces == nil {
+		namespaces = []string{}
+	}
+	var smtpPort any
+	if sink.SMTPPort != 0 {
+		smtpPort = sink.SMTPPort
+	}
+
+	_, err := r.db.Exec(ctx, query,
+		sink.Name,
+		sink.Kind,
+		namespaces,
+		events,
+		nullIfEmpty(sink.URL),
+		nullIfEmpty(sink.Secret),
+		nullIfEmpty(sink.SMTPHost),
+		smtpPort,
+		nullIfEmpty(sink.From),
+		sink.To,
+		nullIfEmpty(sink.Username),
+		nullIfEmpty(sink.CredentialsRef),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save notification sink %s: %w", sink.Name, err)
+	}
+
+	return nil
+}
+
+func (r *notificationSinkRepository) DeleteSink(ctx context.Context, name string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM notification_sinks WHERE name = $1`, name)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete notification sink %s: %w", name, err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *notificationSinkRepository) Close() {}
+
+func scanNotificationSink(row pgx.Row) (*api.NotificationSink, error) {
+	sink := &api.NotificationSink{}
+	var events []string
+	err := row.Scan(
+		&sink.Name,
+		&sink.Kind,
+		&sink.Namespaces,
+		&events,
+		&sink.URL,
+		&sink.Secret,
+		&sink.SMTPHost,
+		&sink.SMTPPort,
+		&sink.From,
+		&sink.To,
+		&sink.Username,
+		&sink.CredentialsRef,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, event := range events {
+		sink.Events = append(sink.Events, api.EventType(event))
+	}
+	sink.HasSecret = sink.Secret != ""
+
+	return sink, nil
+}
@@ -0,0 +1,119 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+	"github.com/jackc/pgx/v5"
+)
+
+// repositoryConfigColumns lists the columns scanned by scanRepositoryConfig, in scan order
+const repositoryConfigColumns = `repo_name, source, COALESCE(remote_url, ''), COALESCE(api_base_url, ''),
+	COALESCE(token, ''), COALESCE(ca_bundle, '')`
+
+type repositoryConfigRepository struct {
+	db querier
+}
+
+var (
+	repoConfigRepo *repositoryConfigRepository
+	repoConfigOnce sync.Once
+)
+
+func GetRepositoryConfigRepository() repository.RepositoryConfigRepository {
+	repoConfigOnce.Do(func() {
+		repoConfigRepo = &repositoryConfigRepository{db: GetMetadataPool()}
+	})
+
+	return repoConfigRepo
+}
+
+// GetConfig returns the config for a repository, or nil if it has none
+func (r *repositoryConfigRepository) GetConfig(ctx context.Context, repoName string) (*api.RepositoryConfig, error) {
+	query := `SELECT ` + repositoryConfigColumns + ` FROM repositories WHERE repo_name = $1`
+	config, err := scanRepositoryConfig(r.db.QueryRow(ctx, query, repoName))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch config for repository %s: %w", repoName, err)
+	}
+
+	return config, nil
+}
+
+func (r *repositoryConfigRepository) ListConfigs(ctx context.Context) ([]*api.RepositoryConfig, error) {
+	query := `SELECT ` + repositoryConfigColumns + ` FROM repositories ORDER BY repo_name`
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query repository configs: %w", err)
+	}
+	defer rows.Close()
+
+	configs := make([]*api.RepositoryConfig, 0)
+	for rows.Next() {
+		config, err := scanRepositoryConfig(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan repository config: %w", err)
+		}
+		configs = append(configs, config)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating repository config rows: %w", err)
+	}
+
+	return configs, nil
+}
+
+func (r *repositoryConfigRepository) UpsertConfig(ctx context.Context, config *api.RepositoryConfig) error {
+	query := `
+		INSERT INTO repositories (repo_name, source, remote_url, api_base_url, token, ca_bundle)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (repo_name) DO UPDATE SET
+			source = EXCLUDED.source,
+			remote_url = EXCLUDED.remote_url,
+			api_base_url = EXCLUDED.api_base_url,
+			token = EXCLUDED.token,
+			ca_bundle = EXCLUDED.ca_bundle`
+
+	_, err := r.db.Exec(ctx, query,
+		config.RepoName,
+		config.Source,
+		nullIfEmpty(config.RemoteURL),
+		nullIfEmpty(config.APIBaseURL),
+		nullIfEmpty(config.Token),
+		nullIfEmpty(config.CABundle),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save config for repository %s: %w", config.RepoName, err)
+	}
+
+	return nil
+}
+
+func (r *repositoryConfigRepository) DeleteConfig(ctx context.Context, repoName string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM repositories WHERE repo_name = $1`, repoName)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete config for repository %s: %w", repoName, err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *repositoryConfigRepository) Close() {}
+
+func scanRepositoryConfig(row pgx.Row) (*api.RepositoryConfig, error) {
+	config := &api.RepositoryConfig{}
+	err := row.Scan(&config.RepoName, &config.Source, &config.RemoteURL, &config.APIBaseURL, &config.Token, &config.CABundle)
+	if err != nil {
+		return nil, err
+	}
+	config.HasToken = config.Token != ""
+
+	return config, nil
+}
@@ -3,14 +3,10 @@ package postgres
 import (
 	"context"
 	"sync"
-
-	"github.com/dfryer1193/gomad/internal/data/utils"
-	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/rs/zerolog/log"
 )
 
 type secretRepository struct {
-	pool *pgxpool.Pool
+	db querier
 }
 
 var (
@@ -20,25 +16,16 @@ var (
 
 func GetSecretsRepository() *secretRepository {
 	secretsOnce.Do(func() {
-		connString, err := utils.BuildConnectionString("secrets")
-		if err != nil {
-			log.Fatal().Err(err).Msg("failed to build connection string for secrets database")
-		}
-
-		pool, err := pgxpool.New(context.Background(), connString)
-		if err != nil {
-			log.Fatal().Err(err).Msg("failed to create connection pool for secrets database")
-		}
-		secretsRepo = &secretRepository{pool: pool}
+		secretsRepo = &secretRepository{db: GetMetadataPool()}
 	})
 
 	return secretsRepo
 }
 
-func (r *secretRepository) InsertSecret(repoName string, secret string) (string, error) {
+func (r *secretRepository) InsertSecret(ctx context.Context, repoName string, secret string) (string, error) {
 	query := `INSERT INTO webhook_secrets (repo_name, secret) VALUES ($1, $2) RETURNING secret`
 	var savedSecret string
-	err := r.pool.QueryRow(context.Background(), query, repoName, secret).Scan(&savedSecret)
+	err := r.db.QueryRow(ctx, query, repoName, secret).Scan(&savedSecret)
 	if err != nil {
 		return "", err
 	}
@@ -46,10 +33,10 @@ func (r *secretRepository) InsertSecret(repoName string, secret string) (string,
 	return savedSecret, nil
 }
 
-func (r *secretRepository) GetSecret(repoName string) (string, error) {
+func (r *secretRepository) GetSecret(ctx context.Context, repoName string) (string, error) {
 	query := `SELECT secret FROM webhook_secrets WHERE repo_name = $1`
 	var secret string
-	err := r.pool.QueryRow(context.Background(), query, repoName).Scan(&secret)
+	err := r.db.QueryRow(ctx, query, repoName).Scan(&secret)
 	if err != nil {
 		return "", err
 	}
@@ -57,6 +44,4 @@ func (r *secretRepository) GetSecret(repoName string) (string, error) {
 	return secret, nil
 }
 
-func (r *secretRepository) Close() {
-	r.pool.Close()
-}
+func (r *secretRepository) Close() {}
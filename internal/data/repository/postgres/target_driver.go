@@ -0,0 +1,411 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+	"github.com/dfryer1193/gomad/internal/data/utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// lockNotAvailable is the SQLSTATE raised when lock_timeout expires
+const lockNotAvailable = "55P03"
+
+// nonTransactionalPattern matches statements postgres refuses to run inside a transaction block
+var nonTransactionalPattern = regexp.MustCompile(`(?is)^(?:(?:CREATE (?:UNIQUE )?|DROP )INDEX CONCURRENTLY\b|REINDEX\b.*\bCONCURRENTLY\b|VACUUM\b|(?:CREATE|DROP) DATABASE\b|ALTER SYSTEM\b|(?:CREATE|DROP) TABLESPACE\b)`)
+
+// execer runs a statement, in a transaction or on a connection of its own
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+type targetDriver struct {
+	mu    sync.Mutex
+	pools map[string]*namespacePool
+	// attempts maps the connections running migrations to the attempts their notices are collected into
+	attempts map[*pgconn.PgConn]*api.ExecutionAttempt
+}
+
+// namespacePool is a namespace's pool along with the connection string it was opened with, so the pool can be
+// replaced when the namespace's connection changes
+type namespacePool struct {
+	pool       *pgxpool.Pool
+	connString string
+}
+
+var (
+	targetDrv  *targetDriver
+	targetOnce sync.Once
+)
+
+// GetTargetDriver returns the driver for namespaces backed by postgres
+func GetTargetDriver() repository.TargetDriver {
+	return getTargetDriver()
+}
+
+func getTargetDriver() *targetDriver {
+	targetOnce.Do(func() {
+		targetDrv = newTargetDriver()
+	})
+
+	return targetDrv
+}
+
+// TargetPoolStats reports the statistics of the pool open for each namespace backed by postgres
+func TargetPoolStats() map[string]*pgxpool.Stat {
+	return getTargetDriver().poolStats()
+}
+
+func newTargetDriver() *targetDriver {
+	return &targetDriver{
+		pools:    make(map[string]*namespacePool),
+		attempts: make(map[*pgconn.PgConn]*api.ExecutionAttempt),
+	}
+}
+
+// ExecuteMigration runs the DDL in a single transaction against the namespace's database, applying the session
+// settings with SET LOCAL first so they only last for the migration. Statements are sent one at a time so each can be
+// timed, and the notices they raise are collected into the attempt. DDL that can't run in a transaction block, such
+// as CREATE INDEX CONCURRENTLY, is run without one instead.
+func (d *targetDriver) ExecuteMigration(ctx context.Context, conn *api.NamespaceConnection, ddl string, settings api.SessionSettings, attempt *api.ExecutionAttempt) error {
+	pool, err := d.getPool(conn)
+	if err != nil {
+		return err
+	}
+
+	session, err := pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to namespace %s: %w", conn.Namespace, err)
+	}
+	defer session.Release()
+
+	attempt.Identity = session.Conn().Config().User
+	defer d.collectNotices(session.Conn().PgConn(), attempt)()
+
+	ddlStatements := utils.SplitStatements(ddl)
+	if slices.ContainsFunc(ddlStatements, nonTransactional) {
+		err := executeWithoutTransaction(ctx, session, ddlStatements, settings, attempt)
+		if errors.Is(err, errSettingsNotReset) {
+			// The connection can't go back to the pool with the migration's timeouts still set
+			session.Conn().Close(context.WithoutCancel(ctx))
+		}
+		return err
+	}
+
+	tx, err := session.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction in namespace %s: %w", conn.Namespace, err)
+	}
+	// Rolling back has to go through even when ctx was cancelled mid-migration
+	defer tx.Rollback(context.WithoutCancel(ctx))
+
+	statements, _, err := setStatements(settings, "LOCAL")
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range statements {
+		if err := execStatement(ctx, tx, stmt, attempt); err != nil {
+			return fmt.Errorf("failed to apply session setting %q: %w", stmt, err)
+		}
+	}
+
+	for _, stmt := range ddlStatements {
+		if err := execStatement(ctx, tx, stmt, attempt); err != nil {
+			return wrapExecError(err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		attempt.Error = executionError(err, "COMMIT")
+		return fmt.Errorf("failed to commit migration: %w", wrapExecError(err))
+	}
+
+	return nil
+}
+
+// errSettingsNotReset is returned when a connection's session settings couldn't be put back after a migration
+var errSettingsNotReset = errors.New("failed to reset session settings")
+
+// executeWithoutTransaction runs the DDL one statement at a time outside of a transaction, so each statement commits
+// on its own: if one fails, the ones before it stay applied and the error says how many there were. The session
+// settings are applied with SET and reset afterwards, since the connection goes back to the pool.
+func executeWithoutTransaction(ctx context.Context, session execer, ddlStatements []string, settings api.SessionSettings, attempt *api.ExecutionAttempt) (err error) {
+	statements, reset, err := setStatements(settings, "SESSION")
+	if err != nil {
+		return err
+	}
+
+	// Resetting has to go through even when ctx was cancelled mid-migration
+	defer func() {
+		for _, stmt := range reset {
+			if _, resetErr := session.Exec(context.WithoutCancel(ctx), stmt); resetErr != nil {
+				err = errors.Join(err, fmt.Errorf("%w: %w", errSettingsNotReset, resetErr))
+				return
+			}
+		}
+	}()
+
+	for _, stmt := range statements {
+		if err := execStatement(ctx, session, stmt, attempt); err != nil {
+			return fmt.Errorf("failed to apply session setting %q: %w", stmt, err)
+		}
+	}
+
+	for idx, stmt := range ddlStatements {
+		if err := execStatement(ctx, session, stmt, attempt); err != nil {
+			// Retrying reruns the whole migration, so a lock timeout is only retryable while nothing has committed
+			if idx == 0 {
+				err = wrapExecError(err)
+			}
+			return fmt.Errorf("statement %d of %d failed, %d earlier statements were committed: %w",
+				idx+1, len(ddlStatements), idx, err)
+		}
+	}
+
+	return nil
+}
+
+// nonTransactional reports whether a statement has to run outside of a transaction block
+func nonTransactional(stmt string) bool {
+	return nonTransactionalPattern.MatchString(stmt)
+}
+
+// execStatement runs a statement of a migration, recording how it went in the attempt
+func execStatement(ctx context.Context, session execer, stmt string, attempt *api.ExecutionAttempt) error {
+	start := time.Now()
+	tag, err := session.Exec(ctx, stmt)
+	attempt.AddStatement(stmt, time.Since(start), tag.RowsAffected(), err != nil)
+	if err != nil {
+		attempt.Error = executionError(err, stmt)
+	}
+
+	return err
+}
+
+// executionError describes a failed statement, with the fields of the server's error report when there is one
+func executionError(err error, stmt string) *api.ExecutionError {
+	execErr := &api.ExecutionError{Message: err.Error(), Statement: stmt}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		execErr.Message = pgErr.Message
+		execErr.SQLState = pgErr.Code
+		execErr.Detail = pgErr.Detail
+		execErr.Hint = pgErr.Hint
+		execErr.Position = int(pgErr.Position)
+	}
+
+	return execErr
+}
+
+// collectNotices sends the notices raised on a connection to the attempt until the returned func is called
+func (d *targetDriver) collectNotices(pgConn *pgconn.PgConn, attempt *api.ExecutionAttempt) func() {
+	d.mu.Lock()
+	d.attempts[pgConn] = attempt
+	d.mu.Unlock()
+
+	return func() {
+		d.mu.Lock()
+		delete(d.attempts, pgConn)
+		d.mu.Unlock()
+	}
+}
+
+// handleNotice is the notice handler of every namespace's pool. Notices raised outside of a migration are dropped.
+func (d *targetDriver) handleNotice(pgConn *pgconn.PgConn, notice *pgconn.Notice) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	attempt, ok := d.attempts[pgConn]
+	if !ok {
+		return
+	}
+
+	attempt.AddMessage(&api.ExecutionMessage{
+		Severity: notice.Severity,
+		Code:     notice.Code,
+		Message:  notice.Message,
+		Detail:   notice.Detail,
+		Hint:     notice.Hint,
+	})
+}
+
+func (d *targetDriver) EstimateTableRows(ctx context.Context, conn *api.NamespaceConnection, table string) (int64, repository.TableEstimate, error) {
+	pool, err := d.getPool(conn)
+	if err != nil {
+		return 0, repository.TableMissing, err
+	}
+
+	query := `SELECT reltuples::bigint FROM pg_class WHERE oid = to_regclass($1)`
+	var rows int64
+	err = pool.QueryRow(ctx, query, table).Scan(&rows)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, repository.TableMissing, nil
+	}
+	if err != nil {
+		return 0, repository.TableMissing, fmt.Errorf("failed to estimate rows for table %s: %w", table, err)
+	}
+
+	// Tables that have never been vacuumed or analyzed report -1
+	if rows < 0 {
+		return 0, repository.TableSizeUnknown, nil
+	}
+
+	return rows, repository.TableSizeKnown, nil
+}
+
+func (d *targetDriver) DatabaseExists(ctx context.Context, conn *api.NamespaceConnection) (bool, error) {
+	server, err := connectMaintenance(ctx, conn)
+	if err != nil {
+		return false, err
+	}
+	defer server.Close(context.Background())
+
+	var exists bool
+	query := `SELECT EXISTS(SELECT 1 FROM pg_database WHERE datname = $1)`
+	if err := server.QueryRow(ctx, query, conn.Database).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check database existence: %w", err)
+	}
+
+	return exists, nil
+}
+
+func (d *targetDriver) CreateDatabase(ctx context.Context, conn *api.NamespaceConnection) error {
+	server, err := connectMaintenance(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer server.Close(context.Background())
+
+	query := fmt.Sprintf("CREATE DATABASE %s", pgx.Identifier{conn.Database}.Sanitize())
+	if _, err := server.Exec(ctx, query); err != nil {
+		return fmt.Errorf("failed to create database %s: %w", conn.Database, err)
+	}
+
+	return nil
+}
+
+func (d *targetDriver) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for namespace, pool := range d.pools {
+		pool.pool.Close()
+		delete(d.pools, namespace)
+	}
+}
+
+func (d *targetDriver) poolStats() map[string]*pgxpool.Stat {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stats := make(map[string]*pgxpool.Stat, len(d.pools))
+	for namespace, pool := range d.pools {
+		stats[namespace] = pool.pool.Stat()
+	}
+	return stats
+}
+
+// getPool returns the pool for a namespace's database, replacing it if the namespace's connection or credentials
+// have changed since it was opened
+func (d *targetDriver) getPool(conn *api.NamespaceConnection) (*pgxpool.Pool, error) {
+	connString, err := utils.BuildNamespaceConnectionString(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if existing, ok := d.pools[conn.Namespace]; ok {
+		if existing.connString == connString {
+			return existing.pool, nil
+		}
+		existing.pool.Close()
+		delete(d.pools, conn.Namespace)
+	}
+
+	config, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse connection string for namespace %s: %w", conn.Namespace, err)
+	}
+	config.ConnConfig.OnNotice = d.handleNotice
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connection pool for namespace %s: %w", conn.Namespace, err)
+	}
+
+	d.pools[conn.Namespace] = &namespacePool{pool: pool, connString: connString}
+	return pool, nil
+}
+
+// connectMaintenance connects to the postgres maintenance database on the namespace's server, for creating and
+// checking the namespace's own database
+func connectMaintenance(ctx context.Context, conn *api.NamespaceConnection) (*pgx.Conn, error) {
+	maintenance := *conn
+	maintenance.Database = "postgres"
+	maintenance.Schema = ""
+
+	connString, err := utils.BuildNamespaceConnectionString(&maintenance)
+	if err != nil {
+		return nil, err
+	}
+
+	server, err := pgx.Connect(ctx, connString)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to server of namespace %s: %w", conn.Namespace, err)
+	}
+
+	return server, nil
+}
+
+// setStatements converts the configured timeouts into SET statements of the scope, LOCAL or SESSION, in milliseconds,
+// along with the statements resetting them
+func setStatements(settings api.SessionSettings, scope string) ([]string, []string, error) {
+	timeouts := []struct {
+		name  string
+		value string
+	}{
+		{"lock_timeout", settings.LockTimeout},
+		{"statement_timeout", settings.StatementTimeout},
+		{"idle_in_transaction_session_timeout", settings.IdleInTransactionSessionTimeout},
+	}
+
+	statements := make([]string, 0, len(timeouts))
+	reset := make([]string, 0, len(timeouts))
+	for _, timeout := range timeouts {
+		if timeout.value == "" {
+			continue
+		}
+
+		d, err := time.ParseDuration(timeout.value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid %s %q: %w", timeout.name, timeout.value, err)
+		}
+		statements = append(statements, fmt.Sprintf("SET %s %s = %d", scope, timeout.name, d.Milliseconds()))
+		reset = append(reset, "RESET "+timeout.name)
+	}
+
+	return statements, reset, nil
+}
+
+// wrapExecError marks lock timeouts with repository.ErrLockTimeout so callers can retry them
+func wrapExecError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == lockNotAvailable {
+		return fmt.Errorf("%w: %w", repository.ErrLockTimeout, err)
+	}
+
+	return err
+}
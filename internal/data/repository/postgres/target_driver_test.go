@@ -0,0 +1,139 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestHandleNotice(t *testing.T) {
+	d := newTargetDriver()
+	migrating, idle := new(pgconn.PgConn), new(pgconn.PgConn)
+	attempt := &api.ExecutionAttempt{}
+
+	stop := d.collectNotices(migrating, attempt)
+	d.handleNotice(migrating, &pgconn.Notice{Severity: "NOTICE", Code: "00000", Message: `relation "t" already exists, skipping`})
+	d.handleNotice(idle, &pgconn.Notice{Severity: "WARNING", Message: "not part of the migration"})
+	stop()
+	d.handleNotice(migrating, &pgconn.Notice{Severity: "NOTICE", Message: "raised after the migration"})
+
+	if len(attempt.Messages) != 1 || attempt.Messages[0].Severity != "NOTICE" || attempt.Messages[0].Code != "00000" {
+		t.Errorf("expected only the notice raised during the migration, got %+v", attempt.Messages)
+	}
+	if len(d.attempts) != 0 {
+		t.Errorf("expected the connection to be forgotten, got %d", len(d.attempts))
+	}
+}
+
+func TestExecutionError(t *testing.T) {
+	pgErr := &pgconn.PgError{
+		Code:     "42P07",
+		Message:  `relation "t" already exists`,
+		Detail:   "detail",
+		Hint:     "hint",
+		Position: 14,
+	}
+	got := executionError(fmt.Errorf("wrapped: %w", pgErr), "CREATE TABLE t (id INT)")
+	want := api.ExecutionError{
+		Message:   pgErr.Message,
+		SQLState:  "42P07",
+		Detail:    "detail",
+		Hint:      "hint",
+		Position:  14,
+		Statement: "CREATE TABLE t (id INT)",
+	}
+	if *got != want {
+		t.Errorf("executionError() = %+v, want %+v", *got, want)
+	}
+
+	got = executionError(fmt.Errorf("conn closed"), "COMMIT")
+	if got.Message != "conn closed" || got.SQLState != "" || got.Statement != "COMMIT" {
+		t.Errorf("executionError() without a server error = %+v", *got)
+	}
+}
+
+// fakeSession records the statements it runs, failing the first one containing failOn with err
+type fakeSession struct {
+	statements []string
+	failOn     string
+	err        error
+}
+
+func (s *fakeSession) Exec(_ context.Context, sql string, _ ...any) (pgconn.CommandTag, error) {
+	s.statements = append(s.statements, sql)
+	if s.failOn != "" && strings.Contains(sql, s.failOn) {
+		return pgconn.CommandTag{}, s.err
+	}
+	return pgconn.NewCommandTag("CREATE INDEX"), nil
+}
+
+func TestNonTransactional(t *testing.T) {
+	testCases := []struct {
+		stmt string
+		want bool
+	}{
+		{stmt: "CREATE INDEX CONCURRENTLY idx ON t (a)", want: true},
+		{stmt: "create unique index concurrently idx on t (a)", want: true},
+		{stmt: "DROP INDEX CONCURRENTLY IF EXISTS idx", want: true},
+		{stmt: "REINDEX (VERBOSE) TABLE CONCURRENTLY t", want: true},
+		{stmt: "VACUUM ANALYZE t", want: true},
+		{stmt: "CREATE INDEX idx ON t (a)"},
+		{stmt: "ALTER TABLE concurrently ADD COLUMN a int"},
+		{stmt: "COMMENT ON TABLE t IS 'VACUUM nightly'"},
+	}
+
+	for _, tc := range testCases {
+		if got := nonTransactional(tc.stmt); got != tc.want {
+			t.Errorf("nonTransactional(%q) = %v, want %v", tc.stmt, got, tc.want)
+		}
+	}
+}
+
+func TestExecuteWithoutTransaction(t *testing.T) {
+	lockErr := &pgconn.PgError{Code: lockNotAvailable, Message: "canceling statement due to lock timeout"}
+	ddl := []string{"CREATE INDEX CONCURRENTLY a_idx ON t (a)", "CREATE INDEX CONCURRENTLY b_idx ON t (b)"}
+	settings := api.SessionSettings{LockTimeout: "3s"}
+
+	testCases := []struct {
+		name        string
+		failOn      string
+		err         error
+		wantErr     bool
+		lockTimeout bool
+	}{
+		{name: "applies and resets session settings"},
+		{name: "lock timeout on the first statement is retryable", failOn: "a_idx", err: lockErr, wantErr: true, lockTimeout: true},
+		{name: "lock timeout after a commit is not", failOn: "b_idx", err: lockErr, wantErr: true},
+		{name: "failed reset", failOn: "RESET", err: errors.New("connection lost"), wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			session := &fakeSession{failOn: tc.failOn, err: tc.err}
+			err := executeWithoutTransaction(context.Background(), session, ddl, settings, &api.ExecutionAttempt{})
+
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("executeWithoutTransaction() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if errors.Is(err, repository.ErrLockTimeout) != tc.lockTimeout {
+				t.Errorf("expected ErrLockTimeout to be %v, got %v", tc.lockTimeout, err)
+			}
+			if tc.failOn == "RESET" && !errors.Is(err, errSettingsNotReset) {
+				t.Errorf("expected errSettingsNotReset, got %v", err)
+			}
+			if session.statements[0] != "SET SESSION lock_timeout = 3000" || session.statements[len(session.statements)-1] != "RESET lock_timeout" {
+				t.Errorf("unexpected statements %v", session.statements)
+			}
+			if tc.failOn == "a_idx" && slices.Contains(session.statements, ddl[1]) {
+				t.Errorf("expected the migration to stop at the failed statement, ran %v", session.statements)
+			}
+		})
+	}
+}
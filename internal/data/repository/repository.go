@@ -1,26 +1,193 @@
 package repository
 
 import (
+	"context"
+	"errors"
+	"time"
+
 	"github.com/dfryer1193/gomad/api"
 )
 
+// ErrLockTimeout is returned by a TargetRepository when a migration gave up waiting for a lock
+var ErrLockTimeout = errors.New("lock timeout exceeded")
+
+// ErrStatusConflict is returned by a MigrationRepository when a migration isn't in the status a transition expects,
+// typically because it was changed concurrently
+var ErrStatusConflict = errors.New("migration status changed concurrently")
+
 type SecretRepository interface {
-	InsertSecret(repoName string, secret string) (string, error)
-	GetSecret(repoName string) (string, error)
+	InsertSecret(ctx context.Context, repoName string, secret string) (string, error)
+	GetSecret(ctx context.Context, repoName string) (string, error)
 	Close()
 }
 
 type MigrationRepository interface {
-	GetFilteredBySignature(signatures []uint64) ([]*api.Migration, error)
-	GetAllForNamespace(namespace string) ([]*api.Migration, error)
-	GetById(id uint64) (*api.Migration, error)
-	BulkInsert(migrations []*api.MigrationProto) error
+	GetFilteredBySignature(ctx context.Context, signatures []uint64) ([]*api.Migration, error)
+	// ListForNamespace returns a page of a namespace's migrations matching the query
+	ListForNamespace(ctx context.Context, query MigrationQuery) ([]*api.Migration, error)
+	GetById(ctx context.Context, id uint64) (*api.Migration, error)
+	// BulkInsert adds the migrations in their initial status, recording it as the first entry of their history
+	BulkInsert(ctx context.Context, migrations []*api.MigrationProto) error
+	// TransitionStatus moves a migration to a new status and appends the change to its history. The transition
+	// isn't checked against the lifecycle; that's up to the caller.
+	TransitionStatus(ctx context.Context, transition StatusTransition) error
+	// GetStatusHistory returns the migration's status changes, oldest first
+	GetStatusHistory(ctx context.Context, id uint64) ([]*api.MigrationStatusChange, error)
+	// CountByStatus counts the migrations of every namespace by the status they're in
+	CountByStatus(ctx context.Context) ([]*MigrationStatusCount, error)
+	Close()
+}
+
+// MigrationStatusCount is how many of a namespace's migrations are in a status
+type MigrationStatusCount struct {
+	Namespace string
+	Status    api.MigrationStatus
+	Count     int64
+}
+
+// StatusTransition changes a migration's status. It fails with ErrStatusConflict unless the migration is in status
+// From. Moving to succeeded also sets the migration's CompletedAt to At.
+type StatusTransition struct {
+	ID     uint64
+	From   api.MigrationStatus
+	To     api.MigrationStatus
+	Reason string
+	At     time.Time
+	// RenderedDDL, if set, records the SQL the migration is executed with
+	RenderedDDL string
+}
+
+// MigrationQuery selects a page of a namespace's migrations, ordered by creation time with ties broken by id
+type MigrationQuery struct {
+	Namespace   string
+	Statuses    []api.MigrationStatus
+	User        string
+	Repo        string
+	Search      string
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	Descending  bool
+	// After resumes the listing after the migration at this position, in the query's order
+	After *MigrationCursor
+	Limit int
+}
+
+// MigrationCursor is a migration's position in a listing
+type MigrationCursor struct {
+	CreatedAt time.Time
+	ID        uint64
+}
+
+// ExecutionAttemptRepository stores the record of each try at executing a migration
+type ExecutionAttemptRepository interface {
+	// RecordAttempt stores a finished attempt, setting its ID and Number
+	RecordAttempt(ctx context.Context, attempt *api.ExecutionAttempt) error
+	// ListAttempts returns the migration's attempts, oldest first
+	ListAttempts(ctx context.Context, migrationID uint64) ([]*api.ExecutionAttempt, error)
+	Close()
+}
+
+type NamespaceSettingsRepository interface {
+	GetSettings(ctx context.Context, namespace string) (*api.NamespaceSettings, error)
+	UpsertSettings(ctx context.Context, settings *api.NamespaceSettings) error
+	ListNamespacesByEnvironment(ctx context.Context, environments []string) ([]string, error)
+	Close()
+}
+
+// NamespaceVariablesRepository stores the values substituted into templated migration DDL
+type NamespaceVariablesRepository interface {
+	GetVariables(ctx context.Context, namespace string) (map[string]string, error)
+	SetVariable(ctx context.Context, namespace string, name string, value string) error
+	DeleteVariable(ctx context.Context, namespace string, name string) (bool, error)
+	Close()
+}
+
+// RepositoryConfigRepository stores how files are fetched from each registered git repository
+type RepositoryConfigRepository interface {
+	GetConfig(ctx context.Context, repoName string) (*api.RepositoryConfig, error)
+	ListConfigs(ctx context.Context) ([]*api.RepositoryConfig, error)
+	UpsertConfig(ctx context.Context, config *api.RepositoryConfig) error
+	DeleteConfig(ctx context.Context, repoName string) (bool, error)
+	Close()
+}
+
+// NotificationSinkRepository stores where migration events are sent
+type NotificationSinkRepository interface {
+	// GetSink returns the sink with the name, or nil if there's none
+	GetSink(ctx context.Context, name string) (*api.NotificationSink, error)
+	ListSinks(ctx context.Context) ([]*api.NotificationSink, error)
+	UpsertSink(ctx context.Context, sink *api.NotificationSink) error
+	DeleteSink(ctx context.Context, name string) (bool, error)
 	Close()
 }
 
+// NamespaceConnectionRepository stores the registry of where each namespace's database lives
+type NamespaceConnectionRepository interface {
+	// GetConnection returns the namespace's connection, or nil if it isn't registered
+	GetConnection(ctx context.Context, namespace string) (*api.NamespaceConnection, error)
+	ListConnections(ctx context.Context) ([]*api.NamespaceConnection, error)
+	UpsertConnection(ctx context.Context, conn *api.NamespaceConnection) error
+	DeleteConnection(ctx context.Context, namespace string) (bool, error)
+	Close()
+}
+
+// TableEstimate says what a TargetDriver knows about the size of a table
+type TableEstimate int
+
+const (
+	// TableMissing means the table does not exist
+	TableMissing TableEstimate = iota
+	// TableSizeUnknown means the table exists but the database has no statistics for it yet
+	TableSizeUnknown
+	// TableSizeKnown means the row estimate is usable
+	TableSizeKnown
+)
+
+// TargetRepository executes migrations against the database backing a namespace, using the driver for the
+// namespace's dialect
+type TargetRepository interface {
+	// ExecuteMigration runs the DDL, filling in what the database reported in attempt: the identity it ran as, each
+	// statement sent, the messages raised and, if it failed, the error
+	ExecuteMigration(ctx context.Context, namespace string, ddl string, settings api.SessionSettings, attempt *api.ExecutionAttempt) error
+	// EstimateTableRows returns the planner's row estimate for a table, and whether the table exists and has an estimate
+	EstimateTableRows(ctx context.Context, namespace string, table string) (int64, TableEstimate, error)
+	// CreateDatabase creates the namespace's database on its server, returning false if it already existed
+	CreateDatabase(ctx context.Context, namespace string) (bool, error)
+	Close()
+}
+
+// TargetDriver runs migrations against the databases of a single SQL dialect. Each call is given the connection of
+// the namespace it acts on.
+type TargetDriver interface {
+	ExecuteMigration(ctx context.Context, conn *api.NamespaceConnection, ddl string, settings api.SessionSettings, attempt *api.ExecutionAttempt) error
+	EstimateTableRows(ctx context.Context, conn *api.NamespaceConnection, table string) (int64, TableEstimate, error)
+	DatabaseExists(ctx context.Context, conn *api.NamespaceConnection) (bool, error)
+	CreateDatabase(ctx context.Context, conn *api.NamespaceConnection) error
+	Close()
+}
+
+// Repositories bundles the repositories of gomad's own data
+type Repositories struct {
+	Migrations           MigrationRepository
+	ExecutionAttempts    ExecutionAttemptRepository
+	Secrets              SecretRepository
+	NamespaceSettings    NamespaceSettingsRepository
+	NamespaceVariables   NamespaceVariablesRepository
+	RepositoryConfigs    RepositoryConfigRepository
+	NamespaceConnections NamespaceConnectionRepository
+}
+
+// Transactor runs operations spanning several metadata repositories atomically
+type Transactor interface {
+	// InTx calls fn with repositories bound to a single transaction, which is committed if fn returns nil and rolled
+	// back otherwise. The repositories must not be used after fn returns.
+	InTx(ctx context.Context, fn func(repos Repositories) error) error
+}
+
+// DatabaseRepository manages the databases on the default cluster that back unregistered namespaces
 type DatabaseRepository interface {
-	CreateDatabase(dbName string, owner string) error
-	DatabaseExists(dbName string) (bool, error)
-	ListDatabases() ([]string, error)
+	CreateDatabase(ctx context.Context, dbName string, owner string) error
+	DatabaseExists(ctx context.Context, dbName string) (bool, error)
+	ListDatabases(ctx context.Context) ([]string, error)
 	Close()
 }
@@ -0,0 +1,311 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+	dataUtils "github.com/dfryer1193/gomad/internal/data/utils"
+	sqlitedriver "modernc.org/sqlite"
+)
+
+// sqliteBusy is SQLite's result code for a database file locked by another connection
+const sqliteBusy = 5
+
+// defaultLockWait is how long a migration waits for a database file's lock when no lock timeout is configured
+const defaultLockWait = 30 * time.Second
+
+type targetDriver struct {
+	dir  string
+	open func(dsn string) (*sql.DB, error)
+
+	mu    sync.Mutex
+	dbs   map[string]*sql.DB
+	locks map[string]chan struct{}
+}
+
+var (
+	targetDrv  *targetDriver
+	targetOnce sync.Once
+)
+
+// GetTargetDriver returns the driver for namespaces backed by SQLite files, meant for running gomad locally without a
+// database server
+func GetTargetDriver() repository.TargetDriver {
+	targetOnce.Do(func() {
+		targetDrv = newTargetDriver(databaseDir())
+	})
+
+	return targetDrv
+}
+
+func newTargetDriver(dir string) *targetDriver {
+	return &targetDriver{
+		dir:   dir,
+		open:  func(dsn string) (*sql.DB, error) { return sql.Open("sqlite", dsn) },
+		dbs:   make(map[string]*sql.DB),
+		locks: make(map[string]chan struct{}),
+	}
+}
+
+// databaseDir is where SQLite files given by a relative path live
+func databaseDir() string {
+	if dir := os.Getenv("GOMAD_SQLITE_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "gomad", "sqlite")
+}
+
+// ExecuteMigration runs the DDL in a single transaction, so a failed migration leaves the file untouched. Migrations
+// against the same file are serialized, both within gomad and with other processes through SQLite's own file lock.
+func (d *targetDriver) ExecuteMigration(ctx context.Context, conn *api.NamespaceConnection, ddl string, settings api.SessionSettings, attempt *api.ExecutionAttempt) error {
+	lockWait := defaultLockWait
+	if settings.LockTimeout != "" {
+		var err error
+		if lockWait, err = time.ParseDuration(settings.LockTimeout); err != nil {
+			return fmt.Errorf("invalid lock_timeout %q: %w", settings.LockTimeout, err)
+		}
+	}
+
+	var statementTimeout time.Duration
+	if settings.StatementTimeout != "" {
+		var err error
+		if statementTimeout, err = time.ParseDuration(settings.StatementTimeout); err != nil {
+			return fmt.Errorf("invalid statement_timeout %q: %w", settings.StatementTimeout, err)
+		}
+	}
+
+	path := d.path(conn)
+	release, err := d.lockFile(ctx, path, lockWait)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	db, err := d.getDB(path)
+	if err != nil {
+		return err
+	}
+
+	session, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open database %s of namespace %s: %w", path, conn.Namespace, err)
+	}
+	defer session.Close()
+
+	// busy_timeout bounds the wait for another process's lock on the file
+	busyTimeout := fmt.Sprintf("PRAGMA busy_timeout = %d", lockWait.Milliseconds())
+	if err := execStatement(ctx, session, busyTimeout, 0, attempt); err != nil {
+		return fmt.Errorf("failed to set busy timeout: %w", err)
+	}
+
+	// IMMEDIATE takes the write lock up front, so a migration never fails halfway through for want of it
+	if err := execStatement(ctx, session, "BEGIN IMMEDIATE", 0, attempt); err != nil {
+		return wrapExecError(fmt.Errorf("failed to lock database %s: %w", path, err))
+	}
+
+	for _, stmt := range dataUtils.SplitStatements(ddl) {
+		if err := execStatement(ctx, session, stmt, statementTimeout, attempt); err != nil {
+			session.ExecContext(context.WithoutCancel(ctx), "ROLLBACK")
+			return wrapExecError(err)
+		}
+	}
+
+	if err := execStatement(ctx, session, "COMMIT", 0, attempt); err != nil {
+		session.ExecContext(context.WithoutCancel(ctx), "ROLLBACK")
+		return wrapExecError(fmt.Errorf("failed to commit migration: %w", err))
+	}
+
+	return nil
+}
+
+// execStatement runs a statement of a migration, recording how it went in the attempt
+func execStatement(ctx context.Context, session *sql.Conn, stmt string, timeout time.Duration, attempt *api.ExecutionAttempt) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	result, err := session.ExecContext(ctx, stmt)
+	took := time.Since(start)
+
+	var rows int64
+	if err == nil {
+		rows, _ = result.RowsAffected()
+	}
+	attempt.AddStatement(stmt, took, rows, err != nil)
+	if err != nil {
+		attempt.Error = &api.ExecutionError{Message: err.Error(), Statement: stmt}
+	}
+
+	return err
+}
+
+// EstimateTableRows counts the table's rows. SQLite keeps no row estimates, but local databases are small enough to
+// count exactly.
+func (d *targetDriver) EstimateTableRows(ctx context.Context, conn *api.NamespaceConnection, table string) (int64, repository.TableEstimate, error) {
+	db, err := d.getDB(d.path(conn))
+	if err != nil {
+		return 0, repository.TableMissing, err
+	}
+
+	name := table
+	if schema, after, ok := strings.Cut(table, "."); ok {
+		// Tables in attached databases aren't part of the namespace
+		if !strings.EqualFold(unquote(schema), "main") {
+			return 0, repository.TableMissing, nil
+		}
+		name = after
+	}
+	name = unquote(name)
+
+	var tables int
+	err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_schema WHERE type = 'table' AND name = ?`, name).Scan(&tables)
+	if err != nil {
+		return 0, repository.TableMissing, fmt.Errorf("failed to look up table %s: %w", table, err)
+	}
+	if tables == 0 {
+		return 0, repository.TableMissing, nil
+	}
+
+	var rows int64
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+quoteIdentifier(name)).Scan(&rows); err != nil {
+		return 0, repository.TableMissing, fmt.Errorf("failed to count rows of table %s: %w", table, err)
+	}
+
+	return rows, repository.TableSizeKnown, nil
+}
+
+func (d *targetDriver) DatabaseExists(ctx context.Context, conn *api.NamespaceConnection) (bool, error) {
+	_, err := os.Stat(d.path(conn))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check database file of namespace %s: %w", conn.Namespace, err)
+	}
+
+	return true, nil
+}
+
+// CreateDatabase creates the namespace's database file in WAL mode, which lets it be read while a migration runs
+func (d *targetDriver) CreateDatabase(ctx context.Context, conn *api.NamespaceConnection) error {
+	path := d.path(conn)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for database %s: %w", path, err)
+	}
+
+	db, err := d.open(dsn(path, "rwc"))
+	if err != nil {
+		return fmt.Errorf("failed to create database %s: %w", path, err)
+	}
+	defer db.Close()
+
+	if _, err := db.ExecContext(ctx, "PRAGMA journal_mode = WAL"); err != nil {
+		return fmt.Errorf("failed to create database %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (d *targetDriver) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for path, db := range d.dbs {
+		db.Close()
+		delete(d.dbs, path)
+	}
+}
+
+// path is the database file backing a namespace. Relative paths are resolved against the SQLite directory.
+func (d *targetDriver) path(conn *api.NamespaceConnection) string {
+	path := conn.Database
+	if path == "" {
+		path = conn.Namespace + ".db"
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(d.dir, path)
+	}
+
+	return filepath.Clean(path)
+}
+
+// getDB returns the pool for a database file. Files are opened read-write without being created, so a namespace
+// whose file is missing fails like one whose database doesn't exist on a server.
+func (d *targetDriver) getDB(path string) (*sql.DB, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if db, ok := d.dbs[path]; ok {
+		return db, nil
+	}
+
+	db, err := d.open(dsn(path, "rw"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+
+	d.dbs[path] = db
+	return db, nil
+}
+
+// lockFile serializes migrations against a database file within this process, returning a func that releases it
+func (d *targetDriver) lockFile(ctx context.Context, path string, wait time.Duration) (func(), error) {
+	d.mu.Lock()
+	lock, ok := d.locks[path]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		d.locks[path] = lock
+	}
+	d.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case lock <- struct{}{}:
+		return func() { <-lock }, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("%w: another migration is running against %s", repository.ErrLockTimeout, path)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func dsn(path string, mode string) string {
+	return "file:" + (&url.URL{Path: path}).EscapedPath() + "?mode=" + mode + "&_pragma=foreign_keys(1)"
+}
+
+// wrapExecError marks errors from a file locked by another process with repository.ErrLockTimeout so callers can
+// retry them
+func wrapExecError(err error) error {
+	var sqliteErr *sqlitedriver.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code()&0xff == sqliteBusy {
+		return fmt.Errorf("%w: %w", repository.ErrLockTimeout, err)
+	}
+
+	return err
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func unquote(name string) string {
+	if len(name) >= 2 && name[0] == '"' && name[len(name)-1] == '"' {
+		return strings.ReplaceAll(name[1:len(name)-1], `""`, `"`)
+	}
+	return name
+}
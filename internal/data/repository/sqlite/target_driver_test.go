@@ -0,0 +1,139 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+)
+
+func newTestDatabase(t *testing.T) (*targetDriver, *api.NamespaceConnection) {
+	t.Helper()
+	d := newTargetDriver(t.TempDir())
+	t.Cleanup(d.Close)
+
+	conn := &api.NamespaceConnection{Namespace: "billing", Driver: api.DriverSQLite}
+	if err := d.CreateDatabase(context.Background(), conn); err != nil {
+		t.Fatalf("CreateDatabase() error = %v", err)
+	}
+	return d, conn
+}
+
+func TestCreateDatabase(t *testing.T) {
+	d := newTargetDriver(t.TempDir())
+	defer d.Close()
+
+	conn := &api.NamespaceConnection{Namespace: "billing", Driver: api.DriverSQLite, Database: "nested/billing.sqlite"}
+	exists, err := d.DatabaseExists(context.Background(), conn)
+	if err != nil || exists {
+		t.Fatalf("DatabaseExists() = %v, %v before creating it", exists, err)
+	}
+
+	if err := d.ExecuteMigration(context.Background(), conn, "CREATE TABLE t (id INTEGER)", api.SessionSettings{}, &api.ExecutionAttempt{}); err == nil {
+		t.Errorf("expected migrating a missing database to fail")
+	}
+	if exists, _ := d.DatabaseExists(context.Background(), conn); exists {
+		t.Errorf("expected a failed migration not to create the database file")
+	}
+
+	if err := d.CreateDatabase(context.Background(), conn); err != nil {
+		t.Fatalf("CreateDatabase() error = %v", err)
+	}
+	if d.path(conn) != filepath.Join(d.dir, "nested", "billing.sqlite") {
+		t.Errorf("path() = %s", d.path(conn))
+	}
+
+	exists, err = d.DatabaseExists(context.Background(), conn)
+	if err != nil || !exists {
+		t.Errorf("DatabaseExists() = %v, %v after creating it", exists, err)
+	}
+}
+
+func TestExecuteMigration(t *testing.T) {
+	d, conn := newTestDatabase(t)
+
+	ddl := "CREATE TABLE invoices (id INTEGER PRIMARY KEY, note TEXT DEFAULT ';');\nINSERT INTO invoices (id) VALUES (1), (2);"
+	attempt := &api.ExecutionAttempt{}
+	if err := d.ExecuteMigration(context.Background(), conn, ddl, api.SessionSettings{LockTimeout: "1s", StatementTimeout: "5s"}, attempt); err != nil {
+		t.Fatalf("ExecuteMigration() error = %v", err)
+	}
+
+	// The busy timeout, BEGIN and COMMIT are recorded around the migration's own statements
+	if len(attempt.Statements) != 5 || attempt.Statements[3].RowsAffected != 2 || attempt.Error != nil {
+		t.Errorf("attempt statements = %+v, error = %+v", attempt.Statements, attempt.Error)
+	}
+
+	rows, estimate, err := d.EstimateTableRows(context.Background(), conn, "invoices")
+	if err != nil || estimate != repository.TableSizeKnown || rows != 2 {
+		t.Errorf("EstimateTableRows(invoices) = %d, %v, %v", rows, estimate, err)
+	}
+
+	rows, estimate, err = d.EstimateTableRows(context.Background(), conn, `main."invoices"`)
+	if err != nil || estimate != repository.TableSizeKnown || rows != 2 {
+		t.Errorf("EstimateTableRows(main.invoices) = %d, %v, %v", rows, estimate, err)
+	}
+
+	if _, estimate, _ := d.EstimateTableRows(context.Background(), conn, "missing"); estimate != repository.TableMissing {
+		t.Errorf("expected missing table not to exist")
+	}
+}
+
+func TestExecuteMigrationRollsBack(t *testing.T) {
+	d, conn := newTestDatabase(t)
+
+	attempt := &api.ExecutionAttempt{}
+	err := d.ExecuteMigration(context.Background(), conn, "CREATE TABLE invoices (id INTEGER); CREATE TABLE invoices (id INTEGER)", api.SessionSettings{}, attempt)
+	if err == nil {
+		t.Fatalf("expected error creating the same table twice")
+	}
+	if attempt.Error == nil || attempt.Error.Statement != "CREATE TABLE invoices (id INTEGER)" || !attempt.Statements[len(attempt.Statements)-1].Failed {
+		t.Errorf("expected the attempt to record the failed statement, got %+v", attempt.Error)
+	}
+
+	if _, estimate, _ := d.EstimateTableRows(context.Background(), conn, "invoices"); estimate != repository.TableMissing {
+		t.Errorf("expected the failed migration's first statement to be rolled back")
+	}
+}
+
+func TestExecuteMigrationLocksFile(t *testing.T) {
+	d, conn := newTestDatabase(t)
+
+	release, err := d.lockFile(context.Background(), d.path(conn), time.Second)
+	if err != nil {
+		t.Fatalf("lockFile() error = %v", err)
+	}
+
+	err = d.ExecuteMigration(context.Background(), conn, "CREATE TABLE t (id INTEGER)", api.SessionSettings{LockTimeout: "50ms"}, &api.ExecutionAttempt{})
+	if !errors.Is(err, repository.ErrLockTimeout) {
+		t.Errorf("expected ErrLockTimeout while the file is locked in process, got %v", err)
+	}
+	release()
+
+	// Another process holding the file's write lock
+	other, err := sql.Open("sqlite", dsn(d.path(conn), "rw"))
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	defer other.Close()
+	other.SetMaxOpenConns(1)
+	if _, err := other.Exec("BEGIN IMMEDIATE"); err != nil {
+		t.Fatalf("BEGIN IMMEDIATE error = %v", err)
+	}
+
+	err = d.ExecuteMigration(context.Background(), conn, "CREATE TABLE t (id INTEGER)", api.SessionSettings{LockTimeout: "50ms"}, &api.ExecutionAttempt{})
+	if !errors.Is(err, repository.ErrLockTimeout) {
+		t.Errorf("expected ErrLockTimeout while another connection holds the write lock, got %v", err)
+	}
+
+	if _, err := other.Exec("ROLLBACK"); err != nil {
+		t.Fatalf("ROLLBACK error = %v", err)
+	}
+	if err := d.ExecuteMigration(context.Background(), conn, "CREATE TABLE t (id INTEGER)", api.SessionSettings{LockTimeout: "1s"}, &api.ExecutionAttempt{}); err != nil {
+		t.Errorf("ExecuteMigration() after the lock was released error = %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dfryer1193/gomad/internal/data/repository"
+	"github.com/dfryer1193/gomad/internal/data/repository/memory"
+	"github.com/dfryer1193/gomad/internal/data/repository/postgres"
+	"github.com/rs/zerolog/log"
+)
+
+// Backend selects where gomad keeps its own data: migrations, namespace settings, secrets and the like
+type Backend string
+
+const (
+	BackendPostgres Backend = "postgres"
+	// BackendMemory keeps everything in process, for demos and tests. Nothing survives a restart.
+	BackendMemory Backend = "memory"
+)
+
+// ParseBackend validates a storage backend name, defaulting to postgres when it's empty
+func ParseBackend(name string) (Backend, error) {
+	switch Backend(name) {
+	case "", BackendPostgres:
+		return BackendPostgres, nil
+	case BackendMemory:
+		return BackendMemory, nil
+	default:
+		return "", fmt.Errorf("unknown storage backend %q: expected %s or %s", name, BackendPostgres, BackendMemory)
+	}
+}
+
+// GetBackend returns the storage backend named by GOMAD_STORAGE_BACKEND
+func GetBackend() Backend {
+	backend, err := ParseBackend(os.Getenv("GOMAD_STORAGE_BACKEND"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid GOMAD_STORAGE_BACKEND")
+	}
+
+	return backend
+}
+
+func GetSecretsRepository() repository.SecretRepository {
+	if GetBackend() == BackendMemory {
+		return memory.GetSecretsRepository()
+	}
+	return postgres.GetSecretsRepository()
+}
+
+func GetMigrationRepository() repository.MigrationRepository {
+	if GetBackend() == BackendMemory {
+		return memory.GetMigrationRepository()
+	}
+	return postgres.GetMigrationRepository()
+}
+
+func GetExecutionAttemptRepository() repository.ExecutionAttemptRepository {
+	if GetBackend() == BackendMemory {
+		return memory.GetExecutionAttemptRepository()
+	}
+	return postgres.GetExecutionAttemptRepository()
+}
+
+func GetDatabaseRepository() repository.DatabaseRepository {
+	if GetBackend() == BackendMemory {
+		return memory.GetDatabaseRepository()
+	}
+	return postgres.GetDatabaseRepository()
+}
+
+func GetNamespaceSettingsRepository() repository.NamespaceSettingsRepository {
+	if GetBackend() == BackendMemory {
+		return memory.GetNamespaceSettingsRepository()
+	}
+	return postgres.GetNamespaceSettingsRepository()
+}
+
+func GetNamespaceVariablesRepository() repository.NamespaceVariablesRepository {
+	if GetBackend() == BackendMemory {
+		return memory.GetNamespaceVariablesRepository()
+	}
+	return postgres.GetNamespaceVariablesRepository()
+}
+
+func GetRepositoryConfigRepository() repository.RepositoryConfigRepository {
+	if GetBackend() == BackendMemory {
+		return memory.GetRepositoryConfigRepository()
+	}
+	return postgres.GetRepositoryConfigRepository()
+}
+
+func GetNamespaceConnectionRepository() repository.NamespaceConnectionRepository {
+	if GetBackend() == BackendMemory {
+		return memory.GetNamespaceConnectionRepository()
+	}
+	return postgres.GetNamespaceConnectionRepository()
+}
+
+func GetNotificationSinkRepository() repository.NotificationSinkRepository {
+	if GetBackend() == BackendMemory {
+		return memory.GetNotificationSinkRepository()
+	}
+	return postgres.GetNotificationSinkRepository()
+}
+
+func GetTransactor() repository.Transactor {
+	if GetBackend() == BackendMemory {
+		return memory.GetTransactor()
+	}
+	return postgres.GetTransactor()
+}
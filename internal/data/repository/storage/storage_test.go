@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseBackend(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Backend
+		wantErr bool
+	}{
+		{name: "", want: BackendPostgres},
+		{name: "postgres", want: BackendPostgres},
+		{name: "memory", want: BackendMemory},
+		{name: "sqlite", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		backend, err := ParseBackend(tt.name)
+		if (err != nil) != tt.wantErr || backend != tt.want {
+			t.Errorf("ParseBackend(%q) = %q, %v", tt.name, backend, err)
+		}
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	t.Setenv("GOMAD_STORAGE_BACKEND", "memory")
+
+	// The memory backend hands out shared repositories, so managers see each other's writes
+	if err := GetNamespaceVariablesRepository().SetVariable(context.Background(), "ns1", "schema", "app"); err != nil {
+		t.Fatalf("SetVariable() error = %v", err)
+	}
+	vars, err := GetNamespaceVariablesRepository().GetVariables(context.Background(), "ns1")
+	if err != nil || vars["schema"] != "app" {
+		t.Errorf("GetVariables() = %v, %v", vars, err)
+	}
+}
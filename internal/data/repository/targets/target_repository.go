@@ -0,0 +1,113 @@
+package targets
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+	"github.com/dfryer1193/gomad/internal/data/repository/mysql"
+	"github.com/dfryer1193/gomad/internal/data/repository/postgres"
+	"github.com/dfryer1193/gomad/internal/data/repository/sqlite"
+	"github.com/dfryer1193/gomad/internal/data/repository/storage"
+	"github.com/dfryer1193/gomad/internal/data/utils"
+)
+
+// targetRepository sends each namespace's operations to the driver for its database's dialect
+type targetRepository struct {
+	connections repository.NamespaceConnectionRepository
+	drivers     map[api.Driver]repository.TargetDriver
+}
+
+var (
+	targetRepo *targetRepository
+	targetOnce sync.Once
+)
+
+func GetTargetRepository() repository.TargetRepository {
+	targetOnce.Do(func() {
+		targetRepo = &targetRepository{
+			connections: storage.GetNamespaceConnectionRepository(),
+			drivers: map[api.Driver]repository.TargetDriver{
+				api.DriverPostgres: postgres.GetTargetDriver(),
+				api.DriverMySQL:    mysql.GetTargetDriver(),
+				api.DriverSQLite:   sqlite.GetTargetDriver(),
+			},
+		}
+	})
+
+	return targetRepo
+}
+
+func (r *targetRepository) ExecuteMigration(ctx context.Context, namespace string, ddl string, settings api.SessionSettings, attempt *api.ExecutionAttempt) error {
+	conn, driver, err := r.resolve(ctx, namespace)
+	if err != nil {
+		return err
+	}
+
+	return driver.ExecuteMigration(ctx, conn, ddl, settings, attempt)
+}
+
+func (r *targetRepository) EstimateTableRows(ctx context.Context, namespace string, table string) (int64, repository.TableEstimate, error) {
+	conn, driver, err := r.resolve(ctx, namespace)
+	if err != nil {
+		return 0, repository.TableMissing, err
+	}
+
+	return driver.EstimateTableRows(ctx, conn, table)
+}
+
+func (r *targetRepository) CreateDatabase(ctx context.Context, namespace string) (bool, error) {
+	conn, driver, err := r.resolve(ctx, namespace)
+	if err != nil {
+		return false, err
+	}
+
+	exists, err := driver.DatabaseExists(ctx, conn)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return false, nil
+	}
+
+	if err := driver.CreateDatabase(ctx, conn); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *targetRepository) Close() {
+	for _, driver := range r.drivers {
+		driver.Close()
+	}
+}
+
+// resolve looks up the namespace's registered connection, falling back to a database named after the namespace on
+// the default cluster, and the driver for its dialect
+func (r *targetRepository) resolve(ctx context.Context, namespace string) (*api.NamespaceConnection, repository.TargetDriver, error) {
+	conn, err := r.connections.GetConnection(ctx, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if conn == nil {
+		conn, err = utils.DefaultNamespaceConnection(namespace)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to build connection for namespace %s: %w", namespace, err)
+		}
+	}
+
+	dialect := conn.Driver
+	if dialect == "" {
+		dialect = api.DriverPostgres
+	}
+
+	driver, ok := r.drivers[dialect]
+	if !ok {
+		return nil, nil, fmt.Errorf("namespace %s uses unsupported driver %q", namespace, dialect)
+	}
+
+	return conn, driver, nil
+}
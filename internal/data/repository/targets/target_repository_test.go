@@ -0,0 +1,103 @@
+package targets
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+)
+
+type fakeConnectionRepository struct {
+	conns map[string]*api.NamespaceConnection
+}
+
+func (r *fakeConnectionRepository) GetConnection(_ context.Context, namespace string) (*api.NamespaceConnection, error) {
+	return r.conns[namespace], nil
+}
+
+func (r *fakeConnectionRepository) ListConnections(_ context.Context) ([]*api.NamespaceConnection, error) {
+	return nil, nil
+}
+
+func (r *fakeConnectionRepository) UpsertConnection(context.Context, *api.NamespaceConnection) error {
+	return nil
+}
+
+func (r *fakeConnectionRepository) DeleteConnection(context.Context, string) (bool, error) {
+	return false, nil
+}
+
+func (r *fakeConnectionRepository) Close() {}
+
+// fakeDriver records the namespaces it executed migrations for and the databases it created
+type fakeDriver struct {
+	executed []string
+	existing map[string]bool
+	created  []string
+}
+
+func (d *fakeDriver) ExecuteMigration(_ context.Context, conn *api.NamespaceConnection, _ string, _ api.SessionSettings, _ *api.ExecutionAttempt) error {
+	d.executed = append(d.executed, conn.Namespace)
+	return nil
+}
+
+func (d *fakeDriver) EstimateTableRows(context.Context, *api.NamespaceConnection, string) (int64, repository.TableEstimate, error) {
+	return 0, repository.TableMissing, nil
+}
+
+func (d *fakeDriver) DatabaseExists(_ context.Context, conn *api.NamespaceConnection) (bool, error) {
+	return d.existing[conn.Database], nil
+}
+
+func (d *fakeDriver) CreateDatabase(_ context.Context, conn *api.NamespaceConnection) error {
+	d.created = append(d.created, conn.Database)
+	return nil
+}
+
+func (d *fakeDriver) Close() {}
+
+func TestTargetRepositoryRoutesByDriver(t *testing.T) {
+	postgresDriver := &fakeDriver{}
+	mysqlDriver := &fakeDriver{existing: map[string]bool{"shop": true}}
+	repo := &targetRepository{
+		connections: &fakeConnectionRepository{conns: map[string]*api.NamespaceConnection{
+			"shop":    {Namespace: "shop", Driver: api.DriverMySQL, Database: "shop"},
+			"billing": {Namespace: "billing", Driver: api.DriverMySQL, Database: "billing"},
+			"legacy":  {Namespace: "legacy", Database: "legacy"},
+			"oracle":  {Namespace: "oracle", Driver: "oracle", Database: "oracle"},
+		}},
+		drivers: map[api.Driver]repository.TargetDriver{
+			api.DriverPostgres: postgresDriver,
+			api.DriverMySQL:    mysqlDriver,
+		},
+	}
+
+	for _, namespace := range []string{"shop", "legacy", "unregistered"} {
+		if err := repo.ExecuteMigration(context.Background(), namespace, "SELECT 1", api.SessionSettings{}, &api.ExecutionAttempt{}); err != nil {
+			t.Fatalf("ExecuteMigration(%s) error = %v", namespace, err)
+		}
+	}
+	if len(mysqlDriver.executed) != 1 || mysqlDriver.executed[0] != "shop" {
+		t.Errorf("mysql driver executed %v", mysqlDriver.executed)
+	}
+	if len(postgresDriver.executed) != 2 {
+		t.Errorf("postgres driver executed %v, expected legacy and the unregistered namespace", postgresDriver.executed)
+	}
+
+	if err := repo.ExecuteMigration(context.Background(), "oracle", "SELECT 1", api.SessionSettings{}, &api.ExecutionAttempt{}); err == nil {
+		t.Errorf("expected error for unsupported driver")
+	}
+
+	created, err := repo.CreateDatabase(context.Background(), "shop")
+	if err != nil || created {
+		t.Errorf("CreateDatabase(shop) = %v, %v, expected existing database to be left alone", created, err)
+	}
+	created, err = repo.CreateDatabase(context.Background(), "billing")
+	if err != nil || !created {
+		t.Errorf("CreateDatabase(billing) = %v, %v", created, err)
+	}
+	if len(mysqlDriver.created) != 1 || mysqlDriver.created[0] != "billing" {
+		t.Errorf("mysql driver created %v", mysqlDriver.created)
+	}
+}
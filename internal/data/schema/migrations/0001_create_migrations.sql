@@ -0,0 +1,18 @@
+-- Migration ids are 64-bit FNV signatures, which overflow BIGINT, so they're stored as NUMERIC
+CREATE TABLE migrations (
+    id NUMERIC(20, 0) PRIMARY KEY,
+    namespace VARCHAR(50) NOT NULL,
+    "user" VARCHAR(50),
+    comment TEXT,
+    ddl TEXT,
+    renderedDdl TEXT,
+    includes TEXT[],
+    createdAt TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    completedAt TIMESTAMP WITH TIME ZONE,
+    shouldSkip BOOLEAN NOT NULL DEFAULT FALSE,
+    lockTimeout VARCHAR(32),
+    statementTimeout VARCHAR(32),
+    idleInTransactionSessionTimeout VARCHAR(32)
+);
+
+CREATE INDEX migrations_namespace_idx ON migrations (namespace, createdAt);
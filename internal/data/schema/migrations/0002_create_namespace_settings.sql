@@ -0,0 +1,11 @@
+CREATE TABLE namespace_settings (
+    namespace VARCHAR(50) PRIMARY KEY,
+    lock_timeout VARCHAR(32),
+    statement_timeout VARCHAR(32),
+    idle_in_transaction_session_timeout VARCHAR(32),
+    lock_retries INTEGER NOT NULL DEFAULT 3,
+    lint_rules JSONB,
+    environment VARCHAR(32)
+);
+
+CREATE INDEX namespace_settings_environment_idx ON namespace_settings (environment);
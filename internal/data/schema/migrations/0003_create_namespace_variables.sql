@@ -0,0 +1,6 @@
+CREATE TABLE namespace_variables (
+    namespace VARCHAR(50) NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    value TEXT NOT NULL,
+    PRIMARY KEY (namespace, name)
+);
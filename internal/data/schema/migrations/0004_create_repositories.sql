@@ -0,0 +1,8 @@
+CREATE TABLE repositories (
+    repo_name VARCHAR(255) PRIMARY KEY,
+    source VARCHAR(16) NOT NULL DEFAULT 'github',
+    remote_url TEXT,
+    api_base_url TEXT,
+    token TEXT,
+    ca_bundle TEXT
+);
@@ -0,0 +1,11 @@
+CREATE TABLE namespace_connections (
+    namespace VARCHAR(50) PRIMARY KEY,
+    host VARCHAR(255) NOT NULL,
+    port INTEGER NOT NULL DEFAULT 5432,
+    database VARCHAR(63) NOT NULL,
+    schema VARCHAR(63),
+    username VARCHAR(63) NOT NULL,
+    credentials_ref TEXT,
+    ssl_mode VARCHAR(16),
+    ssl_root_cert TEXT
+);
@@ -0,0 +1 @@
+ALTER TABLE namespace_connections ADD COLUMN driver VARCHAR(16) NOT NULL DEFAULT 'postgres';
@@ -0,0 +1,5 @@
+CREATE TABLE webhook_secrets (
+    repo_name VARCHAR(255) PRIMARY KEY,
+    secret VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1 @@
+ALTER TABLE migrations ADD COLUMN interruptedAt TIMESTAMP WITH TIME ZONE;
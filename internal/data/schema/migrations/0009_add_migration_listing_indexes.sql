@@ -0,0 +1,14 @@
+ALTER TABLE migrations ADD COLUMN repo VARCHAR(255);
+
+-- Listings page through a namespace's migrations by (createdAt, id), optionally narrowed to a user or repository
+DROP INDEX migrations_namespace_idx;
+CREATE INDEX migrations_namespace_created_idx ON migrations (namespace, createdAt, id);
+CREATE INDEX migrations_namespace_user_idx ON migrations (namespace, "user", createdAt, id);
+CREATE INDEX migrations_namespace_repo_idx ON migrations (namespace, repo, createdAt, id);
+
+-- Most listings filter to migrations that still have to run
+CREATE INDEX migrations_namespace_incomplete_idx ON migrations (namespace, createdAt, id) WHERE completedAt IS NULL;
+
+-- Comment search is a case-insensitive substring match, which needs a trigram index
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX migrations_comment_trgm_idx ON migrations USING GIN (comment gin_trgm_ops);
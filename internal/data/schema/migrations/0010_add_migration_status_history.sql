@@ -0,0 +1,48 @@
+ALTER TABLE migrations ADD COLUMN status VARCHAR(32) NOT NULL DEFAULT 'pending'
+    CHECK (status IN ('pending', 'awaiting-approval', 'running', 'succeeded', 'failed', 'skipped', 'rolled-back', 'drifted'));
+
+-- Interrupted executions are now failures, with the interruption kept in the history
+UPDATE migrations SET status = CASE
+    WHEN completedAt IS NOT NULL THEN 'succeeded'
+    WHEN shouldSkip THEN 'skipped'
+    WHEN interruptedAt IS NOT NULL THEN 'failed'
+    ELSE 'pending'
+END;
+
+CREATE TABLE migration_status_history (
+    id BIGSERIAL PRIMARY KEY,
+    migrationId NUMERIC(20, 0) NOT NULL REFERENCES migrations (id),
+    -- NULL for the status a migration was created with
+    fromStatus VARCHAR(32),
+    toStatus VARCHAR(32) NOT NULL,
+    reason TEXT,
+    changedAt TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX migration_status_history_migration_idx ON migration_status_history (migrationId, id);
+
+-- Existing migrations start their history at the status they have now
+INSERT INTO migration_status_history (migrationId, toStatus, reason, changedAt)
+SELECT id, status,
+    CASE WHEN interruptedAt IS NOT NULL AND completedAt IS NULL AND NOT shouldSkip
+        THEN 'execution interrupted' ELSE 'status recorded before history was kept' END,
+    COALESCE(completedAt, interruptedAt, createdAt)
+FROM migrations
+ORDER BY createdAt, id;
+
+ALTER TABLE migrations DROP COLUMN interruptedAt;
+
+-- The history is append-only
+CREATE FUNCTION reject_migration_status_history_change() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+    RAISE EXCEPTION 'migration_status_history is append-only';
+END;
+$$;
+
+CREATE TRIGGER migration_status_history_append_only
+    BEFORE UPDATE OR DELETE OR TRUNCATE ON migration_status_history
+    FOR EACH STATEMENT EXECUTE FUNCTION reject_migration_status_history_change();
+
+-- Listings filter by status instead of completion
+DROP INDEX migrations_namespace_incomplete_idx;
+CREATE INDEX migrations_namespace_status_idx ON migrations (namespace, status, createdAt, id);
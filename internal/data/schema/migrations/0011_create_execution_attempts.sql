@@ -0,0 +1,15 @@
+CREATE TABLE execution_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    migrationId NUMERIC(20, 0) NOT NULL REFERENCES migrations (id),
+    number INTEGER NOT NULL,
+    startedAt TIMESTAMP WITH TIME ZONE NOT NULL,
+    finishedAt TIMESTAMP WITH TIME ZONE NOT NULL,
+    identity TEXT,
+    host TEXT,
+    sql TEXT NOT NULL,
+    statements JSONB NOT NULL DEFAULT '[]'::jsonb,
+    messages JSONB NOT NULL DEFAULT '[]'::jsonb,
+    -- NULL for attempts that succeeded
+    error JSONB,
+    UNIQUE (migrationId, number)
+);
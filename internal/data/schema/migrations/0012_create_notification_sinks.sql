@@ -0,0 +1,15 @@
+CREATE TABLE notification_sinks (
+    name VARCHAR(255) PRIMARY KEY,
+    kind VARCHAR(16) NOT NULL,
+    -- Empty for every namespace
+    namespaces TEXT[] NOT NULL DEFAULT '{}',
+    events TEXT[] NOT NULL,
+    url TEXT,
+    secret TEXT,
+    smtp_host TEXT,
+    smtp_port INTEGER,
+    smtp_from TEXT,
+    smtp_to TEXT[],
+    smtp_username TEXT,
+    credentials_ref TEXT
+);
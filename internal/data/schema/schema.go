@@ -0,0 +1,212 @@
+package schema
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dfryer1193/gomad/internal/data/utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrSchemaTooNew is returned when a database has schema versions this binary doesn't know about, which means it
+// was migrated by a newer gomad and running against it could corrupt data
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// advisoryLockKey serializes schema migrations between gomad replicas starting at the same time
+const advisoryLockKey = 0x676f6d6164 // "gomad"
+
+// Version is a single schema migration, loaded from a file named <version>_<description>.sql
+type Version struct {
+	Version     int
+	Description string
+	SQL         string
+}
+
+// Versions returns the embedded schema migrations for the metadata database, ordered by version
+func Versions() ([]Version, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema migrations: %w", err)
+	}
+
+	versions := make([]Version, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+
+		version, description, err := parseFileName(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schema migration %s: %w", entry.Name(), err)
+		}
+
+		versions = append(versions, Version{Version: version, Description: description, SQL: string(content)})
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	for idx, version := range versions {
+		if version.Version != idx+1 {
+			return nil, fmt.Errorf("schema migrations must be numbered from 1 without gaps: found %d at position %d",
+				version.Version, idx+1)
+		}
+	}
+
+	return versions, nil
+}
+
+// parseFileName splits a schema migration file name like 0002_create_settings.sql into its version and description
+func parseFileName(name string) (int, string, error) {
+	prefix, rest, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), "_")
+	if !ok || rest == "" {
+		return 0, "", fmt.Errorf("invalid schema migration file name %s: expected <version>_<description>.sql", name)
+	}
+
+	version, err := strconv.Atoi(prefix)
+	if err != nil || version < 1 {
+		return 0, "", fmt.Errorf("invalid schema migration file name %s: version must be a positive integer", name)
+	}
+
+	return version, strings.ReplaceAll(rest, "_", " "), nil
+}
+
+// Bootstrap creates the metadata database if it's missing and brings its schema up to the latest version
+func Bootstrap(ctx context.Context) error {
+	database := utils.MetadataDatabase()
+	if err := createDatabase(ctx, database); err != nil {
+		return err
+	}
+
+	connString, err := utils.BuildMetadataConnectionString()
+	if err != nil {
+		return fmt.Errorf("failed to build connection string for metadata database %s: %w", database, err)
+	}
+
+	pool, err := pgxpool.New(ctx, connString)
+	if err != nil {
+		return fmt.Errorf("failed to create connection pool for metadata database %s: %w", database, err)
+	}
+	defer pool.Close()
+
+	versions, err := Versions()
+	if err != nil {
+		return err
+	}
+
+	return Migrate(ctx, pool, utils.MetadataSchema(), versions)
+}
+
+// createDatabase creates a database through the cluster's maintenance database, since it can't be connected to
+// before it exists
+func createDatabase(ctx context.Context, database string) error {
+	connString, err := utils.BuildConnectionString("postgres")
+	if err != nil {
+		return fmt.Errorf("failed to build connection string for maintenance database: %w", err)
+	}
+
+	conn, err := pgx.Connect(ctx, connString)
+	if err != nil {
+		return fmt.Errorf("failed to connect to maintenance database: %w", err)
+	}
+	defer conn.Close(context.Background())
+
+	var exists bool
+	err = conn.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM pg_database WHERE datname = $1)`, database).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check whether database %s exists: %w", database, err)
+	}
+	if exists {
+		return nil
+	}
+
+	log.Info().Str("database", database).Msg("creating metadata database")
+	if _, err := conn.Exec(ctx, "CREATE DATABASE "+pgx.Identifier{database}.Sanitize()); err != nil {
+		return fmt.Errorf("failed to create database %s: %w", database, err)
+	}
+
+	return nil
+}
+
+// Migrate creates the schema if needed and applies the versions it hasn't seen yet, each in its own transaction.
+// It refuses to run against a schema that has already been migrated past the latest version given.
+func Migrate(ctx context.Context, pool *pgxpool.Pool, schema string, versions []Version) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to metadata database: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, advisoryLockKey); err != nil {
+		return fmt.Errorf("failed to lock metadata schema %s: %w", schema, err)
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, advisoryLockKey)
+
+	if _, err := conn.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS "+pgx.Identifier{schema}.Sanitize()); err != nil {
+		return fmt.Errorf("failed to create metadata schema %s: %w", schema, err)
+	}
+
+	versionTable := pgx.Identifier{schema, "gomad_schema_version"}.Sanitize()
+	_, err = conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS `+versionTable+` (
+			version INTEGER PRIMARY KEY,
+			description TEXT NOT NULL,
+			applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema version table in schema %s: %w", schema, err)
+	}
+
+	var current int
+	err = conn.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM `+versionTable).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("failed to read version of schema %s: %w", schema, err)
+	}
+
+	latest := len(versions)
+	if current > latest {
+		return fmt.Errorf("%w: schema %s is at version %d, but this binary only knows up to %d",
+			ErrSchemaTooNew, schema, current, latest)
+	}
+
+	for _, version := range versions[current:] {
+		err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			// Schema migrations use unqualified names, so they're created in the metadata schema
+			if _, err := tx.Exec(ctx, "SET LOCAL search_path TO "+pgx.Identifier{schema}.Sanitize()); err != nil {
+				return err
+			}
+
+			if _, err := tx.Exec(ctx, version.SQL); err != nil {
+				return err
+			}
+
+			_, err := tx.Exec(ctx, `INSERT INTO `+versionTable+` (version, description) VALUES ($1, $2)`,
+				version.Version, version.Description)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply schema version %d (%s) to schema %s: %w",
+				version.Version, version.Description, schema, err)
+		}
+
+		log.Info().Str("schema", schema).Int("version", version.Version).Str("description", version.Description).
+			Msg("applied schema migration")
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package schema
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestVersions(t *testing.T) {
+	versions, err := Versions()
+	if err != nil {
+		t.Fatalf("Versions() error = %v", err)
+	}
+
+	if len(versions) == 0 {
+		t.Fatalf("expected schema migrations")
+	}
+
+	tables := make([]string, 0)
+	for idx, version := range versions {
+		if version.Version != idx+1 {
+			t.Errorf("expected version %d, got %d", idx+1, version.Version)
+		}
+		if version.Description == "" || strings.TrimSpace(version.SQL) == "" {
+			t.Errorf("version %d is missing its description or SQL", version.Version)
+		}
+		if strings.Contains(version.SQL, "gomad.") {
+			t.Errorf("version %d qualifies table names; the metadata schema is configurable", version.Version)
+		}
+		if table, ok := strings.CutPrefix(version.Description, "create "); ok {
+			tables = append(tables, table)
+		}
+	}
+
+	// Every metadata table lives in the one database
+	for _, table := range []string{"migrations", "namespace settings", "webhook secrets"} {
+		if !slices.Contains(tables, table) {
+			t.Errorf("expected a schema migration creating %s, got %v", table, tables)
+		}
+	}
+}
+
+func TestParseFileName(t *testing.T) {
+	tests := []struct {
+		name        string
+		version     int
+		description string
+		wantErr     bool
+	}{
+		{name: "0001_create_migrations.sql", version: 1, description: "create migrations"},
+		{name: "12_add_index.sql", version: 12, description: "add index"},
+		{name: "create_migrations.sql", wantErr: true},
+		{name: "0001.sql", wantErr: true},
+		{name: "0000_initial.sql", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		version, description, err := parseFileName(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseFileName(%s) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if version != tt.version || description != tt.description {
+			t.Errorf("parseFileName(%s) = %d, %q", tt.name, version, description)
+		}
+	}
+}
@@ -2,8 +2,15 @@ package utils
 
 import (
 	"fmt"
+	"io"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+
+	"github.com/dfryer1193/gomad/api"
 )
 
 // Helper functions
@@ -30,6 +37,184 @@ func BuildConnectionString(dbName string) (string, error) {
 	), nil
 }
 
+// MetadataDatabase is the database holding gomad's own tables, named by GOMAD_METADATA_DB
+func MetadataDatabase() string {
+	return getEnvOrDefault("GOMAD_METADATA_DB", "gomad")
+}
+
+// MetadataSchema is the schema gomad's tables live in within the metadata database, named by GOMAD_METADATA_SCHEMA
+func MetadataSchema() string {
+	return getEnvOrDefault("GOMAD_METADATA_SCHEMA", "gomad")
+}
+
+// BuildMetadataConnectionString constructs a connection string for the metadata database with the metadata schema
+// on its search_path, so gomad's queries don't need to qualify table names
+func BuildMetadataConnectionString() (string, error) {
+	connString, err := BuildConnectionString(MetadataDatabase())
+	if err != nil {
+		return "", err
+	}
+
+	return connString + "?" + url.Values{"search_path": {MetadataSchema()}}.Encode(), nil
+}
+
+// DefaultNamespaceConnection is the connection used for namespaces that aren't registered: a postgres database named
+// after the namespace on the cluster given by the DB_* environment variables
+func DefaultNamespaceConnection(namespace string) (*api.NamespaceConnection, error) {
+	port, err := strconv.Atoi(getEnvOrDefault("DB_PORT", "5432"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid port number: %w", err)
+	}
+
+	conn := &api.NamespaceConnection{
+		Namespace: namespace,
+		Driver:    api.DriverPostgres,
+		Host:      getEnvOrDefault("DB_HOST", "localhost"),
+		Port:      port,
+		Database:  namespace,
+		User:      getEnvOrDefault("DB_USER", "postgres"),
+	}
+	if _, ok := os.LookupEnv("DB_PASSWORD"); ok {
+		conn.CredentialsRef = "env:DB_PASSWORD"
+	}
+
+	return conn, nil
+}
+
+// BuildNamespaceConnectionString constructs a connection string for a registered namespace connection, reading its
+// password from the credentials reference
+func BuildNamespaceConnectionString(conn *api.NamespaceConnection) (string, error) {
+	password, err := ResolveCredentials(conn.CredentialsRef)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve credentials for namespace %s: %w", conn.Namespace, err)
+	}
+
+	port := conn.Port
+	if port == 0 {
+		port = 5432
+	}
+
+	dsn := url.URL{
+		Scheme: "postgres",
+		Host:   net.JoinHostPort(conn.Host, strconv.Itoa(port)),
+		Path:   "/" + conn.Database,
+	}
+	if password != "" {
+		dsn.User = url.UserPassword(conn.User, password)
+	} else {
+		dsn.User = url.User(conn.User)
+	}
+
+	params := url.Values{}
+	if conn.SSLMode != "" {
+		params.Set("sslmode", conn.SSLMode)
+	}
+	if conn.SSLRootCert != "" {
+		params.Set("sslrootcert", conn.SSLRootCert)
+	}
+	if conn.Schema != "" {
+		params.Set("search_path", conn.Schema)
+	}
+	dsn.RawQuery = params.Encode()
+
+	return dsn.String(), nil
+}
+
+const (
+	// credentialEnvPrefix is the prefix environment variables must have to be referenced as credentials, so a
+	// reference can't point at gomad's own secrets such as GITHUB_TOKEN or GOMAD_ADMIN_SECRET
+	credentialEnvPrefix = "GOMAD_CREDENTIAL_"
+	// credentialsDirEnv names the directory credentials files must be in. Without it, files can't be referenced.
+	credentialsDirEnv = "GOMAD_CREDENTIALS_DIR"
+)
+
+// ValidateCredentialsRef checks that a credentials reference is one gomad will resolve: env:NAME for an environment
+// variable whose name starts with GOMAD_CREDENTIAL_, or file:/path for a file inside GOMAD_CREDENTIALS_DIR. An empty
+// reference is valid and has no secret.
+func ValidateCredentialsRef(ref string) error {
+	if ref == "" {
+		return nil
+	}
+
+	kind, target, ok := strings.Cut(ref, ":")
+	if !ok || target == "" {
+		return fmt.Errorf("invalid credentials reference %q: expected env:NAME or file:/path", ref)
+	}
+
+	switch kind {
+	case "env":
+		if !strings.HasPrefix(target, credentialEnvPrefix) || target == credentialEnvPrefix {
+			return fmt.Errorf("credentials environment variable %s must start with %s", target, credentialEnvPrefix)
+		}
+		return nil
+	case "file":
+		_, err := credentialsFilePath(target)
+		return err
+	default:
+		return fmt.Errorf("unsupported credentials reference kind %q", kind)
+	}
+}
+
+// credentialsFilePath returns the cleaned path of a credentials file, checking that it's inside the credentials
+// directory
+func credentialsFilePath(path string) (string, error) {
+	dir := os.Getenv(credentialsDirEnv)
+	if dir == "" {
+		return "", fmt.Errorf("credentials files can't be referenced unless %s is set", credentialsDirEnv)
+	}
+
+	dir, path = filepath.Clean(dir), filepath.Clean(path)
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("credentials file %s must be an absolute path", path)
+	}
+	if rel, err := filepath.Rel(dir, path); err != nil || rel == "." || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("credentials file %s must be inside %s", path, dir)
+	}
+
+	return path, nil
+}
+
+// ResolveCredentials reads the secret a credentials reference points at, refusing references ValidateCredentialsRef
+// rejects. Credentials files are read through an os.Root on the credentials directory, so symlinks can't lead out
+// of it.
+func ResolveCredentials(ref string) (string, error) {
+	if err := ValidateCredentialsRef(ref); err != nil || ref == "" {
+		return "", err
+	}
+
+	kind, target, _ := strings.Cut(ref, ":")
+	switch kind {
+	case "env":
+		value, ok := os.LookupEnv(target)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", target)
+		}
+		return value, nil
+	default:
+		path, _ := credentialsFilePath(target)
+		dir := filepath.Clean(os.Getenv(credentialsDirEnv))
+		rel, _ := filepath.Rel(dir, path)
+
+		root, err := os.OpenRoot(dir)
+		if err != nil {
+			return "", fmt.Errorf("failed to open credentials directory: %w", err)
+		}
+		defer root.Close()
+
+		file, err := root.Open(rel)
+		if err != nil {
+			return "", fmt.Errorf("failed to read credentials file: %w", err)
+		}
+		defer file.Close()
+
+		content, err := io.ReadAll(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read credentials file: %w", err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	}
+}
+
 // getEnvOrDefault returns environment variable value or default if not set
 func getEnvOrDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
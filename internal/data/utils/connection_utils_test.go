@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestBuildNamespaceConnectionString(t *testing.T) {
+	t.Setenv("GOMAD_CREDENTIAL_BILLING_DB", "p@ss:word/with?chars")
+
+	connString, err := BuildNamespaceConnectionString(&api.NamespaceConnection{
+		Namespace:      "billing",
+		Host:           "db.internal",
+		Port:           6432,
+		Database:       "billing_prod",
+		Schema:         "ledger",
+		User:           "gomad",
+		CredentialsRef: "env:GOMAD_CREDENTIAL_BILLING_DB",
+		SSLMode:        "disable",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", connString, err)
+	}
+
+	conn := config.ConnConfig
+	if conn.Host != "db.internal" || conn.Port != 6432 || conn.Database != "billing_prod" || conn.User != "gomad" {
+		t.Errorf("unexpected connection config %s@%s:%d/%s", conn.User, conn.Host, conn.Port, conn.Database)
+	}
+	if conn.Password != "p@ss:word/with?chars" {
+		t.Errorf("expected password from environment, got %q", conn.Password)
+	}
+	if conn.RuntimeParams["search_path"] != "ledger" {
+		t.Errorf("expected search_path ledger, got %q", conn.RuntimeParams["search_path"])
+	}
+	if conn.TLSConfig != nil {
+		t.Errorf("expected TLS to be disabled")
+	}
+}
+
+func TestResolveCredentials(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "password")
+	if err := os.WriteFile(secretFile, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "outside")
+	if err := os.WriteFile(outside, []byte("not a credential"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOMAD_CREDENTIALS_DIR", dir)
+	t.Setenv("GOMAD_CREDENTIAL_TEST_PASSWORD", "from-env")
+	t.Setenv("GOMAD_TEST_PASSWORD", "not a credential")
+
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{ref: "", want: ""},
+		{ref: "env:GOMAD_CREDENTIAL_TEST_PASSWORD", want: "from-env"},
+		{ref: "file:" + secretFile, want: "from-file"},
+		{ref: "env:GOMAD_CREDENTIAL_UNSET", wantErr: true},
+		{ref: "file:" + filepath.Join(dir, "missing"), wantErr: true},
+		// Only credentials gomad was given for namespaces can be referenced
+		{ref: "env:GOMAD_TEST_PASSWORD", wantErr: true},
+		{ref: "env:GITHUB_TOKEN", wantErr: true},
+		{ref: "file:" + outside, wantErr: true},
+		{ref: "file:" + dir + "/../outside", wantErr: true},
+		{ref: "file:" + filepath.Join(dir, "link"), wantErr: true},
+		{ref: "file:password", wantErr: true},
+		{ref: "file:" + dir, wantErr: true},
+		{ref: "vault:secret/billing", wantErr: true},
+		{ref: "hunter2", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ResolveCredentials(tt.ref)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ResolveCredentials(%q) error = %v, wantErr %v", tt.ref, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ResolveCredentials(%q) = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestBuildMetadataConnectionString(t *testing.T) {
+	t.Setenv("GOMAD_METADATA_DB", "gomad_meta")
+	t.Setenv("GOMAD_METADATA_SCHEMA", "meta")
+
+	connString, err := BuildMetadataConnectionString()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", connString, err)
+	}
+
+	if config.ConnConfig.Database != "gomad_meta" {
+		t.Errorf("expected database gomad_meta, got %q", config.ConnConfig.Database)
+	}
+	if config.ConnConfig.RuntimeParams["search_path"] != "meta" {
+		t.Errorf("expected search_path meta, got %q", config.ConnConfig.RuntimeParams["search_path"])
+	}
+}
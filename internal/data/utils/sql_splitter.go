@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+var dollarQuoteTag = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+// SplitStatements splits DDL into individual statements on semicolons, ignoring semicolons inside quoted strings,
+// quoted identifiers (including MySQL's backticks), dollar-quoted bodies and comments. Comments are dropped from the
+// returned statements.
+func SplitStatements(ddl string) []string {
+	var statements []string
+	var current strings.Builder
+
+	flush := func() {
+		stmt := strings.TrimSpace(current.String())
+		if stmt != "" {
+			statements = append(statements, stmt)
+		}
+		current.Reset()
+	}
+
+	for i := 0; i < len(ddl); i++ {
+		c := ddl[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := ClosingQuote(ddl, i+1, c)
+			current.WriteString(ddl[i:end])
+			i = end - 1
+		case c == '-' && strings.HasPrefix(ddl[i:], "--"):
+			end := strings.IndexByte(ddl[i:], '\n')
+			if end < 0 {
+				i = len(ddl)
+			} else {
+				i += end
+				current.WriteByte('\n')
+			}
+		case c == '/' && strings.HasPrefix(ddl[i:], "/*"):
+			end := strings.Index(ddl[i+2:], "*/")
+			if end < 0 {
+				i = len(ddl)
+			} else {
+				i += end + 3
+				current.WriteByte(' ')
+			}
+		case c == '$':
+			tag := dollarQuoteTag.FindString(ddl[i:])
+			if tag == "" {
+				current.WriteByte(c)
+				continue
+			}
+			end := strings.Index(ddl[i+len(tag):], tag)
+			if end < 0 {
+				current.WriteString(ddl[i:])
+				i = len(ddl)
+			} else {
+				stop := i + len(tag) + end + len(tag)
+				current.WriteString(ddl[i:stop])
+				i = stop - 1
+			}
+		case c == ';':
+			flush()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	flush()
+
+	return statements
+}
+
+// ClosingQuote returns the index just past the quote closing the string starting at start. Doubled quotes are
+// treated as escapes.
+func ClosingQuote(s string, start int, quote byte) int {
+	for i := start; i < len(s); i++ {
+		if s[i] != quote {
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == quote {
+			i++
+			continue
+		}
+		return i + 1
+	}
+	return len(s)
+}
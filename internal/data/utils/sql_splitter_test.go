@@ -0,0 +1,61 @@
+package utils
+
+import "testing"
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		ddl  string
+		want []string
+	}{
+		{
+			name: "simple statements",
+			ddl:  "CREATE TABLE a (id INT);\nCREATE TABLE b (id INT);",
+			want: []string{"CREATE TABLE a (id INT)", "CREATE TABLE b (id INT)"},
+		},
+		{
+			name: "semicolon in string",
+			ddl:  "INSERT INTO a VALUES ('x;y');",
+			want: []string{"INSERT INTO a VALUES ('x;y')"},
+		},
+		{
+			name: "escaped quote in string",
+			ddl:  "INSERT INTO a VALUES ('it''s;');SELECT 1",
+			want: []string{"INSERT INTO a VALUES ('it''s;')", "SELECT 1"},
+		},
+		{
+			name: "dollar quoted body",
+			ddl:  "CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql;",
+			want: []string{"CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql"},
+		},
+		{
+			name: "backtick quoted identifier",
+			ddl:  "CREATE TABLE `odd;name` (id INT);SELECT 1",
+			want: []string{"CREATE TABLE `odd;name` (id INT)", "SELECT 1"},
+		},
+		{
+			name: "comments dropped",
+			ddl:  "-- drop it;\nDROP TABLE a; /* and; this */ SELECT 1;",
+			want: []string{"DROP TABLE a", "SELECT 1"},
+		},
+		{
+			name: "empty",
+			ddl:  " ; ;",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SplitStatements(tt.ddl)
+			if len(got) != len(tt.want) {
+				t.Fatalf("SplitStatements() = %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("statement[%d] = %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
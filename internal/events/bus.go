@@ -0,0 +1,205 @@
+package events
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/tracing"
+	"github.com/rs/zerolog/log"
+)
+
+// subscriptionBuffer is how many events a subscriber can fall behind by before it starts missing them
+const subscriptionBuffer = 256
+
+// Bus passes migration events from the parts of gomad that produce them to whoever is listening. Publishing never
+// blocks: a subscriber that can't keep up misses events rather than holding up migrations, apart from the event
+// types it asked to keep, which are queued for it instead.
+type Bus struct {
+	mu          sync.Mutex
+	lastID      uint64
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+// Subscription receives the events published to a bus, optionally limited to one namespace
+type Subscription struct {
+	bus       *Bus
+	namespace string
+	keep      []api.EventType
+	events    chan *api.Event
+
+	// The rest is guarded by the bus's lock. backlog holds kept events that didn't fit in the buffer until drain
+	// hands them over; while it's draining, events is closed by drain rather than by end.
+	backlog    []*api.Event
+	draining   bool
+	ended      bool
+	abandoning bool
+	abandoned  chan struct{}
+}
+
+var (
+	bus     *Bus
+	busOnce sync.Once
+)
+
+func GetBus() *Bus {
+	busOnce.Do(func() {
+		bus = NewBus()
+	})
+
+	return bus
+}
+
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Publish numbers the event, stamps it with the trace in ctx and sends it to every subscriber interested in its
+// namespace. Events published after the bus is closed are dropped.
+func (b *Bus) Publish(ctx context.Context, event *api.Event) {
+	tracing.InjectEvent(ctx, event)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+
+	b.lastID++
+	event.ID = b.lastID
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+
+	for sub := range b.subscribers {
+		if sub.namespace != "" && sub.namespace != event.Namespace {
+			continue
+		}
+
+		sub.send(event)
+	}
+}
+
+// Subscribe starts receiving the events of a namespace, or of every namespace if it's empty. Events of the kept
+// types are never dropped: those that don't fit in the buffer are queued until the subscriber catches up, and are
+// still delivered after the bus is closed. The subscription must be closed when it's no longer needed.
+func (b *Bus) Subscribe(namespace string, keep ...api.EventType) *Subscription {
+	sub := &Subscription{
+		bus:       b,
+		namespace: namespace,
+		keep:      keep,
+		events:    make(chan *api.Event, subscriptionBuffer),
+		abandoned: make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		sub.end(false)
+		return sub
+	}
+	b.subscribers[sub] = struct{}{}
+
+	return sub
+}
+
+// Close ends every subscription, so streams following the bus finish. Nothing is delivered after it returns.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subscribers {
+		delete(b.subscribers, sub)
+		sub.end(false)
+	}
+}
+
+// Events is closed when the subscription or its bus is
+func (s *Subscription) Events() <-chan *api.Event {
+	return s.events
+}
+
+// Close ends the subscription, abandoning any kept events still queued for it
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	delete(s.bus.subscribers, s)
+	s.end(true)
+}
+
+// send hands the event to the subscriber, queueing it if the buffer is full and it's of a type the subscriber keeps.
+// Kept events queue behind each other, so they arrive in the order they were published. Called with the bus locked.
+func (s *Subscription) send(event *api.Event) {
+	if !s.draining {
+		select {
+		case s.events <- event:
+			return
+		default:
+		}
+	}
+
+	if !slices.Contains(s.keep, event.Type) {
+		log.Warn().Uint64("event", event.ID).Str("type", string(event.Type)).Msg("event subscriber is falling behind, dropping event")
+		return
+	}
+
+	s.backlog = append(s.backlog, event)
+	if !s.draining {
+		s.draining = true
+		go s.drain()
+	}
+}
+
+// drain hands the backlog over as the subscriber reads, closing events once it's empty if the subscription has ended
+func (s *Subscription) drain() {
+	for {
+		s.bus.mu.Lock()
+		if len(s.backlog) == 0 {
+			s.draining = false
+			if s.ended {
+				close(s.events)
+			}
+			s.bus.mu.Unlock()
+			return
+		}
+		event := s.backlog[0]
+		s.backlog = s.backlog[1:]
+		s.bus.mu.Unlock()
+
+		select {
+		case s.events <- event:
+		case <-s.abandoned:
+			s.bus.mu.Lock()
+			s.draining = false
+			s.backlog = nil
+			close(s.events)
+			s.bus.mu.Unlock()
+			return
+		}
+	}
+}
+
+// end stops the subscription receiving events. Kept events still queued are delivered first unless abandon is set.
+// Called with the bus locked.
+func (s *Subscription) end(abandon bool) {
+	if !s.draining {
+		if !s.ended {
+			close(s.events)
+		}
+		s.ended = true
+		return
+	}
+
+	s.ended = true
+	if abandon && !s.abandoning {
+		s.abandoning = true
+		close(s.abandoned)
+	}
+}
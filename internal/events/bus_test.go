@@ -0,0 +1,112 @@
+package events
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/dfryer1193/gomad/api"
+)
+
+func TestBus(t *testing.T) {
+	b := NewBus()
+	all := b.Subscribe("")
+	ns1 := b.Subscribe("ns1")
+
+	b.Publish(context.Background(), &api.Event{Type: api.EventMigrationIngested, Namespace: "ns1", MigrationID: 1})
+	b.Publish(context.Background(), &api.Event{Type: api.EventMigrationIngested, Namespace: "ns2", MigrationID: 2})
+
+	for _, want := range []uint64{1, 2} {
+		event := <-all.Events()
+		if event.ID != want || event.MigrationID != want || event.At.IsZero() {
+			t.Errorf("global subscription got %+v, want event %d", event, want)
+		}
+	}
+	if event := <-ns1.Events(); event.Namespace != "ns1" {
+		t.Errorf("ns1 subscription got %+v", event)
+	}
+	if len(ns1.Events()) != 0 {
+		t.Errorf("expected ns1 subscription to skip other namespaces' events")
+	}
+
+	ns1.Close()
+	b.Publish(context.Background(), &api.Event{Type: api.EventMigrationStarted, Namespace: "ns1", MigrationID: 1})
+	if _, ok := <-ns1.Events(); ok {
+		t.Errorf("expected a closed subscription to receive nothing")
+	}
+
+	b.Close()
+	if event := <-all.Events(); event.Type != api.EventMigrationStarted {
+		t.Errorf("expected events published before closing to be kept, got %+v", event)
+	}
+	if _, ok := <-all.Events(); ok {
+		t.Errorf("expected closing the bus to end subscriptions")
+	}
+	if _, ok := <-b.Subscribe("").Events(); ok {
+		t.Errorf("expected subscribing to a closed bus to end immediately")
+	}
+	b.Publish(context.Background(), &api.Event{Type: api.EventMigrationSucceeded, Namespace: "ns1"})
+}
+
+func TestBusDropsForSlowSubscribers(t *testing.T) {
+	b := NewBus()
+	sub := b.Subscribe("")
+	defer sub.Close()
+
+	for range subscriptionBuffer + 10 {
+		b.Publish(context.Background(), &api.Event{Type: api.EventNotice, Namespace: "ns1"})
+	}
+
+	if len(sub.Events()) != subscriptionBuffer {
+		t.Errorf("expected %d buffered events, got %d", subscriptionBuffer, len(sub.Events()))
+	}
+}
+
+func TestBusKeepsEventsForSlowSubscribers(t *testing.T) {
+	b := NewBus()
+	sub := b.Subscribe("", api.EventMigrationFailed)
+
+	for range subscriptionBuffer {
+		b.Publish(context.Background(), &api.Event{Type: api.EventNotice, Namespace: "ns1"})
+	}
+	for id := range uint64(3) {
+		b.Publish(context.Background(), &api.Event{Type: api.EventMigrationFailed, Namespace: "ns1", MigrationID: id})
+		b.Publish(context.Background(), &api.Event{Type: api.EventNotice, Namespace: "ns1"})
+	}
+	b.Close()
+
+	var failures []uint64
+	notices := 0
+	for event := range sub.Events() {
+		switch event.Type {
+		case api.EventMigrationFailed:
+			failures = append(failures, event.MigrationID)
+		case api.EventNotice:
+			notices++
+		}
+	}
+
+	// Notices past the buffer are dropped, but every failure arrives, in order, even though the bus was closed
+	if notices != subscriptionBuffer || !slices.Equal(failures, []uint64{0, 1, 2}) {
+		t.Errorf("expected %d notices and failures [0 1 2], got %d notices and failures %v", subscriptionBuffer, notices, failures)
+	}
+}
+
+func TestClosingSubscriptionAbandonsKeptEvents(t *testing.T) {
+	b := NewBus()
+	defer b.Close()
+	sub := b.Subscribe("", api.EventMigrationFailed)
+
+	for range subscriptionBuffer + 5 {
+		b.Publish(context.Background(), &api.Event{Type: api.EventMigrationFailed, Namespace: "ns1"})
+	}
+	sub.Close()
+
+	received := 0
+	for range sub.Events() {
+		received++
+	}
+	if received < subscriptionBuffer || received > subscriptionBuffer+5 {
+		t.Errorf("expected the buffered events and at most the queued ones, got %d", received)
+	}
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/dfryer1193/gomad/internal/data/repository"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectTimeout bounds the queries run while a scrape is being served
+const collectTimeout = 5 * time.Second
+
+// MigrationCounter counts migrations by namespace and status
+type MigrationCounter interface {
+	CountByStatus(ctx context.Context) ([]*repository.MigrationStatusCount, error)
+}
+
+// migrationStatusCollector reports how many migrations are in each status, counting them on every scrape
+type migrationStatusCollector struct {
+	counter MigrationCounter
+	desc    *prometheus.Desc
+}
+
+func NewMigrationStatusCollector(counter MigrationCounter) prometheus.Collector {
+	return &migrationStatusCollector{
+		counter: counter,
+		desc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "migrations"),
+			"Migrations in each status, by namespace.", []string{"namespace", "status"}, nil),
+	}
+}
+
+func (c *migrationStatusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *migrationStatusCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+
+	counts, err := c.counter.CountByStatus(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+
+	for _, count := range counts {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count.Count), count.Namespace, string(count.Status))
+	}
+}
+
+// PoolStats is a snapshot of one pgx pool. Pool is "metadata" for gomad's own database, or "target" for a
+// namespace's database, in which case Namespace names it.
+type PoolStats struct {
+	Pool      string
+	Namespace string
+	Stat      *pgxpool.Stat
+}
+
+// poolCollector reports the statistics of the pgx pools open when a scrape is served
+type poolCollector struct {
+	pools func() []PoolStats
+
+	acquiredConns    *prometheus.Desc
+	idleConns        *prometheus.Desc
+	totalConns       *prometheus.Desc
+	maxConns         *prometheus.Desc
+	acquires         *prometheus.Desc
+	acquireDuration  *prometheus.Desc
+	emptyAcquires    *prometheus.Desc
+	canceledAcquires *prometheus.Desc
+}
+
+func NewPoolCollector(pools func() []PoolStats) prometheus.Collector {
+	labels := []string{"pool", "namespace"}
+	desc := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "pgx_pool", name), help, labels, nil)
+	}
+
+	return &poolCollector{
+		pools:            pools,
+		acquiredConns:    desc("acquired_connections", "Connections currently acquired from the pool."),
+		idleConns:        desc("idle_connections", "Idle connections in the pool."),
+		totalConns:       desc("total_connections", "Connections in the pool, acquired, idle or being opened."),
+		maxConns:         desc("max_connections", "Most connections the pool will open."),
+		acquires:         desc("acquires_total", "Connections acquired from the pool."),
+		acquireDuration:  desc("acquire_duration_seconds_total", "Time spent acquiring connections from the pool."),
+		emptyAcquires:    desc("empty_acquires_total", "Acquires that had to wait because the pool had no idle connection."),
+		canceledAcquires: desc("canceled_acquires_total", "Acquires cancelled before a connection was available."),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquiredConns
+	ch <- c.idleConns
+	ch <- c.totalConns
+	ch <- c.maxConns
+	ch <- c.acquires
+	ch <- c.acquireDuration
+	ch <- c.emptyAcquires
+	ch <- c.canceledAcquires
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, pool := range c.pools() {
+		stat := pool.Stat
+		labels := []string{pool.Pool, pool.Namespace}
+		ch <- prometheus.MustNewConstMetric(c.acquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()), labels...)
+		ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(stat.IdleConns()), labels...)
+		ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.GaugeValue, float64(stat.TotalConns()), labels...)
+		ch <- prometheus.MustNewConstMetric(c.maxConns, prometheus.GaugeValue, float64(stat.MaxConns()), labels...)
+		ch <- prometheus.MustNewConstMetric(c.acquires, prometheus.CounterValue, float64(stat.AcquireCount()), labels...)
+		ch <- prometheus.MustNewConstMetric(c.acquireDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds(), labels...)
+		ch <- prometheus.MustNewConstMetric(c.emptyAcquires, prometheus.CounterValue, float64(stat.EmptyAcquireCount()), labels...)
+		ch <- prometheus.MustNewConstMetric(c.canceledAcquires, prometheus.CounterValue, float64(stat.CanceledAcquireCount()), labels...)
+	}
+}
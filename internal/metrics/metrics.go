@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gomad"
+
+// Outcomes of a webhook delivery
+const (
+	WebhookProcessed      = "processed"
+	WebhookIgnored        = "ignored"
+	WebhookInvalidPayload = "invalid_payload"
+	WebhookUnauthorized   = "unauthorized"
+	WebhookRejected       = "rejected"
+	WebhookError          = "error"
+)
+
+// registry holds every metric gomad exposes. It's kept apart from the default registry so only gomad's own
+// collectors, plus the runtime ones, end up on /metrics.
+var registry = prometheus.NewRegistry()
+
+var (
+	WebhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Push webhooks received, by the git provider that sent them and how they were handled.",
+	}, []string{"provider", "outcome"})
+
+	WebhookSignatureFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_signature_failures_total",
+		Help:      "Push webhooks rejected because their signature didn't match the repository's secret.",
+	}, []string{"provider"})
+
+	FileFetchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "file_fetch_duration_seconds",
+		Help:      "Time taken to fetch a migration file, by the source it was read from.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"source"})
+
+	FileFetchErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "file_fetch_errors_total",
+		Help:      "Migration files that couldn't be fetched, by the source they were read from.",
+	}, []string{"source"})
+
+	FileCacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "file_cache_hits_total",
+		Help:      "Migration files whose contents were found in the file cache.",
+	})
+
+	FileCacheMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "file_cache_misses_total",
+		Help:      "Migration files whose contents had to be downloaded because they weren't in the file cache.",
+	})
+
+	ParseErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "migration_parse_errors_total",
+		Help:      "Migration files that couldn't be parsed.",
+	})
+
+	ExecutionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "migration_execution_duration_seconds",
+		Help:      "Time taken to execute a migration, retries included, by namespace and whether it succeeded.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 14),
+	}, []string{"namespace", "outcome"})
+
+	LockTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "migration_lock_timeouts_total",
+		Help:      "Execution attempts that gave up waiting for a lock, by namespace.",
+	}, []string{"namespace"})
+
+	LockWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "migration_lock_wait_seconds",
+		Help:      "Time the statement that hit a lock timeout spent waiting for its lock, by namespace.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+	}, []string{"namespace"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		WebhookDeliveries,
+		WebhookSignatureFailures,
+		FileFetchDuration,
+		FileFetchErrors,
+		FileCacheHits,
+		FileCacheMisses,
+		ParseErrors,
+		ExecutionDuration,
+		LockTimeouts,
+		LockWait,
+	)
+}
+
+// Register adds collectors whose metrics depend on how gomad is set up, like those reading its storage
+func Register(collectors ...prometheus.Collector) {
+	registry.MustRegister(collectors...)
+}
+
+// Handler serves every registered metric in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
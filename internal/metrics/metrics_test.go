@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type fakeMigrationCounter struct {
+	counts []*repository.MigrationStatusCount
+	err    error
+}
+
+func (c *fakeMigrationCounter) CountByStatus(_ context.Context) ([]*repository.MigrationStatusCount, error) {
+	return c.counts, c.err
+}
+
+func TestMigrationStatusCollector(t *testing.T) {
+	counter := &fakeMigrationCounter{counts: []*repository.MigrationStatusCount{
+		{Namespace: "ns1", Status: api.MigrationStatusPending, Count: 2},
+		{Namespace: "ns2", Status: api.MigrationStatusFailed, Count: 1},
+	}}
+
+	expected := `
+# HELP gomad_migrations Migrations in each status, by namespace.
+# TYPE gomad_migrations gauge
+gomad_migrations{namespace="ns1",status="pending"} 2
+gomad_migrations{namespace="ns2",status="failed"} 1
+`
+	collector := NewMigrationStatusCollector(counter)
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	// A failed count fails the scrape rather than reporting no migrations
+	counter.err = errors.New("database unavailable")
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	if _, err := registry.Gather(); err == nil {
+		t.Errorf("expected collecting to fail when migrations can't be counted")
+	}
+}
+
+func TestPoolCollector(t *testing.T) {
+	// Pools don't connect until a connection is acquired, so one can be opened without a server
+	pool, err := pgxpool.New(context.Background(), "postgres://gomad@localhost:1/gomad?pool_max_conns=7")
+	if err != nil {
+		t.Fatalf("pgxpool.New() error = %v", err)
+	}
+	defer pool.Close()
+
+	collector := NewPoolCollector(func() []PoolStats {
+		return []PoolStats{{Pool: "target", Namespace: "ns1", Stat: pool.Stat()}}
+	})
+
+	expected := `
+# HELP gomad_pgx_pool_max_connections Most connections the pool will open.
+# TYPE gomad_pgx_pool_max_connections gauge
+gomad_pgx_pool_max_connections{namespace="ns1",pool="target"} 7
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "gomad_pgx_pool_max_connections"); err != nil {
+		t.Error(err)
+	}
+	if count := testutil.CollectAndCount(collector); count != 8 {
+		t.Errorf("expected 8 metrics for one pool, got %d", count)
+	}
+	if problems, err := testutil.CollectAndLint(collector); err != nil || len(problems) != 0 {
+		t.Errorf("CollectAndLint() = %v, %v", problems, err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	WebhookDeliveries.WithLabelValues("github", WebhookProcessed).Inc()
+	FileFetchDuration.WithLabelValues("mirror").Observe(0.2)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, _ := io.ReadAll(rec.Body)
+	for _, want := range []string{
+		`gomad_webhook_deliveries_total{outcome="processed",provider="github"}`,
+		`gomad_file_fetch_duration_seconds_count{source="mirror"} 1`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("expected %s in the metrics served", want)
+		}
+	}
+}
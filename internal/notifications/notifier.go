@@ -0,0 +1,241 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+	"github.com/dfryer1193/gomad/internal/data/repository/storage"
+	"github.com/dfryer1193/gomad/internal/events"
+	"github.com/dfryer1193/gomad/internal/tracing"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// deliveryAttempts is how many times an event is sent to a sink before giving up on it
+	deliveryAttempts = 4
+	// defaultRetryBackoff is the wait before the first retry of a delivery; it doubles on each retry
+	defaultRetryBackoff = 2 * time.Second
+	// deliveryTimeout bounds a single attempt at sending an event to a sink
+	deliveryTimeout = 10 * time.Second
+	// sinkLookupTimeout bounds fetching the sinks an event goes to
+	sinkLookupTimeout = 5 * time.Second
+	// sinkCacheTTL is how long fetched sinks are reused. Changes made through this server take effect at once; the
+	// TTL bounds how long changes made through other servers sharing the storage take.
+	sinkCacheTTL = 30 * time.Second
+)
+
+// ErrUnsupportedSink is returned for sinks of a kind the notifier has no sender for
+var ErrUnsupportedSink = errors.New("unsupported notification sink")
+
+// sender delivers an event to one kind of sink
+type sender interface {
+	Send(ctx context.Context, sink *api.NotificationSink, event *api.Event) error
+}
+
+// permanentError marks a delivery failure that retrying won't fix, such as a sink rejecting the request
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Notifier sends the events published on the bus to the sinks subscribed to them. Each delivery runs on its own and
+// is retried with exponential backoff, so a slow or failing sink holds up neither migrations nor other sinks. Sinks
+// are cached, so events no sink subscribes to cost no lookups.
+type Notifier struct {
+	sinks        repository.NotificationSinkRepository
+	bus          *events.Bus
+	senders      map[api.SinkKind]sender
+	retryBackoff time.Duration
+	now          func() time.Time
+
+	// cachedSinks were fetched at sinksFetchedAt; generation changes whenever they're invalidated
+	sinksMu        sync.Mutex
+	cachedSinks    []*api.NotificationSink
+	sinksFetchedAt time.Time
+	generation     uint64
+
+	// ctx is cancelled to abandon the deliveries still pending at shutdown
+	ctx        context.Context
+	cancel     context.CancelFunc
+	deliveries sync.WaitGroup
+	startOnce  sync.Once
+}
+
+var (
+	notifier     *Notifier
+	notifierOnce sync.Once
+)
+
+func GetNotifier() *Notifier {
+	notifierOnce.Do(func() {
+		notifier = NewNotifier(storage.GetNotificationSinkRepository(), events.GetBus())
+	})
+
+	return notifier
+}
+
+func NewNotifier(sinks repository.NotificationSinkRepository, bus *events.Bus) *Notifier {
+	ctx, cancel := context.WithCancel(context.Background())
+	httpSender := newHTTPSender()
+
+	return &Notifier{
+		sinks: sinks,
+		bus:   bus,
+		senders: map[api.SinkKind]sender{
+			api.SinkWebhook: httpSender,
+			api.SinkSlack:   httpSender,
+			api.SinkEmail:   &emailSender{},
+		},
+		retryBackoff: defaultRetryBackoff,
+		now:          time.Now,
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// Start follows the bus until it's closed, delivering events in the background. Failures are never dropped, however
+// far behind the notifier falls.
+func (n *Notifier) Start() {
+	n.startOnce.Do(func() {
+		sub := n.bus.Subscribe("", api.EventMigrationFailed)
+		n.deliveries.Add(1)
+		go func() {
+			defer n.deliveries.Done()
+			for event := range sub.Events() {
+				n.notify(event)
+			}
+		}()
+	})
+}
+
+// Send delivers an event to a sink once, without retrying
+func (n *Notifier) Send(ctx context.Context, sink *api.NotificationSink, event *api.Event) error {
+	s, ok := n.senders[sink.Kind]
+	if !ok {
+		return fmt.Errorf("%w: kind %q", ErrUnsupportedSink, sink.Kind)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+
+	return s.Send(ctx, sink, event)
+}
+
+// Shutdown waits for the deliveries in progress, retries included, until ctx is done, then abandons the rest. The
+// bus should be closed first so no new deliveries start.
+func (n *Notifier) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		n.deliveries.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		n.cancel()
+		return ctx.Err()
+	}
+}
+
+// InvalidateSinks drops the cached sinks, so changes to them apply to the next event
+func (n *Notifier) InvalidateSinks() {
+	n.sinksMu.Lock()
+	defer n.sinksMu.Unlock()
+
+	n.cachedSinks = nil
+	n.sinksFetchedAt = time.Time{}
+	n.generation++
+}
+
+// listSinks returns the cached sinks, fetching them again once they're older than sinkCacheTTL. The lock isn't held
+// while fetching, and sinks invalidated meanwhile aren't cached.
+func (n *Notifier) listSinks() ([]*api.NotificationSink, error) {
+	n.sinksMu.Lock()
+	sinks, fetchedAt, generation := n.cachedSinks, n.sinksFetchedAt, n.generation
+	n.sinksMu.Unlock()
+	if !fetchedAt.IsZero() && n.now().Sub(fetchedAt) < sinkCacheTTL {
+		return sinks, nil
+	}
+
+	ctx, cancel := context.WithTimeout(n.ctx, sinkLookupTimeout)
+	defer cancel()
+
+	sinks, err := n.sinks.ListSinks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	n.sinksMu.Lock()
+	defer n.sinksMu.Unlock()
+	if n.generation == generation {
+		n.cachedSinks, n.sinksFetchedAt = sinks, n.now()
+	}
+
+	return sinks, nil
+}
+
+// notify starts delivering the event to each sink subscribed to it
+func (n *Notifier) notify(event *api.Event) {
+	sinks, err := n.listSinks()
+	if err != nil {
+		log.Error().Err(err).Uint64("event", event.ID).Msg("failed to fetch notification sinks")
+		return
+	}
+
+	for _, sink := range sinks {
+		if !sink.Subscribed(event) {
+			continue
+		}
+
+		n.deliveries.Add(1)
+		go func() {
+			defer n.deliveries.Done()
+			n.deliver(sink, event)
+		}()
+	}
+}
+
+// deliver sends the event to the sink, retrying failures that might not happen again. The delivery is traced as
+// part of the trace the event was published in.
+func (n *Notifier) deliver(sink *api.NotificationSink, event *api.Event) {
+	ctx, span := tracing.Start(tracing.EventContext(n.ctx, event), "notification.deliver",
+		attribute.String("gomad.sink", sink.Name), attribute.String("gomad.sink_kind", string(sink.Kind)),
+		attribute.String("gomad.event", string(event.Type)))
+	err := n.deliverWithRetries(ctx, sink, event)
+	tracing.End(span, err)
+}
+
+func (n *Notifier) deliverWithRetries(ctx context.Context, sink *api.NotificationSink, event *api.Event) error {
+	backoff := n.retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := n.Send(ctx, sink, event)
+		if err == nil {
+			return nil
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) || attempt >= deliveryAttempts {
+			log.Error().Err(err).Str("sink", sink.Name).Uint64("event", event.ID).Int("attempts", attempt).Msg("failed to deliver notification")
+			return err
+		}
+
+		log.Warn().Err(err).Str("sink", sink.Name).Uint64("event", event.ID).Int("attempt", attempt).Msg("notification delivery failed, retrying")
+		select {
+		case <-ctx.Done():
+			log.Warn().Str("sink", sink.Name).Uint64("event", event.ID).Msg("abandoning notification delivery at shutdown")
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,449 @@
+package notifications
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+	"github.com/dfryer1193/gomad/internal/data/repository/memory"
+	"github.com/dfryer1193/gomad/internal/events"
+	"github.com/dfryer1193/gomad/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordingServer stands in for webhook and slack endpoints, failing the first failures requests with status
+type recordingServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	failures int
+	status   int
+}
+
+func newRecordingServer(t *testing.T) *recordingServer {
+	s := &recordingServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, body)
+		if len(s.requests) <= s.failures {
+			w.WriteHeader(s.status)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *recordingServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.requests)
+}
+
+// smtpServer stands in for a mail server, keeping the messages it's sent
+type smtpServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	messages []string
+	rcpts    []string
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	s := &smtpServer{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			s.mu.Lock()
+			s.rcpts = append(s.rcpts, strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>"))
+			s.mu.Unlock()
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 go ahead")
+			var msg strings.Builder
+			for {
+				data, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if data == ".\r\n" {
+					break
+				}
+				msg.WriteString(data)
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, msg.String())
+			s.mu.Unlock()
+			reply("250 queued")
+		case command == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func failedEvent() *api.Event {
+	return &api.Event{
+		ID:          7,
+		Type:        api.EventMigrationFailed,
+		Namespace:   "billing",
+		MigrationID: 42,
+		At:          time.Now(),
+		Status:      api.MigrationStatusFailed,
+		Reason:      "execution failed",
+		Error:       &api.ExecutionError{Message: "relation \"t\" already exists", SQLState: "42P07"},
+	}
+}
+
+func TestSendWebhook(t *testing.T) {
+	server := newRecordingServer(t)
+	n := NewNotifier(memory.NewNotificationSinkRepository(), events.NewBus())
+	sink := &api.NotificationSink{Name: "hooks", Kind: api.SinkWebhook, URL: server.URL, Secret: "s3cret"}
+
+	if err := n.Send(context.Background(), sink, failedEvent()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	req, body := server.requests[0], server.bodies[0]
+	if req.Header.Get(SignatureHeader) != Sign("s3cret", body) {
+		t.Errorf("signature %q doesn't match the body", req.Header.Get(SignatureHeader))
+	}
+	if req.Header.Get(EventHeader) != "migration.failed" || req.Header.Get(DeliveryHeader) != "7" {
+		t.Errorf("event headers = %v", req.Header)
+	}
+	var event api.Event
+	if err := json.Unmarshal(body, &event); err != nil || event.MigrationID != 42 || event.Error.SQLState != "42P07" {
+		t.Errorf("delivered %s, %v", body, err)
+	}
+}
+
+func TestSendSlack(t *testing.T) {
+	server := newRecordingServer(t)
+	n := NewNotifier(memory.NewNotificationSinkRepository(), events.NewBus())
+	sink := &api.NotificationSink{Name: "chat", Kind: api.SinkSlack, URL: server.URL}
+
+	if err := n.Send(context.Background(), sink, failedEvent()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	var message map[string]string
+	if err := json.Unmarshal(server.bodies[0], &message); err != nil {
+		t.Fatalf("delivered %s, %v", server.bodies[0], err)
+	}
+	if want := `[billing] migration 42 failed: relation "t" already exists (SQLSTATE 42P07)`; message["text"] != want {
+		t.Errorf("text = %q, want %q", message["text"], want)
+	}
+	if server.requests[0].Header.Get(SignatureHeader) != "" {
+		t.Errorf("expected slack deliveries to be unsigned")
+	}
+}
+
+func TestSendEmail(t *testing.T) {
+	server := newSMTPServer(t)
+	_, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	n := NewNotifier(memory.NewNotificationSinkRepository(), events.NewBus())
+	sink := &api.NotificationSink{
+		Name:     "oncall",
+		Kind:     api.SinkEmail,
+		SMTPHost: "127.0.0.1",
+		SMTPPort: portNumber,
+		From:     "gomad@example.com",
+		To:       []string{"dba@example.com", "oncall@example.com"},
+	}
+
+	if err := n.Send(context.Background(), sink, failedEvent()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if strings.Join(server.rcpts, ",") != "dba@example.com,oncall@example.com" {
+		t.Errorf("recipients = %v", server.rcpts)
+	}
+	if len(server.messages) != 1 {
+		t.Fatalf("expected one message, got %d", len(server.messages))
+	}
+	msg := server.messages[0]
+	if !strings.Contains(msg, "Subject: [billing] migration 42 failed") || !strings.Contains(msg, "Reason: execution failed") {
+		t.Errorf("message = %s", msg)
+	}
+}
+
+func TestDeliveryRetries(t *testing.T) {
+	testCases := []struct {
+		name      string
+		failures  int
+		status    int
+		wantCalls int
+	}{
+		{name: "recovers after server errors", failures: 2, status: http.StatusServiceUnavailable, wantCalls: 3},
+		{name: "gives up after every attempt fails", failures: 10, status: http.StatusInternalServerError, wantCalls: deliveryAttempts},
+		{name: "doesn't retry a rejected request", failures: 10, status: http.StatusBadRequest, wantCalls: 1},
+		{name: "retries when rate limited", failures: 1, status: http.StatusTooManyRequests, wantCalls: 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newRecordingServer(t)
+			server.failures, server.status = tc.failures, tc.status
+			n := NewNotifier(memory.NewNotificationSinkRepository(), events.NewBus())
+			n.retryBackoff = time.Millisecond
+
+			n.deliver(&api.NotificationSink{Name: "hooks", Kind: api.SinkWebhook, URL: server.URL}, failedEvent())
+
+			if server.count() != tc.wantCalls {
+				t.Errorf("expected %d deliveries, got %d", tc.wantCalls, server.count())
+			}
+		})
+	}
+}
+
+func TestNotifierFollowsBus(t *testing.T) {
+	subscribed, other := newRecordingServer(t), newRecordingServer(t)
+	sinks := memory.NewNotificationSinkRepository()
+	sinks.UpsertSink(context.Background(), &api.NotificationSink{
+		Name:       "billing-failures",
+		Kind:       api.SinkWebhook,
+		URL:        subscribed.URL,
+		Namespaces: []string{"billing"},
+		Events:     []api.EventType{api.EventMigrationFailed},
+	})
+	sinks.UpsertSink(context.Background(), &api.NotificationSink{
+		Name:   "successes",
+		Kind:   api.SinkWebhook,
+		URL:    other.URL,
+		Events: []api.EventType{api.EventMigrationSucceeded},
+	})
+
+	bus := events.NewBus()
+	n := NewNotifier(sinks, bus)
+	n.Start()
+
+	bus.Publish(context.Background(), failedEvent())
+	bus.Publish(context.Background(), &api.Event{Type: api.EventMigrationFailed, Namespace: "shop", MigrationID: 3})
+	bus.Publish(context.Background(), &api.Event{Type: api.EventMigrationStarted, Namespace: "billing", MigrationID: 42})
+	bus.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := n.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	if subscribed.count() != 1 || other.count() != 0 {
+		t.Errorf("expected only billing's failure to be delivered, got %d and %d deliveries", subscribed.count(), other.count())
+	}
+}
+
+// countingSinks counts sink lookups, holding each one until release is closed
+type countingSinks struct {
+	repository.NotificationSinkRepository
+	lookups atomic.Int32
+	release chan struct{}
+}
+
+func (s *countingSinks) ListSinks(ctx context.Context) ([]*api.NotificationSink, error) {
+	s.lookups.Add(1)
+	<-s.release
+	return s.NotificationSinkRepository.ListSinks(ctx)
+}
+
+func TestNotifierCachesSinks(t *testing.T) {
+	server := newRecordingServer(t)
+	sinks := &countingSinks{NotificationSinkRepository: memory.NewNotificationSinkRepository(), release: make(chan struct{})}
+	close(sinks.release)
+	sinks.UpsertSink(context.Background(), &api.NotificationSink{
+		Name:   "failures",
+		Kind:   api.SinkWebhook,
+		URL:    server.URL,
+		Events: []api.EventType{api.EventMigrationFailed},
+	})
+
+	now := time.Now()
+	n := NewNotifier(sinks, events.NewBus())
+	n.now = func() time.Time { return now }
+
+	for range 50 {
+		n.notify(&api.Event{Type: api.EventStatementFinished, Namespace: "billing"})
+	}
+	n.notify(failedEvent())
+	if got := sinks.lookups.Load(); got != 1 {
+		t.Errorf("expected sinks to be looked up once, got %d", got)
+	}
+
+	// Invalidating or outliving the cache fetches the sinks again
+	n.InvalidateSinks()
+	n.notify(failedEvent())
+	now = now.Add(sinkCacheTTL)
+	n.notify(failedEvent())
+	if got := sinks.lookups.Load(); got != 3 {
+		t.Errorf("expected 3 lookups, got %d", got)
+	}
+
+	n.deliveries.Wait()
+	if server.count() != 3 {
+		t.Errorf("expected 3 deliveries, got %d", server.count())
+	}
+}
+
+func TestNotifierKeepsFailuresWhenBehind(t *testing.T) {
+	server := newRecordingServer(t)
+	sinks := &countingSinks{NotificationSinkRepository: memory.NewNotificationSinkRepository(), release: make(chan struct{})}
+	sinks.UpsertSink(context.Background(), &api.NotificationSink{
+		Name:   "failures",
+		Kind:   api.SinkWebhook,
+		URL:    server.URL,
+		Events: []api.EventType{api.EventMigrationFailed},
+	})
+
+	bus := events.NewBus()
+	n := NewNotifier(sinks, bus)
+	n.Start()
+
+	// The first lookup stalls, so the notifier falls far enough behind for the bus to drop what it can
+	bus.Publish(context.Background(), &api.Event{Type: api.EventMigrationStarted, Namespace: "billing"})
+	for range 1000 {
+		bus.Publish(context.Background(), &api.Event{Type: api.EventNotice, Namespace: "billing"})
+	}
+	bus.Publish(context.Background(), failedEvent())
+	bus.Close()
+	close(sinks.release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := n.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	if server.count() != 1 {
+		t.Errorf("expected the failure to be delivered, got %d deliveries", server.count())
+	}
+}
+
+func TestDeliveryContinuesTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(previous)
+		otel.SetTextMapPropagator(previousPropagator)
+	}()
+
+	server := newRecordingServer(t)
+	sinks := memory.NewNotificationSinkRepository()
+	sinks.UpsertSink(context.Background(), &api.NotificationSink{
+		Name: "hooks", Kind: api.SinkWebhook, URL: server.URL, Events: []api.EventType{api.EventMigrationFailed},
+	})
+	bus := events.NewBus()
+	n := NewNotifier(sinks, bus)
+	n.Start()
+
+	ctx, span := tracing.Start(context.Background(), "migration.execute")
+	bus.Publish(ctx, failedEvent())
+	span.End()
+	bus.Close()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := n.Shutdown(shutdownCtx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	// The delivery is part of the publisher's trace, and passes it on to the webhook
+	traceID := span.SpanContext().TraceID().String()
+	if header := server.requests[0].Header.Get("traceparent"); !strings.Contains(header, traceID) {
+		t.Errorf("traceparent %q isn't part of trace %s", header, traceID)
+	}
+	delivered := false
+	for _, ended := range recorder.Ended() {
+		if ended.Name() == "notification.deliver" && ended.SpanContext().TraceID() == span.SpanContext().TraceID() {
+			delivered = true
+		}
+	}
+	if !delivered {
+		t.Errorf("expected a notification.deliver span in trace %s", traceID)
+	}
+}
+
+func TestShutdownAbandonsRetries(t *testing.T) {
+	server := newRecordingServer(t)
+	server.failures, server.status = 10, http.StatusBadGateway
+	n := NewNotifier(memory.NewNotificationSinkRepository(), events.NewBus())
+	n.retryBackoff = time.Hour
+
+	n.deliveries.Add(1)
+	go func() {
+		defer n.deliveries.Done()
+		n.deliver(&api.NotificationSink{Name: "hooks", Kind: api.SinkWebhook, URL: server.URL}, failedEvent())
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := n.Shutdown(ctx); err == nil {
+		t.Fatalf("expected Shutdown() to give up on the pending retry")
+	}
+
+	// The abandoned delivery returns instead of waiting out its backoff
+	done := make(chan struct{})
+	go func() {
+		n.deliveries.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("delivery kept waiting to retry after shutdown")
+	}
+}
@@ -0,0 +1,231 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/utils"
+	"github.com/dfryer1193/gomad/internal/tracing"
+)
+
+const (
+	// SignatureHeader carries the HMAC-SHA256 of a webhook delivery's body, as sha256=<hex>, when the sink has a secret
+	SignatureHeader = "X-Gomad-Signature-256"
+	// EventHeader carries the type of the event delivered to a webhook
+	EventHeader = "X-Gomad-Event"
+	// DeliveryHeader carries the id of the event delivered to a webhook, which is the same on every retry
+	DeliveryHeader = "X-Gomad-Delivery"
+
+	defaultSMTPPort = 587
+)
+
+// httpSender posts events to webhook and slack sinks
+type httpSender struct {
+	client *http.Client
+}
+
+func newHTTPSender() *httpSender {
+	return &httpSender{client: &http.Client{Timeout: deliveryTimeout}}
+}
+
+func (s *httpSender) Send(ctx context.Context, sink *api.NotificationSink, event *api.Event) error {
+	var body []byte
+	var err error
+	if sink.Kind == api.SinkSlack {
+		body, err = json.Marshal(map[string]string{"text": describeEvent(event)})
+	} else {
+		body, err = json.Marshal(event)
+	}
+	if err != nil {
+		return &permanentError{fmt.Errorf("failed to encode event: %w", err)}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sink.URL, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{fmt.Errorf("invalid url for sink %s: %w", sink.Name, err)}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if sink.Kind == api.SinkWebhook {
+		req.Header.Set(EventHeader, string(event.Type))
+		req.Header.Set(DeliveryHeader, strconv.FormatUint(event.ID, 10))
+		if sink.Secret != "" {
+			req.Header.Set(SignatureHeader, Sign(sink.Secret, body))
+		}
+		tracing.InjectHTTP(ctx, req.Header)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post to sink %s: %w", sink.Name, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	err = fmt.Errorf("sink %s responded %s", sink.Name, resp.Status)
+	// Other client errors mean the request itself is wrong, which sending it again won't change
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusRequestTimeout {
+		return &permanentError{err}
+	}
+	return err
+}
+
+// Sign returns the value of SignatureHeader for a body signed with secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// emailSender mails events to email sinks, upgrading to TLS when the server offers it
+type emailSender struct{}
+
+func (s *emailSender) Send(ctx context.Context, sink *api.NotificationSink, event *api.Event) error {
+	port := sink.SMTPPort
+	if port == 0 {
+		port = defaultSMTPPort
+	}
+	addr := net.JoinHostPort(sink.SMTPHost, strconv.Itoa(port))
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to mail server %s: %w", addr, err)
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(deliveryTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, sink.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to greet mail server %s: %w", addr, err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: sink.SMTPHost}); err != nil {
+			return fmt.Errorf("failed to start TLS with mail server %s: %w", addr, err)
+		}
+	}
+
+	if sink.Username != "" {
+		password, err := utils.ResolveCredentials(sink.CredentialsRef)
+		if err != nil {
+			return &permanentError{fmt.Errorf("failed to resolve mail credentials for sink %s: %w", sink.Name, err)}
+		}
+		if err := client.Auth(smtp.PlainAuth("", sink.Username, password, sink.SMTPHost)); err != nil {
+			return fmt.Errorf("failed to authenticate with mail server %s: %w", addr, err)
+		}
+	}
+
+	if err := client.Mail(sink.From); err != nil {
+		return fmt.Errorf("mail server %s refused sender: %w", addr, err)
+	}
+	for _, to := range sink.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("mail server %s refused recipient %s: %w", addr, to, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to start message to mail server %s: %w", addr, err)
+	}
+	if _, err := w.Write(emailMessage(sink, event)); err != nil {
+		return fmt.Errorf("failed to send message to mail server %s: %w", addr, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("mail server %s rejected message: %w", addr, err)
+	}
+
+	return client.Quit()
+}
+
+// emailMessage builds the message mailed for an event, its subject being the event's description
+func emailMessage(sink *api.NotificationSink, event *api.Event) []byte {
+	subject := describeEvent(event)
+	// Header values can't span lines
+	subject = strings.NewReplacer("\r", " ", "\n", " ").Replace(subject)
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", sink.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(sink.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", event.At.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+
+	fmt.Fprintf(&msg, "Namespace: %s\r\n", event.Namespace)
+	fmt.Fprintf(&msg, "Migration: %d\r\n", event.MigrationID)
+	fmt.Fprintf(&msg, "Event: %s\r\n", event.Type)
+	if event.Status != "" {
+		fmt.Fprintf(&msg, "Status: %s\r\n", event.Status)
+	}
+	if event.Reason != "" {
+		fmt.Fprintf(&msg, "Reason: %s\r\n", event.Reason)
+	}
+	if event.Error != nil {
+		fmt.Fprintf(&msg, "\r\n%s\r\n", describeError(event.Error))
+	}
+
+	return msg.Bytes()
+}
+
+// describeEvent summarizes an event in a line, for chat messages and email subjects
+func describeEvent(event *api.Event) string {
+	switch event.Type {
+	case api.EventTest:
+		return "gomad test notification"
+	case api.EventMigrationIngested:
+		return fmt.Sprintf("[%s] migration %d was ingested as %s", event.Namespace, event.MigrationID, event.Status)
+	case api.EventMigrationStarted:
+		return fmt.Sprintf("[%s] migration %d started", event.Namespace, event.MigrationID)
+	case api.EventMigrationSucceeded:
+		return fmt.Sprintf("[%s] migration %d succeeded", event.Namespace, event.MigrationID)
+	case api.EventMigrationFailed:
+		description := fmt.Sprintf("[%s] migration %d failed", event.Namespace, event.MigrationID)
+		if event.Error != nil {
+			description += ": " + describeError(event.Error)
+		}
+		return description
+	case api.EventStatementFinished:
+		if event.Statement == nil {
+			break
+		}
+		return fmt.Sprintf("[%s] migration %d ran %q in %.1fms", event.Namespace, event.MigrationID, event.Statement.SQL, event.Statement.DurationMs)
+	case api.EventNotice:
+		if event.Message == nil {
+			break
+		}
+		return fmt.Sprintf("[%s] migration %d raised %s: %s", event.Namespace, event.MigrationID, event.Message.Severity, event.Message.Message)
+	}
+
+	return fmt.Sprintf("[%s] migration %d: %s", event.Namespace, event.MigrationID, event.Type)
+}
+
+func describeError(err *api.ExecutionError) string {
+	if err.SQLState != "" {
+		return fmt.Sprintf("%s (SQLSTATE %s)", err.Message, err.SQLState)
+	}
+	return err.Message
+}
@@ -1,28 +1,99 @@
 package rest
 
 import (
+	"net/http"
+
+	"github.com/dfryer1193/gomad/internal/metrics"
 	"github.com/dfryer1193/gomad/internal/rest/handlers"
+	mjolnirMiddleware "github.com/dfryer1193/mjolnir/middleware"
 	mjolnirUtils "github.com/dfryer1193/mjolnir/utils"
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 )
 
 func SetupRoutes(router *chi.Mux) {
 	hookHandler := handlers.GetHookHandler()
 	migrationsHandler := handlers.GetMigrationHandler()
+	repositoryHandler := handlers.GetRepositoryHandler()
+	connectionHandler := handlers.GetConnectionHandler()
+	notificationHandler := handlers.GetNotificationHandler()
 
 	router.Route("/login/v1", func(r chi.Router) {
 		r.Post("/", mjolnirUtils.ErrorHandler(handlers.GetAdminHandler().Login))
 	})
 
+	router.Route("/lint/v1", func(r chi.Router) {
+		r.Post("/", mjolnirUtils.ErrorHandler(handlers.GetLintHandler().Lint))
+	})
+
+	router.Route("/repositories/v1", func(r chi.Router) {
+		r.Get("/", mjolnirUtils.ErrorHandler(repositoryHandler.GetRepositories))
+		r.Put("/", mjolnirUtils.ErrorHandler(repositoryHandler.PutRepository))
+		r.Delete("/", mjolnirUtils.ErrorHandler(repositoryHandler.DeleteRepository))
+	})
+
+	router.Route("/connections/v1", func(r chi.Router) {
+		r.Get("/", mjolnirUtils.ErrorHandler(connectionHandler.GetConnections))
+		r.Put("/", mjolnirUtils.ErrorHandler(connectionHandler.PutConnection))
+		r.Delete("/", mjolnirUtils.ErrorHandler(connectionHandler.DeleteConnection))
+		r.Post("/database", mjolnirUtils.ErrorHandler(connectionHandler.PostConnectionDatabase))
+	})
+
+	router.Route("/notifications/v1", func(r chi.Router) {
+		r.Get("/", mjolnirUtils.ErrorHandler(notificationHandler.GetSinks))
+		r.Put("/", mjolnirUtils.ErrorHandler(notificationHandler.PutSink))
+		r.Delete("/", mjolnirUtils.ErrorHandler(notificationHandler.DeleteSink))
+		r.Post("/test", mjolnirUtils.ErrorHandler(notificationHandler.PostTestSink))
+	})
+
 	router.Route("/handlers/v1", func(r chi.Router) {
 		r.Post("/push", mjolnirUtils.ErrorHandler(hookHandler.HandlePush))
 	})
 
-	router.Route("/namespaces/v1", func(r chi.Router) {
-		r.Get("/", mjolnirUtils.ErrorHandler(migrationsHandler.GetNamespaces))
-		r.Get("/:namespace/managers", mjolnirUtils.ErrorHandler(migrationsHandler.GetMigrationsForNamespace))
-		r.Get("/:namespace/migrations/:migrationId", mjolnirUtils.ErrorHandler(migrationsHandler.GetMigrationById))
-		// TODO: Write the handlers required for frontend
-		// POST /<namespace>/managers/<migrationId>/execute
-	})
+	router.Route("/namespaces/v1", namespaceRoutes(migrationsHandler))
+
+	router.Handle("/metrics", metrics.Handler())
+}
+
+func namespaceRoutes(h *handlers.MigrationHandler) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Get("/", mjolnirUtils.ErrorHandler(h.GetNamespaces))
+		r.Route("/{namespace}", func(r chi.Router) {
+			r.Get("/migrations", mjolnirUtils.ErrorHandler(h.GetMigrationsForNamespace))
+			r.Get("/migrations/{migrationId}", mjolnirUtils.ErrorHandler(h.GetMigrationById))
+			r.Get("/migrations/{migrationId}/history", mjolnirUtils.ErrorHandler(h.GetMigrationHistory))
+			r.Get("/migrations/{migrationId}/attempts", mjolnirUtils.ErrorHandler(h.GetExecutionAttempts))
+			r.Post("/migrations/{migrationId}/execute", mjolnirUtils.ErrorHandler(h.ExecuteMigration))
+			r.Get("/settings", mjolnirUtils.ErrorHandler(h.GetNamespaceSettings))
+			r.Put("/settings", mjolnirUtils.ErrorHandler(h.PutNamespaceSettings))
+			r.Get("/variables", mjolnirUtils.ErrorHandler(h.GetNamespaceVariables))
+			r.Put("/variables/{name}", mjolnirUtils.ErrorHandler(h.PutNamespaceVariable))
+			r.Delete("/variables/{name}", mjolnirUtils.ErrorHandler(h.DeleteNamespaceVariable))
+			// TODO: Write the handlers required for frontend
+		})
+	}
+}
+
+// WithEventStreams routes the event streams ahead of the router. The router's request logger wraps responses in a
+// writer that can't be flushed, which streaming needs, so the streams get their own middleware instead.
+func WithEventStreams(router http.Handler) http.Handler {
+	return withEventStreams(router, handlers.GetEventHandler())
+}
+
+func withEventStreams(router http.Handler, h *handlers.EventHandler) http.Handler {
+	root := chi.NewRouter()
+	root.Route("/events/v1", eventRoutes(h))
+	root.Mount("/", router)
+
+	return root
+}
+
+func eventRoutes(h *handlers.EventHandler) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Use(middleware.RealIP)
+		r.Use(middleware.Recoverer)
+		r.Use(mjolnirMiddleware.RequestID)
+		r.Get("/", mjolnirUtils.ErrorHandler(h.StreamEvents))
+		r.Get("/{namespace}", mjolnirUtils.ErrorHandler(h.StreamEvents))
+	}
 }
@@ -0,0 +1,256 @@
+package rest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/events"
+	"github.com/dfryer1193/gomad/internal/rest/handlers"
+	"github.com/dfryer1193/gomad/internal/rest/managers"
+	"github.com/dfryer1193/mjolnir/router"
+	mjolnirUtils "github.com/dfryer1193/mjolnir/utils"
+)
+
+const adminToken = "admin-token"
+
+// fakeMigrationManager holds migrations by id and records the namespaces it was asked about
+type fakeMigrationManager struct {
+	migrations map[uint64]*api.Migration
+	listed     []string
+	listOpts   api.MigrationListOptions
+	executed   []uint64
+}
+
+func (m *fakeMigrationManager) ProcessMigrations(_ context.Context, _ []api.MigrationProto) error {
+	return nil
+}
+
+func (m *fakeMigrationManager) ListMigrations(_ context.Context, namespace string, opts api.MigrationListOptions) (*api.MigrationList, error) {
+	m.listed = append(m.listed, namespace)
+	m.listOpts = opts
+	return &api.MigrationList{Migrations: []*api.Migration{}}, nil
+}
+
+func (m *fakeMigrationManager) GetMigrationById(_ context.Context, namespace string, id uint64) (*api.Migration, error) {
+	migration, ok := m.migrations[id]
+	if !ok || migration.Namespace != namespace {
+		return nil, fmt.Errorf("%w: id %d in namespace %s", managers.ErrMigrationNotFound, id, namespace)
+	}
+	return migration, nil
+}
+
+func (m *fakeMigrationManager) GetMigrationHistory(ctx context.Context, namespace string, id uint64) (*api.MigrationHistory, error) {
+	if _, err := m.GetMigrationById(ctx, namespace, id); err != nil {
+		return nil, err
+	}
+	return &api.MigrationHistory{MigrationID: id, Changes: []*api.MigrationStatusChange{{To: api.MigrationStatusPending}}}, nil
+}
+
+func (m *fakeMigrationManager) ListExecutionAttempts(ctx context.Context, namespace string, id uint64) (*api.ExecutionAttemptList, error) {
+	if _, err := m.GetMigrationById(ctx, namespace, id); err != nil {
+		return nil, err
+	}
+	return &api.ExecutionAttemptList{MigrationID: id, Attempts: []*api.ExecutionAttempt{{MigrationID: id, Number: 1}}}, nil
+}
+
+func (m *fakeMigrationManager) ExecuteMigration(ctx context.Context, namespace string, id uint64, _ managers.ExecuteOptions) (*api.Migration, error) {
+	migration, err := m.GetMigrationById(ctx, namespace, id)
+	if err != nil {
+		return nil, err
+	}
+	m.executed = append(m.executed, id)
+	return migration, nil
+}
+
+func (m *fakeMigrationManager) LintMigrations(_ context.Context, _ []api.MigrationProto) (*api.LintResponse, error) {
+	return &api.LintResponse{}, nil
+}
+
+func (m *fakeMigrationManager) Close() {}
+
+// fakeNamespaceManager records the variables set on each namespace
+type fakeNamespaceManager struct {
+	variables map[string]map[string]string
+}
+
+func (m *fakeNamespaceManager) GetNamespaces(_ context.Context) ([]string, error) {
+	return []string{"ns1"}, nil
+}
+
+func (m *fakeNamespaceManager) GetSettings(_ context.Context, namespace string) (*api.NamespaceSettings, error) {
+	return &api.NamespaceSettings{Namespace: namespace}, nil
+}
+
+func (m *fakeNamespaceManager) SaveSettings(_ context.Context, _ *api.NamespaceSettings) error {
+	return nil
+}
+
+func (m *fakeNamespaceManager) GetVariables(_ context.Context, namespace string) (*api.NamespaceVariables, error) {
+	return &api.NamespaceVariables{Namespace: namespace, Variables: m.variables[namespace]}, nil
+}
+
+func (m *fakeNamespaceManager) SetVariable(_ context.Context, namespace string, name string, value string) error {
+	if m.variables[namespace] == nil {
+		m.variables[namespace] = make(map[string]string)
+	}
+	m.variables[namespace][name] = value
+	return nil
+}
+
+func (m *fakeNamespaceManager) DeleteVariable(_ context.Context, namespace string, name string) error {
+	if _, ok := m.variables[namespace][name]; !ok {
+		return fmt.Errorf("%w: %s in namespace %s", managers.ErrVariableNotFound, name, namespace)
+	}
+	delete(m.variables[namespace], name)
+	return nil
+}
+
+type fakeAdminHandler struct{}
+
+func (a *fakeAdminHandler) Login(_ http.ResponseWriter, _ *http.Request) *mjolnirUtils.ApiError {
+	return nil
+}
+
+func (a *fakeAdminHandler) ValidateToken(token string) (bool, error) {
+	return token == adminToken, nil
+}
+
+func TestNamespaceRoutes(t *testing.T) {
+	migrationMgr := &fakeMigrationManager{
+		migrations: map[uint64]*api.Migration{
+			42: {MigrationCommonFields: api.MigrationCommonFields{Namespace: "ns1"}, ID: 42},
+		},
+	}
+	namespaceMgr := &fakeNamespaceManager{variables: map[string]map[string]string{}}
+
+	r := router.New()
+	r.Route("/namespaces/v1", namespaceRoutes(handlers.NewMigrationHandler(migrationMgr, namespaceMgr, &fakeAdminHandler{})))
+
+	testCases := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		admin      bool
+		wantStatus int
+		// wantID is the id of the migration expected in the response
+		wantID uint64
+	}{
+		{name: "list namespaces", method: http.MethodGet, path: "/namespaces/v1/", wantStatus: http.StatusOK},
+		{name: "list migrations", method: http.MethodGet, path: "/namespaces/v1/ns1/migrations?status=pending&limit=10", wantStatus: http.StatusOK},
+		{name: "list migrations with a bad limit", method: http.MethodGet, path: "/namespaces/v1/ns1/migrations?limit=-1", wantStatus: http.StatusBadRequest},
+		{name: "get migration", method: http.MethodGet, path: "/namespaces/v1/ns1/migrations/42", wantStatus: http.StatusOK, wantID: 42},
+		{name: "get missing migration", method: http.MethodGet, path: "/namespaces/v1/ns1/migrations/43", wantStatus: http.StatusNotFound},
+		{name: "get migration of another namespace", method: http.MethodGet, path: "/namespaces/v1/ns2/migrations/42", wantStatus: http.StatusNotFound},
+		{name: "get migration with a bad id", method: http.MethodGet, path: "/namespaces/v1/ns1/migrations/abc", wantStatus: http.StatusBadRequest},
+		{name: "get migration history", method: http.MethodGet, path: "/namespaces/v1/ns1/migrations/42/history", wantStatus: http.StatusOK},
+		{name: "get history of another namespace's migration", method: http.MethodGet, path: "/namespaces/v1/ns2/migrations/42/history", wantStatus: http.StatusNotFound},
+		{name: "get execution attempts", method: http.MethodGet, path: "/namespaces/v1/ns1/migrations/42/attempts", wantStatus: http.StatusOK},
+		{name: "get attempts of a missing migration", method: http.MethodGet, path: "/namespaces/v1/ns1/migrations/43/attempts", wantStatus: http.StatusNotFound},
+		{name: "execute without the admin token", method: http.MethodPost, path: "/namespaces/v1/ns1/migrations/42/execute", wantStatus: http.StatusUnauthorized},
+		{name: "execute", method: http.MethodPost, path: "/namespaces/v1/ns1/migrations/42/execute", admin: true, wantStatus: http.StatusOK, wantID: 42},
+		{name: "execute in another namespace", method: http.MethodPost, path: "/namespaces/v1/ns2/migrations/42/execute", admin: true, wantStatus: http.StatusNotFound},
+		{name: "get settings", method: http.MethodGet, path: "/namespaces/v1/ns1/settings", wantStatus: http.StatusOK},
+		{name: "set variable", method: http.MethodPut, path: "/namespaces/v1/ns1/variables/schema", body: `{"value": "app"}`, admin: true, wantStatus: http.StatusNoContent},
+		{name: "delete variable", method: http.MethodDelete, path: "/namespaces/v1/ns1/variables/schema", admin: true, wantStatus: http.StatusNoContent},
+		{name: "delete missing variable", method: http.MethodDelete, path: "/namespaces/v1/ns1/variables/schema", admin: true, wantStatus: http.StatusNotFound},
+		{name: "old query string route", method: http.MethodGet, path: "/namespaces/v1/:namespace/managers?namespace=ns1", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			if tc.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			if tc.admin {
+				req.Header.Set("Authorization", "Bearer "+adminToken)
+			}
+			rec := httptest.NewRecorder()
+
+			r.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("%s %s returned %d, want %d: %s", tc.method, tc.path, rec.Code, tc.wantStatus, rec.Body.String())
+			}
+			if tc.wantID != 0 {
+				var migration api.Migration
+				if err := json.Unmarshal(rec.Body.Bytes(), &migration); err != nil || migration.ID != tc.wantID {
+					t.Errorf("expected migration %d, got %s", tc.wantID, rec.Body.String())
+				}
+			}
+		})
+	}
+
+	if len(migrationMgr.listed) != 1 || migrationMgr.listed[0] != "ns1" || migrationMgr.listOpts.Limit != 10 {
+		t.Errorf("expected ns1 to be listed with limit 10, got %v with %+v", migrationMgr.listed, migrationMgr.listOpts)
+	}
+	if len(migrationMgr.executed) != 1 || migrationMgr.executed[0] != 42 {
+		t.Errorf("expected migration 42 to be executed once, got %v", migrationMgr.executed)
+	}
+}
+
+func TestEventStreams(t *testing.T) {
+	srv := httptest.NewServer(withEventStreams(router.New(), handlers.NewEventHandler(events.GetBus(), &fakeAdminHandler{})))
+	defer srv.Close()
+
+	// Events carry rendered SQL, so the stream needs the admin token
+	unauthorized, err := http.Get(srv.URL + "/events/v1/ns1")
+	if err != nil {
+		t.Fatalf("GET /events/v1/ns1 error = %v", err)
+	}
+	unauthorized.Body.Close()
+	if unauthorized.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GET /events/v1/ns1 without the admin token returned %d", unauthorized.StatusCode)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events/v1/ns1", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /events/v1/ns1 error = %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET /events/v1/ns1 returned %d with %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	events.GetBus().Publish(context.Background(), &api.Event{Type: api.EventMigrationIngested, Namespace: "ns2", MigrationID: 7})
+	events.GetBus().Publish(context.Background(), &api.Event{Type: api.EventMigrationStarted, Namespace: "ns1", MigrationID: 42})
+
+	// Only the ns1 event arrives, without waiting for the response to end
+	lines := make([]string, 0, 3)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() && scanner.Text() != "" {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != 3 || lines[1] != "event: migration.started" || !strings.HasPrefix(lines[2], "data: ") {
+		t.Fatalf("unexpected event %q", lines)
+	}
+	var event api.Event
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &event); err != nil || event.MigrationID != 42 {
+		t.Errorf("event data = %s, %v", lines[2], err)
+	}
+	if lines[0] != fmt.Sprintf("id: %d", event.ID) {
+		t.Errorf("event id line %q doesn't match event %d", lines[0], event.ID)
+	}
+
+	// Other routes still go through the router
+	other, err := http.Get(srv.URL + "/lint/v1")
+	if err != nil {
+		t.Fatalf("GET /lint/v1 error = %v", err)
+	}
+	other.Body.Close()
+	if other.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /lint/v1 returned %d, expected the router's not found", other.StatusCode)
+	}
+}
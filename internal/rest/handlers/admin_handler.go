@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 
 	mjolnirUtils "github.com/dfryer1193/mjolnir/utils"
@@ -78,6 +79,25 @@ func (h *adminHandler) ValidateToken(tokenString string) (bool, error) {
 	return tokenString == h.activeToken, nil
 }
 
+// authorizeAdmin checks that the request carries the active admin token as a bearer token
+func authorizeAdmin(r *http.Request, admin AdminHandler) *mjolnirUtils.ApiError {
+	bearerToken := r.Header.Get("Authorization")
+	if !strings.HasPrefix(bearerToken, "Bearer ") {
+		return mjolnirUtils.UnauthorizedErr(fmt.Errorf("missing or invalid authorization header"))
+	}
+	token := strings.TrimPrefix(bearerToken, "Bearer ")
+
+	authed, err := admin.ValidateToken(token)
+	if err != nil {
+		return mjolnirUtils.UnauthorizedErr(fmt.Errorf("failed to validate token: %w", err))
+	}
+	if !authed {
+		return mjolnirUtils.UnauthorizedErr(fmt.Errorf("invalid token"))
+	}
+
+	return nil
+}
+
 func generateToken() (string, error) {
 	bytes := make([]byte, 32)
 	if _, err := rand.Read(bytes); err != nil {
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/rest/managers"
+	mjolnirUtils "github.com/dfryer1193/mjolnir/utils"
+)
+
+type ConnectionManager interface {
+	ListConnections(ctx context.Context) ([]*api.NamespaceConnection, error)
+	GetConnection(ctx context.Context, namespace string) (*api.NamespaceConnection, error)
+	SaveConnection(ctx context.Context, conn *api.NamespaceConnection) error
+	DeleteConnection(ctx context.Context, namespace string) error
+	CreateDatabase(ctx context.Context, namespace string) (bool, error)
+}
+
+// ConnectionHandler manages the registry of namespace database connections. Every endpoint requires the admin token.
+type ConnectionHandler struct {
+	connectionMgr ConnectionManager
+	adminHandler  AdminHandler
+}
+
+var (
+	connectionHandler *ConnectionHandler
+	connectionOnce    sync.Once
+)
+
+func GetConnectionHandler() *ConnectionHandler {
+	connectionOnce.Do(func() {
+		connectionHandler = &ConnectionHandler{
+			connectionMgr: managers.GetConnectionManager(),
+			adminHandler:  GetAdminHandler(),
+		}
+	})
+
+	return connectionHandler
+}
+
+// GetConnections lists every registered connection, or just the one for the namespace query parameter if given
+func (h *ConnectionHandler) GetConnections(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
+	if apiErr := authorizeAdmin(r, h.adminHandler); apiErr != nil {
+		return apiErr
+	}
+
+	if namespace := r.URL.Query().Get("namespace"); namespace != "" {
+		conn, err := h.connectionMgr.GetConnection(r.Context(), namespace)
+		if errors.Is(err, managers.ErrConnectionNotFound) {
+			return mjolnirUtils.NewApiError(err, http.StatusNotFound)
+		}
+		if err != nil {
+			return mjolnirUtils.InternalServerErr(fmt.Errorf("error fetching connection for namespace %s: %w", namespace, err))
+		}
+
+		mjolnirUtils.RespondJSON(w, r, http.StatusOK, conn)
+		return nil
+	}
+
+	conns, err := h.connectionMgr.ListConnections(r.Context())
+	if err != nil {
+		return mjolnirUtils.InternalServerErr(fmt.Errorf("error fetching connections: %w", err))
+	}
+
+	mjolnirUtils.RespondJSON(w, r, http.StatusOK, &api.NamespaceConnectionList{Connections: conns})
+	return nil
+}
+
+func (h *ConnectionHandler) PutConnection(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
+	if apiErr := authorizeAdmin(r, h.adminHandler); apiErr != nil {
+		return apiErr
+	}
+
+	conn := &api.NamespaceConnection{}
+	if _, err := mjolnirUtils.DecodeJSON(r, conn); err != nil {
+		return mjolnirUtils.BadRequestErr(err)
+	}
+
+	err := h.connectionMgr.SaveConnection(r.Context(), conn)
+	if errors.Is(err, managers.ErrInvalidConnection) {
+		return mjolnirUtils.BadRequestErr(err)
+	}
+	if err != nil {
+		return mjolnirUtils.InternalServerErr(fmt.Errorf("error saving connection for namespace %s: %w", conn.Namespace, err))
+	}
+
+	mjolnirUtils.RespondJSON(w, r, http.StatusOK, conn)
+	return nil
+}
+
+func (h *ConnectionHandler) DeleteConnection(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
+	if apiErr := authorizeAdmin(r, h.adminHandler); apiErr != nil {
+		return apiErr
+	}
+
+	namespace := r.URL.Query().Get("namespace")
+	if namespace == "" {
+		return mjolnirUtils.BadRequestErr(fmt.Errorf("namespace is required"))
+	}
+
+	err := h.connectionMgr.DeleteConnection(r.Context(), namespace)
+	if errors.Is(err, managers.ErrConnectionNotFound) {
+		return mjolnirUtils.NewApiError(err, http.StatusNotFound)
+	}
+	if err != nil {
+		return mjolnirUtils.InternalServerErr(fmt.Errorf("error deleting connection for namespace %s: %w", namespace, err))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// PostConnectionDatabase creates the database backing the namespace query parameter, responding 201 if it was
+// created and 200 if it already existed
+func (h *ConnectionHandler) PostConnectionDatabase(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
+	if apiErr := authorizeAdmin(r, h.adminHandler); apiErr != nil {
+		return apiErr
+	}
+
+	namespace := r.URL.Query().Get("namespace")
+	if namespace == "" {
+		return mjolnirUtils.BadRequestErr(fmt.Errorf("namespace is required"))
+	}
+
+	created, err := h.connectionMgr.CreateDatabase(r.Context(), namespace)
+	if err != nil {
+		return mjolnirUtils.InternalServerErr(fmt.Errorf("error creating database for namespace %s: %w", namespace, err))
+	}
+
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	return nil
+}
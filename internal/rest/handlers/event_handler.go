@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dfryer1193/gomad/internal/events"
+	mjolnirUtils "github.com/dfryer1193/mjolnir/utils"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// eventKeepAlive is how often an idle stream gets a comment, so proxies don't time it out
+const eventKeepAlive = 15 * time.Second
+
+type EventHandler struct {
+	bus          *events.Bus
+	adminHandler AdminHandler
+	keepAlive    time.Duration
+	// closed ends every stream when the server shuts down
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+var (
+	eventHandler *EventHandler
+	eventOnce    sync.Once
+)
+
+func GetEventHandler() *EventHandler {
+	eventOnce.Do(func() {
+		eventHandler = NewEventHandler(events.GetBus(), GetAdminHandler())
+	})
+
+	return eventHandler
+}
+
+func NewEventHandler(bus *events.Bus, adminHandler AdminHandler) *EventHandler {
+	return &EventHandler{bus: bus, adminHandler: adminHandler, keepAlive: eventKeepAlive, closed: make(chan struct{})}
+}
+
+// Close ends the streams in progress. The bus stays open, so events still reach the notifier during shutdown.
+func (h *EventHandler) Close() {
+	h.closeOnce.Do(func() { close(h.closed) })
+}
+
+// StreamEvents streams migration events as server-sent events until the client goes away or the handler is closed.
+// With a namespace path parameter only that namespace's events are sent; without one, every namespace's are.
+// Requires the admin token, since events carry the rendered SQL of each statement, variables included.
+func (h *EventHandler) StreamEvents(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
+	if apiErr := authorizeAdmin(r, h.adminHandler); apiErr != nil {
+		return apiErr
+	}
+
+	// Subscribing before the headers go out means a client sees every event published once it's connected
+	sub := h.bus.Subscribe(chi.URLParam(r, "namespace"))
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Stops nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	// Flushing sends the headers, or fails without writing anything when the response can't be streamed
+	if err := rc.Flush(); err != nil {
+		return mjolnirUtils.InternalServerErr(fmt.Errorf("event streaming is not supported: %w", err))
+	}
+
+	keepAlive := time.NewTicker(h.keepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-h.closed:
+			return nil
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+		case event, ok := <-sub.Events():
+			if !ok {
+				return nil
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Error().Err(err).Uint64("event", event.ID).Msg("failed to encode event")
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+				return nil
+			}
+		}
+
+		if err := rc.Flush(); err != nil {
+			return nil
+		}
+	}
+}
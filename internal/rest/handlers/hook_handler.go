@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -8,13 +10,18 @@ import (
 	"sync"
 
 	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/metrics"
 	"github.com/dfryer1193/gomad/internal/rest/managers"
+	"github.com/dfryer1193/gomad/internal/tracing"
 	"github.com/dfryer1193/gomad/internal/utils"
 	mjolnirUtils "github.com/dfryer1193/mjolnir/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type MigrationFileProcessor interface {
-	ProcessFile(repoName string, path string, commit string) ([]api.MigrationProto, error)
+	ProcessFiles(ctx context.Context, repoName string, paths []string, commit string) ([]api.MigrationProto, error)
+	ChangedFiles(ctx context.Context, repoName string, before string, after string) ([]string, bool, error)
 }
 
 type SignatureValidator interface {
@@ -54,30 +61,19 @@ func GetHookHandler() *hookHandler {
 }
 
 func (h *hookHandler) HandleCreateSecret(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
-	// Check for bearer token
-	bearerToken := r.Header.Get("Authorization")
-	if !strings.HasPrefix(bearerToken, "Bearer ") {
-		return mjolnirUtils.UnauthorizedErr(fmt.Errorf("missing or invalid authorization header"))
-	}
-	token := strings.TrimPrefix(bearerToken, "Bearer ")
-
-	authed, err := h.adminHandler.ValidateToken(token)
-	if err != nil {
-		return mjolnirUtils.UnauthorizedErr(fmt.Errorf("failed to validate token: %w", err))
-	}
-	if !authed {
-		return mjolnirUtils.UnauthorizedErr(fmt.Errorf("invalid token"))
+	if apiErr := authorizeAdmin(r, h.adminHandler); apiErr != nil {
+		return apiErr
 	}
 
 	var repoName struct {
 		Name string `json:"repoName"`
 	}
-	_, err = mjolnirUtils.DecodeJSON(r, repoName)
+	_, err := mjolnirUtils.DecodeJSON(r, repoName)
 	if err != nil {
 		return mjolnirUtils.BadRequestErr(fmt.Errorf("failed to decode JSON: %w", err))
 	}
 
-	secret, err := h.secretMgr.SaveSecret(repoName.Name)
+	secret, err := h.secretMgr.SaveSecret(r.Context(), repoName.Name)
 	if err != nil {
 		return mjolnirUtils.InternalServerErr(fmt.Errorf("failed to save secret: %w", err))
 	}
@@ -114,51 +110,91 @@ type PushEvent struct {
 
 // HandlePush handles Git push webhooks by looking for added or modified sql files and treating them as migrations files
 func (h *hookHandler) HandlePush(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
+	provider := webhookProvider(r)
+	ctx, span := tracing.Start(tracing.ExtractHTTP(r.Context(), r.Header), "webhook.push",
+		attribute.String("gomad.webhook.provider", provider))
+
+	outcome, apiErr := h.handlePush(w, r.WithContext(ctx), provider)
+	metrics.WebhookDeliveries.WithLabelValues(provider, outcome).Inc()
+
+	span.SetAttributes(attribute.String("gomad.webhook.outcome", outcome))
+	var err error
+	if apiErr != nil {
+		err = apiErr
+	}
+	tracing.End(span, err)
+
+	return apiErr
+}
+
+// handlePush processes a push webhook, returning how it was handled for the delivery metrics
+func (h *hookHandler) handlePush(w http.ResponseWriter, r *http.Request, provider string) (string, *mjolnirUtils.ApiError) {
 	// Read the raw body
 	event := &PushEvent{}
 	bodyBytes, err := mjolnirUtils.DecodeJSON(r, event)
 	if err != nil {
-		return mjolnirUtils.BadRequestErr(fmt.Errorf("failed to decode JSON: %w", err))
+		return metrics.WebhookInvalidPayload, mjolnirUtils.BadRequestErr(fmt.Errorf("failed to decode JSON: %w", err))
 	}
+	trace.SpanFromContext(r.Context()).SetAttributes(
+		attribute.String("gomad.repo", event.Repository.FullName),
+		attribute.String("gomad.ref", event.Ref),
+		attribute.String("gomad.commit", event.After),
+	)
 
-	secret, err := h.secretMgr.GetSecret(event.Repository.FullName)
+	secret, err := h.secretMgr.GetSecret(r.Context(), event.Repository.FullName)
 	if err != nil {
-		return mjolnirUtils.InternalServerErr(fmt.Errorf("failed to get secret for repo %s: %w", event.Repository.FullName, err))
+		return metrics.WebhookError, mjolnirUtils.InternalServerErr(fmt.Errorf("failed to get secret for repo %s: %w", event.Repository.FullName, err))
 	}
 
 	// Validate webhook signature
 	if !h.validator.ValidateSignature(r, event.Repository.FullName, secret, bodyBytes) {
-		return mjolnirUtils.UnauthorizedErr(fmt.Errorf("Invalid webhook signature"))
+		metrics.WebhookSignatureFailures.WithLabelValues(provider).Inc()
+		return metrics.WebhookUnauthorized, mjolnirUtils.UnauthorizedErr(fmt.Errorf("Invalid webhook signature"))
 	}
 
 	// Only process pushes to master branch
 	if event.Ref != "refs/heads/master" {
 		w.WriteHeader(http.StatusNoContent)
-		return nil
+		return metrics.WebhookIgnored, nil
 	}
 
-	sqlFiles := h.getSQLFiles(event)
+	sqlFiles, err := h.getSQLFiles(r.Context(), event)
+	if err != nil {
+		return metrics.WebhookError, mjolnirUtils.InternalServerErr(fmt.Errorf("failed to list changed files: %w", err))
+	}
 	if len(sqlFiles) == 0 {
 		w.WriteHeader(http.StatusNoContent)
-		return nil
+		return metrics.WebhookIgnored, nil
 	}
 
-	migrationPrototypes := make([]api.MigrationProto, 0)
-	for _, file := range sqlFiles {
-		proto, err := h.migrationFileProcessor.ProcessFile(event.Repository.FullName, file, event.After)
-		if err != nil {
-			return mjolnirUtils.InternalServerErr(fmt.Errorf("failed to process SQL file: %s", file))
-		}
-		migrationPrototypes = append(migrationPrototypes, proto...)
+	migrationPrototypes, err := h.migrationFileProcessor.ProcessFiles(r.Context(), event.Repository.FullName, sqlFiles, event.After)
+	if err != nil {
+		return metrics.WebhookError, mjolnirUtils.InternalServerErr(fmt.Errorf("failed to process SQL files: %w", err))
 	}
 
-	err = h.migrationMgr.ProcessMigrations(migrationPrototypes)
+	err = h.migrationMgr.ProcessMigrations(r.Context(), migrationPrototypes)
+	if errors.Is(err, managers.ErrUnresolvedVariables) {
+		return metrics.WebhookRejected, mjolnirUtils.NewApiError(err, http.StatusUnprocessableEntity)
+	}
 	if err != nil {
-		return mjolnirUtils.InternalServerErr(fmt.Errorf("failed to process SQL changes: %w", err))
+		return metrics.WebhookError, mjolnirUtils.InternalServerErr(fmt.Errorf("failed to process SQL changes: %w", err))
 	}
 
 	w.WriteHeader(http.StatusNoContent)
-	return nil
+	return metrics.WebhookProcessed, nil
+}
+
+// webhookProvider names the git provider that sent a webhook, going by the event header each one sets. Gitea also
+// sets GitHub's headers, so it's checked first.
+func webhookProvider(r *http.Request) string {
+	switch {
+	case r.Header.Get("X-Gitea-Event") != "":
+		return "gitea"
+	case r.Header.Get("X-GitHub-Event") != "":
+		return "github"
+	default:
+		return "unknown"
+	}
 }
 
 func (h *hookHandler) Close() {
@@ -166,9 +202,24 @@ func (h *hookHandler) Close() {
 	h.secretMgr.Close()
 }
 
-func (h *hookHandler) getSQLFiles(event *PushEvent) []string {
+// getSQLFiles lists the SQL files added or modified by a push. Repositories whose file source can diff commits are
+// diffed directly, since push events only list the files changed by their first commits.
+func (h *hookHandler) getSQLFiles(ctx context.Context, event *PushEvent) ([]string, error) {
 	sqlFiles := make([]string, 0)
 
+	changed, ok, err := h.migrationFileProcessor.ChangedFiles(ctx, event.Repository.FullName, event.Before, event.After)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		for _, file := range changed {
+			if strings.HasSuffix(file, ".sql") {
+				sqlFiles = append(sqlFiles, file)
+			}
+		}
+		return sqlFiles, nil
+	}
+
 	// Collect all changed files
 	for _, commit := range event.Commits {
 		for _, file := range commit.Added {
@@ -183,7 +234,7 @@ func (h *hookHandler) getSQLFiles(event *PushEvent) []string {
 		}
 	}
 
-	return sqlFiles
+	return sqlFiles, nil
 }
 
 func isIPInRange(ipStr, cidrStr string) bool {
@@ -2,14 +2,18 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"testing"
 
 	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/metrics"
 	"github.com/dfryer1193/gomad/internal/rest/managers"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 const (
@@ -32,55 +36,113 @@ func (v *invalidSignatureValidator) ValidateSignature(r *http.Request, repoName
 
 type errorFileProcessor struct{}
 
-func (f *errorFileProcessor) ProcessFile(_, path, _ string) ([]api.MigrationProto, error) {
-	return nil, fmt.Errorf("error processing file %s", path)
+func (f *errorFileProcessor) ProcessFiles(_ context.Context, _ string, paths []string, _ string) ([]api.MigrationProto, error) {
+	return nil, fmt.Errorf("error processing file %s", paths[0])
+}
+
+func (f *errorFileProcessor) ChangedFiles(_ context.Context, _, _, _ string) ([]string, bool, error) {
+	return nil, false, nil
 }
 
 type mockFileProcessor struct{}
 
-func (f *mockFileProcessor) ProcessFile(_, _, _ string) ([]api.MigrationProto, error) {
+func (f *mockFileProcessor) ProcessFiles(_ context.Context, _ string, _ []string, _ string) ([]api.MigrationProto, error) {
+	return []api.MigrationProto{}, nil
+}
+
+func (f *mockFileProcessor) ChangedFiles(_ context.Context, _, _, _ string) ([]string, bool, error) {
+	return nil, false, nil
+}
+
+// diffingFileProcessor lists changed files itself and fails on any file it wasn't told about
+type diffingFileProcessor struct {
+	changed []string
+}
+
+func (f *diffingFileProcessor) ProcessFiles(_ context.Context, _ string, paths []string, _ string) ([]api.MigrationProto, error) {
+	for _, path := range paths {
+		if !slices.Contains(f.changed, path) {
+			return nil, fmt.Errorf("unexpected file %s", path)
+		}
+	}
 	return []api.MigrationProto{}, nil
 }
 
+func (f *diffingFileProcessor) ChangedFiles(_ context.Context, _, _, _ string) ([]string, bool, error) {
+	return f.changed, true, nil
+}
+
 type errorMigrationManager struct{}
 
-func (m *errorMigrationManager) ProcessMigrations(_ []api.MigrationProto) error {
+func (m *errorMigrationManager) ProcessMigrations(_ context.Context, _ []api.MigrationProto) error {
 	return fmt.Errorf("error processing migrations")
 }
 
-func (m *errorMigrationManager) GetMigrationsForNamespace(_ string) ([]*api.Migration, error) {
+func (m *errorMigrationManager) ListMigrations(_ context.Context, _ string, _ api.MigrationListOptions) (*api.MigrationList, error) {
 	return nil, fmt.Errorf("error getting migrations")
 }
 
-func (m *errorMigrationManager) GetMigrationById(_ uint64) (*api.Migration, error) {
+func (m *errorMigrationManager) GetMigrationById(_ context.Context, _ string, _ uint64) (*api.Migration, error) {
 	return nil, fmt.Errorf("error getting migration")
 }
 
+func (m *errorMigrationManager) GetMigrationHistory(_ context.Context, _ string, _ uint64) (*api.MigrationHistory, error) {
+	return nil, fmt.Errorf("error getting migration history")
+}
+
+func (m *errorMigrationManager) ListExecutionAttempts(_ context.Context, _ string, _ uint64) (*api.ExecutionAttemptList, error) {
+	return nil, fmt.Errorf("error listing execution attempts")
+}
+
+func (m *errorMigrationManager) ExecuteMigration(_ context.Context, _ string, _ uint64, _ managers.ExecuteOptions) (*api.Migration, error) {
+	return nil, fmt.Errorf("error executing migration")
+}
+
+func (m *errorMigrationManager) LintMigrations(_ context.Context, _ []api.MigrationProto) (*api.LintResponse, error) {
+	return nil, fmt.Errorf("error linting migrations")
+}
+
 func (m *errorMigrationManager) Close() {}
 
 type mockMigrationManager struct{}
 
-func (m *mockMigrationManager) ProcessMigrations(_ []api.MigrationProto) error {
+func (m *mockMigrationManager) ProcessMigrations(_ context.Context, _ []api.MigrationProto) error {
 	return nil
 }
 
-func (m *mockMigrationManager) GetMigrationsForNamespace(_ string) ([]*api.Migration, error) {
+func (m *mockMigrationManager) ListMigrations(_ context.Context, _ string, _ api.MigrationListOptions) (*api.MigrationList, error) {
 	return nil, nil
 }
 
-func (m *mockMigrationManager) GetMigrationById(_ uint64) (*api.Migration, error) {
+func (m *mockMigrationManager) GetMigrationById(_ context.Context, _ string, _ uint64) (*api.Migration, error) {
 	return nil, nil
 }
 
+func (m *mockMigrationManager) GetMigrationHistory(_ context.Context, _ string, _ uint64) (*api.MigrationHistory, error) {
+	return nil, nil
+}
+
+func (m *mockMigrationManager) ListExecutionAttempts(_ context.Context, _ string, _ uint64) (*api.ExecutionAttemptList, error) {
+	return nil, nil
+}
+
+func (m *mockMigrationManager) ExecuteMigration(_ context.Context, _ string, _ uint64, _ managers.ExecuteOptions) (*api.Migration, error) {
+	return nil, nil
+}
+
+func (m *mockMigrationManager) LintMigrations(_ context.Context, _ []api.MigrationProto) (*api.LintResponse, error) {
+	return &api.LintResponse{}, nil
+}
+
 func (m *mockMigrationManager) Close() {}
 
 type secretManagerMock struct{}
 
-func (s *secretManagerMock) SaveSecret(_ string) (string, error) {
+func (s *secretManagerMock) SaveSecret(_ context.Context, _ string) (string, error) {
 	return "test-secret", nil
 }
 
-func (s *secretManagerMock) GetSecret(_ string) (string, error) {
+func (s *secretManagerMock) GetSecret(_ context.Context, _ string) (string, error) {
 	return "test-secret", nil
 }
 
@@ -96,13 +158,16 @@ func TestHandlePush(t *testing.T) {
 		mangleBody         bool
 		secret             string
 		wantStatus         int
+		// wantOutcome is how the delivery is expected to be counted
+		wantOutcome string
 	}{
 		{
-			name:       "bad json payload",
-			event:      nil,
-			mangleBody: true,
-			secret:     TEST_SECRET,
-			wantStatus: http.StatusBadRequest,
+			name:        "bad json payload",
+			event:       nil,
+			mangleBody:  true,
+			secret:      TEST_SECRET,
+			wantStatus:  http.StatusBadRequest,
+			wantOutcome: metrics.WebhookInvalidPayload,
 		},
 		{
 			name:               "bad signature",
@@ -110,8 +175,9 @@ func TestHandlePush(t *testing.T) {
 			event: &PushEvent{
 				Ref: "refs/heads/master",
 			},
-			secret:     "wrong-secret",
-			wantStatus: http.StatusUnauthorized,
+			secret:      "wrong-secret",
+			wantStatus:  http.StatusUnauthorized,
+			wantOutcome: metrics.WebhookUnauthorized,
 		},
 		{
 			name:               "non-master branch",
@@ -124,11 +190,13 @@ func TestHandlePush(t *testing.T) {
 					},
 				},
 			},
-			wantStatus: http.StatusNoContent,
+			wantStatus:  http.StatusNoContent,
+			wantOutcome: metrics.WebhookIgnored,
 		},
 		{
 			name:               "no sql files",
 			signatureValidator: &validSignatureValidator{},
+			fileProcessor:      &mockFileProcessor{},
 			event: &PushEvent{
 				Ref: "refs/heads/master",
 				Commits: []Commit{
@@ -137,7 +205,8 @@ func TestHandlePush(t *testing.T) {
 					},
 				},
 			},
-			wantStatus: http.StatusNoContent,
+			wantStatus:  http.StatusNoContent,
+			wantOutcome: metrics.WebhookIgnored,
 		},
 		{
 			name:               "error processing sql files",
@@ -151,7 +220,8 @@ func TestHandlePush(t *testing.T) {
 					},
 				},
 			},
-			wantStatus: http.StatusInternalServerError,
+			wantStatus:  http.StatusInternalServerError,
+			wantOutcome: metrics.WebhookError,
 		},
 		{
 			name:               "error processing migrations files",
@@ -165,7 +235,8 @@ func TestHandlePush(t *testing.T) {
 					},
 				},
 			},
-			wantStatus: http.StatusInternalServerError,
+			wantStatus:  http.StatusInternalServerError,
+			wantOutcome: metrics.WebhookError,
 		},
 		{
 			name:               "error processing migration prototypes",
@@ -180,7 +251,24 @@ func TestHandlePush(t *testing.T) {
 					},
 				},
 			},
-			wantStatus: http.StatusInternalServerError,
+			wantStatus:  http.StatusInternalServerError,
+			wantOutcome: metrics.WebhookError,
+		},
+		{
+			name:               "changed files from diff",
+			signatureValidator: &validSignatureValidator{},
+			fileProcessor:      &diffingFileProcessor{changed: []string{"diffed.sql", "README.md"}},
+			migrationManager:   &mockMigrationManager{},
+			event: &PushEvent{
+				Ref: "refs/heads/master",
+				Commits: []Commit{
+					{
+						Added: []string{TEST_SQL_PATH},
+					},
+				},
+			},
+			wantStatus:  http.StatusNoContent,
+			wantOutcome: metrics.WebhookProcessed,
 		},
 		{
 			name:               "successful processing",
@@ -195,7 +283,8 @@ func TestHandlePush(t *testing.T) {
 					},
 				},
 			},
-			wantStatus: http.StatusNoContent,
+			wantStatus:  http.StatusNoContent,
+			wantOutcome: metrics.WebhookProcessed,
 		},
 	}
 
@@ -214,8 +303,14 @@ func TestHandlePush(t *testing.T) {
 			}
 			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBuffer(bodyBytes))
 			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-GitHub-Event", "push")
+			deliveries := metrics.WebhookDeliveries.WithLabelValues("github", tc.wantOutcome)
+			before := testutil.ToFloat64(deliveries)
 
 			err := h.HandlePush(w, req)
+			if delivered := testutil.ToFloat64(deliveries) - before; delivered != 1 {
+				t.Errorf("expected the delivery to be counted as %s once, got %v", tc.wantOutcome, delivered)
+			}
 			if tc.wantStatus > 204 {
 				if err == nil {
 					t.Errorf("Expected error, got none")
@@ -230,3 +325,22 @@ func TestHandlePush(t *testing.T) {
 		})
 	}
 }
+
+func TestWebhookProvider(t *testing.T) {
+	testCases := []struct {
+		header string
+		want   string
+	}{
+		{header: "X-GitHub-Event", want: "github"},
+		{header: "X-Gitea-Event", want: "gitea"},
+		{header: "X-Other-Event", want: "unknown"},
+	}
+
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set(tc.header, "push")
+		if got := webhookProvider(req); got != tc.want {
+			t.Errorf("webhookProvider() with %s = %s, want %s", tc.header, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/rest/managers"
+	"github.com/dfryer1193/gomad/internal/utils"
+	mjolnirUtils "github.com/dfryer1193/mjolnir/utils"
+)
+
+type SQLFileParser interface {
+	ParseSQL(content string) ([]api.MigrationProto, error)
+}
+
+type LintHandler struct {
+	parser        SQLFileParser
+	migrationsMgr managers.MigrationManager
+}
+
+var (
+	lintHandler *LintHandler
+	lintOnce    sync.Once
+)
+
+func GetLintHandler() *LintHandler {
+	lintOnce.Do(func() {
+		lintHandler = &LintHandler{
+			parser:        utils.GetMigrationFileParser(),
+			migrationsMgr: managers.GetMigrationsManager(),
+		}
+	})
+
+	return lintHandler
+}
+
+// Lint parses a migration file and reports risky statements in each migration, using the lint rule severities
+// configured for the migration's namespace
+func (h *LintHandler) Lint(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
+	request := &api.LintRequest{}
+	if _, err := mjolnirUtils.DecodeJSON(r, request); err != nil {
+		return mjolnirUtils.BadRequestErr(err)
+	}
+
+	migrations, err := h.parser.ParseSQL(request.SQL)
+	if err != nil {
+		return mjolnirUtils.BadRequestErr(fmt.Errorf("failed to parse migrations: %w", err))
+	}
+
+	response, err := h.migrationsMgr.LintMigrations(r.Context(), migrations)
+	if err != nil {
+		return mjolnirUtils.InternalServerErr(fmt.Errorf("error linting migrations: %w", err))
+	}
+
+	mjolnirUtils.RespondJSON(w, r, http.StatusOK, response)
+	return nil
+}
@@ -1,23 +1,35 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/dfryer1193/gomad/api"
 	"github.com/dfryer1193/gomad/internal/rest/managers"
 	mjolnirUtils "github.com/dfryer1193/mjolnir/utils"
+	"github.com/go-chi/chi/v5"
 )
 
 type NamespaceManager interface {
-	GetNamespaces() ([]string, error)
+	GetNamespaces(ctx context.Context) ([]string, error)
+	GetSettings(ctx context.Context, namespace string) (*api.NamespaceSettings, error)
+	SaveSettings(ctx context.Context, settings *api.NamespaceSettings) error
+	GetVariables(ctx context.Context, namespace string) (*api.NamespaceVariables, error)
+	SetVariable(ctx context.Context, namespace string, name string, value string) error
+	DeleteVariable(ctx context.Context, namespace string, name string) error
 }
 
 type MigrationHandler struct {
 	migrationsMgr managers.MigrationManager
 	namespacesMgr NamespaceManager
+	adminHandler  AdminHandler
 }
 
 var (
@@ -27,17 +39,22 @@ var (
 
 func GetMigrationHandler() *MigrationHandler {
 	migrationOnce.Do(func() {
-		handler = &MigrationHandler{
-			namespacesMgr: managers.GetNamespaceManager(),
-			migrationsMgr: managers.GetMigrationsManager(),
-		}
+		handler = NewMigrationHandler(managers.GetMigrationsManager(), managers.GetNamespaceManager(), GetAdminHandler())
 	})
 
 	return handler
 }
 
+func NewMigrationHandler(migrationsMgr managers.MigrationManager, namespacesMgr NamespaceManager, adminHandler AdminHandler) *MigrationHandler {
+	return &MigrationHandler{
+		migrationsMgr: migrationsMgr,
+		namespacesMgr: namespacesMgr,
+		adminHandler:  adminHandler,
+	}
+}
+
 func (h *MigrationHandler) GetNamespaces(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
-	namespaces, err := h.namespacesMgr.GetNamespaces()
+	namespaces, err := h.namespacesMgr.GetNamespaces(r.Context())
 	if err != nil {
 		return mjolnirUtils.InternalServerErr(fmt.Errorf("error fetching namespaces: %w", err))
 	}
@@ -46,34 +63,83 @@ func (h *MigrationHandler) GetNamespaces(w http.ResponseWriter, r *http.Request)
 	return nil
 }
 
+// GetMigrationsForNamespace lists a page of a namespace's migrations. The status (comma separated), user, repo, q
+// (comment search), from and to (RFC 3339) query parameters filter the listing, order is asc or desc by creation
+// time, and limit and cursor page through it.
 func (h *MigrationHandler) GetMigrationsForNamespace(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
-	namespace := r.URL.Query().Get("namespace")
-	if namespace == "" {
-		return mjolnirUtils.BadRequestErr(fmt.Errorf("namespace is required"))
+	namespace := chi.URLParam(r, "namespace")
+
+	opts, err := parseListOptions(r.URL.Query())
+	if err != nil {
+		return mjolnirUtils.BadRequestErr(err)
 	}
 
-	migrations, err := h.migrationsMgr.GetMigrationsForNamespace(namespace)
+	migrations, err := h.migrationsMgr.ListMigrations(r.Context(), namespace, opts)
+	if errors.Is(err, managers.ErrInvalidListOptions) {
+		return mjolnirUtils.BadRequestErr(err)
+	}
 	if err != nil {
 		return mjolnirUtils.InternalServerErr(fmt.Errorf("error fetching migrations: %w", err))
 	}
 
-	mjolnirUtils.RespondJSON(w, r, http.StatusOK, &api.MigrationList{Migrations: migrations})
+	mjolnirUtils.RespondJSON(w, r, http.StatusOK, migrations)
 	return nil
 }
 
-func (h *MigrationHandler) GetMigrationById(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
-	namespace := r.URL.Query().Get("namespace")
-	idStr := r.URL.Query().Get("migrationId")
-	if idStr == "" {
-		return mjolnirUtils.BadRequestErr(fmt.Errorf("migrationId is required"))
+func parseListOptions(query url.Values) (api.MigrationListOptions, error) {
+	opts := api.MigrationListOptions{
+		User:   query.Get("user"),
+		Repo:   query.Get("repo"),
+		Search: query.Get("q"),
+		Cursor: query.Get("cursor"),
+	}
+
+	if statuses := query.Get("status"); statuses != "" {
+		for _, status := range strings.Split(statuses, ",") {
+			opts.Statuses = append(opts.Statuses, api.MigrationStatus(strings.TrimSpace(status)))
+		}
+	}
+
+	var err error
+	if from := query.Get("from"); from != "" {
+		if opts.CreatedFrom, err = time.Parse(time.RFC3339, from); err != nil {
+			return opts, fmt.Errorf("invalid from: must be an RFC 3339 timestamp")
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if opts.CreatedTo, err = time.Parse(time.RFC3339, to); err != nil {
+			return opts, fmt.Errorf("invalid to: must be an RFC 3339 timestamp")
+		}
+	}
+
+	switch order := query.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		opts.Descending = true
+	default:
+		return opts, fmt.Errorf("invalid order %q: must be asc or desc", order)
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		if opts.Limit, err = strconv.Atoi(limit); err != nil || opts.Limit <= 0 {
+			return opts, fmt.Errorf("invalid limit: must be a positive integer")
+		}
 	}
 
-	id, err := strconv.ParseUint(idStr, 10, 64)
+	return opts, nil
+}
+
+func (h *MigrationHandler) GetMigrationById(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
+	namespace := chi.URLParam(r, "namespace")
+	id, err := strconv.ParseUint(chi.URLParam(r, "migrationId"), 10, 64)
 	if err != nil {
 		return mjolnirUtils.BadRequestErr(fmt.Errorf("invalid migrationId: must be a positive integer"))
 	}
 
-	migration, err := h.migrationsMgr.GetMigrationById(id)
+	migration, err := h.migrationsMgr.GetMigrationById(r.Context(), namespace, id)
+	if errors.Is(err, managers.ErrMigrationNotFound) {
+		return mjolnirUtils.NewApiError(err, http.StatusNotFound)
+	}
 	if err != nil {
 		return mjolnirUtils.InternalServerErr(fmt.Errorf("error fetching migration id %d for namespace %s: %w", id, namespace, err))
 	}
@@ -81,3 +147,189 @@ func (h *MigrationHandler) GetMigrationById(w http.ResponseWriter, r *http.Reque
 	mjolnirUtils.RespondJSON(w, r, http.StatusOK, migration)
 	return nil
 }
+
+// GetMigrationHistory lists the status changes of a migration, oldest first
+func (h *MigrationHandler) GetMigrationHistory(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
+	namespace := chi.URLParam(r, "namespace")
+	id, err := strconv.ParseUint(chi.URLParam(r, "migrationId"), 10, 64)
+	if err != nil {
+		return mjolnirUtils.BadRequestErr(fmt.Errorf("invalid migrationId: must be a positive integer"))
+	}
+
+	history, err := h.migrationsMgr.GetMigrationHistory(r.Context(), namespace, id)
+	if errors.Is(err, managers.ErrMigrationNotFound) {
+		return mjolnirUtils.NewApiError(err, http.StatusNotFound)
+	}
+	if err != nil {
+		return mjolnirUtils.InternalServerErr(fmt.Errorf("error fetching history of migration id %d for namespace %s: %w", id, namespace, err))
+	}
+
+	mjolnirUtils.RespondJSON(w, r, http.StatusOK, history)
+	return nil
+}
+
+// GetExecutionAttempts lists the execution attempts of a migration, oldest first, with the statements each sent and
+// how the database responded
+func (h *MigrationHandler) GetExecutionAttempts(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
+	namespace := chi.URLParam(r, "namespace")
+	id, err := strconv.ParseUint(chi.URLParam(r, "migrationId"), 10, 64)
+	if err != nil {
+		return mjolnirUtils.BadRequestErr(fmt.Errorf("invalid migrationId: must be a positive integer"))
+	}
+
+	attempts, err := h.migrationsMgr.ListExecutionAttempts(r.Context(), namespace, id)
+	if errors.Is(err, managers.ErrMigrationNotFound) {
+		return mjolnirUtils.NewApiError(err, http.StatusNotFound)
+	}
+	if err != nil {
+		return mjolnirUtils.InternalServerErr(fmt.Errorf("error fetching execution attempts of migration id %d for namespace %s: %w", id, namespace, err))
+	}
+
+	mjolnirUtils.RespondJSON(w, r, http.StatusOK, attempts)
+	return nil
+}
+
+// ExecuteMigration runs a migration against its namespace. Migrations whose status doesn't allow running them are
+// refused with a conflict, and ones with error-level lint findings unless the overrideLint query parameter is true.
+// Requires the admin token.
+func (h *MigrationHandler) ExecuteMigration(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
+	if apiErr := authorizeAdmin(r, h.adminHandler); apiErr != nil {
+		return apiErr
+	}
+
+	namespace := chi.URLParam(r, "namespace")
+
+	id, err := strconv.ParseUint(chi.URLParam(r, "migrationId"), 10, 64)
+	if err != nil {
+		return mjolnirUtils.BadRequestErr(fmt.Errorf("invalid migrationId: must be a positive integer"))
+	}
+
+	opts := managers.ExecuteOptions{}
+	if override := r.URL.Query().Get("overrideLint"); override != "" {
+		opts.OverrideLint, err = strconv.ParseBool(override)
+		if err != nil {
+			return mjolnirUtils.BadRequestErr(fmt.Errorf("invalid overrideLint: must be a boolean"))
+		}
+	}
+
+	migration, err := h.migrationsMgr.ExecuteMigration(r.Context(), namespace, id, opts)
+	if errors.Is(err, managers.ErrMigrationNotFound) {
+		return mjolnirUtils.NewApiError(err, http.StatusNotFound)
+	}
+	if errors.Is(err, managers.ErrMigrationCompleted) || errors.Is(err, managers.ErrIllegalTransition) {
+		return mjolnirUtils.NewApiError(err, http.StatusConflict)
+	}
+	if errors.Is(err, managers.ErrLintFailed) || errors.Is(err, managers.ErrUnresolvedVariables) {
+		return mjolnirUtils.NewApiError(err, http.StatusUnprocessableEntity)
+	}
+	if err != nil {
+		return mjolnirUtils.InternalServerErr(fmt.Errorf("error executing migration id %d for namespace %s: %w", id, namespace, err))
+	}
+
+	mjolnirUtils.RespondJSON(w, r, http.StatusOK, migration)
+	return nil
+}
+
+func (h *MigrationHandler) GetNamespaceSettings(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
+	namespace := chi.URLParam(r, "namespace")
+
+	settings, err := h.namespacesMgr.GetSettings(r.Context(), namespace)
+	if err != nil {
+		return mjolnirUtils.InternalServerErr(fmt.Errorf("error fetching settings for namespace %s: %w", namespace, err))
+	}
+
+	mjolnirUtils.RespondJSON(w, r, http.StatusOK, settings)
+	return nil
+}
+
+// PutNamespaceSettings replaces the execution defaults for a namespace. Requires the admin token.
+func (h *MigrationHandler) PutNamespaceSettings(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
+	if apiErr := authorizeAdmin(r, h.adminHandler); apiErr != nil {
+		return apiErr
+	}
+
+	namespace := chi.URLParam(r, "namespace")
+
+	settings := &api.NamespaceSettings{}
+	if _, err := mjolnirUtils.DecodeJSON(r, settings); err != nil {
+		return mjolnirUtils.BadRequestErr(err)
+	}
+	settings.Namespace = namespace
+
+	err := h.namespacesMgr.SaveSettings(r.Context(), settings)
+	if errors.Is(err, managers.ErrInvalidSettings) {
+		return mjolnirUtils.BadRequestErr(err)
+	}
+	if err != nil {
+		return mjolnirUtils.InternalServerErr(fmt.Errorf("error saving settings for namespace %s: %w", namespace, err))
+	}
+
+	mjolnirUtils.RespondJSON(w, r, http.StatusOK, settings)
+	return nil
+}
+
+// GetNamespaceVariables lists the template variables for a namespace. Requires the admin token.
+func (h *MigrationHandler) GetNamespaceVariables(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
+	if apiErr := authorizeAdmin(r, h.adminHandler); apiErr != nil {
+		return apiErr
+	}
+
+	namespace := chi.URLParam(r, "namespace")
+
+	vars, err := h.namespacesMgr.GetVariables(r.Context(), namespace)
+	if err != nil {
+		return mjolnirUtils.InternalServerErr(fmt.Errorf("error fetching variables for namespace %s: %w", namespace, err))
+	}
+
+	mjolnirUtils.RespondJSON(w, r, http.StatusOK, vars)
+	return nil
+}
+
+// PutNamespaceVariable sets a template variable for a namespace. Requires the admin token.
+func (h *MigrationHandler) PutNamespaceVariable(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
+	if apiErr := authorizeAdmin(r, h.adminHandler); apiErr != nil {
+		return apiErr
+	}
+
+	namespace := chi.URLParam(r, "namespace")
+	name := chi.URLParam(r, "name")
+
+	var body struct {
+		Value string `json:"value"`
+	}
+	if _, err := mjolnirUtils.DecodeJSON(r, &body); err != nil {
+		return mjolnirUtils.BadRequestErr(err)
+	}
+
+	err := h.namespacesMgr.SetVariable(r.Context(), namespace, name, body.Value)
+	if errors.Is(err, managers.ErrInvalidVariable) {
+		return mjolnirUtils.BadRequestErr(err)
+	}
+	if err != nil {
+		return mjolnirUtils.InternalServerErr(fmt.Errorf("error setting variable %s for namespace %s: %w", name, namespace, err))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// DeleteNamespaceVariable removes a template variable from a namespace. Requires the admin token.
+func (h *MigrationHandler) DeleteNamespaceVariable(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
+	if apiErr := authorizeAdmin(r, h.adminHandler); apiErr != nil {
+		return apiErr
+	}
+
+	namespace := chi.URLParam(r, "namespace")
+	name := chi.URLParam(r, "name")
+
+	err := h.namespacesMgr.DeleteVariable(r.Context(), namespace, name)
+	if errors.Is(err, managers.ErrVariableNotFound) {
+		return mjolnirUtils.NewApiError(err, http.StatusNotFound)
+	}
+	if err != nil {
+		return mjolnirUtils.InternalServerErr(fmt.Errorf("error deleting variable %s for namespace %s: %w", name, namespace, err))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
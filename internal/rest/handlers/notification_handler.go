@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/rest/managers"
+	mjolnirUtils "github.com/dfryer1193/mjolnir/utils"
+)
+
+type NotificationManager interface {
+	ListSinks(ctx context.Context) ([]*api.NotificationSink, error)
+	SaveSink(ctx context.Context, sink *api.NotificationSink) error
+	DeleteSink(ctx context.Context, name string) error
+	TestSink(ctx context.Context, name string) error
+}
+
+// NotificationHandler manages the sinks migration events are sent to. Every endpoint requires the admin token.
+type NotificationHandler struct {
+	notificationMgr NotificationManager
+	adminHandler    AdminHandler
+}
+
+var (
+	notificationHandler *NotificationHandler
+	notificationOnce    sync.Once
+)
+
+func GetNotificationHandler() *NotificationHandler {
+	notificationOnce.Do(func() {
+		notificationHandler = NewNotificationHandler(managers.GetNotificationManager(), GetAdminHandler())
+	})
+
+	return notificationHandler
+}
+
+func NewNotificationHandler(notificationMgr NotificationManager, adminHandler AdminHandler) *NotificationHandler {
+	return &NotificationHandler{notificationMgr: notificationMgr, adminHandler: adminHandler}
+}
+
+func (h *NotificationHandler) GetSinks(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
+	if apiErr := authorizeAdmin(r, h.adminHandler); apiErr != nil {
+		return apiErr
+	}
+
+	sinks, err := h.notificationMgr.ListSinks(r.Context())
+	if err != nil {
+		return mjolnirUtils.InternalServerErr(fmt.Errorf("error fetching notification sinks: %w", err))
+	}
+
+	mjolnirUtils.RespondJSON(w, r, http.StatusOK, &api.NotificationSinkList{Sinks: sinks})
+	return nil
+}
+
+func (h *NotificationHandler) PutSink(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
+	if apiErr := authorizeAdmin(r, h.adminHandler); apiErr != nil {
+		return apiErr
+	}
+
+	sink := &api.NotificationSink{}
+	if _, err := mjolnirUtils.DecodeJSON(r, sink); err != nil {
+		return mjolnirUtils.BadRequestErr(err)
+	}
+
+	err := h.notificationMgr.SaveSink(r.Context(), sink)
+	if errors.Is(err, managers.ErrInvalidNotificationSink) {
+		return mjolnirUtils.BadRequestErr(err)
+	}
+	if err != nil {
+		return mjolnirUtils.InternalServerErr(fmt.Errorf("error saving notification sink %s: %w", sink.Name, err))
+	}
+
+	mjolnirUtils.RespondJSON(w, r, http.StatusOK, sink)
+	return nil
+}
+
+func (h *NotificationHandler) DeleteSink(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
+	if apiErr := authorizeAdmin(r, h.adminHandler); apiErr != nil {
+		return apiErr
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		return mjolnirUtils.BadRequestErr(fmt.Errorf("name is required"))
+	}
+
+	err := h.notificationMgr.DeleteSink(r.Context(), name)
+	if errors.Is(err, managers.ErrNotificationSinkNotFound) {
+		return mjolnirUtils.NewApiError(err, http.StatusNotFound)
+	}
+	if err != nil {
+		return mjolnirUtils.InternalServerErr(fmt.Errorf("error deleting notification sink %s: %w", name, err))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// PostTestSink sends a test notification to the sink named by the name query parameter. A sink that can't be
+// reached or rejects the notification gets a 502 saying why.
+func (h *NotificationHandler) PostTestSink(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
+	if apiErr := authorizeAdmin(r, h.adminHandler); apiErr != nil {
+		return apiErr
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		return mjolnirUtils.BadRequestErr(fmt.Errorf("name is required"))
+	}
+
+	err := h.notificationMgr.TestSink(r.Context(), name)
+	if errors.Is(err, managers.ErrNotificationSinkNotFound) {
+		return mjolnirUtils.NewApiError(err, http.StatusNotFound)
+	}
+	if errors.Is(err, managers.ErrNotificationFailed) {
+		return mjolnirUtils.NewApiError(err, http.StatusBadGateway)
+	}
+	if err != nil {
+		return mjolnirUtils.InternalServerErr(fmt.Errorf("error testing notification sink %s: %w", name, err))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/rest/managers"
+	mjolnirUtils "github.com/dfryer1193/mjolnir/utils"
+)
+
+type RepositoryManager interface {
+	ListRepositories(ctx context.Context) ([]*api.RepositoryConfig, error)
+	SaveRepository(ctx context.Context, config *api.RepositoryConfig) error
+	DeleteRepository(ctx context.Context, repoName string) error
+}
+
+// RepositoryHandler manages the per-repository file fetching configs. Every endpoint requires the admin token.
+type RepositoryHandler struct {
+	repositoryMgr RepositoryManager
+	adminHandler  AdminHandler
+}
+
+var (
+	repositoryHandler *RepositoryHandler
+	repositoryOnce    sync.Once
+)
+
+func GetRepositoryHandler() *RepositoryHandler {
+	repositoryOnce.Do(func() {
+		repositoryHandler = &RepositoryHandler{
+			repositoryMgr: managers.GetRepositoryManager(),
+			adminHandler:  GetAdminHandler(),
+		}
+	})
+
+	return repositoryHandler
+}
+
+func (h *RepositoryHandler) GetRepositories(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
+	if apiErr := authorizeAdmin(r, h.adminHandler); apiErr != nil {
+		return apiErr
+	}
+
+	configs, err := h.repositoryMgr.ListRepositories(r.Context())
+	if err != nil {
+		return mjolnirUtils.InternalServerErr(fmt.Errorf("error fetching repositories: %w", err))
+	}
+
+	mjolnirUtils.RespondJSON(w, r, http.StatusOK, &api.RepositoryConfigList{Repositories: configs})
+	return nil
+}
+
+func (h *RepositoryHandler) PutRepository(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
+	if apiErr := authorizeAdmin(r, h.adminHandler); apiErr != nil {
+		return apiErr
+	}
+
+	config := &api.RepositoryConfig{}
+	if _, err := mjolnirUtils.DecodeJSON(r, config); err != nil {
+		return mjolnirUtils.BadRequestErr(err)
+	}
+
+	err := h.repositoryMgr.SaveRepository(r.Context(), config)
+	if errors.Is(err, managers.ErrInvalidRepositoryConfig) {
+		return mjolnirUtils.BadRequestErr(err)
+	}
+	if err != nil {
+		return mjolnirUtils.InternalServerErr(fmt.Errorf("error saving repository %s: %w", config.RepoName, err))
+	}
+
+	mjolnirUtils.RespondJSON(w, r, http.StatusOK, config)
+	return nil
+}
+
+func (h *RepositoryHandler) DeleteRepository(w http.ResponseWriter, r *http.Request) *mjolnirUtils.ApiError {
+	if apiErr := authorizeAdmin(r, h.adminHandler); apiErr != nil {
+		return apiErr
+	}
+
+	repoName := r.URL.Query().Get("repoName")
+	if repoName == "" {
+		return mjolnirUtils.BadRequestErr(fmt.Errorf("repoName is required"))
+	}
+
+	err := h.repositoryMgr.DeleteRepository(r.Context(), repoName)
+	if errors.Is(err, managers.ErrRepositoryNotFound) {
+		return mjolnirUtils.NewApiError(err, http.StatusNotFound)
+	}
+	if err != nil {
+		return mjolnirUtils.InternalServerErr(fmt.Errorf("error deleting repository %s: %w", repoName, err))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
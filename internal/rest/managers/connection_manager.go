@@ -0,0 +1,160 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+	"github.com/dfryer1193/gomad/internal/data/repository/storage"
+	"github.com/dfryer1193/gomad/internal/data/repository/targets"
+	dataUtils "github.com/dfryer1193/gomad/internal/data/utils"
+)
+
+var (
+	ErrInvalidConnection  = errors.New("invalid namespace connection")
+	ErrConnectionNotFound = errors.New("namespace connection not found")
+)
+
+// identifierPattern matches unquoted postgres identifiers
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]{0,62}$`)
+
+// ConnectionManager manages the registry of where each namespace's database lives
+type ConnectionManager struct {
+	connectionRepo repository.NamespaceConnectionRepository
+	targets        repository.TargetRepository
+}
+
+var (
+	connectionMgr  *ConnectionManager
+	connectionOnce sync.Once
+)
+
+func GetConnectionManager() *ConnectionManager {
+	connectionOnce.Do(func() {
+		connectionMgr = &ConnectionManager{
+			connectionRepo: storage.GetNamespaceConnectionRepository(),
+			targets:        targets.GetTargetRepository(),
+		}
+	})
+
+	return connectionMgr
+}
+
+func (mgr *ConnectionManager) ListConnections(ctx context.Context) ([]*api.NamespaceConnection, error) {
+	conns, err := mgr.connectionRepo.ListConnections(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch namespace connections: %w", err)
+	}
+
+	return conns, nil
+}
+
+func (mgr *ConnectionManager) GetConnection(ctx context.Context, namespace string) (*api.NamespaceConnection, error) {
+	conn, err := mgr.connectionRepo.GetConnection(ctx, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch connection for namespace %s: %w", namespace, err)
+	}
+
+	if conn == nil {
+		return nil, fmt.Errorf("%w: %s", ErrConnectionNotFound, namespace)
+	}
+
+	return conn, nil
+}
+
+// SaveConnection validates and registers where a namespace's database lives, replacing any existing connection.
+// The driver defaults to postgres, the port to the driver's default port and the database to the namespace's name.
+// SQLite connections need no host, port or user.
+func (mgr *ConnectionManager) SaveConnection(ctx context.Context, conn *api.NamespaceConnection) error {
+	if conn.Namespace == "" || conn.Namespace == api.AllNamespaces {
+		return fmt.Errorf("%w: a namespace is required", ErrInvalidConnection)
+	}
+
+	if conn.Driver == "" {
+		conn.Driver = api.DriverPostgres
+	}
+	if !slices.Contains(api.Drivers, conn.Driver) {
+		return fmt.Errorf("%w: unsupported driver %q", ErrInvalidConnection, conn.Driver)
+	}
+
+	if conn.Driver.Networked() {
+		if conn.Host == "" {
+			return fmt.Errorf("%w: host is required", ErrInvalidConnection)
+		}
+
+		if conn.Port == 0 {
+			conn.Port = conn.Driver.DefaultPort()
+		}
+		if conn.Port < 1 || conn.Port > 65535 {
+			return fmt.Errorf("%w: port must be between 1 and 65535", ErrInvalidConnection)
+		}
+
+		if conn.User == "" {
+			return fmt.Errorf("%w: user is required", ErrInvalidConnection)
+		}
+	}
+
+	if conn.Database == "" {
+		conn.Database = conn.Namespace
+		if conn.Driver == api.DriverSQLite {
+			conn.Database += ".db"
+		}
+	}
+
+	// Only postgres has schemas within a database; elsewhere a namespace's tables always live in the database itself
+	if conn.Schema != "" && conn.Driver != api.DriverPostgres {
+		return fmt.Errorf("%w: schema is not supported by the %s driver", ErrInvalidConnection, conn.Driver)
+	}
+
+	if conn.Schema != "" && !identifierPattern.MatchString(conn.Schema) {
+		return fmt.Errorf("%w: schema %q is not a valid identifier", ErrInvalidConnection, conn.Schema)
+	}
+
+	if err := dataUtils.ValidateCredentialsRef(conn.CredentialsRef); err != nil {
+		return fmt.Errorf("%w: credentialsRef: %w", ErrInvalidConnection, err)
+	}
+
+	if conn.SSLMode != "" && !slices.Contains(api.SSLModes, conn.SSLMode) {
+		return fmt.Errorf("%w: sslMode must be one of %s", ErrInvalidConnection, strings.Join(api.SSLModes, ", "))
+	}
+
+	if err := mgr.connectionRepo.UpsertConnection(ctx, conn); err != nil {
+		return fmt.Errorf("failed to save connection for namespace %s: %w", conn.Namespace, err)
+	}
+
+	return nil
+}
+
+func (mgr *ConnectionManager) DeleteConnection(ctx context.Context, namespace string) error {
+	deleted, err := mgr.connectionRepo.DeleteConnection(ctx, namespace)
+	if err != nil {
+		return fmt.Errorf("failed to delete connection for namespace %s: %w", namespace, err)
+	}
+
+	if !deleted {
+		return fmt.Errorf("%w: %s", ErrConnectionNotFound, namespace)
+	}
+
+	return nil
+}
+
+// CreateDatabase creates the database backing a namespace on its server, returning false if it already existed
+func (mgr *ConnectionManager) CreateDatabase(ctx context.Context, namespace string) (bool, error) {
+	created, err := mgr.targets.CreateDatabase(ctx, namespace)
+	if err != nil {
+		return false, fmt.Errorf("failed to create database for namespace %s: %w", namespace, err)
+	}
+
+	return created, nil
+}
+
+func (mgr *ConnectionManager) Close() {
+	mgr.connectionRepo.Close()
+	mgr.targets.Close()
+}
@@ -0,0 +1,173 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dfryer1193/gomad/api"
+)
+
+type fakeConnectionRepository struct {
+	conns map[string]*api.NamespaceConnection
+}
+
+func (r *fakeConnectionRepository) GetConnection(_ context.Context, namespace string) (*api.NamespaceConnection, error) {
+	return r.conns[namespace], nil
+}
+
+func (r *fakeConnectionRepository) ListConnections(_ context.Context) ([]*api.NamespaceConnection, error) {
+	conns := make([]*api.NamespaceConnection, 0, len(r.conns))
+	for _, conn := range r.conns {
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+func (r *fakeConnectionRepository) UpsertConnection(_ context.Context, conn *api.NamespaceConnection) error {
+	r.conns[conn.Namespace] = conn
+	return nil
+}
+
+func (r *fakeConnectionRepository) DeleteConnection(_ context.Context, namespace string) (bool, error) {
+	_, ok := r.conns[namespace]
+	delete(r.conns, namespace)
+	return ok, nil
+}
+
+func (r *fakeConnectionRepository) Close() {}
+
+func TestSaveConnection(t *testing.T) {
+	t.Setenv("GOMAD_CREDENTIALS_DIR", "/run/secrets")
+	tests := []struct {
+		name    string
+		conn    api.NamespaceConnection
+		wantErr bool
+	}{
+		{
+			name: "fills in defaults",
+			conn: api.NamespaceConnection{Namespace: "billing", Host: "db.internal", User: "gomad"},
+		},
+		{
+			name: "full connection",
+			conn: api.NamespaceConnection{
+				Namespace:      "billing",
+				Host:           "db.internal",
+				Port:           6432,
+				Database:       "billing_prod",
+				Schema:         "ledger",
+				User:           "gomad",
+				CredentialsRef: "file:/run/secrets/billing",
+				SSLMode:        "verify-full",
+				SSLRootCert:    "/etc/ssl/billing-ca.pem",
+			},
+		},
+		{
+			name:    "wildcard namespace",
+			conn:    api.NamespaceConnection{Namespace: api.AllNamespaces, Host: "db.internal", User: "gomad"},
+			wantErr: true,
+		},
+		{
+			name:    "missing host",
+			conn:    api.NamespaceConnection{Namespace: "billing", User: "gomad"},
+			wantErr: true,
+		},
+		{
+			name:    "bad port",
+			conn:    api.NamespaceConnection{Namespace: "billing", Host: "db.internal", Port: 70000, User: "gomad"},
+			wantErr: true,
+		},
+		{
+			name:    "bad schema",
+			conn:    api.NamespaceConnection{Namespace: "billing", Host: "db.internal", User: "gomad", Schema: "ledger; DROP"},
+			wantErr: true,
+		},
+		{
+			name:    "credentials outside the credentials directory",
+			conn:    api.NamespaceConnection{Namespace: "billing", Host: "db.internal", User: "gomad", CredentialsRef: "file:/run/secrets/../../etc/shadow"},
+			wantErr: true,
+		},
+		{
+			name:    "gomad's own environment",
+			conn:    api.NamespaceConnection{Namespace: "billing", Host: "db.internal", User: "gomad", CredentialsRef: "env:GITHUB_TOKEN"},
+			wantErr: true,
+		},
+		{
+			name:    "inline password instead of reference",
+			conn:    api.NamespaceConnection{Namespace: "billing", Host: "db.internal", User: "gomad", CredentialsRef: "hunter2"},
+			wantErr: true,
+		},
+		{
+			name: "mysql connection",
+			conn: api.NamespaceConnection{Namespace: "billing", Driver: api.DriverMySQL, Host: "db.internal", User: "gomad"},
+		},
+		{
+			name: "sqlite file",
+			conn: api.NamespaceConnection{Namespace: "billing", Driver: api.DriverSQLite},
+		},
+		{
+			name:    "unknown driver",
+			conn:    api.NamespaceConnection{Namespace: "billing", Driver: "oracle", Host: "db.internal", User: "gomad"},
+			wantErr: true,
+		},
+		{
+			name:    "schema on mysql",
+			conn:    api.NamespaceConnection{Namespace: "billing", Driver: api.DriverMySQL, Host: "db.internal", User: "gomad", Schema: "ledger"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown ssl mode",
+			conn:    api.NamespaceConnection{Namespace: "billing", Host: "db.internal", User: "gomad", SSLMode: "always"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeConnectionRepository{conns: make(map[string]*api.NamespaceConnection)}
+			mgr := &ConnectionManager{connectionRepo: repo}
+
+			conn := tt.conn
+			err := mgr.SaveConnection(context.Background(), &conn)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidConnection) {
+					t.Fatalf("expected ErrInvalidConnection, got %v", err)
+				}
+				if len(repo.conns) != 0 {
+					t.Errorf("expected invalid connection not to be saved")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			saved := repo.conns[conn.Namespace]
+			if saved == nil {
+				t.Fatalf("expected connection to be saved")
+			}
+			if (saved.Driver.Networked() && saved.Port == 0) || saved.Database == "" || saved.Driver == "" {
+				t.Errorf("expected defaults to be filled in, got %+v", saved)
+			}
+			if saved.Driver == api.DriverSQLite && saved.Database != "billing.db" {
+				t.Errorf("expected sqlite database to default to the namespace's file, got %+v", saved)
+			} else if tt.conn.Port == 0 && saved.Driver.Networked() && (saved.Port != saved.Driver.DefaultPort() || saved.Database != saved.Namespace) {
+				t.Errorf("expected default port and database, got %+v", saved)
+			}
+		})
+	}
+}
+
+func TestDeleteConnection(t *testing.T) {
+	repo := &fakeConnectionRepository{conns: map[string]*api.NamespaceConnection{
+		"billing": {Namespace: "billing"},
+	}}
+	mgr := &ConnectionManager{connectionRepo: repo}
+
+	if err := mgr.DeleteConnection(context.Background(), "billing"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mgr.DeleteConnection(context.Background(), "billing"); !errors.Is(err, ErrConnectionNotFound) {
+		t.Errorf("expected ErrConnectionNotFound, got %v", err)
+	}
+}
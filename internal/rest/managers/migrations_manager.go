@@ -1,24 +1,87 @@
 package managers
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/dfryer1193/gomad/api"
 	"github.com/dfryer1193/gomad/internal/data/repository"
-	"github.com/dfryer1193/gomad/internal/data/repository/postgres"
+	"github.com/dfryer1193/gomad/internal/data/repository/storage"
+	"github.com/dfryer1193/gomad/internal/data/repository/targets"
+	"github.com/dfryer1193/gomad/internal/events"
+	"github.com/dfryer1193/gomad/internal/metrics"
+	"github.com/dfryer1193/gomad/internal/tracing"
+	"github.com/dfryer1193/gomad/internal/utils"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type MigrationManager interface {
-	ProcessMigrations(pending []api.MigrationProto) error
-	GetMigrationsForNamespace(namespace string) ([]*api.Migration, error)
-	GetMigrationById(id uint64) (*api.Migration, error)
+	ProcessMigrations(ctx context.Context, pending []api.MigrationProto) error
+	ListMigrations(ctx context.Context, namespace string, opts api.MigrationListOptions) (*api.MigrationList, error)
+	GetMigrationById(ctx context.Context, namespace string, id uint64) (*api.Migration, error)
+	GetMigrationHistory(ctx context.Context, namespace string, id uint64) (*api.MigrationHistory, error)
+	ListExecutionAttempts(ctx context.Context, namespace string, id uint64) (*api.ExecutionAttemptList, error)
+	ExecuteMigration(ctx context.Context, namespace string, id uint64, opts ExecuteOptions) (*api.Migration, error)
+	LintMigrations(ctx context.Context, migrations []api.MigrationProto) (*api.LintResponse, error)
 	Close()
 }
 
+// ExecuteOptions controls how a single migration execution behaves
+type ExecuteOptions struct {
+	// OverrideLint runs the migration even when it has error-level lint findings
+	OverrideLint bool
+}
+
+var (
+	ErrMigrationNotFound  = errors.New("migration not found")
+	ErrMigrationCompleted = errors.New("migration already completed")
+	ErrLintFailed         = errors.New("migration has error-level lint findings")
+	// ErrUnresolvedVariables is returned when a migration references template variables its namespace doesn't define
+	ErrUnresolvedVariables = errors.New("migration references undefined template variables")
+	ErrInvalidListOptions  = errors.New("invalid migration list options")
+	// ErrIllegalTransition is returned when a migration can't move to a status from the one it's in
+	ErrIllegalTransition = errors.New("illegal migration status transition")
+)
+
+const (
+	// defaultMigrationPageSize is used when a listing doesn't ask for a page size
+	defaultMigrationPageSize = 50
+	maxMigrationPageSize     = 500
+)
+
+type migrationLinter interface {
+	Lint(ddl string, severities map[string]api.LintSeverity, estimate utils.TableSizeEstimator) []api.LintFinding
+}
+
+// defaultLockRetryBackoff is the wait before the first retry after a lock timeout; it doubles on each retry
+const defaultLockRetryBackoff = time.Second
+
+// statusRecordTimeout bounds recording how an execution ended, which may happen after its own context is done
+const statusRecordTimeout = 5 * time.Second
+
 type migrationManager struct {
-	databases  repository.DatabaseRepository
-	migrations repository.MigrationRepository
+	databases        repository.DatabaseRepository
+	migrations       repository.MigrationRepository
+	attempts         repository.ExecutionAttemptRepository
+	settings         repository.NamespaceSettingsRepository
+	variables        repository.NamespaceVariablesRepository
+	targets          repository.TargetRepository
+	events           *events.Bus
+	linter           migrationLinter
+	lockRetryBackoff time.Duration
+	// host identifies this instance in the execution attempts it records
+	host string
+	// executions tracks the migrations being executed, so shutdown can wait for them
+	executions sync.WaitGroup
 }
 
 var (
@@ -29,51 +92,612 @@ var (
 func GetMigrationsManager() *migrationManager {
 	migrationsOnce.Do(func() {
 		manager = &migrationManager{
-			databases:  postgres.GetDatabaseRepository(),
-			migrations: postgres.GetMigrationRepository(),
+			databases:        storage.GetDatabaseRepository(),
+			migrations:       storage.GetMigrationRepository(),
+			attempts:         storage.GetExecutionAttemptRepository(),
+			settings:         storage.GetNamespaceSettingsRepository(),
+			variables:        storage.GetNamespaceVariablesRepository(),
+			targets:          targets.GetTargetRepository(),
+			events:           events.GetBus(),
+			linter:           utils.GetMigrationLinter(),
+			lockRetryBackoff: defaultLockRetryBackoff,
+			host:             hostname(),
 		}
 	})
 
 	return manager
 }
 
+// hostname returns the name of the host gomad runs on, or an empty string if it can't be determined
+func hostname() string {
+	host, err := os.Hostname()
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to determine hostname for execution attempts")
+		return ""
+	}
+
+	return host
+}
+
+// Wait blocks until every migration execution in progress has returned, or until ctx is done
+func (mgr *migrationManager) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		mgr.executions.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (mgr *migrationManager) Close() {
 	mgr.databases.Close()
 	mgr.migrations.Close()
+	mgr.attempts.Close()
+	mgr.settings.Close()
+	mgr.variables.Close()
+	mgr.targets.Close()
 }
 
-func (mgr *migrationManager) ProcessMigrations(pending []api.MigrationProto) error {
-	incomplete, err := mgr.filterCompleted(pending)
+func (mgr *migrationManager) ProcessMigrations(ctx context.Context, pending []api.MigrationProto) error {
+	targeted, err := mgr.targetNamespaces(ctx, pending)
+	if err != nil {
+		return fmt.Errorf("failed to resolve target namespaces: %w", err)
+	}
+
+	incomplete, err := mgr.filterCompleted(ctx, targeted)
 	if err != nil {
 		return fmt.Errorf("failed to fetch managers while processing managers: %w", err)
 	}
 
-	err = mgr.migrations.BulkInsert(incomplete)
+	if err := mgr.checkTemplateVariables(ctx, incomplete); err != nil {
+		return err
+	}
+
+	err = mgr.migrations.BulkInsert(ctx, incomplete)
 	if err != nil {
 		return fmt.Errorf("failed to bulk insert managers: %w", err)
 	}
+
+	for _, proto := range incomplete {
+		mgr.events.Publish(ctx, &api.Event{
+			Type:        api.EventMigrationIngested,
+			Namespace:   proto.Namespace,
+			MigrationID: proto.Signature,
+			Status:      proto.InitialStatus(),
+		})
+	}
 	return nil
 }
 
-func (mgr *migrationManager) GetMigrationsForNamespace(namespace string) ([]*api.Migration, error) {
-	migrations, err := mgr.migrations.GetAllForNamespace(namespace)
+// ListMigrations returns a page of the namespace's migrations matching opts, along with the cursor of the next page
+func (mgr *migrationManager) ListMigrations(ctx context.Context, namespace string, opts api.MigrationListOptions) (*api.MigrationList, error) {
+	query, err := listQuery(namespace, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// One migration past the page tells whether there's a next page
+	limit := query.Limit
+	query.Limit++
+	migrations, err := mgr.migrations.ListForNamespace(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch migrations for namespace %s : %w", namespace, err)
 	}
 
-	return migrations, nil
+	list := &api.MigrationList{Migrations: migrations}
+	if len(migrations) > limit {
+		list.Migrations = migrations[:limit]
+		last := list.Migrations[limit-1]
+		list.NextCursor = encodeCursor(repository.MigrationCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+
+	return list, nil
+}
+
+// listQuery validates list options and converts them to a repository query
+func listQuery(namespace string, opts api.MigrationListOptions) (repository.MigrationQuery, error) {
+	query := repository.MigrationQuery{
+		Namespace:   namespace,
+		Statuses:    opts.Statuses,
+		User:        opts.User,
+		Repo:        opts.Repo,
+		Search:      opts.Search,
+		CreatedFrom: opts.CreatedFrom,
+		CreatedTo:   opts.CreatedTo,
+		Descending:  opts.Descending,
+		Limit:       opts.Limit,
+	}
+
+	for _, status := range opts.Statuses {
+		if !slices.Contains(api.MigrationStatuses, status) {
+			return query, fmt.Errorf("%w: unknown status %q", ErrInvalidListOptions, status)
+		}
+	}
+
+	if !opts.CreatedFrom.IsZero() && !opts.CreatedTo.IsZero() && !opts.CreatedFrom.Before(opts.CreatedTo) {
+		return query, fmt.Errorf("%w: the end of the creation range must be after its start", ErrInvalidListOptions)
+	}
+
+	if query.Limit == 0 {
+		query.Limit = defaultMigrationPageSize
+	}
+	if query.Limit < 0 || query.Limit > maxMigrationPageSize {
+		return query, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidListOptions, maxMigrationPageSize)
+	}
+
+	if opts.Cursor != "" {
+		cursor, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return query, fmt.Errorf("%w: %w", ErrInvalidListOptions, err)
+		}
+		query.After = &cursor
+	}
+
+	return query, nil
+}
+
+// encodeCursor makes a listing position opaque to clients, who only pass it back
+func encodeCursor(cursor repository.MigrationCursor) string {
+	raw := strconv.FormatInt(cursor.CreatedAt.UnixNano(), 10) + ":" + strconv.FormatUint(cursor.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
 }
 
-func (mgr *migrationManager) GetMigrationById(id uint64) (*api.Migration, error) {
-	migration, err := mgr.migrations.GetById(id)
+func decodeCursor(encoded string) (repository.MigrationCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return repository.MigrationCursor{}, errors.New("malformed cursor")
+	}
+
+	createdAt, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return repository.MigrationCursor{}, errors.New("malformed cursor")
+	}
+
+	nanos, err := strconv.ParseInt(createdAt, 10, 64)
+	if err != nil {
+		return repository.MigrationCursor{}, errors.New("malformed cursor")
+	}
+	cursor := repository.MigrationCursor{CreatedAt: time.Unix(0, nanos)}
+	if cursor.ID, err = strconv.ParseUint(id, 10, 64); err != nil {
+		return repository.MigrationCursor{}, errors.New("malformed cursor")
+	}
+
+	return cursor, nil
+}
+
+// GetMigrationById returns the migration with the id, which must belong to the namespace
+func (mgr *migrationManager) GetMigrationById(ctx context.Context, namespace string, id uint64) (*api.Migration, error) {
+	migration, err := mgr.migrations.GetById(ctx, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch migration id %d: %w", id, err)
 	}
 
+	if migration == nil || migration.Namespace != namespace {
+		return nil, fmt.Errorf("%w: id %d in namespace %s", ErrMigrationNotFound, id, namespace)
+	}
+
 	return migration, nil
 }
 
-func (mgr *migrationManager) filterCompleted(pending []api.MigrationProto) ([]*api.MigrationProto, error) {
+// GetMigrationHistory returns the status changes of the migration with the id, which must belong to the namespace
+func (mgr *migrationManager) GetMigrationHistory(ctx context.Context, namespace string, id uint64) (*api.MigrationHistory, error) {
+	if _, err := mgr.GetMigrationById(ctx, namespace, id); err != nil {
+		return nil, err
+	}
+
+	changes, err := mgr.migrations.GetStatusHistory(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch status history of migration id %d: %w", id, err)
+	}
+
+	return &api.MigrationHistory{MigrationID: id, Changes: changes}, nil
+}
+
+// ListExecutionAttempts returns the execution attempts of the migration with the id, which must belong to the
+// namespace
+func (mgr *migrationManager) ListExecutionAttempts(ctx context.Context, namespace string, id uint64) (*api.ExecutionAttemptList, error) {
+	if _, err := mgr.GetMigrationById(ctx, namespace, id); err != nil {
+		return nil, err
+	}
+
+	attempts, err := mgr.attempts.ListAttempts(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch execution attempts of migration id %d: %w", id, err)
+	}
+
+	return &api.ExecutionAttemptList{MigrationID: id, Attempts: attempts}, nil
+}
+
+// ExecuteMigration runs a pending, failed or rolled back migration against its namespace with the namespace's session
+// settings, overridden by the migration's own. Migrations with error-level lint findings are refused unless
+// opts.OverrideLint is set. Lock timeouts are retried with exponential backoff up to the namespace's retry limit.
+// The migration is running while it executes and ends up succeeded or failed; a failure caused by ctx being
+// cancelled is recorded as an interruption. Every try, retries included, is recorded as an execution attempt and
+// traced as a span of the execution.
+func (mgr *migrationManager) ExecuteMigration(ctx context.Context, namespace string, id uint64, opts ExecuteOptions) (*api.Migration, error) {
+	ctx, span := tracing.Start(ctx, "migration.execute",
+		attribute.String("gomad.namespace", namespace), attribute.String("gomad.migration_id", strconv.FormatUint(id, 10)))
+	migration, err := mgr.executeMigration(ctx, namespace, id, opts)
+	tracing.End(span, err)
+
+	return migration, err
+}
+
+func (mgr *migrationManager) executeMigration(ctx context.Context, namespace string, id uint64, opts ExecuteOptions) (*api.Migration, error) {
+	mgr.executions.Add(1)
+	defer mgr.executions.Done()
+
+	migration, err := mgr.migrations.GetById(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch migration id %d: %w", id, err)
+	}
+
+	if migration == nil || migration.Namespace != namespace {
+		return nil, fmt.Errorf("%w: id %d in namespace %s", ErrMigrationNotFound, id, namespace)
+	}
+
+	if migration.Status == api.MigrationStatusSucceeded {
+		return nil, fmt.Errorf("%w: id %d", ErrMigrationCompleted, id)
+	}
+	if !migration.Status.CanTransitionTo(api.MigrationStatusRunning) {
+		return nil, fmt.Errorf("%w: migration id %d is %s and can't be executed", ErrIllegalTransition, id, migration.Status)
+	}
+
+	nsSettings, err := mgr.settings.GetSettings(ctx, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch settings for namespace %s: %w", namespace, err)
+	}
+	session := migration.Settings.Merge(nsSettings.Session)
+
+	vars, err := mgr.variables.GetVariables(ctx, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch variables for namespace %s: %w", namespace, err)
+	}
+
+	rendered, err := utils.RenderTemplate(migration.DDL, vars)
+	if err != nil {
+		return nil, fmt.Errorf("%w: id %d: %w", ErrUnresolvedVariables, id, err)
+	}
+
+	findings := mgr.linter.Lint(rendered, nsSettings.LintRules, mgr.tableSizeEstimator(ctx, namespace))
+	if api.HasLintErrors(findings) {
+		if !opts.OverrideLint {
+			return nil, fmt.Errorf("%w: %s", ErrLintFailed, describeFindings(findings))
+		}
+		log.Warn().Uint64("id", id).Str("findings", describeFindings(findings)).Msg("executing migration with lint override")
+	}
+
+	// Moving to running only succeeds for one caller, so a migration is never executed twice at once
+	err = mgr.transition(ctx, migration, repository.StatusTransition{
+		To:          api.MigrationStatusRunning,
+		Reason:      "execution started",
+		RenderedDDL: rendered,
+	})
+	if err != nil {
+		return nil, err
+	}
+	mgr.publish(ctx, api.EventMigrationStarted, migration, "")
+
+	// How the execution ended is recorded even if ctx was cancelled in the meantime
+	recordCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), statusRecordTimeout)
+	defer cancel()
+
+	var attempt *api.ExecutionAttempt
+	started := time.Now()
+	backoff := mgr.lockRetryBackoff
+	for try := 0; ; try++ {
+		attemptCtx, span := tracing.Start(ctx, "migration.attempt", attribute.Int("gomad.attempt", try+1))
+		attempt = &api.ExecutionAttempt{MigrationID: id, StartedAt: time.Now(), Host: mgr.host, SQL: rendered}
+		attempt.Observe(&attemptEvents{ctx: attemptCtx, bus: mgr.events, namespace: namespace, migrationID: id})
+		err = mgr.targets.ExecuteMigration(attemptCtx, namespace, rendered, session, attempt)
+		tracing.End(span, err)
+		mgr.recordAttempt(recordCtx, attempt, err)
+		if errors.Is(err, repository.ErrLockTimeout) {
+			metrics.LockTimeouts.WithLabelValues(namespace).Inc()
+			if waited, ok := lockWait(attempt); ok {
+				metrics.LockWait.WithLabelValues(namespace).Observe(waited.Seconds())
+			}
+		}
+		if err == nil || !errors.Is(err, repository.ErrLockTimeout) || try >= nsSettings.LockRetries {
+			break
+		}
+
+		log.Warn().Err(err).Uint64("id", id).Int("attempt", try+1).Msg("migration hit lock timeout, retrying")
+		if err = sleep(ctx, backoff); err != nil {
+			break
+		}
+		backoff *= 2
+	}
+
+	if err != nil {
+		metrics.ExecutionDuration.WithLabelValues(namespace, "failed").Observe(time.Since(started).Seconds())
+		reason := "execution failed: " + err.Error()
+		if ctx.Err() != nil {
+			reason = "execution interrupted: " + err.Error()
+			log.Warn().Uint64("id", id).Msg("migration execution was interrupted")
+		}
+		if recordErr := mgr.transition(recordCtx, migration, repository.StatusTransition{To: api.MigrationStatusFailed, Reason: reason}); recordErr != nil {
+			log.Error().Err(recordErr).Uint64("id", id).Msg("failed to mark migration failed")
+		}
+		failed := mgr.event(api.EventMigrationFailed, migration, reason)
+		failed.Status = api.MigrationStatusFailed
+		failed.Error = attempt.Error
+		mgr.events.Publish(ctx, failed)
+		return nil, fmt.Errorf("failed to execute migration id %d: %w", id, err)
+	}
+
+	metrics.ExecutionDuration.WithLabelValues(namespace, "succeeded").Observe(time.Since(started).Seconds())
+
+	err = mgr.transition(recordCtx, migration, repository.StatusTransition{To: api.MigrationStatusSucceeded, Reason: "execution succeeded"})
+	if err != nil {
+		return nil, fmt.Errorf("migration id %d executed but could not be marked succeeded: %w", id, err)
+	}
+	mgr.publish(ctx, api.EventMigrationSucceeded, migration, "")
+
+	return migration, nil
+}
+
+// event describes something that happened to the migration, in the status it's in now
+func (mgr *migrationManager) event(eventType api.EventType, migration *api.Migration, reason string) *api.Event {
+	return &api.Event{
+		Type:        eventType,
+		Namespace:   migration.Namespace,
+		MigrationID: migration.ID,
+		Status:      migration.Status,
+		Reason:      reason,
+	}
+}
+
+func (mgr *migrationManager) publish(ctx context.Context, eventType api.EventType, migration *api.Migration, reason string) {
+	mgr.events.Publish(ctx, mgr.event(eventType, migration, reason))
+}
+
+// attemptEvents publishes the statements and notices of an execution attempt as they happen. Each statement is also
+// traced as a span of the attempt in ctx, timed as the driver reported.
+type attemptEvents struct {
+	ctx         context.Context
+	bus         *events.Bus
+	namespace   string
+	migrationID uint64
+}
+
+func (e *attemptEvents) StatementFinished(statement *api.StatementResult) {
+	end := time.Now()
+	start := end.Add(-time.Duration(statement.DurationMs * float64(time.Millisecond)))
+	tracing.Record(e.ctx, "migration.statement", start, end, statement.Failed,
+		attribute.String("db.statement", statement.SQL), attribute.Int64("db.rows_affected", statement.RowsAffected))
+
+	e.bus.Publish(e.ctx, &api.Event{
+		Type:        api.EventStatementFinished,
+		Namespace:   e.namespace,
+		MigrationID: e.migrationID,
+		Statement:   statement,
+	})
+}
+
+func (e *attemptEvents) MessageRaised(message *api.ExecutionMessage) {
+	e.bus.Publish(e.ctx, &api.Event{
+		Type:        api.EventNotice,
+		Namespace:   e.namespace,
+		MigrationID: e.migrationID,
+		Message:     message,
+	})
+}
+
+// recordAttempt stores a finished execution attempt. Failing to store it doesn't fail the execution.
+func (mgr *migrationManager) recordAttempt(ctx context.Context, attempt *api.ExecutionAttempt, err error) {
+	attempt.Observe(nil)
+	attempt.FinishedAt = time.Now()
+	// Errors that didn't come from a statement, like failing to connect, still belong in the record
+	if err != nil && attempt.Error == nil {
+		attempt.Error = &api.ExecutionError{Message: err.Error()}
+	}
+
+	if recordErr := mgr.attempts.RecordAttempt(ctx, attempt); recordErr != nil {
+		log.Error().Err(recordErr).Uint64("id", attempt.MigrationID).Msg("failed to record execution attempt")
+	}
+}
+
+// transition moves the migration from its current status to transition.To, updating it to match. Illegal
+// transitions, and ones that lose a race with another change, fail with ErrIllegalTransition.
+func (mgr *migrationManager) transition(ctx context.Context, migration *api.Migration, transition repository.StatusTransition) error {
+	if !migration.Status.CanTransitionTo(transition.To) {
+		return fmt.Errorf("%w: migration id %d can't go from %s to %s", ErrIllegalTransition, migration.ID, migration.Status, transition.To)
+	}
+
+	transition.ID = migration.ID
+	transition.From = migration.Status
+	transition.At = time.Now()
+	err := mgr.migrations.TransitionStatus(ctx, transition)
+	if errors.Is(err, repository.ErrStatusConflict) {
+		return fmt.Errorf("%w: %w", ErrIllegalTransition, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to move migration id %d to %s: %w", migration.ID, transition.To, err)
+	}
+
+	migration.Status = transition.To
+	if transition.To == api.MigrationStatusSucceeded {
+		migration.CompletedAt = transition.At
+	}
+	if transition.RenderedDDL != "" {
+		migration.RenderedDDL = transition.RenderedDDL
+	}
+	return nil
+}
+
+// LintMigrations lints each migration using the rule severities configured for its namespace. Templates are
+// rendered first when the namespace defines all of their variables.
+func (mgr *migrationManager) LintMigrations(ctx context.Context, migrations []api.MigrationProto) (*api.LintResponse, error) {
+	response := &api.LintResponse{Results: make([]api.MigrationLintResult, 0, len(migrations))}
+	for _, migration := range migrations {
+		nsSettings, err := mgr.settings.GetSettings(ctx, migration.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch settings for namespace %s: %w", migration.Namespace, err)
+		}
+
+		vars, err := mgr.variables.GetVariables(ctx, migration.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch variables for namespace %s: %w", migration.Namespace, err)
+		}
+
+		ddl := migration.DDL
+		if rendered, err := utils.RenderTemplate(ddl, vars); err == nil {
+			ddl = rendered
+		}
+
+		findings := mgr.linter.Lint(ddl, nsSettings.LintRules, mgr.tableSizeEstimator(ctx, migration.Namespace))
+		response.HasErrors = response.HasErrors || api.HasLintErrors(findings)
+		response.Results = append(response.Results, api.MigrationLintResult{
+			Namespace: migration.Namespace,
+			Comment:   migration.Comment,
+			Signature: migration.Signature,
+			Findings:  findings,
+		})
+	}
+
+	return response, nil
+}
+
+// lockWait returns how long the statement that failed an attempt ran. When the attempt hit a lock timeout, that is
+// the time spent waiting for the lock; earlier statements in the attempt held their locks rather than waiting.
+func lockWait(attempt *api.ExecutionAttempt) (time.Duration, bool) {
+	for i := len(attempt.Statements) - 1; i >= 0; i-- {
+		if attempt.Statements[i].Failed {
+			return time.Duration(attempt.Statements[i].DurationMs * float64(time.Millisecond)), true
+		}
+	}
+	return 0, false
+}
+
+// tableSizeEstimator looks up table sizes in the namespace's database. Lookup failures are logged and reported as
+// unknown so the linter errs on the side of caution.
+func (mgr *migrationManager) tableSizeEstimator(ctx context.Context, namespace string) utils.TableSizeEstimator {
+	return func(table string) (int64, bool) {
+		rows, estimate, err := mgr.targets.EstimateTableRows(ctx, namespace, table)
+		if err != nil {
+			log.Warn().Err(err).Str("namespace", namespace).Str("table", table).Msg("failed to estimate table size")
+			return 0, false
+		}
+		switch estimate {
+		case repository.TableMissing:
+			// The table is created by an earlier statement in the same migration, so it starts empty
+			return 0, true
+		case repository.TableSizeKnown:
+			return rows, true
+		default:
+			return 0, false
+		}
+	}
+}
+
+// targetNamespaces applies environment targeting. Migrations for every namespace (*) are copied to each namespace
+// tagged with one of their environments; migrations for a single namespace are dropped unless the namespace is in
+// one of their environments. Migrations without environments are returned unchanged.
+func (mgr *migrationManager) targetNamespaces(ctx context.Context, pending []api.MigrationProto) ([]api.MigrationProto, error) {
+	environments := make(map[string]string)
+	environmentOf := func(namespace string) (string, error) {
+		if env, ok := environments[namespace]; ok {
+			return env, nil
+		}
+		settings, err := mgr.settings.GetSettings(ctx, namespace)
+		if err != nil {
+			return "", err
+		}
+		environments[namespace] = settings.Environment
+		return settings.Environment, nil
+	}
+
+	out := make([]api.MigrationProto, 0, len(pending))
+	for _, migration := range pending {
+		if len(migration.Environments) == 0 {
+			out = append(out, migration)
+			continue
+		}
+
+		if migration.Namespace != api.AllNamespaces {
+			env, err := environmentOf(migration.Namespace)
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch environment of namespace %s: %w", migration.Namespace, err)
+			}
+			if slices.Contains(migration.Environments, env) {
+				out = append(out, migration)
+			} else {
+				log.Info().Str("namespace", migration.Namespace).Str("comment", migration.Comment).
+					Msg("skipping migration not targeted at namespace's environment")
+			}
+			continue
+		}
+
+		namespaces, err := mgr.settings.ListNamespacesByEnvironment(ctx, migration.Environments)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list namespaces for environments %v: %w", migration.Environments, err)
+		}
+		for _, namespace := range namespaces {
+			targeted := migration
+			targeted.Namespace = namespace
+			targeted.Signature = utils.NamespaceSignature(migration.Signature, namespace)
+			out = append(out, targeted)
+		}
+	}
+
+	return out, nil
+}
+
+// checkTemplateVariables makes sure every migration that will run can have its template rendered
+func (mgr *migrationManager) checkTemplateVariables(ctx context.Context, migrations []*api.MigrationProto) error {
+	varsByNamespace := make(map[string]map[string]string)
+	for _, migration := range migrations {
+		if migration.ShouldSkip {
+			continue
+		}
+
+		vars, ok := varsByNamespace[migration.Namespace]
+		if !ok {
+			var err error
+			vars, err = mgr.variables.GetVariables(ctx, migration.Namespace)
+			if err != nil {
+				return fmt.Errorf("failed to fetch variables for namespace %s: %w", migration.Namespace, err)
+			}
+			varsByNamespace[migration.Namespace] = vars
+		}
+
+		if err := utils.CheckTemplateVariables(migration.DDL, vars); err != nil {
+			return fmt.Errorf("%w: migration %q in namespace %s: %w", ErrUnresolvedVariables, migration.Comment, migration.Namespace, err)
+		}
+	}
+
+	return nil
+}
+
+// sleep waits for d, returning early with ctx's error if ctx is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func describeFindings(findings []api.LintFinding) string {
+	descriptions := make([]string, 0, len(findings))
+	for _, finding := range findings {
+		descriptions = append(descriptions, fmt.Sprintf("%s (%s): %s", finding.Rule, finding.Severity, finding.Message))
+	}
+	return strings.Join(descriptions, "; ")
+}
+
+func (mgr *migrationManager) filterCompleted(ctx context.Context, pending []api.MigrationProto) ([]*api.MigrationProto, error) {
 	sigMap := make(map[uint64]*api.MigrationProto)
 	signatures := make([]uint64, 0, len(pending))
 	for idx := range pending {
@@ -81,7 +705,7 @@ func (mgr *migrationManager) filterCompleted(pending []api.MigrationProto) ([]*a
 		signatures = append(signatures, pending[idx].Signature)
 	}
 
-	existing, err := mgr.migrations.GetFilteredBySignature(signatures)
+	existing, err := mgr.migrations.GetFilteredBySignature(ctx, signatures)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch migrations: %w", err)
 	}
@@ -0,0 +1,904 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+	"github.com/dfryer1193/gomad/internal/data/repository/memory"
+	"github.com/dfryer1193/gomad/internal/events"
+	"github.com/dfryer1193/gomad/internal/utils"
+)
+
+type fakeMigrationRepository struct {
+	migrations map[uint64]*api.Migration
+	inserted   []*api.MigrationProto
+	// transitions records the status changes made, in order
+	transitions []repository.StatusTransition
+}
+
+func (r *fakeMigrationRepository) GetFilteredBySignature(_ context.Context, _ []uint64) ([]*api.Migration, error) {
+	return nil, nil
+}
+
+func (r *fakeMigrationRepository) ListForNamespace(_ context.Context, _ repository.MigrationQuery) ([]*api.Migration, error) {
+	return nil, nil
+}
+
+func (r *fakeMigrationRepository) GetById(_ context.Context, id uint64) (*api.Migration, error) {
+	return r.migrations[id], nil
+}
+
+func (r *fakeMigrationRepository) BulkInsert(_ context.Context, migrations []*api.MigrationProto) error {
+	r.inserted = append(r.inserted, migrations...)
+	return nil
+}
+
+func (r *fakeMigrationRepository) TransitionStatus(_ context.Context, transition repository.StatusTransition) error {
+	m := r.migrations[transition.ID]
+	if m.Status != transition.From {
+		return fmt.Errorf("%w: migration is %s", repository.ErrStatusConflict, m.Status)
+	}
+	m.Status = transition.To
+	r.transitions = append(r.transitions, transition)
+	return nil
+}
+
+func (r *fakeMigrationRepository) GetStatusHistory(_ context.Context, _ uint64) ([]*api.MigrationStatusChange, error) {
+	return nil, nil
+}
+
+func (r *fakeMigrationRepository) CountByStatus(_ context.Context) ([]*repository.MigrationStatusCount, error) {
+	return nil, nil
+}
+
+func (r *fakeMigrationRepository) Close() {}
+
+type fakeSettingsRepository struct {
+	settings api.NamespaceSettings
+	// environments maps namespaces to their environment tags
+	environments map[string]string
+}
+
+func (r *fakeSettingsRepository) GetSettings(_ context.Context, namespace string) (*api.NamespaceSettings, error) {
+	settings := r.settings
+	settings.Namespace = namespace
+	settings.Environment = r.environments[namespace]
+	return &settings, nil
+}
+
+func (r *fakeSettingsRepository) ListNamespacesByEnvironment(_ context.Context, environments []string) ([]string, error) {
+	namespaces := make([]string, 0)
+	for namespace, env := range r.environments {
+		if slices.Contains(environments, env) {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	slices.Sort(namespaces)
+	return namespaces, nil
+}
+
+func (r *fakeSettingsRepository) UpsertSettings(_ context.Context, _ *api.NamespaceSettings) error {
+	return nil
+}
+
+func (r *fakeSettingsRepository) Close() {}
+
+type fakeVariablesRepository struct {
+	vars map[string]string
+}
+
+func (r *fakeVariablesRepository) GetVariables(_ context.Context, _ string) (map[string]string, error) {
+	return r.vars, nil
+}
+
+func (r *fakeVariablesRepository) SetVariable(_ context.Context, _ string, _ string, _ string) error {
+	return nil
+}
+
+func (r *fakeVariablesRepository) DeleteVariable(_ context.Context, _ string, _ string) (bool, error) {
+	return false, nil
+}
+
+func (r *fakeVariablesRepository) Close() {}
+
+// fakeTargetRepository fails the first failures executions with err
+type fakeTargetRepository struct {
+	failures int
+	err      error
+	calls    int
+	settings []api.SessionSettings
+	ddl      []string
+	// rows and estimate are returned for every table
+	rows     int64
+	estimate repository.TableEstimate
+}
+
+func (r *fakeTargetRepository) ExecuteMigration(_ context.Context, _ string, ddl string, settings api.SessionSettings, attempt *api.ExecutionAttempt) error {
+	r.calls++
+	r.settings = append(r.settings, settings)
+	r.ddl = append(r.ddl, ddl)
+	attempt.Identity = "gomad"
+	attempt.AddStatement(ddl, time.Millisecond, 0, r.calls <= r.failures)
+	if r.calls <= r.failures {
+		return r.err
+	}
+	return nil
+}
+
+func (r *fakeTargetRepository) EstimateTableRows(_ context.Context, _ string, _ string) (int64, repository.TableEstimate, error) {
+	return r.rows, r.estimate, nil
+}
+
+func (r *fakeTargetRepository) CreateDatabase(_ context.Context, _ string) (bool, error) {
+	return false, nil
+}
+
+func (r *fakeTargetRepository) Close() {}
+
+func TestExecuteMigration(t *testing.T) {
+	lockErr := fmt.Errorf("%w: canceling statement due to lock timeout", repository.ErrLockTimeout)
+
+	testCases := []struct {
+		name        string
+		migration   *api.Migration
+		namespace   string
+		settings    api.NamespaceSettings
+		vars        map[string]string
+		target      *fakeTargetRepository
+		wantErr     error
+		wantAnyErr  bool
+		wantCalls   int
+		wantSession api.SessionSettings
+		opts        ExecuteOptions
+		wantDDL     string
+		// wantStatus is the migration's status afterwards
+		wantStatus api.MigrationStatus
+	}{
+		{
+			name:      "missing migration",
+			namespace: "ns1",
+			target:    &fakeTargetRepository{},
+			wantErr:   ErrMigrationNotFound,
+		},
+		{
+			name: "migration in another namespace",
+			migration: &api.Migration{
+				MigrationCommonFields: api.MigrationCommonFields{Namespace: "ns2"},
+			},
+			namespace: "ns1",
+			target:    &fakeTargetRepository{},
+			wantErr:   ErrMigrationNotFound,
+		},
+		{
+			name: "already completed",
+			migration: &api.Migration{
+				MigrationCommonFields: api.MigrationCommonFields{Namespace: "ns1"},
+				CompletedAt:           time.Now(),
+				Status:                api.MigrationStatusSucceeded,
+			},
+			namespace:  "ns1",
+			target:     &fakeTargetRepository{},
+			wantErr:    ErrMigrationCompleted,
+			wantStatus: api.MigrationStatusSucceeded,
+		},
+		{
+			name: "awaiting approval",
+			migration: &api.Migration{
+				MigrationCommonFields: api.MigrationCommonFields{Namespace: "ns1"},
+				Status:                api.MigrationStatusAwaitingApproval,
+			},
+			namespace:  "ns1",
+			target:     &fakeTargetRepository{},
+			wantErr:    ErrIllegalTransition,
+			wantStatus: api.MigrationStatusAwaitingApproval,
+		},
+		{
+			name: "skipped",
+			migration: &api.Migration{
+				MigrationCommonFields: api.MigrationCommonFields{Namespace: "ns1"},
+				Status:                api.MigrationStatusSkipped,
+			},
+			namespace:  "ns1",
+			target:     &fakeTargetRepository{},
+			wantErr:    ErrIllegalTransition,
+			wantStatus: api.MigrationStatusSkipped,
+		},
+		{
+			name: "retries a failed migration",
+			migration: &api.Migration{
+				MigrationCommonFields: api.MigrationCommonFields{Namespace: "ns1"},
+				Status:                api.MigrationStatusFailed,
+			},
+			namespace:  "ns1",
+			target:     &fakeTargetRepository{},
+			wantCalls:  1,
+			wantStatus: api.MigrationStatusSucceeded,
+		},
+		{
+			name: "merges namespace defaults under migration overrides",
+			migration: &api.Migration{
+				MigrationCommonFields: api.MigrationCommonFields{
+					Namespace: "ns1",
+					Settings:  api.SessionSettings{LockTimeout: "1s"},
+				},
+			},
+			namespace: "ns1",
+			settings: api.NamespaceSettings{
+				Session: api.SessionSettings{LockTimeout: "5s", StatementTimeout: "1m"},
+			},
+			target:      &fakeTargetRepository{},
+			wantCalls:   1,
+			wantSession: api.SessionSettings{LockTimeout: "1s", StatementTimeout: "1m"},
+			wantStatus:  api.MigrationStatusSucceeded,
+		},
+		{
+			name: "retries lock timeouts",
+			migration: &api.Migration{
+				MigrationCommonFields: api.MigrationCommonFields{Namespace: "ns1"},
+			},
+			namespace:  "ns1",
+			settings:   api.NamespaceSettings{LockRetries: 3},
+			target:     &fakeTargetRepository{failures: 2, err: lockErr},
+			wantCalls:  3,
+			wantStatus: api.MigrationStatusSucceeded,
+		},
+		{
+			name: "gives up after retry limit",
+			migration: &api.Migration{
+				MigrationCommonFields: api.MigrationCommonFields{Namespace: "ns1"},
+			},
+			namespace:  "ns1",
+			settings:   api.NamespaceSettings{LockRetries: 2},
+			target:     &fakeTargetRepository{failures: 5, err: lockErr},
+			wantErr:    repository.ErrLockTimeout,
+			wantCalls:  3,
+			wantStatus: api.MigrationStatusFailed,
+		},
+		{
+			name: "refuses lint errors",
+			migration: &api.Migration{
+				MigrationCommonFields: api.MigrationCommonFields{Namespace: "ns1", DDL: "DROP TABLE users;"},
+			},
+			namespace:  "ns1",
+			target:     &fakeTargetRepository{},
+			wantErr:    ErrLintFailed,
+			wantStatus: api.MigrationStatusPending,
+		},
+		{
+			name: "lint errors overridden",
+			migration: &api.Migration{
+				MigrationCommonFields: api.MigrationCommonFields{Namespace: "ns1", DDL: "DROP TABLE users;"},
+			},
+			namespace:  "ns1",
+			target:     &fakeTargetRepository{},
+			opts:       ExecuteOptions{OverrideLint: true},
+			wantCalls:  1,
+			wantStatus: api.MigrationStatusSucceeded,
+		},
+		{
+			name: "lint rule downgraded for namespace",
+			migration: &api.Migration{
+				MigrationCommonFields: api.MigrationCommonFields{Namespace: "ns1", DDL: "DROP TABLE users;"},
+			},
+			namespace: "ns1",
+			settings: api.NamespaceSettings{
+				LintRules: map[string]api.LintSeverity{"drop-table": api.LintSeverityWarning},
+			},
+			target:     &fakeTargetRepository{},
+			wantCalls:  1,
+			wantStatus: api.MigrationStatusSucceeded,
+		},
+		{
+			name: "renders template variables",
+			migration: &api.Migration{
+				MigrationCommonFields: api.MigrationCommonFields{
+					Namespace: "ns1",
+					DDL:       "GRANT SELECT ON users TO {{ role }};",
+				},
+			},
+			namespace:  "ns1",
+			vars:       map[string]string{"role": "reader"},
+			target:     &fakeTargetRepository{},
+			wantCalls:  1,
+			wantDDL:    "GRANT SELECT ON users TO reader;",
+			wantStatus: api.MigrationStatusSucceeded,
+		},
+		{
+			name: "unresolved template variables",
+			migration: &api.Migration{
+				MigrationCommonFields: api.MigrationCommonFields{
+					Namespace: "ns1",
+					DDL:       "GRANT SELECT ON users TO {{ role }};",
+				},
+			},
+			namespace: "ns1",
+			target:    &fakeTargetRepository{},
+			wantErr:   ErrUnresolvedVariables,
+		},
+		{
+			name: "does not retry other errors",
+			migration: &api.Migration{
+				MigrationCommonFields: api.MigrationCommonFields{Namespace: "ns1"},
+			},
+			namespace:  "ns1",
+			settings:   api.NamespaceSettings{LockRetries: 3},
+			target:     &fakeTargetRepository{failures: 1, err: errors.New("syntax error")},
+			wantAnyErr: true,
+			wantCalls:  1,
+			wantStatus: api.MigrationStatusFailed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			migrations := &fakeMigrationRepository{migrations: map[uint64]*api.Migration{}}
+			if tc.migration != nil {
+				// Migrations are pending unless the case says otherwise
+				if tc.migration.Status == "" {
+					tc.migration.Status = api.MigrationStatusPending
+				}
+				tc.migration.ID = 1
+				migrations.migrations[1] = tc.migration
+			}
+			attempts := memory.NewExecutionAttemptRepository()
+			bus := events.NewBus()
+			stream := bus.Subscribe("")
+			defer stream.Close()
+			mgr := &migrationManager{
+				migrations: migrations,
+				attempts:   attempts,
+				events:     bus,
+				settings:   &fakeSettingsRepository{settings: tc.settings},
+				variables:  &fakeVariablesRepository{vars: tc.vars},
+				targets:    tc.target,
+				linter:     utils.GetMigrationLinter(),
+				host:       "gomad-1",
+			}
+
+			_, err := mgr.ExecuteMigration(context.Background(), tc.namespace, 1, tc.opts)
+			if tc.wantErr != nil && !errors.Is(err, tc.wantErr) {
+				t.Errorf("Expected error %v, got %v", tc.wantErr, err)
+			}
+			if tc.wantAnyErr && err == nil {
+				t.Errorf("Expected error, got none")
+			}
+			if tc.wantErr == nil && !tc.wantAnyErr && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+
+			if tc.target.calls != tc.wantCalls {
+				t.Errorf("Expected %d executions, got %d", tc.wantCalls, tc.target.calls)
+			}
+			if tc.wantCalls > 0 && tc.target.settings[0] != tc.wantSession && tc.wantSession != (api.SessionSettings{}) {
+				t.Errorf("Expected session settings %+v, got %+v", tc.wantSession, tc.target.settings[0])
+			}
+
+			if tc.wantDDL != "" {
+				if tc.target.ddl[0] != tc.wantDDL {
+					t.Errorf("Expected DDL %q, got %q", tc.wantDDL, tc.target.ddl[0])
+				}
+				if migrations.transitions[0].RenderedDDL != tc.wantDDL {
+					t.Errorf("Expected rendered DDL %q to be recorded, got %q", tc.wantDDL, migrations.transitions[0].RenderedDDL)
+				}
+			}
+
+			if tc.wantStatus != "" && tc.migration.Status != tc.wantStatus {
+				t.Errorf("Expected status %s, got %s", tc.wantStatus, tc.migration.Status)
+			}
+			// Every execution goes through running
+			if tc.wantCalls > 0 && migrations.transitions[0].To != api.MigrationStatusRunning {
+				t.Errorf("Expected the migration to be marked running first, got %+v", migrations.transitions)
+			}
+
+			// Every try is recorded, and only the last can have succeeded
+			recorded, _ := attempts.ListAttempts(context.Background(), 1)
+			if len(recorded) != tc.wantCalls {
+				t.Fatalf("Expected %d execution attempts, got %d", tc.wantCalls, len(recorded))
+			}
+			for idx, attempt := range recorded {
+				failed := idx < len(recorded)-1 || err != nil
+				if attempt.Number != idx+1 || attempt.Host != "gomad-1" || attempt.Identity != "gomad" || len(attempt.Statements) != 1 {
+					t.Errorf("Unexpected attempt %+v", attempt)
+				}
+				if (attempt.Error != nil) != failed || attempt.FinishedAt.Before(attempt.StartedAt) {
+					t.Errorf("Expected attempt %d failed = %v, got %+v", attempt.Number, failed, attempt.Error)
+				}
+			}
+
+			// Executions end with an event saying how they went
+			var last *api.Event
+			for len(stream.Events()) > 0 {
+				last = <-stream.Events()
+			}
+			if tc.wantCalls > 0 {
+				if err != nil && (last.Type != api.EventMigrationFailed || last.Error == nil) {
+					t.Errorf("Expected a failure event, got %+v", last)
+				}
+				if err == nil && last.Type != api.EventMigrationSucceeded {
+					t.Errorf("Expected a success event, got %+v", last)
+				}
+			}
+		})
+	}
+}
+
+// signallingTargetRepository closes started on its first execution. Blocking executions run until ctx is cancelled;
+// the others are passed on to the embedded fake.
+type signallingTargetRepository struct {
+	fakeTargetRepository
+	started chan struct{}
+	block   bool
+	once    sync.Once
+}
+
+func (r *signallingTargetRepository) ExecuteMigration(ctx context.Context, namespace string, ddl string, settings api.SessionSettings, attempt *api.ExecutionAttempt) error {
+	r.once.Do(func() { close(r.started) })
+	if r.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return r.fakeTargetRepository.ExecuteMigration(ctx, namespace, ddl, settings, attempt)
+}
+
+func TestLockWait(t *testing.T) {
+	attempt := &api.ExecutionAttempt{}
+	if _, ok := lockWait(attempt); ok {
+		t.Errorf("expected no lock wait for an attempt without statements")
+	}
+
+	attempt.AddStatement("SET lock_timeout = '5s'", time.Millisecond, 0, false)
+	attempt.AddStatement("UPDATE invoices SET paid = true", 30*time.Second, 0, false)
+	attempt.AddStatement("ALTER TABLE invoices ADD COLUMN note text", 5*time.Second, 0, true)
+
+	// Only the statement that timed out was waiting for a lock
+	waited, ok := lockWait(attempt)
+	if !ok || waited != 5*time.Second {
+		t.Errorf("lockWait() = %v, %v, expected 5s", waited, ok)
+	}
+}
+
+func TestTableSizeEstimator(t *testing.T) {
+	testCases := []struct {
+		name      string
+		target    *fakeTargetRepository
+		wantRows  int64
+		wantKnown bool
+	}{
+		{name: "missing table starts empty", target: &fakeTargetRepository{estimate: repository.TableMissing}, wantKnown: true},
+		{name: "known size", target: &fakeTargetRepository{rows: 5_000_000, estimate: repository.TableSizeKnown}, wantRows: 5_000_000, wantKnown: true},
+		{name: "no statistics", target: &fakeTargetRepository{estimate: repository.TableSizeUnknown}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mgr := &migrationManager{targets: tc.target}
+
+			rows, known := mgr.tableSizeEstimator(context.Background(), "billing")("invoices")
+			if rows != tc.wantRows || known != tc.wantKnown {
+				t.Errorf("estimate = %d, %v, expected %d, %v", rows, known, tc.wantRows, tc.wantKnown)
+			}
+		})
+	}
+}
+
+func TestExecuteMigrationCancellation(t *testing.T) {
+	lockErr := fmt.Errorf("%w: canceling statement due to lock timeout", repository.ErrLockTimeout)
+
+	testCases := []struct {
+		name   string
+		target *signallingTargetRepository
+	}{
+		{
+			name:   "while executing",
+			target: &signallingTargetRepository{block: true},
+		},
+		{
+			name:   "while waiting to retry a lock timeout",
+			target: &signallingTargetRepository{fakeTargetRepository: fakeTargetRepository{failures: 10, err: lockErr}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			migrations := &fakeMigrationRepository{
+				migrations: map[uint64]*api.Migration{
+					1: {MigrationCommonFields: api.MigrationCommonFields{Namespace: "ns1"}, ID: 1, Status: api.MigrationStatusPending},
+				},
+			}
+			tc.target.started = make(chan struct{})
+			mgr := &migrationManager{
+				migrations:       migrations,
+				attempts:         memory.NewExecutionAttemptRepository(),
+				events:           events.NewBus(),
+				settings:         &fakeSettingsRepository{settings: api.NamespaceSettings{LockRetries: 3}},
+				variables:        &fakeVariablesRepository{},
+				targets:          tc.target,
+				linter:           utils.GetMigrationLinter(),
+				lockRetryBackoff: time.Hour,
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			errs := make(chan error, 1)
+			go func() {
+				_, err := mgr.ExecuteMigration(ctx, "ns1", 1, ExecuteOptions{})
+				errs <- err
+			}()
+
+			<-tc.target.started
+			cancel()
+
+			waitCtx, cancelWait := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancelWait()
+			if err := mgr.Wait(waitCtx); err != nil {
+				t.Fatalf("Expected execution to return after cancellation, got %v", err)
+			}
+
+			if err := <-errs; !errors.Is(err, context.Canceled) {
+				t.Errorf("Expected context.Canceled, got %v", err)
+			}
+			last := migrations.transitions[len(migrations.transitions)-1]
+			if last.To != api.MigrationStatusFailed || !strings.HasPrefix(last.Reason, "execution interrupted") {
+				t.Errorf("Expected migration to be marked failed by an interruption, got %+v", last)
+			}
+		})
+	}
+}
+
+func TestProcessMigrationsChecksTemplateVariables(t *testing.T) {
+	testCases := []struct {
+		name    string
+		proto   api.MigrationProto
+		vars    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "resolved",
+			proto: api.MigrationProto{
+				MigrationCommonFields: api.MigrationCommonFields{Namespace: "ns1", DDL: "CREATE SCHEMA {{ schema }};"},
+			},
+			vars: map[string]string{"schema": "app"},
+		},
+		{
+			name: "unresolved",
+			proto: api.MigrationProto{
+				MigrationCommonFields: api.MigrationCommonFields{Namespace: "ns1", DDL: "CREATE SCHEMA {{ schema }};"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unresolved but skipped",
+			proto: api.MigrationProto{
+				MigrationCommonFields: api.MigrationCommonFields{Namespace: "ns1", DDL: "CREATE SCHEMA {{ schema }};"},
+				ShouldSkip:            true,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mgr := &migrationManager{
+				migrations: &fakeMigrationRepository{},
+				events:     events.NewBus(),
+				settings:   &fakeSettingsRepository{},
+				variables:  &fakeVariablesRepository{vars: tc.vars},
+			}
+
+			err := mgr.ProcessMigrations(context.Background(), []api.MigrationProto{tc.proto})
+			if tc.wantErr && !errors.Is(err, ErrUnresolvedVariables) {
+				t.Errorf("Expected unresolved variables error, got %v", err)
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestProcessMigrationsTargetsEnvironments(t *testing.T) {
+	environments := map[string]string{
+		"app-dev":     "dev",
+		"app-staging": "staging",
+		"app-prod":    "prod",
+		"billing-dev": "dev",
+	}
+
+	testCases := []struct {
+		name           string
+		proto          api.MigrationProto
+		wantNamespaces []string
+	}{
+		{
+			name: "no environments",
+			proto: api.MigrationProto{
+				MigrationCommonFields: api.MigrationCommonFields{Namespace: "app-prod"},
+				Signature:             1,
+			},
+			wantNamespaces: []string{"app-prod"},
+		},
+		{
+			name: "namespace in environment",
+			proto: api.MigrationProto{
+				MigrationCommonFields: api.MigrationCommonFields{Namespace: "app-dev"},
+				Signature:             1,
+				Environments:          []string{"dev", "staging"},
+			},
+			wantNamespaces: []string{"app-dev"},
+		},
+		{
+			name: "namespace not in environment",
+			proto: api.MigrationProto{
+				MigrationCommonFields: api.MigrationCommonFields{Namespace: "app-prod"},
+				Signature:             1,
+				Environments:          []string{"dev", "staging"},
+			},
+			wantNamespaces: []string{},
+		},
+		{
+			name: "fan out to environments",
+			proto: api.MigrationProto{
+				MigrationCommonFields: api.MigrationCommonFields{Namespace: api.AllNamespaces},
+				Signature:             1,
+				Environments:          []string{"dev", "staging"},
+			},
+			wantNamespaces: []string{"app-dev", "app-staging", "billing-dev"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			migrations := &fakeMigrationRepository{}
+			mgr := &migrationManager{
+				migrations: migrations,
+				events:     events.NewBus(),
+				settings:   &fakeSettingsRepository{environments: environments},
+				variables:  &fakeVariablesRepository{},
+			}
+
+			if err := mgr.ProcessMigrations(context.Background(), []api.MigrationProto{tc.proto}); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			namespaces := make([]string, 0)
+			signatures := make(map[uint64]bool)
+			for _, inserted := range migrations.inserted {
+				namespaces = append(namespaces, inserted.Namespace)
+				signatures[inserted.Signature] = true
+			}
+			slices.Sort(namespaces)
+
+			if !slices.Equal(namespaces, tc.wantNamespaces) {
+				t.Errorf("Expected migrations for %v, got %v", tc.wantNamespaces, namespaces)
+			}
+			if len(signatures) != len(namespaces) {
+				t.Errorf("Expected a distinct signature per namespace, got %d for %d namespaces", len(signatures), len(namespaces))
+			}
+		})
+	}
+}
+
+func TestMigrationLifecycleWithMemoryStorage(t *testing.T) {
+	settings := memory.NewNamespaceSettingsRepository()
+	variables := memory.NewNamespaceVariablesRepository()
+	target := &fakeTargetRepository{}
+	bus := events.NewBus()
+	stream := bus.Subscribe("ns1")
+	defer stream.Close()
+	mgr := &migrationManager{
+		migrations: memory.NewMigrationRepository(),
+		attempts:   memory.NewExecutionAttemptRepository(),
+		events:     bus,
+		settings:   settings,
+		variables:  variables,
+		targets:    target,
+		linter:     utils.GetMigrationLinter(),
+	}
+
+	if err := settings.UpsertSettings(context.Background(), &api.NamespaceSettings{Namespace: "ns1", Session: api.SessionSettings{LockTimeout: "2s"}}); err != nil {
+		t.Fatalf("UpsertSettings() error = %v", err)
+	}
+	if err := variables.SetVariable(context.Background(), "ns1", "table", "users"); err != nil {
+		t.Fatalf("SetVariable() error = %v", err)
+	}
+
+	pending := []api.MigrationProto{{
+		MigrationCommonFields: api.MigrationCommonFields{Namespace: "ns1", DDL: "CREATE TABLE {{ table }} (id INT);", CreatedAt: time.Now()},
+		Signature:             42,
+	}}
+	if err := mgr.ProcessMigrations(context.Background(), pending); err != nil {
+		t.Fatalf("ProcessMigrations() error = %v", err)
+	}
+	// Pushing the same migration again doesn't record it twice
+	if err := mgr.ProcessMigrations(context.Background(), pending); err != nil {
+		t.Fatalf("ProcessMigrations() again error = %v", err)
+	}
+
+	list, err := mgr.ListMigrations(context.Background(), "ns1", api.MigrationListOptions{})
+	if err != nil || len(list.Migrations) != 1 {
+		t.Fatalf("ListMigrations() = %v, %v", list, err)
+	}
+
+	if _, err := mgr.ExecuteMigration(context.Background(), "ns1", 42, ExecuteOptions{}); err != nil {
+		t.Fatalf("ExecuteMigration() error = %v", err)
+	}
+	if target.ddl[0] != "CREATE TABLE users (id INT);" || target.settings[0].LockTimeout != "2s" {
+		t.Errorf("executed %q with %+v", target.ddl[0], target.settings[0])
+	}
+
+	migration, err := mgr.GetMigrationById(context.Background(), "ns1", 42)
+	if err != nil || migration.Status != api.MigrationStatusSucceeded || migration.CompletedAt.IsZero() || migration.RenderedDDL != "CREATE TABLE users (id INT);" {
+		t.Errorf("GetMigrationById() = %+v, %v, expected it to be completed", migration, err)
+	}
+
+	history, err := mgr.GetMigrationHistory(context.Background(), "ns1", 42)
+	if err != nil {
+		t.Fatalf("GetMigrationHistory() error = %v", err)
+	}
+	statuses := make([]api.MigrationStatus, 0, len(history.Changes))
+	for _, change := range history.Changes {
+		statuses = append(statuses, change.To)
+	}
+	if want := []api.MigrationStatus{api.MigrationStatusPending, api.MigrationStatusRunning, api.MigrationStatusSucceeded}; !slices.Equal(statuses, want) {
+		t.Errorf("GetMigrationHistory() went through %v, want %v", statuses, want)
+	}
+
+	// The stream follows the migration from ingestion through each statement to success
+	eventTypes := make([]api.EventType, 0)
+	for len(stream.Events()) > 0 {
+		event := <-stream.Events()
+		if event.MigrationID != 42 {
+			t.Errorf("unexpected event %+v", event)
+		}
+		eventTypes = append(eventTypes, event.Type)
+	}
+	wantTypes := []api.EventType{api.EventMigrationIngested, api.EventMigrationStarted, api.EventStatementFinished, api.EventMigrationSucceeded}
+	if !slices.Equal(eventTypes, wantTypes) {
+		t.Errorf("published %v, want %v", eventTypes, wantTypes)
+	}
+
+	if _, err := mgr.GetMigrationHistory(context.Background(), "ns2", 42); !errors.Is(err, ErrMigrationNotFound) {
+		t.Errorf("expected ErrMigrationNotFound fetching history from another namespace, got %v", err)
+	}
+	if _, err := mgr.GetMigrationById(context.Background(), "ns2", 42); !errors.Is(err, ErrMigrationNotFound) {
+		t.Errorf("expected ErrMigrationNotFound fetching it from another namespace, got %v", err)
+	}
+	if _, err := mgr.GetMigrationById(context.Background(), "ns1", 43); !errors.Is(err, ErrMigrationNotFound) {
+		t.Errorf("expected ErrMigrationNotFound fetching a missing migration, got %v", err)
+	}
+
+	if _, err := mgr.ExecuteMigration(context.Background(), "ns1", 42, ExecuteOptions{}); !errors.Is(err, ErrMigrationCompleted) {
+		t.Errorf("expected ErrMigrationCompleted executing it again, got %v", err)
+	}
+}
+
+func TestListMigrations(t *testing.T) {
+	repo := memory.NewMigrationRepository()
+	mgr := &migrationManager{migrations: repo}
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	protos := make([]*api.MigrationProto, 0)
+	for idx := range 7 {
+		protos = append(protos, &api.MigrationProto{
+			MigrationCommonFields: api.MigrationCommonFields{
+				Namespace: "ns1",
+				User:      []string{"alice", "bob"}[idx%2],
+				Repo:      "org/schema",
+				Comment:   fmt.Sprintf("Migration %d", idx),
+				// Pairs of migrations share a creation time, so pages have to break ties by id
+				CreatedAt: start.Add(time.Duration(idx/2) * time.Hour),
+			},
+			Signature:  uint64(100 - idx),
+			ShouldSkip: idx == 6,
+		})
+	}
+	protos = append(protos, &api.MigrationProto{
+		MigrationCommonFields: api.MigrationCommonFields{Namespace: "ns2", CreatedAt: start},
+		Signature:             1,
+	})
+	if err := repo.BulkInsert(context.Background(), protos); err != nil {
+		t.Fatalf("BulkInsert() error = %v", err)
+	}
+	transitions := []repository.StatusTransition{
+		{ID: 100, From: api.MigrationStatusPending, To: api.MigrationStatusRunning},
+		{ID: 100, From: api.MigrationStatusRunning, To: api.MigrationStatusSucceeded, At: start},
+		{ID: 99, From: api.MigrationStatusPending, To: api.MigrationStatusRunning},
+		{ID: 99, From: api.MigrationStatusRunning, To: api.MigrationStatusFailed},
+	}
+	for _, transition := range transitions {
+		if err := repo.TransitionStatus(context.Background(), transition); err != nil {
+			t.Fatalf("TransitionStatus() error = %v", err)
+		}
+	}
+
+	// listAll pages through a listing, returning the ids in the order they were listed
+	listAll := func(opts api.MigrationListOptions) []uint64 {
+		t.Helper()
+		ids := make([]uint64, 0)
+		for pages := 0; ; pages++ {
+			if pages > 10 {
+				t.Fatalf("listing with %+v didn't end", opts)
+			}
+			list, err := mgr.ListMigrations(context.Background(), "ns1", opts)
+			if err != nil {
+				t.Fatalf("ListMigrations(%+v) error = %v", opts, err)
+			}
+			for _, m := range list.Migrations {
+				ids = append(ids, m.ID)
+			}
+			if list.NextCursor == "" {
+				return ids
+			}
+			opts.Cursor = list.NextCursor
+		}
+	}
+
+	testCases := []struct {
+		name string
+		opts api.MigrationListOptions
+		want []uint64
+	}{
+		{
+			name: "pages in creation order",
+			opts: api.MigrationListOptions{Limit: 3},
+			want: []uint64{99, 100, 97, 98, 95, 96, 94},
+		},
+		{
+			name: "pages newest first",
+			opts: api.MigrationListOptions{Limit: 2, Descending: true},
+			want: []uint64{94, 96, 95, 98, 97, 100, 99},
+		},
+		{
+			name: "filters by status",
+			opts: api.MigrationListOptions{Statuses: []api.MigrationStatus{api.MigrationStatusPending}, Limit: 2},
+			want: []uint64{97, 98, 95, 96},
+		},
+		{
+			name: "filters by several statuses",
+			opts: api.MigrationListOptions{Statuses: []api.MigrationStatus{
+				api.MigrationStatusSucceeded, api.MigrationStatusFailed, api.MigrationStatusSkipped,
+			}},
+			want: []uint64{99, 100, 94},
+		},
+		{
+			name: "filters by user and repo",
+			opts: api.MigrationListOptions{User: "bob", Repo: "org/schema"},
+			want: []uint64{99, 97, 95},
+		},
+		{
+			name: "searches comments ignoring case",
+			opts: api.MigrationListOptions{Search: "MIGRATION 3"},
+			want: []uint64{97},
+		},
+		{
+			name: "filters by creation range",
+			opts: api.MigrationListOptions{CreatedFrom: start.Add(time.Hour), CreatedTo: start.Add(2 * time.Hour)},
+			want: []uint64{97, 98},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := listAll(tc.opts); !slices.Equal(got, tc.want) {
+				t.Errorf("listed %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	for _, opts := range []api.MigrationListOptions{
+		{Statuses: []api.MigrationStatus{"done"}},
+		{Limit: maxMigrationPageSize + 1},
+		{Cursor: "not a cursor"},
+		{CreatedFrom: start, CreatedTo: start},
+	} {
+		if _, err := mgr.ListMigrations(context.Background(), "ns1", opts); !errors.Is(err, ErrInvalidListOptions) {
+			t.Errorf("ListMigrations(%+v) error = %v, expected ErrInvalidListOptions", opts, err)
+		}
+	}
+}
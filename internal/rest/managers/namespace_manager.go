@@ -1,14 +1,28 @@
 package managers
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/dfryer1193/gomad/api"
 	"github.com/dfryer1193/gomad/internal/data/repository"
-	"github.com/dfryer1193/gomad/internal/data/repository/postgres"
+	"github.com/dfryer1193/gomad/internal/data/repository/storage"
+	"github.com/dfryer1193/gomad/internal/utils"
+	"slices"
 	"sync"
 )
 
+var (
+	ErrInvalidSettings  = errors.New("invalid namespace settings")
+	ErrInvalidVariable  = errors.New("invalid template variable")
+	ErrVariableNotFound = errors.New("template variable not found")
+)
+
 type NamespaceManager struct {
-	dbRepo repository.DatabaseRepository
+	dbRepo         repository.DatabaseRepository
+	connectionRepo repository.NamespaceConnectionRepository
+	settingsRepo   repository.NamespaceSettingsRepository
+	variablesRepo  repository.NamespaceVariablesRepository
 }
 
 var (
@@ -19,22 +33,120 @@ var (
 func GetNamespaceManager() *NamespaceManager {
 	namespaceOnce.Do(func() {
 		mgr = &NamespaceManager{
-			dbRepo: postgres.GetDatabaseRepository(),
+			dbRepo:         storage.GetDatabaseRepository(),
+			connectionRepo: storage.GetNamespaceConnectionRepository(),
+			settingsRepo:   storage.GetNamespaceSettingsRepository(),
+			variablesRepo:  storage.GetNamespaceVariablesRepository(),
 		}
 	})
 
 	return mgr
 }
 
-func (mgr *NamespaceManager) GetNamespaces() ([]string, error) {
-	namespaces, err := mgr.dbRepo.ListDatabases()
+// GetNamespaces lists the registered namespaces along with the databases on the default cluster
+func (mgr *NamespaceManager) GetNamespaces(ctx context.Context) ([]string, error) {
+	namespaces, err := mgr.dbRepo.ListDatabases(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch namespaces: %w", err)
 	}
 
+	conns, err := mgr.connectionRepo.ListConnections(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch namespace connections: %w", err)
+	}
+
+	for _, conn := range conns {
+		if !slices.Contains(namespaces, conn.Namespace) {
+			namespaces = append(namespaces, conn.Namespace)
+		}
+	}
+	slices.Sort(namespaces)
+
 	return namespaces, nil
 }
 
+func (mgr *NamespaceManager) GetSettings(ctx context.Context, namespace string) (*api.NamespaceSettings, error) {
+	settings, err := mgr.settingsRepo.GetSettings(ctx, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch settings for namespace %s: %w", namespace, err)
+	}
+
+	return settings, nil
+}
+
+// SaveSettings validates and stores the execution defaults for a namespace
+func (mgr *NamespaceManager) SaveSettings(ctx context.Context, settings *api.NamespaceSettings) error {
+	for _, timeout := range []string{
+		settings.Session.LockTimeout,
+		settings.Session.StatementTimeout,
+		settings.Session.IdleInTransactionSessionTimeout,
+	} {
+		if timeout == "" {
+			continue
+		}
+		if err := utils.ValidateTimeout(timeout); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidSettings, err)
+		}
+	}
+
+	if settings.Environment != "" {
+		if err := utils.ValidateEnvironmentName(settings.Environment); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidSettings, err)
+		}
+	}
+
+	if err := utils.ValidateLintRules(settings.LintRules); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSettings, err)
+	}
+
+	if settings.LockRetries < 0 || settings.LockRetries > api.MaxLockRetries {
+		return fmt.Errorf("%w: lockRetries must be between 0 and %d", ErrInvalidSettings, api.MaxLockRetries)
+	}
+
+	if err := mgr.settingsRepo.UpsertSettings(ctx, settings); err != nil {
+		return fmt.Errorf("failed to save settings for namespace %s: %w", settings.Namespace, err)
+	}
+
+	return nil
+}
+
+func (mgr *NamespaceManager) GetVariables(ctx context.Context, namespace string) (*api.NamespaceVariables, error) {
+	vars, err := mgr.variablesRepo.GetVariables(ctx, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch variables for namespace %s: %w", namespace, err)
+	}
+
+	return &api.NamespaceVariables{Namespace: namespace, Variables: vars}, nil
+}
+
+func (mgr *NamespaceManager) SetVariable(ctx context.Context, namespace string, name string, value string) error {
+	if err := utils.ValidateVariableName(name); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidVariable, err)
+	}
+
+	if err := mgr.variablesRepo.SetVariable(ctx, namespace, name, value); err != nil {
+		return fmt.Errorf("failed to set variable %s for namespace %s: %w", name, namespace, err)
+	}
+
+	return nil
+}
+
+func (mgr *NamespaceManager) DeleteVariable(ctx context.Context, namespace string, name string) error {
+	deleted, err := mgr.variablesRepo.DeleteVariable(ctx, namespace, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete variable %s for namespace %s: %w", name, namespace, err)
+	}
+
+	if !deleted {
+		return fmt.Errorf("%w: %s in namespace %s", ErrVariableNotFound, name, namespace)
+	}
+
+	return nil
+}
+
 func (mgr *NamespaceManager) Close() {
 	mgr.dbRepo.Close()
+	mgr.connectionRepo.Close()
+	mgr.settingsRepo.Close()
+	mgr.variablesRepo.Close()
 }
@@ -0,0 +1,176 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+	"github.com/dfryer1193/gomad/internal/data/repository/storage"
+	dataUtils "github.com/dfryer1193/gomad/internal/data/utils"
+	"github.com/dfryer1193/gomad/internal/notifications"
+)
+
+var (
+	ErrInvalidNotificationSink  = errors.New("invalid notification sink")
+	ErrNotificationSinkNotFound = errors.New("notification sink not found")
+	// ErrNotificationFailed is returned when a test notification couldn't be delivered
+	ErrNotificationFailed = errors.New("notification delivery failed")
+)
+
+type notificationSender interface {
+	Send(ctx context.Context, sink *api.NotificationSink, event *api.Event) error
+	// InvalidateSinks makes changes to sinks apply to the next event sent
+	InvalidateSinks()
+}
+
+// NotificationManager manages the sinks migration events are sent to
+type NotificationManager struct {
+	sinkRepo repository.NotificationSinkRepository
+	sender   notificationSender
+}
+
+var (
+	notificationMgr  *NotificationManager
+	notificationOnce sync.Once
+)
+
+func GetNotificationManager() *NotificationManager {
+	notificationOnce.Do(func() {
+		notificationMgr = &NotificationManager{
+			sinkRepo: storage.GetNotificationSinkRepository(),
+			sender:   notifications.GetNotifier(),
+		}
+	})
+
+	return notificationMgr
+}
+
+// ListSinks returns every sink with its secret redacted
+func (mgr *NotificationManager) ListSinks(ctx context.Context) ([]*api.NotificationSink, error) {
+	sinks, err := mgr.sinkRepo.ListSinks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch notification sinks: %w", err)
+	}
+
+	for _, sink := range sinks {
+		sink.Secret = ""
+	}
+
+	return sinks, nil
+}
+
+// SaveSink validates and stores a sink, replacing any existing sink of the same name. The secret is redacted from
+// sink once it has been saved.
+func (mgr *NotificationManager) SaveSink(ctx context.Context, sink *api.NotificationSink) error {
+	if sink.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidNotificationSink)
+	}
+
+	if !slices.Contains(api.SinkKinds, sink.Kind) {
+		return fmt.Errorf("%w: kind must be one of webhook, slack or email", ErrInvalidNotificationSink)
+	}
+
+	if len(sink.Events) == 0 {
+		return fmt.Errorf("%w: at least one event type is required", ErrInvalidNotificationSink)
+	}
+	for _, event := range sink.Events {
+		if !slices.Contains(api.EventTypes, event) {
+			return fmt.Errorf("%w: unknown event type %q", ErrInvalidNotificationSink, event)
+		}
+	}
+
+	if slices.Contains(sink.Namespaces, api.AllNamespaces) {
+		return fmt.Errorf("%w: leave namespaces empty to subscribe to every namespace", ErrInvalidNotificationSink)
+	}
+
+	switch sink.Kind {
+	case api.SinkWebhook, api.SinkSlack:
+		parsed, err := url.Parse(sink.URL)
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return fmt.Errorf("%w: url must be an absolute http(s) URL", ErrInvalidNotificationSink)
+		}
+	case api.SinkEmail:
+		if err := validateEmailSink(sink); err != nil {
+			return err
+		}
+	}
+
+	if err := mgr.sinkRepo.UpsertSink(ctx, sink); err != nil {
+		return fmt.Errorf("failed to save notification sink %s: %w", sink.Name, err)
+	}
+	mgr.sender.InvalidateSinks()
+
+	sink.HasSecret = sink.Secret != ""
+	sink.Secret = ""
+	return nil
+}
+
+func validateEmailSink(sink *api.NotificationSink) error {
+	if sink.SMTPHost == "" {
+		return fmt.Errorf("%w: smtpHost is required", ErrInvalidNotificationSink)
+	}
+	if sink.SMTPPort < 0 || sink.SMTPPort > 65535 {
+		return fmt.Errorf("%w: smtpPort must be between 1 and 65535", ErrInvalidNotificationSink)
+	}
+
+	if _, err := mail.ParseAddress(sink.From); err != nil {
+		return fmt.Errorf("%w: from must be an email address", ErrInvalidNotificationSink)
+	}
+	if len(sink.To) == 0 {
+		return fmt.Errorf("%w: at least one recipient is required", ErrInvalidNotificationSink)
+	}
+	for _, to := range sink.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("%w: recipient %q is not an email address", ErrInvalidNotificationSink, to)
+		}
+	}
+
+	if err := dataUtils.ValidateCredentialsRef(sink.CredentialsRef); err != nil {
+		return fmt.Errorf("%w: credentialsRef: %w", ErrInvalidNotificationSink, err)
+	}
+
+	return nil
+}
+
+func (mgr *NotificationManager) DeleteSink(ctx context.Context, name string) error {
+	deleted, err := mgr.sinkRepo.DeleteSink(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete notification sink %s: %w", name, err)
+	}
+
+	if !deleted {
+		return fmt.Errorf("%w: %s", ErrNotificationSinkNotFound, name)
+	}
+	mgr.sender.InvalidateSinks()
+
+	return nil
+}
+
+// TestSink sends a test event to the sink straight away, without retrying, so its configuration can be checked
+func (mgr *NotificationManager) TestSink(ctx context.Context, name string) error {
+	sink, err := mgr.sinkRepo.GetSink(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to fetch notification sink %s: %w", name, err)
+	}
+	if sink == nil {
+		return fmt.Errorf("%w: %s", ErrNotificationSinkNotFound, name)
+	}
+
+	event := &api.Event{Type: api.EventTest, At: time.Now(), Reason: "test notification sent from gomad"}
+	if err := mgr.sender.Send(ctx, sink, event); err != nil {
+		return fmt.Errorf("%w: %w", ErrNotificationFailed, err)
+	}
+
+	return nil
+}
+
+func (mgr *NotificationManager) Close() {
+	mgr.sinkRepo.Close()
+}
@@ -0,0 +1,150 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository/memory"
+)
+
+// fakeNotificationSender records the events sent to each sink, failing with err if it's set
+type fakeNotificationSender struct {
+	sent          map[string][]*api.Event
+	err           error
+	invalidations int
+}
+
+func (s *fakeNotificationSender) InvalidateSinks() {
+	s.invalidations++
+}
+
+func (s *fakeNotificationSender) Send(_ context.Context, sink *api.NotificationSink, event *api.Event) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.sent[sink.Name] = append(s.sent[sink.Name], event)
+	return nil
+}
+
+func TestSaveSink(t *testing.T) {
+	failures := []api.EventType{api.EventMigrationFailed}
+
+	testCases := []struct {
+		name    string
+		sink    api.NotificationSink
+		wantErr bool
+	}{
+		{
+			name: "signed webhook",
+			sink: api.NotificationSink{Name: "hooks", Kind: api.SinkWebhook, URL: "https://hooks.example.com/gomad", Secret: "s3cret", Events: failures},
+		},
+		{
+			name: "slack for one namespace",
+			sink: api.NotificationSink{Name: "chat", Kind: api.SinkSlack, URL: "https://hooks.slack.com/services/T/B/X", Namespaces: []string{"billing"}, Events: failures},
+		},
+		{
+			name: "email",
+			sink: api.NotificationSink{Name: "oncall", Kind: api.SinkEmail, SMTPHost: "mail.example.com", From: "gomad@example.com", To: []string{"dba@example.com"}, Username: "gomad", CredentialsRef: "env:GOMAD_CREDENTIAL_SMTP", Events: failures},
+		},
+		{
+			name:    "missing name",
+			sink:    api.NotificationSink{Kind: api.SinkWebhook, URL: "https://hooks.example.com", Events: failures},
+			wantErr: true,
+		},
+		{
+			name:    "unknown kind",
+			sink:    api.NotificationSink{Name: "pager", Kind: "pagerduty", URL: "https://events.example.com", Events: failures},
+			wantErr: true,
+		},
+		{
+			name:    "no events",
+			sink:    api.NotificationSink{Name: "hooks", Kind: api.SinkWebhook, URL: "https://hooks.example.com"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown event",
+			sink:    api.NotificationSink{Name: "hooks", Kind: api.SinkWebhook, URL: "https://hooks.example.com", Events: []api.EventType{"migration.exploded"}},
+			wantErr: true,
+		},
+		{
+			name:    "relative url",
+			sink:    api.NotificationSink{Name: "hooks", Kind: api.SinkWebhook, URL: "/gomad", Events: failures},
+			wantErr: true,
+		},
+		{
+			name:    "email without recipients",
+			sink:    api.NotificationSink{Name: "oncall", Kind: api.SinkEmail, SMTPHost: "mail.example.com", From: "gomad@example.com", Events: failures},
+			wantErr: true,
+		},
+		{
+			name:    "email with a bad credentials reference",
+			sink:    api.NotificationSink{Name: "oncall", Kind: api.SinkEmail, SMTPHost: "mail.example.com", From: "gomad@example.com", To: []string{"dba@example.com"}, CredentialsRef: "hunter2", Events: failures},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mgr := &NotificationManager{sinkRepo: memory.NewNotificationSinkRepository(), sender: &fakeNotificationSender{}}
+
+			sink := tc.sink
+			err := mgr.SaveSink(context.Background(), &sink)
+			if tc.wantErr {
+				if !errors.Is(err, ErrInvalidNotificationSink) {
+					t.Errorf("expected ErrInvalidNotificationSink, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SaveSink() error = %v", err)
+			}
+			if sink.Secret != "" || sink.HasSecret != (tc.sink.Secret != "") {
+				t.Errorf("expected the secret to be redacted, got %+v", sink)
+			}
+
+			sinks, _ := mgr.ListSinks(context.Background())
+			if len(sinks) != 1 || sinks[0].Secret != "" || sinks[0].HasSecret != (tc.sink.Secret != "") {
+				t.Errorf("ListSinks() = %+v", sinks)
+			}
+		})
+	}
+}
+
+func TestTestSink(t *testing.T) {
+	sender := &fakeNotificationSender{sent: map[string][]*api.Event{}}
+	mgr := &NotificationManager{sinkRepo: memory.NewNotificationSinkRepository(), sender: sender}
+	sink := &api.NotificationSink{Name: "hooks", Kind: api.SinkWebhook, URL: "https://hooks.example.com", Events: []api.EventType{api.EventMigrationFailed}}
+	if err := mgr.SaveSink(context.Background(), sink); err != nil {
+		t.Fatalf("SaveSink() error = %v", err)
+	}
+
+	if err := mgr.TestSink(context.Background(), "hooks"); err != nil {
+		t.Fatalf("TestSink() error = %v", err)
+	}
+	if events := sender.sent["hooks"]; len(events) != 1 || events[0].Type != api.EventTest {
+		t.Errorf("sent %+v", events)
+	}
+
+	if err := mgr.TestSink(context.Background(), "missing"); !errors.Is(err, ErrNotificationSinkNotFound) {
+		t.Errorf("expected ErrNotificationSinkNotFound, got %v", err)
+	}
+
+	sender.err = errors.New("connection refused")
+	if err := mgr.TestSink(context.Background(), "hooks"); !errors.Is(err, ErrNotificationFailed) {
+		t.Errorf("expected ErrNotificationFailed, got %v", err)
+	}
+
+	if err := mgr.DeleteSink(context.Background(), "hooks"); err != nil {
+		t.Errorf("DeleteSink() error = %v", err)
+	}
+	if err := mgr.DeleteSink(context.Background(), "hooks"); !errors.Is(err, ErrNotificationSinkNotFound) {
+		t.Errorf("expected ErrNotificationSinkNotFound deleting twice, got %v", err)
+	}
+
+	// Saving and deleting the sink each make the notifier fetch sinks again
+	if sender.invalidations != 2 {
+		t.Errorf("expected 2 sink invalidations, got %d", sender.invalidations)
+	}
+}
@@ -0,0 +1,120 @@
+package managers
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sync"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+	"github.com/dfryer1193/gomad/internal/data/repository/storage"
+)
+
+var (
+	ErrInvalidRepositoryConfig = errors.New("invalid repository config")
+	ErrRepositoryNotFound      = errors.New("repository not found")
+)
+
+// remoteSchemes are the URL schemes a mirrored repository's remote may use
+var remoteSchemes = []string{"https", "ssh", "file"}
+
+type RepositoryManager struct {
+	configRepo repository.RepositoryConfigRepository
+}
+
+var (
+	repositoryMgr  *RepositoryManager
+	repositoryOnce sync.Once
+)
+
+func GetRepositoryManager() *RepositoryManager {
+	repositoryOnce.Do(func() {
+		repositoryMgr = &RepositoryManager{
+			configRepo: storage.GetRepositoryConfigRepository(),
+		}
+	})
+
+	return repositoryMgr
+}
+
+// ListRepositories returns every repository config with its token redacted
+func (mgr *RepositoryManager) ListRepositories(ctx context.Context) ([]*api.RepositoryConfig, error) {
+	configs, err := mgr.configRepo.ListConfigs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch repository configs: %w", err)
+	}
+
+	for _, config := range configs {
+		config.Token = ""
+	}
+
+	return configs, nil
+}
+
+// SaveRepository validates and stores how files are fetched from a repository, replacing any existing config. The
+// token is redacted from config once it has been saved.
+func (mgr *RepositoryManager) SaveRepository(ctx context.Context, config *api.RepositoryConfig) error {
+	if config.RepoName == "" {
+		return fmt.Errorf("%w: repoName is required", ErrInvalidRepositoryConfig)
+	}
+
+	if config.Source == "" {
+		config.Source = api.FileSourceGitHub
+	}
+
+	switch config.Source {
+	case api.FileSourceGitHub, api.FileSourceMirror:
+	default:
+		return fmt.Errorf("%w: unknown source %q", ErrInvalidRepositoryConfig, config.Source)
+	}
+
+	// Remotes are passed to git, so only transports that can't run commands are accepted
+	if config.RemoteURL != "" {
+		parsed, err := url.Parse(config.RemoteURL)
+		if err != nil || !slices.Contains(remoteSchemes, parsed.Scheme) || (parsed.Host == "" && parsed.Scheme != "file") || parsed.Path == "" {
+			return fmt.Errorf("%w: remoteUrl must be an absolute https, ssh or file URL", ErrInvalidRepositoryConfig)
+		}
+	}
+
+	if config.APIBaseURL != "" {
+		parsed, err := url.Parse(config.APIBaseURL)
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return fmt.Errorf("%w: apiBaseUrl must be an absolute http(s) URL", ErrInvalidRepositoryConfig)
+		}
+	}
+
+	if config.CABundle != "" {
+		if !x509.NewCertPool().AppendCertsFromPEM([]byte(config.CABundle)) {
+			return fmt.Errorf("%w: caBundle contains no PEM certificates", ErrInvalidRepositoryConfig)
+		}
+	}
+
+	if err := mgr.configRepo.UpsertConfig(ctx, config); err != nil {
+		return fmt.Errorf("failed to save config for repository %s: %w", config.RepoName, err)
+	}
+
+	config.HasToken = config.Token != ""
+	config.Token = ""
+	return nil
+}
+
+func (mgr *RepositoryManager) DeleteRepository(ctx context.Context, repoName string) error {
+	deleted, err := mgr.configRepo.DeleteConfig(ctx, repoName)
+	if err != nil {
+		return fmt.Errorf("failed to delete config for repository %s: %w", repoName, err)
+	}
+
+	if !deleted {
+		return fmt.Errorf("%w: %s", ErrRepositoryNotFound, repoName)
+	}
+
+	return nil
+}
+
+func (mgr *RepositoryManager) Close() {
+	mgr.configRepo.Close()
+}
@@ -0,0 +1,48 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository/memory"
+)
+
+func TestSaveRepository(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  api.RepositoryConfig
+		wantErr bool
+	}{
+		{name: "github defaults", config: api.RepositoryConfig{RepoName: "acme/schema"}},
+		{name: "https remote", config: api.RepositoryConfig{RepoName: "acme/schema", Source: api.FileSourceMirror, RemoteURL: "https://git.example.com/acme/schema.git"}},
+		{name: "ssh remote", config: api.RepositoryConfig{RepoName: "acme/schema", Source: api.FileSourceMirror, RemoteURL: "ssh://git@git.example.com/acme/schema.git"}},
+		{name: "file remote", config: api.RepositoryConfig{RepoName: "acme/schema", Source: api.FileSourceMirror, RemoteURL: "file:///srv/git/schema.git"}},
+		{name: "option-like remote", config: api.RepositoryConfig{RepoName: "acme/schema", Source: api.FileSourceMirror, RemoteURL: "--upload-pack=touch /tmp/pwned"}, wantErr: true},
+		{name: "ext transport", config: api.RepositoryConfig{RepoName: "acme/schema", Source: api.FileSourceMirror, RemoteURL: "ext::sh -c touch% /tmp/pwned"}, wantErr: true},
+		{name: "plain http remote", config: api.RepositoryConfig{RepoName: "acme/schema", Source: api.FileSourceMirror, RemoteURL: "http://git.example.com/acme/schema.git"}, wantErr: true},
+		{name: "scp-like remote", config: api.RepositoryConfig{RepoName: "acme/schema", Source: api.FileSourceMirror, RemoteURL: "git@github.com:acme/schema.git"}, wantErr: true},
+		{name: "relative path", config: api.RepositoryConfig{RepoName: "acme/schema", Source: api.FileSourceMirror, RemoteURL: "../schema"}, wantErr: true},
+		{name: "unknown source", config: api.RepositoryConfig{RepoName: "acme/schema", Source: "svn"}, wantErr: true},
+		{name: "api base url", config: api.RepositoryConfig{RepoName: "acme/schema", APIBaseURL: "ftp://github.example.com"}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mgr := &RepositoryManager{configRepo: memory.NewRepositoryConfigRepository()}
+			config := tc.config
+
+			err := mgr.SaveRepository(context.Background(), &config)
+			if tc.wantErr {
+				if !errors.Is(err, ErrInvalidRepositoryConfig) {
+					t.Errorf("expected ErrInvalidRepositoryConfig, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SaveRepository() error = %v", err)
+			}
+		})
+	}
+}
@@ -1,18 +1,19 @@
 package managers
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
 	"sync"
 
 	"github.com/dfryer1193/gomad/internal/data/repository"
-	"github.com/dfryer1193/gomad/internal/data/repository/postgres"
+	"github.com/dfryer1193/gomad/internal/data/repository/storage"
 )
 
 type SecretManager interface {
-	SaveSecret(repoName string) (string, error)
-	GetSecret(repoName string) (string, error)
+	SaveSecret(ctx context.Context, repoName string) (string, error)
+	GetSecret(ctx context.Context, repoName string) (string, error)
 	Close()
 }
 
@@ -28,23 +29,23 @@ var (
 func GetSecretManager() SecretManager {
 	secretOnce.Do(func() {
 		secretMgr = &secretManager{
-			repo: postgres.GetSecretsRepository(),
+			repo: storage.GetSecretsRepository(),
 		}
 	})
 
 	return secretMgr
 }
 
-func (s *secretManager) SaveSecret(repoName string) (string, error) {
+func (s *secretManager) SaveSecret(ctx context.Context, repoName string) (string, error) {
 	secret, err := generateRandomSecret()
 	if err != nil {
 		return "", fmt.Errorf("failed to generate secret: %w", err)
 	}
-	return s.repo.InsertSecret(repoName, secret)
+	return s.repo.InsertSecret(ctx, repoName, secret)
 }
 
-func (s *secretManager) GetSecret(repoName string) (string, error) {
-	return s.repo.GetSecret(repoName)
+func (s *secretManager) GetSecret(ctx context.Context, repoName string) (string, error) {
+	return s.repo.GetSecret(ctx, repoName)
 }
 
 func (s *secretManager) Close() {
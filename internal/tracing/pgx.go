@@ -0,0 +1,63 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxStatementLength bounds the SQL recorded on a query span
+const maxStatementLength = 2048
+
+// QueryTracer traces the queries sent over a pgx connection, one span per query or COPY
+type QueryTracer struct{}
+
+func (QueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = Start(ctx, "postgres.query",
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.operation", operation(data.SQL)),
+		attribute.String("db.statement", truncate(data.SQL)),
+	)
+	return ctx
+}
+
+func (QueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	End(span, data.Err)
+}
+
+func (QueryTracer) TraceCopyFromStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	ctx, _ = Start(ctx, "postgres.copy",
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.operation", "COPY"),
+		attribute.String("db.sql.table", data.TableName.Sanitize()),
+	)
+	return ctx
+}
+
+func (QueryTracer) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	End(span, data.Err)
+}
+
+// operation is the statement's leading keyword, such as SELECT
+func operation(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToUpper(fields[0])
+}
+
+func truncate(sql string) string {
+	sql = strings.TrimSpace(sql)
+	if len(sql) > maxStatementLength {
+		return sql[:maxStatementLength]
+	}
+	return sql
+}
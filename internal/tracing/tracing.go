@@ -0,0 +1,148 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/dfryer1193/gomad/api"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporter selects where spans are sent
+type Exporter string
+
+const (
+	// ExporterNone drops every span. Trace context is still propagated, so gomad doesn't break traces passing through.
+	ExporterNone Exporter = "none"
+	// ExporterOTLP sends spans over OTLP/HTTP, configured by the standard OTEL_EXPORTER_OTLP_* variables
+	ExporterOTLP Exporter = "otlp"
+)
+
+const (
+	instrumentationName = "github.com/dfryer1193/gomad"
+	serviceName         = "gomad"
+)
+
+// ParseExporter validates an exporter name, defaulting to none when it's empty
+func ParseExporter(name string) (Exporter, error) {
+	switch Exporter(name) {
+	case "", ExporterNone:
+		return ExporterNone, nil
+	case ExporterOTLP:
+		return ExporterOTLP, nil
+	default:
+		return "", fmt.Errorf("unknown tracing exporter %q: expected %s or %s", name, ExporterNone, ExporterOTLP)
+	}
+}
+
+// Setup installs the propagator and, unless spans are dropped, a tracer provider exporting them with the exporter
+// named by GOMAD_TRACING_EXPORTER. The returned func flushes the spans still buffered and must be called at shutdown.
+func Setup(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	exporter, err := ParseExporter(os.Getenv("GOMAD_TRACING_EXPORTER"))
+	if err != nil {
+		return nil, err
+	}
+	if exporter == ExporterNone {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	otlp, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	// Attributes from OTEL_RESOURCE_ATTRIBUTES and OTEL_SERVICE_NAME override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", serviceName)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe tracing resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(otlp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Start begins a span, as a child of the span in ctx if there is one
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End finishes the span, marking it failed with err if there was one
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Record adds a span for work that has already finished, such as a statement timed by a database driver
+func Record(ctx context.Context, name string, start time.Time, end time.Time, failed bool, attrs ...attribute.KeyValue) {
+	_, span := otel.Tracer(instrumentationName).Start(ctx, name, trace.WithTimestamp(start), trace.WithAttributes(attrs...))
+	if failed {
+		span.SetStatus(codes.Error, name+" failed")
+	}
+	span.End(trace.WithTimestamp(end))
+}
+
+// ExtractHTTP returns ctx carrying the trace context sent in the headers of a request, if any
+func ExtractHTTP(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// InjectHTTP adds the trace context of ctx to the headers of an outgoing request
+func InjectHTTP(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// InjectEvent stamps the event with the trace context of ctx, so whatever handles the event later can continue
+// the trace
+func InjectEvent(ctx context.Context, event *api.Event) {
+	otel.GetTextMapPropagator().Inject(ctx, eventCarrier{event: event})
+}
+
+// EventContext returns ctx carrying the trace context the event was stamped with
+func EventContext(ctx context.Context, event *api.Event) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, eventCarrier{event: event})
+}
+
+// eventCarrier exposes an event's traceparent to propagators. Other fields, like tracestate, aren't kept.
+type eventCarrier struct {
+	event *api.Event
+}
+
+const traceparentKey = "traceparent"
+
+func (c eventCarrier) Get(key string) string {
+	if key == traceparentKey {
+		return c.event.TraceParent
+	}
+	return ""
+}
+
+func (c eventCarrier) Set(key string, value string) {
+	if key == traceparentKey {
+		c.event.TraceParent = value
+	}
+}
+
+func (c eventCarrier) Keys() []string {
+	return []string{traceparentKey}
+}
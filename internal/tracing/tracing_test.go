@@ -0,0 +1,164 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider keeping every span in memory until the test ends
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	return recorder
+}
+
+func TestParseExporter(t *testing.T) {
+	testCases := []struct {
+		name    string
+		want    Exporter
+		wantErr bool
+	}{
+		{name: "", want: ExporterNone},
+		{name: "none", want: ExporterNone},
+		{name: "otlp", want: ExporterOTLP},
+		{name: "jaeger", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		got, err := ParseExporter(tc.name)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("ParseExporter(%q) = %q, %v", tc.name, got, err)
+		}
+	}
+}
+
+func TestSetupDefaultsToNoop(t *testing.T) {
+	t.Setenv("GOMAD_TRACING_EXPORTER", "")
+	shutdown, err := Setup(context.Background())
+	if err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown() error = %v", err)
+	}
+
+	_, span := Start(context.Background(), "dropped")
+	defer span.End()
+	if span.SpanContext().IsValid() {
+		t.Errorf("expected spans to be dropped without an exporter")
+	}
+
+	t.Setenv("GOMAD_TRACING_EXPORTER", "zipkin")
+	if _, err := Setup(context.Background()); err == nil {
+		t.Errorf("expected an unknown exporter to fail setup")
+	}
+}
+
+func TestEnd(t *testing.T) {
+	recorder := recordSpans(t)
+
+	_, ok := Start(context.Background(), "ok")
+	End(ok, nil)
+	_, failed := Start(context.Background(), "failed")
+	End(failed, errors.New("boom"))
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].Status().Code != codes.Unset || spans[1].Status().Code != codes.Error || spans[1].Status().Description != "boom" {
+		t.Errorf("unexpected statuses %+v, %+v", spans[0].Status(), spans[1].Status())
+	}
+}
+
+func TestRecord(t *testing.T) {
+	recorder := recordSpans(t)
+	ctx, parent := Start(context.Background(), "parent")
+
+	start := time.Now().Add(-time.Second)
+	end := start.Add(250 * time.Millisecond)
+	Record(ctx, "statement", start, end, true)
+	parent.End()
+
+	span := recorder.Ended()[0]
+	if !span.StartTime().Equal(start) || !span.EndTime().Equal(end) {
+		t.Errorf("span ran from %v to %v, want %v to %v", span.StartTime(), span.EndTime(), start, end)
+	}
+	if span.Parent().SpanID() != parent.SpanContext().SpanID() || span.Status().Code != codes.Error {
+		t.Errorf("expected a failed child of the parent span, got %+v", span)
+	}
+}
+
+func TestEventPropagation(t *testing.T) {
+	recordSpans(t)
+	ctx, span := Start(context.Background(), "publish")
+	defer span.End()
+
+	event := &api.Event{Type: api.EventMigrationFailed}
+	InjectEvent(ctx, event)
+	if event.TraceParent == "" {
+		t.Fatalf("expected the event to be stamped with the trace")
+	}
+
+	// The event is handled later, on a context of its own
+	handled := trace.SpanContextFromContext(EventContext(context.Background(), event))
+	if handled.TraceID() != span.SpanContext().TraceID() || handled.SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("EventContext() = %v, want the publishing span %v", handled, span.SpanContext())
+	}
+
+	header := http.Header{}
+	InjectHTTP(ctx, header)
+	if header.Get("traceparent") != event.TraceParent {
+		t.Errorf("InjectHTTP() set traceparent %q, want %q", header.Get("traceparent"), event.TraceParent)
+	}
+	if got := trace.SpanContextFromContext(ExtractHTTP(context.Background(), header)); got.TraceID() != span.SpanContext().TraceID() {
+		t.Errorf("ExtractHTTP() = %v", got)
+	}
+}
+
+func TestQueryTracer(t *testing.T) {
+	recorder := recordSpans(t)
+	tracer := QueryTracer{}
+
+	ctx := tracer.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: "\n\t\tselect id FROM migrations"})
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("SELECT 3")})
+	ctx = tracer.TraceCopyFromStart(context.Background(), nil, pgx.TraceCopyFromStartData{TableName: pgx.Identifier{"migrations"}})
+	tracer.TraceCopyFromEnd(ctx, nil, pgx.TraceCopyFromEndData{Err: errors.New("duplicate key")})
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	attrs := make(map[string]string)
+	for _, attr := range spans[0].Attributes() {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	if spans[0].Name() != "postgres.query" || attrs["db.operation"] != "SELECT" || attrs["db.statement"] != "select id FROM migrations" || attrs["db.rows_affected"] != "3" {
+		t.Errorf("query span %s has %v", spans[0].Name(), attrs)
+	}
+	if spans[1].Name() != "postgres.copy" || spans[1].Status().Code != codes.Error {
+		t.Errorf("expected a failed copy span, got %s with %+v", spans[1].Name(), spans[1].Status())
+	}
+}
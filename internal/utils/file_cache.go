@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+)
+
+// FileCacheStats counts lookups in a FileCache since it was created
+type FileCacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+// DiskFileCache stores file contents on disk keyed by repository and git blob SHA. Since a blob's SHA is derived from
+// its content, an entry never goes stale, and content shared between files or commits is only downloaded once.
+type DiskFileCache struct {
+	dir    string
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+var blobSHAPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+func NewDiskFileCache(dir string) *DiskFileCache {
+	return &DiskFileCache{dir: dir}
+}
+
+// fileCacheDir returns the directory cached files are kept in, from GOMAD_FILE_CACHE_DIR
+func fileCacheDir() string {
+	if dir := os.Getenv("GOMAD_FILE_CACHE_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "gomad", "files")
+}
+
+// Get returns the cached content of a blob. Entries whose content no longer matches their SHA are discarded.
+func (c *DiskFileCache) Get(repoName string, sha string) (string, bool) {
+	entryPath, ok := c.entryPath(repoName, sha)
+	if !ok {
+		c.misses.Add(1)
+		return "", false
+	}
+
+	content, err := os.ReadFile(entryPath)
+	if err != nil {
+		c.misses.Add(1)
+		return "", false
+	}
+
+	if gitBlobSHA(content) != sha {
+		log.Warn().Str("repo", repoName).Str("sha", sha).Msg("discarding corrupt file cache entry")
+		os.Remove(entryPath)
+		c.misses.Add(1)
+		return "", false
+	}
+
+	c.hits.Add(1)
+	return string(content), true
+}
+
+// Put caches the content of a blob. Failing to write the cache only costs a later download, so errors are logged.
+func (c *DiskFileCache) Put(repoName string, sha string, content string) {
+	entryPath, ok := c.entryPath(repoName, sha)
+	if !ok {
+		return
+	}
+
+	if err := writeFileAtomic(entryPath, []byte(content)); err != nil {
+		log.Warn().Err(err).Str("repo", repoName).Str("sha", sha).Msg("failed to write file cache entry")
+	}
+}
+
+func (c *DiskFileCache) Stats() FileCacheStats {
+	return FileCacheStats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+	}
+}
+
+// entryPath returns where a blob is cached, and false for SHAs that aren't hex SHA-1s and so can't be verified
+func (c *DiskFileCache) entryPath(repoName string, sha string) (string, bool) {
+	if !blobSHAPattern.MatchString(sha) {
+		return "", false
+	}
+	return filepath.Join(c.dir, url.PathEscape(repoName), sha[:2], sha[2:]), true
+}
+
+// gitBlobSHA computes the SHA git gives a blob with the content
+func gitBlobSHA(content []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(content))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeFileAtomic writes a file by renaming a temporary file into place, so readers never see partial content
+func writeFileAtomic(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDiskFileCache(t *testing.T) {
+	cache := NewDiskFileCache(t.TempDir())
+	content := "CREATE TABLE users (id BIGINT);\n"
+	sha := gitBlobSHA([]byte(content))
+
+	if _, ok := cache.Get(testRepoName, sha); ok {
+		t.Fatalf("expected miss on empty cache")
+	}
+
+	cache.Put(testRepoName, sha, content)
+	cached, ok := cache.Get(testRepoName, sha)
+	if !ok || cached != content {
+		t.Fatalf("expected hit with %q, got %q, %v", content, cached, ok)
+	}
+
+	if _, ok := cache.Get("other/repo", sha); ok {
+		t.Errorf("expected entries to be scoped to their repository")
+	}
+
+	// Content that doesn't hash to its key is never served
+	cache.Put(testRepoName, gitBlobSHA([]byte("SELECT 1;")), "SELECT 2;")
+	if _, ok := cache.Get(testRepoName, gitBlobSHA([]byte("SELECT 1;"))); ok {
+		t.Errorf("expected corrupt entry to be discarded")
+	}
+
+	cache.Put(testRepoName, "../../escape", content)
+	if _, ok := cache.Get(testRepoName, "../../escape"); ok {
+		t.Errorf("expected invalid SHA to be rejected")
+	}
+
+	if stats := cache.Stats(); stats != (FileCacheStats{Hits: 1, Misses: 4}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestGitBlobSHA(t *testing.T) {
+	// git hash-object of "hello\n"
+	if sha := gitBlobSHA([]byte("hello\n")); sha != "ce013625030ba8dba906f756967f9e9ca394464a" {
+		t.Errorf("gitBlobSHA() = %s", sha)
+	}
+}
+
+// fakeBlobSource serves blobs from a map of path to content, counting downloads
+type fakeBlobSource struct {
+	files     map[string]string
+	downloads int
+}
+
+func (s *fakeBlobSource) BlobSHA(_ context.Context, _ *api.RepositoryConfig, metadata FileMetadata) (string, error) {
+	content, ok := s.files[metadata.Path]
+	if !ok {
+		return "", os.ErrNotExist
+	}
+	return gitBlobSHA([]byte(content)), nil
+}
+
+func (s *fakeBlobSource) FetchBlob(_ context.Context, _ *api.RepositoryConfig, sha string) (string, error) {
+	s.downloads++
+	for _, content := range s.files {
+		if gitBlobSHA([]byte(content)) == sha {
+			return content, nil
+		}
+	}
+	return "", os.ErrNotExist
+}
+
+type fakeRepositoryConfigRepository struct{}
+
+func (r fakeRepositoryConfigRepository) GetConfig(context.Context, string) (*api.RepositoryConfig, error) {
+	return nil, nil
+}
+
+func (r fakeRepositoryConfigRepository) ListConfigs(_ context.Context) ([]*api.RepositoryConfig, error) {
+	return nil, nil
+}
+
+func (r fakeRepositoryConfigRepository) UpsertConfig(context.Context, *api.RepositoryConfig) error {
+	return nil
+}
+
+func (r fakeRepositoryConfigRepository) DeleteConfig(context.Context, string) (bool, error) {
+	return false, nil
+}
+
+func (r fakeRepositoryConfigRepository) Close() {}
+
+func TestRepositoryFileFetcherCachesByBlob(t *testing.T) {
+	cacheDir := t.TempDir()
+	source := &fakeBlobSource{files: map[string]string{
+		"db/001.sql":      "SELECT 1;",
+		"db/copy_001.sql": "SELECT 1;",
+		"db/002.sql":      "SELECT 2;",
+	}}
+	fetcher := &RepositoryFileFetcher{
+		configs: fakeRepositoryConfigRepository{},
+		github:  source,
+		cache:   NewDiskFileCache(cacheDir),
+	}
+	hits, misses := testutil.ToFloat64(metrics.FileCacheHits), testutil.ToFloat64(metrics.FileCacheMisses)
+
+	for _, path := range []string{"db/001.sql", "db/copy_001.sql", "db/002.sql", "db/001.sql"} {
+		content, err := fetcher.FetchRawGitFile(context.Background(), FileMetadata{RepoName: testRepoName, Path: path, Commit: testCommit})
+		if err != nil {
+			t.Fatalf("FetchRawGitFile(%s) error = %v", path, err)
+		}
+		if content != source.files[path] {
+			t.Errorf("FetchRawGitFile(%s) = %q", path, content)
+		}
+	}
+
+	if source.downloads != 2 {
+		t.Errorf("expected 2 downloads, got %d", source.downloads)
+	}
+	if stats := fetcher.CacheStats(); stats != (FileCacheStats{Hits: 2, Misses: 2}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if testutil.ToFloat64(metrics.FileCacheHits)-hits != 2 || testutil.ToFloat64(metrics.FileCacheMisses)-misses != 2 {
+		t.Errorf("expected the cache metrics to count 2 hits and 2 misses")
+	}
+
+	// A new fetcher sharing the cache directory, as after a restart, doesn't download anything
+	replay := &RepositoryFileFetcher{
+		configs: fakeRepositoryConfigRepository{},
+		github:  source,
+		cache:   NewDiskFileCache(cacheDir),
+	}
+	if _, err := replay.FetchRawGitFile(context.Background(), FileMetadata{RepoName: testRepoName, Path: "db/002.sql", Commit: testCommit}); err != nil {
+		t.Fatalf("FetchRawGitFile() error = %v", err)
+	}
+	if source.downloads != 2 {
+		t.Errorf("expected replay to be served from cache, got %d downloads", source.downloads)
+	}
+
+	entries, _ := filepath.Glob(filepath.Join(cacheDir, "*", "*", "*"))
+	if len(entries) != 2 {
+		t.Errorf("expected 2 cache entries, got %v", entries)
+	}
+}
@@ -0,0 +1,253 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/dfryer1193/gomad/api"
+)
+
+// emptyTreeHash is git's well-known hash of an empty tree, used to diff the first push to a branch
+const emptyTreeHash = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// zeroCommit is the "before" commit GitHub sends when a push creates a branch
+const zeroCommit = "0000000000000000000000000000000000000000"
+
+// objectNamePattern matches a full git object name. Commits and blobs come from webhooks and the API, so anything
+// else is refused before it can reach git's command line.
+var objectNamePattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// ErrInvalidObjectName is returned for commits and blob SHAs that aren't full git object names
+var ErrInvalidObjectName = errors.New("invalid git object name")
+
+// RemoteResolver returns the git remote URL to mirror for a repository
+type RemoteResolver func(ctx context.Context, repoName string) (string, error)
+
+// GitMirrorFetcher reads files and diffs from bare mirrors of repositories kept on local disk. Mirrors are cloned on
+// first use and fetched whenever a requested commit is missing. Credentials for private remotes come from the remote
+// URL or the git credential helper configured for the gomad process.
+type GitMirrorFetcher struct {
+	baseDir string
+	remotes RemoteResolver
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// NewGitMirrorFetcher creates a fetcher that keeps its mirrors in baseDir
+func NewGitMirrorFetcher(baseDir string, remotes RemoteResolver) *GitMirrorFetcher {
+	return &GitMirrorFetcher{
+		baseDir: baseDir,
+		remotes: remotes,
+		locks:   make(map[string]*sync.Mutex),
+	}
+}
+
+// mirrorBaseDir returns the directory mirrors are kept in, from GOMAD_MIRROR_DIR
+func mirrorBaseDir() string {
+	if dir := os.Getenv("GOMAD_MIRROR_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "gomad", "mirrors")
+}
+
+// DefaultRemoteURL is the remote mirrored for repositories without a configured remote
+func DefaultRemoteURL(repoName string) string {
+	return fmt.Sprintf("https://github.com/%s.git", repoName)
+}
+
+func (f *GitMirrorFetcher) FetchRawGitFile(ctx context.Context, metadata FileMetadata) (string, error) {
+	gitDir, unlock, err := f.mirrorWithCommit(ctx, metadata.RepoName, metadata.Commit)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	content, err := runGit(ctx, gitDir, "cat-file", "blob", "--end-of-options", fmt.Sprintf("%s:%s", metadata.Commit, metadata.Path))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s at %s: %w", metadata.Path, metadata.Commit, err)
+	}
+
+	return string(content), nil
+}
+
+// BlobSHA resolves the SHA of a file's git blob at a commit
+func (f *GitMirrorFetcher) BlobSHA(ctx context.Context, _ *api.RepositoryConfig, metadata FileMetadata) (string, error) {
+	gitDir, unlock, err := f.mirrorWithCommit(ctx, metadata.RepoName, metadata.Commit)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	out, err := runGit(ctx, gitDir, "rev-parse", "--verify", "--quiet", "--end-of-options", fmt.Sprintf("%s:%s", metadata.Commit, metadata.Path))
+	if err != nil {
+		return "", fmt.Errorf("failed to find %s at %s: %w", metadata.Path, metadata.Commit, err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// FetchBlob reads a git blob from the repository's mirror by its SHA
+func (f *GitMirrorFetcher) FetchBlob(ctx context.Context, config *api.RepositoryConfig, sha string) (string, error) {
+	if err := validateObjectName(sha); err != nil {
+		return "", err
+	}
+
+	lock := f.repoLock(config.RepoName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	content, err := runGit(ctx, f.gitDir(config.RepoName), "cat-file", "blob", "--end-of-options", sha)
+	if err != nil {
+		return "", fmt.Errorf("failed to read blob %s: %w", sha, err)
+	}
+
+	return string(content), nil
+}
+
+// ChangedFiles lists the files added or modified between two commits. A zero before commit lists every file in after.
+func (f *GitMirrorFetcher) ChangedFiles(ctx context.Context, repoName string, before string, after string) ([]string, error) {
+	gitDir, unlock, err := f.mirrorWithCommit(ctx, repoName, after)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if before == "" || before == zeroCommit || validateObjectName(before) != nil || !hasCommit(ctx, gitDir, before) {
+		before = emptyTreeHash
+	}
+
+	out, err := runGit(ctx, gitDir, "diff", "--name-only", "--no-renames", "--diff-filter=AM", "--end-of-options", before, after, "--")
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff %s..%s: %w", before, after, err)
+	}
+
+	files := make([]string, 0)
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+
+	return files, nil
+}
+
+// mirrorWithCommit makes sure the repository's mirror exists and contains the commit, returning the mirror's path
+// and a func releasing the lock held on it
+func (f *GitMirrorFetcher) mirrorWithCommit(ctx context.Context, repoName string, commit string) (string, func(), error) {
+	if err := validateObjectName(commit); err != nil {
+		return "", nil, err
+	}
+
+	lock := f.repoLock(repoName)
+	lock.Lock()
+
+	gitDir, err := f.ensureMirror(ctx, repoName, commit)
+	if err != nil {
+		lock.Unlock()
+		return "", nil, err
+	}
+
+	return gitDir, lock.Unlock, nil
+}
+
+func (f *GitMirrorFetcher) ensureMirror(ctx context.Context, repoName string, commit string) (string, error) {
+	remote, err := f.remotes(ctx, repoName)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve remote for repository %s: %w", repoName, err)
+	}
+
+	gitDir := f.gitDir(repoName)
+	if _, err := os.Stat(gitDir); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(f.baseDir, 0o755); err != nil {
+			return "", fmt.Errorf("failed to create mirror directory: %w", err)
+		}
+		if _, err := runGit(ctx, "", "clone", "--mirror", "--quiet", "--end-of-options", remote, gitDir); err != nil {
+			os.RemoveAll(gitDir)
+			return "", fmt.Errorf("failed to mirror repository %s: %w", repoName, err)
+		}
+	} else if err != nil {
+		return "", fmt.Errorf("failed to stat mirror for repository %s: %w", repoName, err)
+	}
+
+	if hasCommit(ctx, gitDir, commit) {
+		return gitDir, nil
+	}
+
+	if _, err := runGit(ctx, gitDir, "remote", "set-url", "--end-of-options", "origin", remote); err != nil {
+		return "", fmt.Errorf("failed to update remote for repository %s: %w", repoName, err)
+	}
+
+	if _, err := runGit(ctx, gitDir, "fetch", "--prune", "--quiet", "origin"); err != nil {
+		return "", fmt.Errorf("failed to fetch repository %s: %w", repoName, err)
+	}
+
+	// The commit may not be reachable from any ref yet, so ask for it directly as a last resort
+	if !hasCommit(ctx, gitDir, commit) {
+		if _, err := runGit(ctx, gitDir, "fetch", "--quiet", "--end-of-options", "origin", commit); err != nil {
+			return "", fmt.Errorf("commit %s not found in repository %s: %w", commit, repoName, err)
+		}
+	}
+
+	return gitDir, nil
+}
+
+func (f *GitMirrorFetcher) gitDir(repoName string) string {
+	return filepath.Join(f.baseDir, url.PathEscape(repoName)+".git")
+}
+
+func (f *GitMirrorFetcher) repoLock(repoName string) *sync.Mutex {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	lock, ok := f.locks[repoName]
+	if !ok {
+		lock = &sync.Mutex{}
+		f.locks[repoName] = lock
+	}
+	return lock
+}
+
+func hasCommit(ctx context.Context, gitDir string, commit string) bool {
+	_, err := runGit(ctx, gitDir, "cat-file", "-e", "--end-of-options", commit+"^{commit}")
+	return err == nil
+}
+
+func validateObjectName(name string) error {
+	if !objectNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidObjectName, name)
+	}
+	return nil
+}
+
+// runGit runs git against gitDir, or in the current directory if gitDir is empty, and returns its stdout. The
+// process is killed if ctx is cancelled. Arguments derived from user input must follow --end-of-options, and only the
+// transports SaveRepository accepts for remotes are allowed.
+func runGit(ctx context.Context, gitDir string, args ...string) ([]byte, error) {
+	// Only the subcommand goes into errors, since other arguments may be remote URLs carrying credentials
+	subcommand := args[0]
+	if gitDir != "" {
+		args = append([]string{"--git-dir", gitDir}, args...)
+	}
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ALLOW_PROTOCOL=https:ssh:file")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", subcommand, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
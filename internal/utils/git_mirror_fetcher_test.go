@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dfryer1193/gomad/api"
+)
+
+// testRemote is a throwaway git repository used as a file:// remote
+type testRemote struct {
+	t   *testing.T
+	dir string
+}
+
+func newTestRemote(t *testing.T) *testRemote {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	r := &testRemote{t: t, dir: t.TempDir()}
+	r.git("init", "--quiet", "--initial-branch", "master")
+	r.git("config", "user.email", "test@example.com")
+	r.git("config", "user.name", "test")
+	return r
+}
+
+func (r *testRemote) git(args ...string) string {
+	r.t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", r.dir}, args...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		r.t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commit writes the files and commits them, returning the new commit hash
+func (r *testRemote) commit(files map[string]string) string {
+	r.t.Helper()
+	for path, content := range files {
+		full := filepath.Join(r.dir, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			r.t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			r.t.Fatalf("write %s: %v", path, err)
+		}
+		r.git("add", path)
+	}
+	r.git("commit", "--quiet", "-m", "test commit")
+	return r.git("rev-parse", "HEAD")
+}
+
+func (r *testRemote) url() string {
+	return "file://" + r.dir
+}
+
+func TestGitMirrorFetcher(t *testing.T) {
+	remote := newTestRemote(t)
+	first := remote.commit(map[string]string{
+		"db/001.sql": "-- :user1:ns1:create\nCREATE TABLE users (id INT);",
+		"README.md":  "readme",
+	})
+
+	fetcher := NewGitMirrorFetcher(t.TempDir(), func(_ context.Context, repoName string) (string, error) {
+		return remote.url(), nil
+	})
+
+	content, err := fetcher.FetchRawGitFile(context.Background(), FileMetadata{RepoName: testRepoName, Path: "db/001.sql", Commit: first})
+	if err != nil {
+		t.Fatalf("FetchRawGitFile() error = %v", err)
+	}
+	if content != "-- :user1:ns1:create\nCREATE TABLE users (id INT);" {
+		t.Errorf("FetchRawGitFile() = %q", content)
+	}
+
+	changed, err := fetcher.ChangedFiles(context.Background(), testRepoName, zeroCommit, first)
+	if err != nil {
+		t.Fatalf("ChangedFiles() error = %v", err)
+	}
+	if !slices.Equal(changed, []string{"README.md", "db/001.sql"}) {
+		t.Errorf("ChangedFiles() for new branch = %v", changed)
+	}
+
+	// A commit pushed after the mirror was cloned has to be fetched
+	second := remote.commit(map[string]string{
+		"db/001.sql": "-- :user1:ns1:create\nCREATE TABLE users (id BIGINT);",
+		"db/002.sql": "-- :user1:ns1:index\nCREATE INDEX users_id ON users (id);",
+	})
+	remote.git("rm", "--quiet", "README.md")
+	remote.git("commit", "--quiet", "-m", "remove readme")
+	third := remote.git("rev-parse", "HEAD")
+
+	changed, err = fetcher.ChangedFiles(context.Background(), testRepoName, first, third)
+	if err != nil {
+		t.Fatalf("ChangedFiles() error = %v", err)
+	}
+	if !slices.Equal(changed, []string{"db/001.sql", "db/002.sql"}) {
+		t.Errorf("ChangedFiles() = %v", changed)
+	}
+
+	content, err = fetcher.FetchRawGitFile(context.Background(), FileMetadata{RepoName: testRepoName, Path: "db/002.sql", Commit: second})
+	if err != nil {
+		t.Fatalf("FetchRawGitFile() error = %v", err)
+	}
+	if !strings.Contains(content, "CREATE INDEX") {
+		t.Errorf("FetchRawGitFile() = %q", content)
+	}
+
+	config := &api.RepositoryConfig{RepoName: testRepoName}
+	sha, err := fetcher.BlobSHA(context.Background(), config, FileMetadata{RepoName: testRepoName, Path: "db/002.sql", Commit: third})
+	if err != nil {
+		t.Fatalf("BlobSHA() error = %v", err)
+	}
+	if sha != gitBlobSHA([]byte(content)) {
+		t.Errorf("BlobSHA() = %s, expected %s", sha, gitBlobSHA([]byte(content)))
+	}
+
+	blob, err := fetcher.FetchBlob(context.Background(), config, sha)
+	if err != nil {
+		t.Fatalf("FetchBlob() error = %v", err)
+	}
+	if blob != content {
+		t.Errorf("FetchBlob() = %q", blob)
+	}
+
+	if _, err := fetcher.FetchRawGitFile(context.Background(), FileMetadata{RepoName: testRepoName, Path: "db/missing.sql", Commit: third}); err == nil {
+		t.Errorf("Expected error fetching missing file")
+	}
+
+	if _, err := fetcher.FetchRawGitFile(context.Background(), FileMetadata{RepoName: testRepoName, Path: "db/001.sql", Commit: strings.Repeat("a", 40)}); err == nil {
+		t.Errorf("Expected error fetching unknown commit")
+	}
+
+	// Names that git could mistake for options never reach it
+	for _, commit := range []string{"--output=/tmp/pwned", "HEAD", strings.Repeat("A", 40), first[:12]} {
+		_, err := fetcher.FetchRawGitFile(context.Background(), FileMetadata{RepoName: testRepoName, Path: "db/001.sql", Commit: commit})
+		if !errors.Is(err, ErrInvalidObjectName) {
+			t.Errorf("expected ErrInvalidObjectName for commit %q, got %v", commit, err)
+		}
+	}
+	if _, err := fetcher.FetchBlob(context.Background(), config, "--batch"); !errors.Is(err, ErrInvalidObjectName) {
+		t.Errorf("expected ErrInvalidObjectName for a blob, got %v", err)
+	}
+
+	// An invalid before commit is diffed like a new branch
+	changed, err = fetcher.ChangedFiles(context.Background(), testRepoName, "--output=/tmp/pwned", first)
+	if err != nil || !slices.Equal(changed, []string{"README.md", "db/001.sql"}) {
+		t.Errorf("ChangedFiles() = %v, %v", changed, err)
+	}
+}
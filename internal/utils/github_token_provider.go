@@ -0,0 +1,329 @@
+package utils
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultGitHubAPIURL = "https://api.github.com"
+	// tokenRefreshMargin is how long before expiry a cached installation token is replaced
+	tokenRefreshMargin = 5 * time.Minute
+	// appJWTLifetime stays under GitHub's 10 minute limit on app JWTs
+	appJWTLifetime = 9 * time.Minute
+	// tokenMintTimeout bounds a token request shared by several callers, which none of their contexts can cancel
+	tokenMintTimeout = 30 * time.Second
+)
+
+// GitHubTokenProvider returns the token used to authenticate GitHub API requests for a repository.
+// An empty token means requests are sent unauthenticated.
+type GitHubTokenProvider interface {
+	Token(ctx context.Context, repoName string) (string, error)
+}
+
+// staticTokenProvider always returns the same token
+type staticTokenProvider struct {
+	token string
+}
+
+func (p *staticTokenProvider) Token(_ context.Context, _ string) (string, error) {
+	return p.token, nil
+}
+
+type installationToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+// gitHubAppTokenProvider authenticates as a GitHub App. It signs a JWT with the app's private key, looks up the
+// app's installation for each repository owner and exchanges the JWT for an installation access token. Installation
+// ids and tokens are cached, and tokens are refreshed shortly before they expire. If the app can't produce a token
+// the static fallback token is used instead, when there is one. Concurrent requests for an owner share one token
+// request, so duplicate tokens aren't minted.
+type gitHubAppTokenProvider struct {
+	appID    string
+	key      *rsa.PrivateKey
+	baseURL  string
+	client   *http.Client
+	fallback string
+	now      func() time.Time
+
+	mu            sync.Mutex
+	installations map[string]int64
+	tokens        map[int64]installationToken
+	minting       singleflight.Group
+}
+
+var (
+	tokenProvider     GitHubTokenProvider
+	tokenProviderOnce sync.Once
+)
+
+// GetGitHubTokenProvider returns a GitHub App token provider when GITHUB_APP_ID and a private key
+// (GITHUB_APP_PRIVATE_KEY or GITHUB_APP_PRIVATE_KEY_FILE) are configured, falling back to GITHUB_TOKEN. Without an
+// app it returns GITHUB_TOKEN for every repository.
+func GetGitHubTokenProvider() GitHubTokenProvider {
+	tokenProviderOnce.Do(func() {
+		static := os.Getenv("GITHUB_TOKEN")
+		appID := os.Getenv("GITHUB_APP_ID")
+		if appID == "" {
+			tokenProvider = &staticTokenProvider{token: static}
+			return
+		}
+
+		key, err := loadAppPrivateKey()
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to load GitHub App private key")
+		}
+
+		baseURL := os.Getenv("GITHUB_API_URL")
+		if baseURL == "" {
+			baseURL = defaultGitHubAPIURL
+		}
+
+		tokenProvider = newGitHubAppTokenProvider(appID, key, baseURL, &http.Client{Timeout: 10 * time.Second}, static)
+	})
+
+	return tokenProvider
+}
+
+func newGitHubAppTokenProvider(appID string, key *rsa.PrivateKey, baseURL string, client *http.Client, fallback string) *gitHubAppTokenProvider {
+	return &gitHubAppTokenProvider{
+		appID:         appID,
+		key:           key,
+		baseURL:       strings.TrimSuffix(baseURL, "/"),
+		client:        client,
+		fallback:      fallback,
+		now:           time.Now,
+		installations: make(map[string]int64),
+		tokens:        make(map[int64]installationToken),
+	}
+}
+
+func (p *gitHubAppTokenProvider) Token(ctx context.Context, repoName string) (string, error) {
+	token, err := p.installationToken(ctx, repoName)
+	if err == nil {
+		return token, nil
+	}
+
+	// A cancelled caller doesn't need a token at all, so there's nothing to fall back for
+	if p.fallback == "" || ctx.Err() != nil {
+		return "", err
+	}
+
+	log.Warn().Err(err).Str("repo", repoName).Msg("falling back to static GitHub token")
+	return p.fallback, nil
+}
+
+func (p *gitHubAppTokenProvider) installationToken(ctx context.Context, repoName string) (string, error) {
+	owner, _, found := strings.Cut(repoName, "/")
+	if !found || owner == "" {
+		return "", fmt.Errorf("invalid repository name %q", repoName)
+	}
+
+	if token, ok := p.cachedToken(owner); ok {
+		return token, nil
+	}
+
+	// The request is shared by every caller waiting on the owner, so it runs on its own deadline rather than the first
+	// caller's. A caller giving up stops waiting, while the others still get the token.
+	results := p.minting.DoChan(owner, func() (any, error) {
+		if token, ok := p.cachedToken(owner); ok {
+			return token, nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), tokenMintTimeout)
+		defer cancel()
+
+		p.mu.Lock()
+		installationID, ok := p.installations[owner]
+		p.mu.Unlock()
+		if !ok {
+			var err error
+			installationID, err = p.findInstallation(ctx, repoName)
+			if err != nil {
+				return "", err
+			}
+
+			p.mu.Lock()
+			p.installations[owner] = installationID
+			p.mu.Unlock()
+		}
+
+		token, err := p.createInstallationToken(ctx, installationID)
+		if err != nil {
+			return "", err
+		}
+
+		p.mu.Lock()
+		p.tokens[installationID] = token
+		p.mu.Unlock()
+		return token.token, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case result := <-results:
+		if result.Err != nil {
+			return "", result.Err
+		}
+		return result.Val.(string), nil
+	}
+}
+
+// cachedToken returns the owner's installation token if it isn't close to expiring
+func (p *gitHubAppTokenProvider) cachedToken(owner string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	installationID, ok := p.installations[owner]
+	if !ok {
+		return "", false
+	}
+
+	cached, ok := p.tokens[installationID]
+	if !ok || !p.now().Add(tokenRefreshMargin).Before(cached.expiresAt) {
+		return "", false
+	}
+
+	return cached.token, true
+}
+
+func (p *gitHubAppTokenProvider) findInstallation(ctx context.Context, repoName string) (int64, error) {
+	var installation struct {
+		ID int64 `json:"id"`
+	}
+	if err := p.appRequest(ctx, http.MethodGet, fmt.Sprintf("/repos/%s/installation", repoName), http.StatusOK, &installation); err != nil {
+		return 0, fmt.Errorf("failed to find app installation for %s: %w", repoName, err)
+	}
+
+	return installation.ID, nil
+}
+
+func (p *gitHubAppTokenProvider) createInstallationToken(ctx context.Context, installationID int64) (installationToken, error) {
+	var response struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	path := fmt.Sprintf("/app/installations/%d/access_tokens", installationID)
+	if err := p.appRequest(ctx, http.MethodPost, path, http.StatusCreated, &response); err != nil {
+		return installationToken{}, fmt.Errorf("failed to create token for installation %d: %w", installationID, err)
+	}
+
+	return installationToken{token: response.Token, expiresAt: response.ExpiresAt}, nil
+}
+
+// appRequest sends a request authenticated as the app itself and decodes the JSON response into out
+func (p *gitHubAppTokenProvider) appRequest(ctx context.Context, method string, path string, wantStatus int, out any) error {
+	jwt, err := p.signJWT()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to %s failed: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != wantStatus {
+		return fmt.Errorf("GitHub API returned status %d for %s", resp.StatusCode, path)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response from %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// signJWT creates the RS256 JWT identifying the app. iat is backdated to allow for clock drift.
+func (p *gitHubAppTokenProvider) signJWT() (string, error) {
+	now := p.now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(appJWTLifetime).Unix(),
+		"iss": p.appID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign app JWT: %w", err)
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func loadAppPrivateKey() (*rsa.PrivateKey, error) {
+	pemData := []byte(os.Getenv("GITHUB_APP_PRIVATE_KEY"))
+	if len(pemData) == 0 {
+		path := os.Getenv("GITHUB_APP_PRIVATE_KEY_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("GITHUB_APP_PRIVATE_KEY or GITHUB_APP_PRIVATE_KEY_FILE is required with GITHUB_APP_ID")
+		}
+
+		var err error
+		pemData, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key: %w", err)
+		}
+	}
+
+	return parseRSAPrivateKey(pemData)
+}
+
+// parseRSAPrivateKey accepts the PKCS#1 keys GitHub issues as well as PKCS#8
+func parseRSAPrivateKey(pemData []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, fmt.Errorf("private key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not an RSA key")
+	}
+	return key, nil
+}
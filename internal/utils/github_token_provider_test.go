@@ -0,0 +1,281 @@
+package utils
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeGitHubApp serves the installation endpoints of the GitHub API, checking that requests carry a JWT signed by
+// the app's key
+type fakeGitHubApp struct {
+	key           *rsa.PrivateKey
+	installations map[string]int64
+	tokenLifetime time.Duration
+	now           func() time.Time
+	lookups       atomic.Int32
+	tokensIssued  atomic.Int32
+}
+
+func (f *fakeGitHubApp) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !f.validJWT(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/repos/") && strings.HasSuffix(r.URL.Path, "/installation"):
+		f.lookups.Add(1)
+		owner := strings.Split(strings.TrimPrefix(r.URL.Path, "/repos/"), "/")[0]
+		id, ok := f.installations[owner]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]int64{"id": id})
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/app/installations/"):
+		n := f.tokensIssued.Add(1)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{
+			"token":      fmt.Sprintf("%s-token-%d", strings.Split(r.URL.Path, "/")[3], n),
+			"expires_at": f.now().Add(f.tokenLifetime).UTC().Format(time.RFC3339),
+		})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeGitHubApp) validJWT(jwt string) bool {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return false
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if rsa.VerifyPKCS1v15(&f.key.PublicKey, crypto.SHA256, digest[:], signature) != nil {
+		return false
+	}
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	var claims struct {
+		Iss string `json:"iss"`
+		Exp int64  `json:"exp"`
+	}
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return false
+	}
+
+	return claims.Iss == "1234" && claims.Exp > f.now().Unix()
+}
+
+func newTestAppKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return key
+}
+
+func TestGitHubAppTokenProvider(t *testing.T) {
+	key := newTestAppKey(t)
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	fake := &fakeGitHubApp{
+		key:           key,
+		installations: map[string]int64{"acme": 42},
+		tokenLifetime: time.Hour,
+		now:           clock,
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	provider := newGitHubAppTokenProvider("1234", key, server.URL, server.Client(), "")
+	provider.now = clock
+
+	token, err := provider.Token(context.Background(), "acme/app")
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if token != "42-token-1" {
+		t.Errorf("Token() = %s, want 42-token-1", token)
+	}
+
+	// Another repository from the same owner reuses the installation and its token
+	token, err = provider.Token(context.Background(), "acme/other")
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if token != "42-token-1" {
+		t.Errorf("Token() = %s, want cached 42-token-1", token)
+	}
+	if fake.lookups.Load() != 1 || fake.tokensIssued.Load() != 1 {
+		t.Errorf("Expected 1 lookup and 1 token, got %d lookups and %d tokens", fake.lookups.Load(), fake.tokensIssued.Load())
+	}
+
+	// Close to expiry the token is refreshed
+	now = now.Add(time.Hour - tokenRefreshMargin + time.Second)
+	token, err = provider.Token(context.Background(), "acme/app")
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if token != "42-token-2" {
+		t.Errorf("Token() = %s, want refreshed 42-token-2", token)
+	}
+
+	if _, err := provider.Token(context.Background(), "stranger/app"); err == nil {
+		t.Errorf("Expected error for owner without an installation")
+	}
+}
+
+func TestGitHubAppTokenProviderFallback(t *testing.T) {
+	key := newTestAppKey(t)
+	fake := &fakeGitHubApp{
+		key:           key,
+		installations: map[string]int64{},
+		tokenLifetime: time.Hour,
+		now:           time.Now,
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	provider := newGitHubAppTokenProvider("1234", key, server.URL, server.Client(), "static-token")
+
+	token, err := provider.Token(context.Background(), "acme/app")
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if token != "static-token" {
+		t.Errorf("Token() = %s, want static-token", token)
+	}
+
+	// A JWT signed with the wrong key is rejected, so the fallback is used too
+	provider = newGitHubAppTokenProvider("1234", newTestAppKey(t), server.URL, server.Client(), "static-token")
+	fake.installations["acme"] = 42
+	if token, _ := provider.Token(context.Background(), "acme/app"); token != "static-token" {
+		t.Errorf("Token() = %s, want static-token", token)
+	}
+}
+
+func TestGitHubAppTokenProviderDoesNotBlockOnSlowRequests(t *testing.T) {
+	key := newTestAppKey(t)
+	fake := &fakeGitHubApp{
+		key:           key,
+		installations: map[string]int64{"acme": 42, "slow": 7},
+		tokenLifetime: time.Hour,
+		now:           time.Now,
+	}
+	stalled := make(chan struct{})
+	var stallOnce sync.Once
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	unblock := func() { releaseOnce.Do(func() { close(release) }) }
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/repos/slow/") {
+			stallOnce.Do(func() { close(stalled) })
+			select {
+			case <-release:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	defer unblock()
+
+	provider := newGitHubAppTokenProvider("1234", key, server.URL, server.Client(), "static-token")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := provider.Token(ctx, "slow/app")
+		done <- err
+	}()
+	<-stalled
+
+	// Other owners are served while the stalled request is in flight
+	token, err := provider.Token(context.Background(), "acme/app")
+	if err != nil || token != "42-token-1" {
+		t.Errorf("Token() = %s, %v, want 42-token-1", token, err)
+	}
+
+	// Cancelling the caller abandons the request instead of falling back to the static token
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Token() didn't return after its context was cancelled")
+	}
+
+	// The request carries on for callers still waiting on the same owner
+	followed := make(chan string, 1)
+	go func() {
+		token, err := provider.Token(context.Background(), "slow/db")
+		if err != nil {
+			t.Errorf("Token() error = %v", err)
+		}
+		followed <- token
+	}()
+	unblock()
+
+	select {
+	case token := <-followed:
+		if token != "7-token-2" {
+			t.Errorf("Token() = %s, want 7-token-2", token)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Token() didn't return once the request completed")
+	}
+	if lookups := fake.lookups.Load(); lookups != 2 {
+		t.Errorf("expected one installation lookup per owner, got %d", lookups)
+	}
+}
+
+func TestParseRSAPrivateKey(t *testing.T) {
+	key := newTestAppKey(t)
+
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if _, err := parseRSAPrivateKey(pkcs1); err != nil {
+		t.Errorf("Expected PKCS#1 key to parse, got %v", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	pkcs8 := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if _, err := parseRSAPrivateKey(pkcs8); err != nil {
+		t.Errorf("Expected PKCS#8 key to parse, got %v", err)
+	}
+
+	if _, err := parseRSAPrivateKey([]byte("not a key")); err == nil {
+		t.Errorf("Expected error for non-PEM key")
+	}
+}
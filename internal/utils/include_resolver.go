@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/dfryer1193/gomad/api"
+)
+
+// maxIncludeDepth limits how deeply included files may include other files
+const maxIncludeDepth = 5
+
+const includeDirective = "include"
+
+// parseIncludeDirective returns the path of an include directive line in the format "--@ include=path", and false
+// if the line is some other directive
+func parseIncludeDirective(line string) (string, bool, error) {
+	input := strings.TrimSpace(strings.TrimPrefix(line, directivePrefix))
+	key, value, found := strings.Cut(input, "=")
+	if !found || strings.ToLower(strings.TrimSpace(key)) != includeDirective {
+		return "", false, nil
+	}
+
+	includePath := strings.TrimSpace(value)
+	if includePath == "" {
+		return "", true, fmt.Errorf("invalid migration directive: include path is empty: %s", line)
+	}
+
+	return includePath, true, nil
+}
+
+// includeResolver replaces include directives with the content of the included files, fetched from the same
+// repository at the same commit as the migration file. Fetched files are cached for the lifetime of the resolver.
+type includeResolver struct {
+	ctx      context.Context
+	fetcher  gitFileFetcher
+	repoName string
+	commit   string
+	contents map[string]string
+}
+
+func newIncludeResolver(ctx context.Context, fetcher gitFileFetcher, repoName string, commit string) *includeResolver {
+	return &includeResolver{
+		ctx:      ctx,
+		fetcher:  fetcher,
+		repoName: repoName,
+		commit:   commit,
+		contents: make(map[string]string),
+	}
+}
+
+// resolve expands the include directives in a migration found in the file at migrationPath, recording every file
+// it pulled in on the migration
+func (r *includeResolver) resolve(migration *api.MigrationProto, migrationPath string) error {
+	var includes []string
+	ddl, err := r.expand(migration.DDL, []string{migrationPath}, &includes)
+	if err != nil {
+		return fmt.Errorf("failed to resolve includes for migration %q: %w", migration.Comment, err)
+	}
+
+	migration.DDL = strings.TrimSpace(ddl)
+	migration.Includes = includes
+	return nil
+}
+
+// expand replaces include directives in content. stack holds the chain of files being expanded, ending with the
+// file content came from, and is used to detect cycles and enforce the depth limit.
+func (r *includeResolver) expand(content string, stack []string, includes *[]string) (string, error) {
+	current := stack[len(stack)-1]
+
+	var out strings.Builder
+	for _, line := range strings.Split(content, "\n") {
+		if !strings.HasPrefix(line, directivePrefix) {
+			out.WriteString(line)
+			out.WriteString("\n")
+			continue
+		}
+
+		includePath, ok, err := parseIncludeDirective(line)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", fmt.Errorf("only include directives are allowed in included file %s: %s", current, line)
+		}
+
+		resolved, err := resolveIncludePath(current, includePath)
+		if err != nil {
+			return "", err
+		}
+
+		for _, including := range stack {
+			if including == resolved {
+				return "", fmt.Errorf("include cycle: %s -> %s", strings.Join(stack, " -> "), resolved)
+			}
+		}
+
+		if len(stack) > maxIncludeDepth {
+			return "", fmt.Errorf("includes nested more than %d deep: %s -> %s", maxIncludeDepth, strings.Join(stack, " -> "), resolved)
+		}
+
+		included, err := r.fetch(resolved)
+		if err != nil {
+			return "", err
+		}
+
+		if !containsPath(*includes, resolved) {
+			*includes = append(*includes, resolved)
+		}
+
+		expanded, err := r.expand(included, append(stack, resolved), includes)
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(strings.TrimRight(expanded, "\n"))
+		out.WriteString("\n")
+	}
+
+	return out.String(), nil
+}
+
+func (r *includeResolver) fetch(filePath string) (string, error) {
+	if content, ok := r.contents[filePath]; ok {
+		return content, nil
+	}
+
+	content, err := r.fetcher.FetchRawGitFile(r.ctx, FileMetadata{
+		RepoName: r.repoName,
+		Path:     filePath,
+		Commit:   r.commit,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch included file %s: %w", filePath, err)
+	}
+
+	r.contents[filePath] = content
+	return content, nil
+}
+
+// resolveIncludePath resolves an include relative to the directory of the including file. Paths starting with /
+// are relative to the repository root.
+func resolveIncludePath(includingFile string, includePath string) (string, error) {
+	var resolved string
+	if strings.HasPrefix(includePath, "/") {
+		resolved = path.Clean(strings.TrimPrefix(includePath, "/"))
+	} else {
+		resolved = path.Join(path.Dir(includingFile), includePath)
+	}
+
+	if resolved == "." || resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return "", fmt.Errorf("include path %s escapes the repository", includePath)
+	}
+
+	return resolved, nil
+}
+
+func containsPath(paths []string, p string) bool {
+	for _, existing := range paths {
+		if existing == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dfryer1193/gomad/api"
+)
+
+// directivePrefix marks a line inside a migration body that configures the migration rather than being part of its DDL
+const directivePrefix = "--@"
+
+var environmentNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// applyDirective parses a directive line in the format "--@ key=value" and applies it to the migration
+func applyDirective(migration *api.MigrationProto, line string) error {
+	input := strings.TrimSpace(strings.TrimPrefix(line, directivePrefix))
+
+	key, value, found := strings.Cut(input, "=")
+	if !found {
+		return fmt.Errorf("invalid migration directive: expected key=value: %s", line)
+	}
+	key = strings.ToLower(strings.TrimSpace(key))
+	value = strings.TrimSpace(value)
+
+	if value == "" {
+		return fmt.Errorf("invalid migration directive: value is empty: %s", line)
+	}
+
+	switch key {
+	case "lock_timeout":
+		return setTimeout(&migration.Settings.LockTimeout, value, line)
+	case "statement_timeout":
+		return setTimeout(&migration.Settings.StatementTimeout, value, line)
+	case "idle_in_transaction_session_timeout":
+		return setTimeout(&migration.Settings.IdleInTransactionSessionTimeout, value, line)
+	case "environments":
+		return setEnvironments(migration, value, line)
+	default:
+		return fmt.Errorf("invalid migration directive: unknown directive %q: %s", key, line)
+	}
+}
+
+func setTimeout(target *string, value string, line string) error {
+	if err := ValidateTimeout(value); err != nil {
+		return fmt.Errorf("invalid migration directive: %w: %s", err, line)
+	}
+
+	*target = value
+	return nil
+}
+
+func setEnvironments(migration *api.MigrationProto, value string, line string) error {
+	environments := make([]string, 0)
+	for _, env := range strings.Split(value, ",") {
+		env = strings.ToLower(strings.TrimSpace(env))
+		if err := ValidateEnvironmentName(env); err != nil {
+			return fmt.Errorf("invalid migration directive: %w: %s", err, line)
+		}
+		environments = append(environments, env)
+	}
+
+	migration.Environments = environments
+	return nil
+}
+
+// validateTargets checks that a wildcard namespace is narrowed down by environments
+func validateTargets(migration *api.MigrationProto) error {
+	if migration.Namespace == api.AllNamespaces && len(migration.Environments) == 0 {
+		return fmt.Errorf("invalid migration: namespace %s requires an environments directive: %s", api.AllNamespaces, migration.Comment)
+	}
+	return nil
+}
+
+// ValidateEnvironmentName checks that an environment is a lowercase name made of letters, digits, - and _
+func ValidateEnvironmentName(env string) error {
+	if !environmentNamePattern.MatchString(env) {
+		return fmt.Errorf("invalid environment %q", env)
+	}
+	return nil
+}
+
+// ValidateTimeout checks that value is a positive duration in Go duration syntax
+func ValidateTimeout(value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid timeout %q", value)
+	}
+
+	if d <= 0 {
+		return fmt.Errorf("timeout %q must be positive", value)
+	}
+
+	return nil
+}
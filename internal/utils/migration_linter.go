@@ -0,0 +1,245 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/dfryer1193/gomad/api"
+	dataUtils "github.com/dfryer1193/gomad/internal/data/utils"
+)
+
+// largeTableRows is the estimated row count above which a table is treated as large
+const largeTableRows = 100_000
+
+// TableSizeEstimator returns the estimated number of rows in a table, and whether the estimate is known.
+// Tables with unknown sizes are treated as large.
+type TableSizeEstimator func(table string) (rows int64, known bool)
+
+type lintRule struct {
+	name            string
+	defaultSeverity api.LintSeverity
+	check           func(stmt string, estimate TableSizeEstimator) []string
+}
+
+var (
+	alterTablePattern      = regexp.MustCompile(`(?i)^ALTER TABLE (?:IF EXISTS )?(?:ONLY )?(\S+) (.*)$`)
+	dropActionPattern      = regexp.MustCompile(`(?i)^DROP (?:COLUMN )?(?:IF EXISTS )?(\S+)`)
+	alterTypePattern       = regexp.MustCompile(`(?i)^ALTER (?:COLUMN )?(\S+) (?:SET DATA )?TYPE `)
+	addColumnPattern       = regexp.MustCompile(`(?i)^ADD (?:COLUMN )?(?:IF NOT EXISTS )?(\S+)`)
+	notNullPattern         = regexp.MustCompile(`(?i)\bNOT NULL\b`)
+	defaultPattern         = regexp.MustCompile(`(?i)\bDEFAULT\b`)
+	dropTablePattern       = regexp.MustCompile(`(?i)^DROP TABLE\b`)
+	truncatePattern        = regexp.MustCompile(`(?i)^TRUNCATE\b`)
+	createIndexPattern     = regexp.MustCompile(`(?i)^CREATE (?:UNIQUE )?INDEX (CONCURRENTLY )?`)
+	indexTablePattern      = regexp.MustCompile(`(?i) ON (?:ONLY )?([^\s(]+)`)
+	createObjectPattern    = regexp.MustCompile(`(?i)^CREATE (?:(?:UNIQUE|TEMP|TEMPORARY|UNLOGGED) )?(TABLE|INDEX|SCHEMA|SEQUENCE|EXTENSION|MATERIALIZED VIEW)\b`)
+	ifNotExistsPattern     = regexp.MustCompile(`(?i)\bIF NOT EXISTS\b`)
+	addConstraintKeywords  = []string{"CONSTRAINT", "PRIMARY", "UNIQUE", "FOREIGN", "CHECK", "EXCLUDE"}
+	dropConstraintKeywords = []string{"CONSTRAINT"}
+)
+
+var lintRules = []lintRule{
+	{
+		name:            "drop-table",
+		defaultSeverity: api.LintSeverityError,
+		check: func(stmt string, _ TableSizeEstimator) []string {
+			if dropTablePattern.MatchString(stmt) {
+				return []string{"dropping a table destroys its data"}
+			}
+			return nil
+		},
+	},
+	{
+		name:            "drop-column",
+		defaultSeverity: api.LintSeverityError,
+		check: func(stmt string, _ TableSizeEstimator) []string {
+			return checkAlterTableActions(stmt, func(table string, action string) string {
+				match := dropActionPattern.FindStringSubmatch(action)
+				if match == nil || hasKeyword(match[1], dropConstraintKeywords) {
+					return ""
+				}
+				return fmt.Sprintf("dropping column %s from %s destroys its data", match[1], table)
+			})
+		},
+	},
+	{
+		name:            "alter-column-type",
+		defaultSeverity: api.LintSeverityWarning,
+		check: func(stmt string, _ TableSizeEstimator) []string {
+			return checkAlterTableActions(stmt, func(table string, action string) string {
+				match := alterTypePattern.FindStringSubmatch(action)
+				if match == nil {
+					return ""
+				}
+				return fmt.Sprintf("changing the type of %s.%s may rewrite the table under an exclusive lock", table, match[1])
+			})
+		},
+	},
+	{
+		name:            "add-not-null-column-without-default",
+		defaultSeverity: api.LintSeverityError,
+		check: func(stmt string, _ TableSizeEstimator) []string {
+			return checkAlterTableActions(stmt, func(table string, action string) string {
+				match := addColumnPattern.FindStringSubmatch(action)
+				if match == nil || hasKeyword(match[1], addConstraintKeywords) {
+					return ""
+				}
+				if !notNullPattern.MatchString(action) || defaultPattern.MatchString(action) {
+					return ""
+				}
+				return fmt.Sprintf("adding NOT NULL column %s to %s without a default fails on non-empty tables", match[1], table)
+			})
+		},
+	},
+	{
+		name:            "create-index-not-concurrently",
+		defaultSeverity: api.LintSeverityWarning,
+		check: func(stmt string, estimate TableSizeEstimator) []string {
+			match := createIndexPattern.FindStringSubmatch(stmt)
+			if match == nil || match[1] != "" {
+				return nil
+			}
+
+			table := ""
+			if tableMatch := indexTablePattern.FindStringSubmatch(stmt); tableMatch != nil {
+				table = tableMatch[1]
+			}
+			if estimate != nil && table != "" {
+				if rows, known := estimate(table); known && rows < largeTableRows {
+					return nil
+				}
+			}
+			return []string{fmt.Sprintf("creating an index on %s without CONCURRENTLY blocks writes while it builds", table)}
+		},
+	},
+	{
+		name:            "truncate",
+		defaultSeverity: api.LintSeverityError,
+		check: func(stmt string, _ TableSizeEstimator) []string {
+			if truncatePattern.MatchString(stmt) {
+				return []string{"truncating a table destroys its data"}
+			}
+			return nil
+		},
+	},
+	{
+		name:            "missing-if-not-exists",
+		defaultSeverity: api.LintSeverityWarning,
+		check: func(stmt string, _ TableSizeEstimator) []string {
+			match := createObjectPattern.FindStringSubmatch(stmt)
+			if match == nil || ifNotExistsPattern.MatchString(stmt) {
+				return nil
+			}
+			return []string{fmt.Sprintf("CREATE %s without IF NOT EXISTS is not safe to re-run", strings.ToUpper(match[1]))}
+		},
+	},
+}
+
+type MigrationLinter struct{}
+
+func GetMigrationLinter() *MigrationLinter {
+	return &MigrationLinter{}
+}
+
+// Lint checks each statement in the DDL against the lint rules. Severities default to each rule's own and can be
+// overridden by rule name; rules set to off are skipped. estimate may be nil when no table sizes are available.
+func (l *MigrationLinter) Lint(ddl string, severities map[string]api.LintSeverity, estimate TableSizeEstimator) []api.LintFinding {
+	findings := make([]api.LintFinding, 0)
+	for _, stmt := range dataUtils.SplitStatements(ddl) {
+		normalized := strings.Join(strings.Fields(stmt), " ")
+		for _, rule := range lintRules {
+			severity := rule.defaultSeverity
+			if override, ok := severities[rule.name]; ok {
+				severity = override
+			}
+			if severity == api.LintSeverityOff {
+				continue
+			}
+
+			for _, message := range rule.check(normalized, estimate) {
+				findings = append(findings, api.LintFinding{
+					Rule:      rule.name,
+					Severity:  severity,
+					Message:   message,
+					Statement: normalized,
+				})
+			}
+		}
+	}
+
+	return findings
+}
+
+// ValidateLintRules checks that every overridden rule exists and has a known severity
+func ValidateLintRules(severities map[string]api.LintSeverity) error {
+	for name, severity := range severities {
+		if !isLintRule(name) {
+			return fmt.Errorf("unknown lint rule %q", name)
+		}
+
+		switch severity {
+		case api.LintSeverityOff, api.LintSeverityWarning, api.LintSeverityError:
+		default:
+			return fmt.Errorf("invalid severity %q for lint rule %q", severity, name)
+		}
+	}
+
+	return nil
+}
+
+func isLintRule(name string) bool {
+	for _, rule := range lintRules {
+		if rule.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAlterTableActions runs check against each comma-separated action of an ALTER TABLE statement
+func checkAlterTableActions(stmt string, check func(table string, action string) string) []string {
+	match := alterTablePattern.FindStringSubmatch(stmt)
+	if match == nil {
+		return nil
+	}
+
+	var messages []string
+	for _, action := range splitTopLevel(match[2], ',') {
+		if message := check(match[1], strings.TrimSpace(action)); message != "" {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// splitTopLevel splits s on sep, ignoring separators inside parentheses or quotes
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\'', '"':
+			i = dataUtils.ClosingQuote(s, i+1, s[i]) - 1
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func hasKeyword(word string, keywords []string) bool {
+	for _, keyword := range keywords {
+		if strings.EqualFold(word, keyword) {
+			return true
+		}
+	}
+	return false
+}
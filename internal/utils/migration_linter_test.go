@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/dfryer1193/gomad/api"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name       string
+		ddl        string
+		severities map[string]api.LintSeverity
+		estimate   TableSizeEstimator
+		wantRules  []string
+	}{
+		{
+			name:      "safe migration",
+			ddl:       "CREATE TABLE IF NOT EXISTS users (id INT);",
+			wantRules: []string{},
+		},
+		{
+			name:      "drop table",
+			ddl:       "DROP TABLE users;",
+			wantRules: []string{"drop-table"},
+		},
+		{
+			name:      "drop column without keyword",
+			ddl:       "ALTER TABLE users DROP name, DROP CONSTRAINT users_pkey;",
+			wantRules: []string{"drop-column"},
+		},
+		{
+			name:      "alter column type",
+			ddl:       "ALTER TABLE users ALTER COLUMN id TYPE BIGINT;",
+			wantRules: []string{"alter-column-type"},
+		},
+		{
+			name:      "not null column without default",
+			ddl:       "ALTER TABLE users ADD COLUMN name TEXT NOT NULL, ADD COLUMN email TEXT NOT NULL DEFAULT '';",
+			wantRules: []string{"add-not-null-column-without-default"},
+		},
+		{
+			name:      "non-concurrent index on unknown table",
+			ddl:       "CREATE INDEX IF NOT EXISTS users_name ON users (name);",
+			wantRules: []string{"create-index-not-concurrently"},
+		},
+		{
+			name: "non-concurrent index on small table",
+			ddl:  "CREATE INDEX IF NOT EXISTS users_name ON users (name);",
+			estimate: func(_ string) (int64, bool) {
+				return 10, true
+			},
+			wantRules: []string{},
+		},
+		{
+			name:      "concurrent index",
+			ddl:       "CREATE INDEX CONCURRENTLY IF NOT EXISTS users_name ON users (name);",
+			wantRules: []string{},
+		},
+		{
+			name:      "truncate",
+			ddl:       "TRUNCATE users;",
+			wantRules: []string{"truncate"},
+		},
+		{
+			name:      "missing if not exists",
+			ddl:       "CREATE TABLE users (id INT);",
+			wantRules: []string{"missing-if-not-exists"},
+		},
+		{
+			name:       "rule turned off",
+			ddl:        "DROP TABLE users;",
+			severities: map[string]api.LintSeverity{"drop-table": api.LintSeverityOff},
+			wantRules:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := GetMigrationLinter().Lint(tt.ddl, tt.severities, tt.estimate)
+			if len(got) != len(tt.wantRules) {
+				t.Fatalf("Lint() = %+v, want rules %v", got, tt.wantRules)
+			}
+			for i := range got {
+				if got[i].Rule != tt.wantRules[i] {
+					t.Errorf("finding[%d] rule = %s, want %s", i, got[i].Rule, tt.wantRules[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateLintRules(t *testing.T) {
+	if err := ValidateLintRules(map[string]api.LintSeverity{"drop-table": api.LintSeverityWarning}); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if err := ValidateLintRules(map[string]api.LintSeverity{"no-such-rule": api.LintSeverityWarning}); err == nil {
+		t.Errorf("Expected error for unknown rule")
+	}
+	if err := ValidateLintRules(map[string]api.LintSeverity{"drop-table": "fatal"}); err == nil {
+		t.Errorf("Expected error for unknown severity")
+	}
+}
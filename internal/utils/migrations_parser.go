@@ -2,8 +2,15 @@ package utils
 
 import (
 	"bufio"
+	"context"
+	"encoding/binary"
 	"fmt"
 	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/metrics"
+	"github.com/dfryer1193/gomad/internal/tracing"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
 	"hash/fnv"
 	"strings"
 	"time"
@@ -18,6 +25,9 @@ func GetMigrationFileParser() *MigrationFileParser {
 // ParseSQL parses a SQL file content into a slice of MigrationProto structs.
 // The SQL file should have migrations in the format:
 // -- skip?:user:namespace:comment
+// --@ directive=value (optional, any number)
+// --@ include=path (optional, replaced by the content of another file in the repository)
+// A namespace of * targets every namespace in the environments given by the environments directive.
 // SQL statements...
 func (p *MigrationFileParser) ParseSQL(content string) ([]api.MigrationProto, error) {
 	var migrations []api.MigrationProto
@@ -35,12 +45,36 @@ func (p *MigrationFileParser) ParseSQL(content string) ([]api.MigrationProto, er
 			continue
 		}
 
+		if strings.HasPrefix(line, directivePrefix) {
+			if currentMigration == nil {
+				return nil, fmt.Errorf("invalid migration: directive before migration header: %s", line)
+			}
+
+			// Includes stay in the DDL so they can be resolved in place once the file's repository is known
+			if _, isInclude, err := parseIncludeDirective(line); isInclude {
+				if err != nil {
+					return nil, err
+				}
+				ddlBuilder.WriteString(line)
+				ddlBuilder.WriteString("\n")
+				continue
+			}
+
+			if err := applyDirective(currentMigration, line); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
 		if strings.HasPrefix(line, "--") {
 			if currentMigration != nil && ddlBuilder.Len() == 0 {
 				return nil, fmt.Errorf("invalid migration: migration header without SQL content: %s", currentMigration.Comment)
 			}
 
 			if currentMigration != nil && ddlBuilder.Len() > 0 {
+				if err := validateTargets(currentMigration); err != nil {
+					return nil, err
+				}
 				currentMigration.DDL = strings.TrimSpace(ddlBuilder.String())
 				migrations = append(migrations, *currentMigration)
 				ddlBuilder.Reset()
@@ -70,6 +104,9 @@ func (p *MigrationFileParser) ParseSQL(content string) ([]api.MigrationProto, er
 
 	// Add the last migration if exists
 	if currentMigration != nil && ddlBuilder.Len() > 0 {
+		if err := validateTargets(currentMigration); err != nil {
+			return nil, err
+		}
 		currentMigration.DDL = strings.TrimSpace(ddlBuilder.String())
 		migrations = append(migrations, *currentMigration)
 	}
@@ -139,13 +176,27 @@ func generateSignature(header string) uint64 {
 	return h.Sum64()
 }
 
+// NamespaceSignature derives the signature of a copy of a migration targeted at a specific namespace, so each
+// namespace's copy is tracked separately
+func NamespaceSignature(signature uint64, namespace string) uint64 {
+	h := fnv.New64a()
+	h.Write(binary.BigEndian.AppendUint64(nil, signature))
+	h.Write([]byte(namespace))
+	return h.Sum64()
+}
+
 type MigrationFileProcessor struct {
 	fileFetcher gitFileFetcher
 	fileParser  sqlFileParser
 }
 
 type gitFileFetcher interface {
-	FetchRawGitFile(metadata FileMetadata) (string, error)
+	FetchRawGitFile(ctx context.Context, metadata FileMetadata) (string, error)
+}
+
+// changedFileLister is implemented by fetchers that can diff commits themselves
+type changedFileLister interface {
+	ChangedFiles(ctx context.Context, repoName string, before string, after string) ([]string, bool, error)
 }
 
 type sqlFileParser interface {
@@ -154,27 +205,102 @@ type sqlFileParser interface {
 
 func GetMigrationFileProcessor() *MigrationFileProcessor {
 	return &MigrationFileProcessor{
-		fileFetcher: GetGitFileFetcher(),
+		fileFetcher: GetRepositoryFileFetcher(),
 		fileParser:  GetMigrationFileParser(),
 	}
 }
 
-// ProcessFile handles fetching and parsing migration files
-func (fp *MigrationFileProcessor) ProcessFile(repoName string, path string, commit string) ([]api.MigrationProto, error) {
+// ProcessFile handles fetching and parsing migration files. Fetching stops once ctx is cancelled.
+func (fp *MigrationFileProcessor) ProcessFile(ctx context.Context, repoName string, path string, commit string) ([]api.MigrationProto, error) {
+	ctx, span := tracing.Start(ctx, "migration_file.process",
+		attribute.String("gomad.repo", repoName), attribute.String("gomad.path", path), attribute.String("gomad.commit", commit))
+	migrations, err := fp.processFile(ctx, repoName, path, commit)
+	span.SetAttributes(attribute.Int("gomad.migrations", len(migrations)))
+	tracing.End(span, err)
+
+	return migrations, err
+}
+
+func (fp *MigrationFileProcessor) processFile(ctx context.Context, repoName string, path string, commit string) ([]api.MigrationProto, error) {
 	metadata := &FileMetadata{
 		RepoName: repoName,
 		Path:     path,
 		Commit:   commit,
 	}
-	content, err := fp.fileFetcher.FetchRawGitFile(*metadata)
+	content, err := fp.fileFetcher.FetchRawGitFile(ctx, *metadata)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch file %s: %w", metadata.Path, err)
 	}
 
+	_, parseSpan := tracing.Start(ctx, "migration_file.parse")
 	foundMigrations, err := fp.fileParser.ParseSQL(content)
+	tracing.End(parseSpan, err)
 	if err != nil {
+		metrics.ParseErrors.Inc()
 		return nil, fmt.Errorf("error parsing sql file %s: %w", metadata.Path, err)
 	}
 
+	resolver := newIncludeResolver(ctx, fp.fileFetcher, repoName, commit)
+	for idx := range foundMigrations {
+		foundMigrations[idx].Repo = repoName
+		if err := resolver.resolve(&foundMigrations[idx], path); err != nil {
+			return nil, fmt.Errorf("error processing sql file %s: %w", metadata.Path, err)
+		}
+	}
+
 	return foundMigrations, nil
 }
+
+// maxConcurrentFetches bounds how many files ProcessFiles fetches at once
+const maxConcurrentFetches = 8
+
+// cacheStatsReporter is implemented by fetchers that cache file contents
+type cacheStatsReporter interface {
+	CacheStats() FileCacheStats
+}
+
+// ProcessFiles fetches and parses several migration files from the same commit concurrently, returning their
+// migrations in the order the files were given. Processing stops at the first file that fails.
+func (fp *MigrationFileProcessor) ProcessFiles(ctx context.Context, repoName string, paths []string, commit string) ([]api.MigrationProto, error) {
+	results := make([][]api.MigrationProto, len(paths))
+
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(maxConcurrentFetches)
+	for idx, filePath := range paths {
+		group.Go(func() error {
+			migrations, err := fp.ProcessFile(groupCtx, repoName, filePath, commit)
+			if err != nil {
+				return err
+			}
+			results[idx] = migrations
+			return nil
+		})
+	}
+
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+
+	if reporter, ok := fp.fileFetcher.(cacheStatsReporter); ok {
+		stats := reporter.CacheStats()
+		log.Debug().Str("repo", repoName).Int("files", len(paths)).Uint64("cacheHits", stats.Hits).
+			Uint64("cacheMisses", stats.Misses).Msg("processed migration files")
+	}
+
+	migrations := make([]api.MigrationProto, 0)
+	for _, fileMigrations := range results {
+		migrations = append(migrations, fileMigrations...)
+	}
+	return migrations, nil
+}
+
+// ChangedFiles lists the files added or modified between two commits when the repository's file source can diff
+// commits. It returns false otherwise.
+func (fp *MigrationFileProcessor) ChangedFiles(ctx context.Context, repoName string, before string, after string) ([]string, bool, error) {
+	lister, ok := fp.fileFetcher.(changedFileLister)
+	if !ok {
+		return nil, false, nil
+	}
+
+	return lister.ChangedFiles(ctx, repoName, before, after)
+}
@@ -1,8 +1,11 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"github.com/dfryer1193/gomad/api"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -173,6 +176,129 @@ CREATE TABLE users;`,
 	}
 }
 
+func TestParseSQLDirectives(t *testing.T) {
+	tests := []struct {
+		name             string
+		content          string
+		want             api.SessionSettings
+		wantEnvironments []string
+		wantDDL          string
+		wantErr          bool
+	}{
+		{
+			name: "all timeouts",
+			content: `-- :user1:ns1:comment1
+--@ lock_timeout=5s
+--@ statement_timeout = 10m
+--@ idle_in_transaction_session_timeout=30s
+ALTER TABLE users ADD COLUMN name TEXT;`,
+			want: api.SessionSettings{
+				LockTimeout:                     "5s",
+				StatementTimeout:                "10m",
+				IdleInTransactionSessionTimeout: "30s",
+			},
+			wantDDL: "ALTER TABLE users ADD COLUMN name TEXT;",
+		},
+		{
+			name: "directive after DDL",
+			content: `-- :user1:ns1:comment1
+ALTER TABLE users ADD COLUMN name TEXT;
+--@ lock_timeout=500ms`,
+			want:    api.SessionSettings{LockTimeout: "500ms"},
+			wantDDL: "ALTER TABLE users ADD COLUMN name TEXT;",
+		},
+		{
+			name: "directive before header",
+			content: `--@ lock_timeout=5s
+-- :user1:ns1:comment1
+ALTER TABLE users ADD COLUMN name TEXT;`,
+			wantErr: true,
+		},
+		{
+			name: "unknown directive",
+			content: `-- :user1:ns1:comment1
+--@ work_mem=5s
+ALTER TABLE users ADD COLUMN name TEXT;`,
+			wantErr: true,
+		},
+		{
+			name: "invalid duration",
+			content: `-- :user1:ns1:comment1
+--@ lock_timeout=soon
+ALTER TABLE users ADD COLUMN name TEXT;`,
+			wantErr: true,
+		},
+		{
+			name: "negative duration",
+			content: `-- :user1:ns1:comment1
+--@ lock_timeout=-5s
+ALTER TABLE users ADD COLUMN name TEXT;`,
+			wantErr: true,
+		},
+		{
+			name: "missing value",
+			content: `-- :user1:ns1:comment1
+--@ lock_timeout
+ALTER TABLE users ADD COLUMN name TEXT;`,
+			wantErr: true,
+		},
+		{
+			name: "environments",
+			content: `-- :user1:*:comment1
+--@ environments=Dev, staging
+ALTER TABLE users ADD COLUMN name TEXT;`,
+			wantEnvironments: []string{"dev", "staging"},
+			wantDDL:          "ALTER TABLE users ADD COLUMN name TEXT;",
+		},
+		{
+			name: "wildcard namespace without environments",
+			content: `-- :user1:*:comment1
+ALTER TABLE users ADD COLUMN name TEXT;`,
+			wantErr: true,
+		},
+		{
+			name: "invalid environment",
+			content: `-- :user1:*:comment1
+--@ environments=dev,,prod
+ALTER TABLE users ADD COLUMN name TEXT;`,
+			wantErr: true,
+		},
+		{
+			name: "header with only directives",
+			content: `-- :user1:ns1:comment1
+--@ lock_timeout=5s
+-- :user2:ns2:comment2
+CREATE TABLE users;`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetMigrationFileParser().ParseSQL(tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseSQL() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != 1 {
+				t.Fatalf("ParseSQL() got %v migrations, want 1", len(got))
+			}
+			if got[0].Settings != tt.want {
+				t.Errorf("Settings = %+v, want %+v", got[0].Settings, tt.want)
+			}
+			if got[0].DDL != tt.wantDDL {
+				t.Errorf("DDL = %v, want %v", got[0].DDL, tt.wantDDL)
+			}
+			if !slices.Equal(got[0].Environments, tt.wantEnvironments) {
+				t.Errorf("Environments = %v, want %v", got[0].Environments, tt.wantEnvironments)
+			}
+		})
+	}
+}
+
 func TestGenerateSignature(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -212,6 +338,19 @@ func TestGenerateSignature(t *testing.T) {
 	}
 }
 
+func TestNamespaceSignature(t *testing.T) {
+	base := generateSignature("-- :user:*:comment")
+	dev := NamespaceSignature(base, "dev")
+	prod := NamespaceSignature(base, "prod")
+
+	if dev == prod || dev == base || prod == base {
+		t.Errorf("Expected distinct signatures, got base %d, dev %d, prod %d", base, dev, prod)
+	}
+	if NamespaceSignature(base, "dev") != dev {
+		t.Errorf("NamespaceSignature() not consistent")
+	}
+}
+
 func TestParseMigrationHeader(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -368,7 +507,7 @@ const (
 
 type errorFileFetcher struct{}
 
-func (f errorFileFetcher) FetchRawGitFile(metadata FileMetadata) (string, error) {
+func (f errorFileFetcher) FetchRawGitFile(_ context.Context, metadata FileMetadata) (string, error) {
 	return "", fmt.Errorf("error fetching file %s", metadata.Path)
 }
 
@@ -376,7 +515,7 @@ type mockFileFetcher struct {
 	content string
 }
 
-func (f mockFileFetcher) FetchRawGitFile(metadata FileMetadata) (string, error) {
+func (f mockFileFetcher) FetchRawGitFile(_ context.Context, metadata FileMetadata) (string, error) {
 	return f.content, nil
 }
 
@@ -433,7 +572,7 @@ func TestProcessFile(t *testing.T) {
 			p.fileFetcher = tc.fetcher
 			p.fileParser = tc.parser
 
-			migrations, err := p.ProcessFile(testRepoName, testPath, testCommit)
+			migrations, err := p.ProcessFile(context.Background(), testRepoName, testPath, testCommit)
 			if len(tc.wantErrMsg) != 0 && err == nil {
 				t.Errorf("Expected error %s, but no error was returned", tc.wantErrMsg)
 			}
@@ -452,3 +591,157 @@ func TestProcessFile(t *testing.T) {
 		})
 	}
 }
+
+// mapFileFetcher serves files from a map of path to content
+type mapFileFetcher struct {
+	files map[string]string
+}
+
+func (f mapFileFetcher) FetchRawGitFile(_ context.Context, metadata FileMetadata) (string, error) {
+	content, ok := f.files[metadata.Path]
+	if !ok {
+		return "", fmt.Errorf("file %s not found", metadata.Path)
+	}
+	return content, nil
+}
+
+func TestProcessFileIncludes(t *testing.T) {
+	testCases := []struct {
+		name         string
+		files        map[string]string
+		wantDDL      string
+		wantIncludes []string
+		wantErr      bool
+	}{
+		{
+			name: "relative and root includes",
+			files: map[string]string{
+				"db/migrations.sql":    "-- :user1:ns1:comment1\nCREATE TABLE users (id INT);\n--@ include=shared/grants.sql\n--@ include=/functions.sql",
+				"db/shared/grants.sql": "GRANT SELECT ON users TO reader;",
+				"functions.sql":        "CREATE FUNCTION one() RETURNS int AS $$ SELECT 1 $$ LANGUAGE sql;",
+			},
+			wantDDL:      "CREATE TABLE users (id INT);\nGRANT SELECT ON users TO reader;\nCREATE FUNCTION one() RETURNS int AS $$ SELECT 1 $$ LANGUAGE sql;",
+			wantIncludes: []string{"db/shared/grants.sql", "functions.sql"},
+		},
+		{
+			name: "nested includes",
+			files: map[string]string{
+				"db/migrations.sql": "-- :user1:ns1:comment1\n--@ include=a.sql",
+				"db/a.sql":          "SELECT 'a';\n--@ include=nested/b.sql",
+				"db/nested/b.sql":   "SELECT 'b';",
+			},
+			wantDDL:      "SELECT 'a';\nSELECT 'b';",
+			wantIncludes: []string{"db/a.sql", "db/nested/b.sql"},
+		},
+		{
+			name: "include cycle",
+			files: map[string]string{
+				"db/migrations.sql": "-- :user1:ns1:comment1\n--@ include=a.sql",
+				"db/a.sql":          "--@ include=b.sql",
+				"db/b.sql":          "--@ include=a.sql",
+			},
+			wantErr: true,
+		},
+		{
+			name: "include of the migration file itself",
+			files: map[string]string{
+				"db/migrations.sql": "-- :user1:ns1:comment1\n--@ include=migrations.sql",
+			},
+			wantErr: true,
+		},
+		{
+			name: "include depth exceeded",
+			files: map[string]string{
+				"db/migrations.sql": "-- :user1:ns1:comment1\n--@ include=1.sql",
+				"db/1.sql":          "--@ include=2.sql",
+				"db/2.sql":          "--@ include=3.sql",
+				"db/3.sql":          "--@ include=4.sql",
+				"db/4.sql":          "--@ include=5.sql",
+				"db/5.sql":          "--@ include=6.sql",
+				"db/6.sql":          "SELECT 1;",
+			},
+			wantErr: true,
+		},
+		{
+			name: "include escaping the repository",
+			files: map[string]string{
+				"db/migrations.sql": "-- :user1:ns1:comment1\n--@ include=../../etc/passwd",
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing include",
+			files: map[string]string{
+				"db/migrations.sql": "-- :user1:ns1:comment1\n--@ include=missing.sql",
+			},
+			wantErr: true,
+		},
+		{
+			name: "other directive in included file",
+			files: map[string]string{
+				"db/migrations.sql": "-- :user1:ns1:comment1\n--@ include=a.sql",
+				"db/a.sql":          "--@ lock_timeout=5s\nSELECT 1;",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := GetMigrationFileProcessor()
+			p.fileFetcher = mapFileFetcher{files: tc.files}
+
+			migrations, err := p.ProcessFile(context.Background(), testRepoName, "db/migrations.sql", testCommit)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ProcessFile() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+
+			if len(migrations) != 1 {
+				t.Fatalf("Expected 1 migration, got %d", len(migrations))
+			}
+			if migrations[0].DDL != tc.wantDDL {
+				t.Errorf("DDL = %q, want %q", migrations[0].DDL, tc.wantDDL)
+			}
+			if !slices.Equal(migrations[0].Includes, tc.wantIncludes) {
+				t.Errorf("Includes = %v, want %v", migrations[0].Includes, tc.wantIncludes)
+			}
+		})
+	}
+}
+
+func TestProcessFiles(t *testing.T) {
+	files := map[string]string{}
+	paths := make([]string, 0)
+	for i := range 20 {
+		path := fmt.Sprintf("db/%03d.sql", i)
+		files[path] = fmt.Sprintf("-- :dfryer:app:migration %d\nSELECT %d;", i, i)
+		paths = append(paths, path)
+	}
+
+	p := &MigrationFileProcessor{
+		fileFetcher: mapFileFetcher{files: files},
+		fileParser:  GetMigrationFileParser(),
+	}
+
+	migrations, err := p.ProcessFiles(context.Background(), testRepoName, paths, testCommit)
+	if err != nil {
+		t.Fatalf("ProcessFiles() error = %v", err)
+	}
+
+	if len(migrations) != len(paths) {
+		t.Fatalf("expected %d migrations, got %d", len(paths), len(migrations))
+	}
+	for i, migration := range migrations {
+		if expected := fmt.Sprintf("migration %d", i); migration.Comment != expected {
+			t.Errorf("migration %d: expected comment %q, got %q", i, expected, migration.Comment)
+		}
+	}
+
+	_, err = p.ProcessFiles(context.Background(), testRepoName, append(paths, "db/missing.sql"), testCommit)
+	if err == nil || !strings.Contains(err.Error(), "db/missing.sql") {
+		t.Errorf("expected error for missing file, got %v", err)
+	}
+}
@@ -1,13 +1,24 @@
 package utils
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/dfryer1193/gomad/api"
+	"golang.org/x/sync/singleflight"
 )
 
 type FileMetadata struct {
@@ -20,10 +31,39 @@ type minimalGitHubFileData struct {
 	Content  string `json:"content"`
 	Encoding string `json:"encoding"`
 	Size     int    `json:"size"`
+	SHA      string `json:"sha"`
 }
 
+const (
+	// maxFetchAttempts bounds how many times a request is sent when GitHub errors or rate limits us
+	maxFetchAttempts = 4
+	// defaultRetryBackoff is the wait before the first retry of a failed request, doubling with each retry
+	defaultRetryBackoff = 500 * time.Millisecond
+	// maxRateLimitWait is the longest we'll wait for a rate limit to reset before giving up
+	maxRateLimitWait = time.Minute
+)
+
+var (
+	ErrGitHubRateLimited  = errors.New("GitHub API rate limit exceeded")
+	ErrGitHubAccessDenied = errors.New("GitHub API access denied")
+	ErrGitHubNotFound     = errors.New("GitHub API resource not found")
+)
+
 type GitFileFetcher struct {
-	client *http.Client
+	client  *http.Client
+	tokens  GitHubTokenProvider
+	baseURL string
+
+	mu sync.Mutex
+	// caClients holds clients trusting custom CA bundles, keyed by the bundle's hash
+	caClients map[[sha256.Size]byte]*http.Client
+	// treeCache holds commit trees by API, repository and commit
+	treeCache map[string]*commitTree
+	trees     singleflight.Group
+
+	retryBackoff time.Duration
+	now          func() time.Time
+	sleep        func(ctx context.Context, d time.Duration) error
 }
 
 var (
@@ -33,54 +73,222 @@ var (
 
 func GetGitFileFetcher() *GitFileFetcher {
 	once.Do(func() {
-		fileFetcher = &GitFileFetcher{
-			client: &http.Client{
-				Timeout: 10 * time.Second,
-				Transport: &http.Transport{
-					MaxIdleConns:        10,
-					IdleConnTimeout:     30 * time.Second,
-					DisableCompression:  true,
-					MaxIdleConnsPerHost: 10,
-					DisableKeepAlives:   true,
-					ForceAttemptHTTP2:   true,
-				},
-			},
+		baseURL := os.Getenv("GITHUB_API_URL")
+		if baseURL == "" {
+			baseURL = defaultGitHubAPIURL
 		}
+
+		fileFetcher = newGitFileFetcher(baseURL, GetGitHubTokenProvider())
 	})
 
 	return fileFetcher
 }
 
-func (f *GitFileFetcher) FetchRawGitFile(metadata FileMetadata) (string, error) {
-	fetchUrl := fmt.Sprintf("https://api.github.com/repos/%s/contents/%s?ref=%s", metadata.RepoName, metadata.Path, metadata.Commit)
-	req, err := http.NewRequest("GET", fetchUrl, nil)
+func newGitFileFetcher(baseURL string, tokens GitHubTokenProvider) *GitFileFetcher {
+	return &GitFileFetcher{
+		client:       newGitHubClient(nil),
+		tokens:       tokens,
+		baseURL:      baseURL,
+		caClients:    make(map[[sha256.Size]byte]*http.Client),
+		treeCache:    make(map[string]*commitTree),
+		retryBackoff: defaultRetryBackoff,
+		now:          time.Now,
+		sleep:        sleepContext,
+	}
+}
+
+func newGitHubClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			MaxIdleConns:        10,
+			IdleConnTimeout:     30 * time.Second,
+			DisableCompression:  true,
+			MaxIdleConnsPerHost: 10,
+			DisableKeepAlives:   true,
+			ForceAttemptHTTP2:   true,
+			TLSClientConfig:     tlsConfig,
+		},
+	}
+}
+
+// BlobSHA looks up the SHA of a file's git blob from its commit's tree, without downloading the file. Trees are read
+// recursively and cached, since a commit's tree never changes. When a tree is too large for GitHub to return whole,
+// the file's own metadata is requested instead. Requests use the repository's API base URL, token and CA bundle when
+// it has them, so repositories on GitHub Enterprise Server can be read alongside ones on github.com. Server errors
+// are retried with exponential backoff and rate limits are waited out when they reset soon enough. Errors wrap
+// ErrGitHubRateLimited, ErrGitHubAccessDenied or ErrGitHubNotFound when GitHub refused the request.
+func (f *GitFileFetcher) BlobSHA(ctx context.Context, config *api.RepositoryConfig, metadata FileMetadata) (string, error) {
+	conn, err := f.connect(ctx, config, metadata.RepoName)
 	if err != nil {
-		return "", fmt.Errorf("failed to create file fetch request: %w", err)
+		return "", err
 	}
 
-	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
-		req.Header.Add("Authorization", fmt.Sprintf("token %s", token))
+	key := fmt.Sprintf("%s|%s@%s", conn.baseURL, metadata.RepoName, metadata.Commit)
+	result, err, _ := f.trees.Do(key, func() (any, error) {
+		if tree, ok := f.cachedTree(key); ok {
+			return tree, nil
+		}
+
+		treeUrl := fmt.Sprintf("%s/repos/%s/git/trees/%s?recursive=1", conn.baseURL, metadata.RepoName, url.PathEscape(metadata.Commit))
+		var response gitHubTree
+		if err := f.getJSON(ctx, conn.client, treeUrl, conn.token, &response); err != nil {
+			return nil, err
+		}
+
+		tree := &commitTree{blobs: make(map[string]string), truncated: response.Truncated}
+		for _, entry := range response.Tree {
+			if entry.Type == "blob" {
+				tree.blobs[entry.Path] = entry.SHA
+			}
+		}
+
+		f.cacheTree(key, tree)
+		return tree, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read tree of commit %s: %w", metadata.Commit, err)
+	}
+
+	tree := result.(*commitTree)
+	if sha, ok := tree.blobs[metadata.Path]; ok {
+		return sha, nil
+	}
+	if !tree.truncated {
+		return "", fmt.Errorf("%w: file %s at %s", ErrGitHubNotFound, metadata.Path, metadata.Commit)
+	}
+
+	// The contents API describes files of any size, though it only returns the content of those under 1MB
+	fileUrl := fmt.Sprintf("%s/repos/%s/contents/%s?ref=%s", conn.baseURL, metadata.RepoName, escapePath(metadata.Path), url.QueryEscape(metadata.Commit))
+	var file gitHubContentsEntry
+	if err := f.getJSON(ctx, conn.client, fileUrl, conn.token, &file); err != nil {
+		return "", fmt.Errorf("failed to look up file %s: %w", metadata.Path, err)
+	}
+	if file.Type != "file" {
+		return "", fmt.Errorf("%w: file %s at %s", ErrGitHubNotFound, metadata.Path, metadata.Commit)
+	}
+
+	return file.SHA, nil
+}
+
+// FetchBlob fetches the content of a git blob by its SHA. Unlike the contents API, the blob API serves files over
+// 1MB, so files of any size are read the same way.
+func (f *GitFileFetcher) FetchBlob(ctx context.Context, config *api.RepositoryConfig, sha string) (string, error) {
+	conn, err := f.connect(ctx, config, config.RepoName)
+	if err != nil {
+		return "", err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	content, err := f.fetchBlob(ctx, conn, config.RepoName, sha)
 	if err != nil {
-		return "", fmt.Errorf("failed to fetch file: %s %w", fetchUrl, err)
+		return "", fmt.Errorf("failed to fetch blob %s: %w", sha, err)
 	}
-	defer resp.Body.Close()
+	return content, nil
+}
+
+// gitHubTree is a commit's tree as returned by the git trees API. Truncated is set when the tree was too large to be
+// returned whole.
+type gitHubTree struct {
+	Tree []struct {
+		Path string `json:"path"`
+		SHA  string `json:"sha"`
+		Type string `json:"type"`
+	} `json:"tree"`
+	Truncated bool `json:"truncated"`
+}
+
+// gitHubContentsEntry describes a file or directory as returned by the contents API
+type gitHubContentsEntry struct {
+	SHA  string `json:"sha"`
+	Type string `json:"type"`
+}
+
+// commitTree holds the blob SHAs of a commit's files by path
+type commitTree struct {
+	blobs     map[string]string
+	truncated bool
+}
+
+// maxCachedTrees bounds the number of commit trees kept in memory
+const maxCachedTrees = 32
+
+func (f *GitFileFetcher) cachedTree(key string) (*commitTree, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tree, ok := f.treeCache[key]
+	return tree, ok
+}
+
+func (f *GitFileFetcher) cacheTree(key string, tree *commitTree) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-	if resp.StatusCode == http.StatusForbidden {
-		return "", fmt.Errorf("file too large to fetch: %s", fetchUrl)
+	if len(f.treeCache) >= maxCachedTrees {
+		clear(f.treeCache)
+	}
+	f.treeCache[key] = tree
+}
+
+// escapePath escapes each segment of a repository path for use in a URL
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// gitHubConnection is how requests for a repository reach the GitHub API
+type gitHubConnection struct {
+	client  *http.Client
+	baseURL string
+	token   string
+}
+
+func (f *GitFileFetcher) connect(ctx context.Context, config *api.RepositoryConfig, repoName string) (*gitHubConnection, error) {
+	baseURL := f.baseURL
+	if config.APIBaseURL != "" {
+		baseURL = config.APIBaseURL
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("GitHub API returned status %d for file: %s", resp.StatusCode, fetchUrl)
+	client, err := f.clientFor(config.CABundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client for %s: %w", repoName, err)
+	}
+
+	// The shared token and app credentials belong to the default API, so they're never sent to another host.
+	// Repositories elsewhere, such as on GitHub Enterprise Server, need a token of their own or are read anonymously.
+	token := config.Token
+	if token == "" && sameBaseURL(baseURL, f.baseURL) {
+		token, err = f.tokens.Token(ctx, repoName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get GitHub token for %s: %w", repoName, err)
+		}
 	}
 
-	var fileContent minimalGitHubFileData
-	if err := json.NewDecoder(resp.Body).Decode(&fileContent); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
+	return &gitHubConnection{
+		client:  client,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+	}, nil
+}
+
+func sameBaseURL(a string, b string) bool {
+	return strings.EqualFold(strings.TrimSuffix(a, "/"), strings.TrimSuffix(b, "/"))
+}
+
+func (f *GitFileFetcher) fetchBlob(ctx context.Context, conn *gitHubConnection, repoName string, sha string) (string, error) {
+	blobUrl := fmt.Sprintf("%s/repos/%s/git/blobs/%s", conn.baseURL, repoName, sha)
+	var blob minimalGitHubFileData
+	if err := f.getJSON(ctx, conn.client, blobUrl, conn.token, &blob); err != nil {
+		return "", err
 	}
 
+	return decodeGitHubContent(blob)
+}
+
+func decodeGitHubContent(fileContent minimalGitHubFileData) (string, error) {
 	if fileContent.Encoding != "base64" {
 		return "", fmt.Errorf("unsupported file encoding: %s", fileContent.Encoding)
 	}
@@ -92,3 +300,170 @@ func (f *GitFileFetcher) FetchRawGitFile(metadata FileMetadata) (string, error)
 
 	return string(decoded), nil
 }
+
+// getJSON sends a GET request to the GitHub API and decodes the response into out, retrying server errors, network
+// errors and short rate limits
+func (f *GitFileFetcher) getJSON(ctx context.Context, client *http.Client, requestUrl string, token string, out any) error {
+	backoff := f.retryBackoff
+
+	var lastErr error
+	for attempt := 1; attempt <= maxFetchAttempts; attempt++ {
+		wait, err := f.tryGetJSON(ctx, client, requestUrl, token, out)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+
+		var retryable *retryableError
+		if !errors.As(err, &retryable) || attempt == maxFetchAttempts {
+			break
+		}
+
+		if wait == 0 {
+			wait = backoff
+			backoff *= 2
+		}
+		if err := f.sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+
+	return lastErr
+}
+
+// retryableError marks a failed request that may succeed if sent again
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+// tryGetJSON sends a single request. Retryable failures are wrapped in a retryableError along with how long GitHub
+// asked us to wait, or zero to use the default backoff.
+func (f *GitFileFetcher) tryGetJSON(ctx context.Context, client *http.Client, requestUrl string, token string, out any) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestUrl, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if token != "" {
+		req.Header.Add("Authorization", fmt.Sprintf("token %s", token))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		// A server we don't trust won't become trustworthy by asking again
+		var certErr *tls.CertificateVerificationError
+		if errors.As(err, &certErr) {
+			return 0, fmt.Errorf("request to %s failed: %w", requestUrl, err)
+		}
+		return 0, &retryableError{fmt.Errorf("request to %s failed: %w", requestUrl, err)}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return 0, fmt.Errorf("failed to decode response: %w", err)
+		}
+		return 0, nil
+	case isRateLimited(resp):
+		wait, known := f.rateLimitWait(resp)
+		err := fmt.Errorf("%w: status %d for %s", ErrGitHubRateLimited, resp.StatusCode, requestUrl)
+		if !known || wait > maxRateLimitWait {
+			return 0, err
+		}
+		return wait, &retryableError{err}
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return 0, fmt.Errorf("%w: status %d for %s", ErrGitHubAccessDenied, resp.StatusCode, requestUrl)
+	case resp.StatusCode == http.StatusNotFound:
+		return 0, fmt.Errorf("%w: %s", ErrGitHubNotFound, requestUrl)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		wait, _ := f.rateLimitWait(resp)
+		return min(wait, maxRateLimitWait), &retryableError{fmt.Errorf("GitHub API returned status %d for %s", resp.StatusCode, requestUrl)}
+	default:
+		return 0, fmt.Errorf("GitHub API returned status %d for %s", resp.StatusCode, requestUrl)
+	}
+}
+
+// isRateLimited reports whether GitHub rejected a request for exceeding its primary or secondary rate limits, as
+// opposed to the token lacking permission
+func isRateLimited(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		return false
+	}
+	return resp.Header.Get("X-RateLimit-Remaining") == "0" || resp.Header.Get("Retry-After") != ""
+}
+
+// rateLimitWait returns how long GitHub asked us to wait from the Retry-After or X-RateLimit-Reset headers, and
+// false if neither was set
+func (f *GitFileFetcher) rateLimitWait(resp *http.Response) (time.Duration, bool) {
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if at, err := http.ParseTime(retryAfter); err == nil {
+			return max(at.Sub(f.now()), 0), true
+		}
+	}
+
+	if reset := resp.Header.Get("X-RateLimit-Reset"); reset != "" {
+		if epoch, err := strconv.ParseInt(reset, 10, 64); err == nil {
+			return max(time.Unix(epoch, 0).Sub(f.now()), 0), true
+		}
+	}
+
+	return 0, false
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// clientFor returns a client trusting the system roots plus the PEM encoded CA bundle, or the default client when
+// there is no bundle
+func (f *GitFileFetcher) clientFor(caBundle string) (*http.Client, error) {
+	if caBundle == "" {
+		return f.client, nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := sha256.Sum256([]byte(caBundle))
+	if client, ok := f.caClients[key]; ok {
+		return client, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM([]byte(caBundle)) {
+		return nil, fmt.Errorf("CA bundle contains no PEM certificates")
+	}
+
+	client := newGitHubClient(&tls.Config{RootCAs: pool})
+	f.caClients[key] = client
+	return client, nil
+}
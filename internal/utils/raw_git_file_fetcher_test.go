@@ -0,0 +1,440 @@
+package utils
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dfryer1193/gomad/api"
+)
+
+type staticTokens string
+
+func (t staticTokens) Token(context.Context, string) (string, error) {
+	return string(t), nil
+}
+
+// newFakeBlobAPI serves the GitHub blob API under /api/v3, recording the Authorization header it receives
+func newFakeBlobAPI(t *testing.T, blobs map[string]string, authorization *string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*authorization = r.Header.Get("Authorization")
+
+		sha, ok := strings.CutPrefix(r.URL.Path, "/api/v3/repos/acme/schema/git/blobs/")
+		content, found := blobs[sha]
+		if !ok || !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		json.NewEncoder(w).Encode(minimalGitHubFileData{
+			Content:  base64.StdEncoding.EncodeToString([]byte(content)),
+			Encoding: "base64",
+			Size:     len(content),
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestFetchBlob(t *testing.T) {
+	var authorization string
+	server := newFakeBlobAPI(t, map[string]string{"abc123": "SELECT 1;"}, &authorization)
+	caBundle := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	tests := []struct {
+		name          string
+		config        *api.RepositoryConfig
+		expected      string
+		authorization string
+		expectErr     bool
+	}{
+		{
+			name: "repository token and CA bundle",
+			config: &api.RepositoryConfig{
+				RepoName:   "acme/schema",
+				APIBaseURL: server.URL + "/api/v3/",
+				Token:      "repo-token",
+				CABundle:   caBundle,
+			},
+			expected:      "SELECT 1;",
+			authorization: "token repo-token",
+		},
+		{
+			name: "shared token isn't sent to another host",
+			config: &api.RepositoryConfig{
+				RepoName:   "acme/schema",
+				APIBaseURL: server.URL + "/api/v3",
+				CABundle:   caBundle,
+			},
+			expected:      "SELECT 1;",
+			authorization: "",
+		},
+		{
+			name: "untrusted certificate",
+			config: &api.RepositoryConfig{
+				RepoName:   "acme/schema",
+				APIBaseURL: server.URL + "/api/v3",
+			},
+			expectErr: true,
+		},
+		{
+			name: "invalid CA bundle",
+			config: &api.RepositoryConfig{
+				RepoName:   "acme/schema",
+				APIBaseURL: server.URL + "/api/v3",
+				CABundle:   "not a certificate",
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authorization = ""
+			fetcher := newGitFileFetcher(defaultGitHubAPIURL, staticTokens("default-token"))
+
+			content, err := fetcher.FetchBlob(context.Background(), tt.config, "abc123")
+			if tt.expectErr {
+				if err == nil {
+					t.Fatalf("expected error, got content %q", content)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if content != tt.expected {
+				t.Errorf("expected content %q, got %q", tt.expected, content)
+			}
+			if authorization != tt.authorization {
+				t.Errorf("expected authorization %q, got %q", tt.authorization, authorization)
+			}
+		})
+	}
+}
+
+func TestFetchBlobUsesDefaultBaseURL(t *testing.T) {
+	var authorization string
+	server := newFakeBlobAPI(t, map[string]string{"def456": "SELECT 2;"}, &authorization)
+
+	fetcher := newGitFileFetcher(server.URL+"/api/v3", staticTokens(""))
+	fetcher.client = server.Client()
+
+	content, err := fetcher.FetchBlob(context.Background(), &api.RepositoryConfig{RepoName: "acme/schema"}, "def456")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if content != "SELECT 2;" {
+		t.Errorf("expected content %q, got %q", "SELECT 2;", content)
+	}
+	if authorization != "" {
+		t.Errorf("expected no authorization header, got %q", authorization)
+	}
+}
+
+func TestFetchBlobSendsSharedTokenToDefaultBaseURL(t *testing.T) {
+	var authorization string
+	server := newFakeBlobAPI(t, map[string]string{"def456": "SELECT 2;"}, &authorization)
+
+	fetcher := newGitFileFetcher(server.URL+"/api/v3", staticTokens("default-token"))
+	fetcher.client = server.Client()
+
+	// A repository naming the default API explicitly is still served by the shared token
+	for _, config := range []*api.RepositoryConfig{
+		{RepoName: "acme/schema"},
+		{RepoName: "acme/schema", APIBaseURL: server.URL + "/api/v3/"},
+	} {
+		authorization = ""
+		if _, err := fetcher.FetchBlob(context.Background(), config, "def456"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if authorization != "token default-token" {
+			t.Errorf("expected the shared token for base URL %q, got %q", config.APIBaseURL, authorization)
+		}
+	}
+}
+
+// scriptedGitHub answers requests with a scripted sequence of responses, repeating the last one once exhausted
+type scriptedGitHub struct {
+	responses []func(w http.ResponseWriter, r *http.Request)
+	requests  atomic.Int32
+}
+
+func (s *scriptedGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := int(s.requests.Add(1))
+	s.responses[min(n, len(s.responses))-1](w, r)
+}
+
+func respondStatus(status int, headers map[string]string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for key, value := range headers {
+			w.Header().Set(key, value)
+		}
+		w.WriteHeader(status)
+	}
+}
+
+func respondContent(content string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(minimalGitHubFileData{
+			Content:  base64.StdEncoding.EncodeToString([]byte(content)),
+			Encoding: "base64",
+			Size:     len(content),
+		})
+	}
+}
+
+func TestFetchBlobRetries(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	config := &api.RepositoryConfig{RepoName: "acme/schema"}
+
+	tests := []struct {
+		name          string
+		responses     []func(w http.ResponseWriter, r *http.Request)
+		expected      string
+		expectedErr   error
+		expectedWaits []time.Duration
+	}{
+		{
+			name: "retries server errors with backoff",
+			responses: []func(w http.ResponseWriter, r *http.Request){
+				respondStatus(http.StatusBadGateway, nil),
+				respondStatus(http.StatusServiceUnavailable, nil),
+				respondContent("SELECT 1;"),
+			},
+			expected:      "SELECT 1;",
+			expectedWaits: []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name: "gives up after max attempts",
+			responses: []func(w http.ResponseWriter, r *http.Request){
+				respondStatus(http.StatusInternalServerError, nil),
+			},
+			expectedWaits: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+		},
+		{
+			name: "waits for Retry-After",
+			responses: []func(w http.ResponseWriter, r *http.Request){
+				respondStatus(http.StatusTooManyRequests, map[string]string{"Retry-After": "7"}),
+				respondContent("SELECT 1;"),
+			},
+			expected:      "SELECT 1;",
+			expectedWaits: []time.Duration{7 * time.Second},
+		},
+		{
+			name: "waits for rate limit reset",
+			responses: []func(w http.ResponseWriter, r *http.Request){
+				respondStatus(http.StatusForbidden, map[string]string{
+					"X-RateLimit-Remaining": "0",
+					"X-RateLimit-Reset":     strconv.FormatInt(now.Add(30*time.Second).Unix(), 10),
+				}),
+				respondContent("SELECT 1;"),
+			},
+			expected:      "SELECT 1;",
+			expectedWaits: []time.Duration{30 * time.Second},
+		},
+		{
+			name: "rate limit resetting too late",
+			responses: []func(w http.ResponseWriter, r *http.Request){
+				respondStatus(http.StatusForbidden, map[string]string{
+					"X-RateLimit-Remaining": "0",
+					"X-RateLimit-Reset":     strconv.FormatInt(now.Add(time.Hour).Unix(), 10),
+				}),
+			},
+			expectedErr: ErrGitHubRateLimited,
+		},
+		{
+			name: "permission denied is not retried",
+			responses: []func(w http.ResponseWriter, r *http.Request){
+				respondStatus(http.StatusForbidden, map[string]string{"X-RateLimit-Remaining": "4999"}),
+			},
+			expectedErr: ErrGitHubAccessDenied,
+		},
+		{
+			name: "missing file",
+			responses: []func(w http.ResponseWriter, r *http.Request){
+				respondStatus(http.StatusNotFound, nil),
+			},
+			expectedErr: ErrGitHubNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(&scriptedGitHub{responses: tt.responses})
+			defer server.Close()
+
+			var waits []time.Duration
+			fetcher := newGitFileFetcher(server.URL, staticTokens(""))
+			fetcher.retryBackoff = time.Second
+			fetcher.now = func() time.Time { return now }
+			fetcher.sleep = func(_ context.Context, d time.Duration) error {
+				waits = append(waits, d)
+				return nil
+			}
+
+			content, err := fetcher.FetchBlob(context.Background(), config, "abc123")
+			if tt.expectedErr != nil && !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tt.expectedErr, err)
+			}
+			if tt.expected == "" && err == nil {
+				t.Fatalf("expected error, got content %q", content)
+			}
+			if tt.expected != "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if content != tt.expected {
+				t.Errorf("expected content %q, got %q", tt.expected, content)
+			}
+			if !slices.Equal(waits, tt.expectedWaits) {
+				t.Errorf("expected waits %v, got %v", tt.expectedWaits, waits)
+			}
+		})
+	}
+}
+
+func TestFetchBlobDecodesLargeFiles(t *testing.T) {
+	// Larger than the 1MB the contents API will return inline
+	content := strings.Repeat("-- padding\n", 1024*1024/10) + "SELECT 1;"
+	encoded := base64.StdEncoding.EncodeToString([]byte(content))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/acme/schema/git/blobs/deadbeef", func(w http.ResponseWriter, r *http.Request) {
+		// The blob API wraps its base64 content across lines
+		var wrapped strings.Builder
+		for i := 0; i < len(encoded); i += 60 {
+			wrapped.WriteString(encoded[i:min(i+60, len(encoded))])
+			wrapped.WriteString("\n")
+		}
+		json.NewEncoder(w).Encode(minimalGitHubFileData{Content: wrapped.String(), Encoding: "base64", Size: len(content)})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	fetcher := newGitFileFetcher(server.URL, staticTokens(""))
+	fetched, err := fetcher.FetchBlob(context.Background(), &api.RepositoryConfig{RepoName: "acme/schema"}, "deadbeef")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fetched != content {
+		t.Errorf("expected %d bytes of content, got %d", len(content), len(fetched))
+	}
+}
+
+func TestFetchBlobStopsWhenCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(respondStatus(http.StatusServiceUnavailable, nil)))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fetcher := newGitFileFetcher(server.URL, staticTokens(""))
+	fetcher.retryBackoff = time.Hour
+	go cancel()
+
+	_, err := fetcher.FetchBlob(ctx, &api.RepositoryConfig{RepoName: "acme/schema"}, "abc123")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestBlobSHAReadsTreeOnce(t *testing.T) {
+	var trees atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/acme/schema/git/trees/main", func(w http.ResponseWriter, r *http.Request) {
+		trees.Add(1)
+		if r.URL.Query().Get("recursive") != "1" {
+			t.Errorf("expected a recursive tree request, got %s", r.URL.RawQuery)
+		}
+		w.Write([]byte(`{"tree": [
+			{"path": "db", "type": "tree", "sha": "ddd"},
+			{"path": "db/001.sql", "type": "blob", "sha": "aaa"},
+			{"path": "db/002.sql", "type": "blob", "sha": "bbb"},
+			{"path": "db/shared", "type": "tree", "sha": "ccc"},
+			{"path": "db/shared/001.sql", "type": "blob", "sha": "eee"}
+		], "truncated": false}`))
+	})
+	mux.HandleFunc("/repos/acme/schema/git/blobs/bbb", respondContent("SELECT 2;"))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	fetcher := newGitFileFetcher(server.URL, staticTokens(""))
+	config := &api.RepositoryConfig{RepoName: "acme/schema"}
+
+	for path, expected := range map[string]string{"db/001.sql": "aaa", "db/002.sql": "bbb", "db/shared/001.sql": "eee"} {
+		sha, err := fetcher.BlobSHA(context.Background(), config, FileMetadata{RepoName: "acme/schema", Path: path, Commit: "main"})
+		if err != nil {
+			t.Fatalf("BlobSHA(%s) error = %v", path, err)
+		}
+		if sha != expected {
+			t.Errorf("BlobSHA(%s) = %q, expected %q", path, sha, expected)
+		}
+	}
+
+	for _, path := range []string{"db/shared", "db/missing.sql"} {
+		_, err := fetcher.BlobSHA(context.Background(), config, FileMetadata{RepoName: "acme/schema", Path: path, Commit: "main"})
+		if !errors.Is(err, ErrGitHubNotFound) {
+			t.Errorf("expected ErrGitHubNotFound for %s, got %v", path, err)
+		}
+	}
+
+	if n := trees.Load(); n != 1 {
+		t.Errorf("expected the tree to be read once, got %d", n)
+	}
+
+	content, err := fetcher.FetchBlob(context.Background(), config, "bbb")
+	if err != nil {
+		t.Fatalf("FetchBlob() error = %v", err)
+	}
+	if content != "SELECT 2;" {
+		t.Errorf("FetchBlob() = %q", content)
+	}
+}
+
+func TestBlobSHAFallsBackForTruncatedTrees(t *testing.T) {
+	var requested string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/acme/schema/git/trees/main", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"tree": [{"path": "db/001.sql", "type": "blob", "sha": "aaa"}], "truncated": true}`))
+	})
+	mux.HandleFunc("/repos/acme/schema/contents/", func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.EscapedPath()
+		if r.URL.Query().Get("ref") != "main" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"type": "file", "sha": "fff"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	fetcher := newGitFileFetcher(server.URL, staticTokens(""))
+	config := &api.RepositoryConfig{RepoName: "acme/schema"}
+
+	// Files missing from a truncated tree are looked up on their own, with each segment of their path escaped
+	sha, err := fetcher.BlobSHA(context.Background(), config, FileMetadata{RepoName: "acme/schema", Path: "db/2024 q1/0999#hotfix?.sql", Commit: "main"})
+	if err != nil {
+		t.Fatalf("BlobSHA() error = %v", err)
+	}
+	if sha != "fff" {
+		t.Errorf("BlobSHA() = %q, expected fff", sha)
+	}
+	if requested != "/repos/acme/schema/contents/db/2024%20q1/0999%23hotfix%3F.sql" {
+		t.Errorf("requested %s", requested)
+	}
+}
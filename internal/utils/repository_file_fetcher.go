@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dfryer1193/gomad/api"
+	"github.com/dfryer1193/gomad/internal/data/repository"
+	"github.com/dfryer1193/gomad/internal/data/repository/storage"
+	"github.com/dfryer1193/gomad/internal/metrics"
+	"github.com/dfryer1193/gomad/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RepositoryFileFetcher fetches files using the source configured for each repository, defaulting to GitHub. File
+// contents are cached by blob SHA, so content that's already been seen is never downloaded again.
+type RepositoryFileFetcher struct {
+	configs repository.RepositoryConfigRepository
+	github  blobSource
+	mirror  *GitMirrorFetcher
+	cache   fileCache
+}
+
+// blobSource reads a repository's files by the SHA of their git blobs, using the repository's own connection settings
+type blobSource interface {
+	BlobSHA(ctx context.Context, config *api.RepositoryConfig, metadata FileMetadata) (string, error)
+	FetchBlob(ctx context.Context, config *api.RepositoryConfig, sha string) (string, error)
+}
+
+type fileCache interface {
+	Get(repoName string, sha string) (string, bool)
+	Put(repoName string, sha string, content string)
+	Stats() FileCacheStats
+}
+
+var (
+	repoFileFetcher     *RepositoryFileFetcher
+	repoFileFetcherOnce sync.Once
+)
+
+func GetRepositoryFileFetcher() *RepositoryFileFetcher {
+	repoFileFetcherOnce.Do(func() {
+		repoFileFetcher = &RepositoryFileFetcher{
+			configs: storage.GetRepositoryConfigRepository(),
+			github:  GetGitFileFetcher(),
+			cache:   NewDiskFileCache(fileCacheDir()),
+		}
+		repoFileFetcher.mirror = NewGitMirrorFetcher(mirrorBaseDir(), repoFileFetcher.remoteURL)
+	})
+
+	return repoFileFetcher
+}
+
+// FetchRawGitFile reads a file from its repository's source, recording how long the fetch took and whether it failed
+func (f *RepositoryFileFetcher) FetchRawGitFile(ctx context.Context, metadata FileMetadata) (string, error) {
+	config, err := f.config(ctx, metadata.RepoName)
+	if err != nil {
+		return "", err
+	}
+
+	source := api.FileSourceGitHub
+	if config.Source == api.FileSourceMirror {
+		source = api.FileSourceMirror
+	}
+
+	ctx, span := tracing.Start(ctx, "file.fetch", attribute.String("gomad.repo", metadata.RepoName),
+		attribute.String("gomad.path", metadata.Path), attribute.String("gomad.file_source", string(source)))
+	start := time.Now()
+	content, err := f.fetch(ctx, config, metadata)
+	metrics.FileFetchDuration.WithLabelValues(string(source)).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.FileFetchErrors.WithLabelValues(string(source)).Inc()
+	}
+	tracing.End(span, err)
+
+	return content, err
+}
+
+func (f *RepositoryFileFetcher) fetch(ctx context.Context, config *api.RepositoryConfig, metadata FileMetadata) (string, error) {
+	var source blobSource = f.github
+	if config.Source == api.FileSourceMirror {
+		source = f.mirror
+	}
+
+	sha, err := source.BlobSHA(ctx, config, metadata)
+	if err != nil {
+		return "", err
+	}
+
+	if content, ok := f.cache.Get(metadata.RepoName, sha); ok {
+		metrics.FileCacheHits.Inc()
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("gomad.cache_hit", true))
+		return content, nil
+	}
+	metrics.FileCacheMisses.Inc()
+
+	content, err := source.FetchBlob(ctx, config, sha)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch file %s: %w", metadata.Path, err)
+	}
+
+	f.cache.Put(metadata.RepoName, sha, content)
+	return content, nil
+}
+
+// CacheStats reports how often fetched files were found in the cache
+func (f *RepositoryFileFetcher) CacheStats() FileCacheStats {
+	return f.cache.Stats()
+}
+
+// ChangedFiles lists the files added or modified between two commits for repositories read from a local mirror.
+// It returns false for sources that can't diff, in which case callers should rely on the push event instead.
+func (f *RepositoryFileFetcher) ChangedFiles(ctx context.Context, repoName string, before string, after string) ([]string, bool, error) {
+	config, err := f.config(ctx, repoName)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if config.Source != api.FileSourceMirror {
+		return nil, false, nil
+	}
+
+	files, err := f.mirror.ChangedFiles(ctx, repoName, before, after)
+	if err != nil {
+		return nil, true, err
+	}
+	return files, true, nil
+}
+
+func (f *RepositoryFileFetcher) config(ctx context.Context, repoName string) (*api.RepositoryConfig, error) {
+	config, err := f.configs.GetConfig(ctx, repoName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch config for repository %s: %w", repoName, err)
+	}
+
+	if config == nil {
+		return &api.RepositoryConfig{RepoName: repoName, Source: api.FileSourceGitHub}, nil
+	}
+	return config, nil
+}
+
+func (f *RepositoryFileFetcher) remoteURL(ctx context.Context, repoName string) (string, error) {
+	config, err := f.config(ctx, repoName)
+	if err != nil {
+		return "", err
+	}
+
+	if config.RemoteURL == "" {
+		return DefaultRemoteURL(repoName), nil
+	}
+	return config.RemoteURL, nil
+}
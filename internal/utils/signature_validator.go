@@ -9,7 +9,7 @@ import (
 	"sync"
 
 	"github.com/dfryer1193/gomad/internal/data/repository"
-	"github.com/dfryer1193/gomad/internal/data/repository/postgres"
+	"github.com/dfryer1193/gomad/internal/data/repository/storage"
 )
 
 type SignatureValidator interface {
@@ -28,7 +28,7 @@ var (
 func NewSignatureValidator() *signatureValidator {
 	signatureOnce.Do(func() {
 		validator = &signatureValidator{
-			secretsRepo: postgres.GetSecretsRepository(),
+			secretsRepo: storage.GetSecretsRepository(),
 		}
 	})
 
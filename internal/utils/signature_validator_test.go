@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
@@ -13,11 +14,11 @@ import (
 type mockSecretRepository struct {
 }
 
-func (m *mockSecretRepository) GetSecret(repoName string) (string, error) {
+func (m *mockSecretRepository) GetSecret(_ context.Context, repoName string) (string, error) {
 	return "test-secret", nil
 }
 
-func (m *mockSecretRepository) InsertSecret(repoName string, secret string) (string, error) {
+func (m *mockSecretRepository) InsertSecret(_ context.Context, repoName string, secret string) (string, error) {
 	return "", nil
 }
 
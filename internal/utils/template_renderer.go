@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	templateVariablePattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+	variableNamePattern     = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// UnresolvedVariablesError lists the template variables used in DDL that have no value
+type UnresolvedVariablesError struct {
+	Names []string
+}
+
+func (e *UnresolvedVariablesError) Error() string {
+	return fmt.Sprintf("unresolved template variables: %s", strings.Join(e.Names, ", "))
+}
+
+// TemplateVariables returns the distinct variable names referenced as {{ name }} in the DDL, sorted
+func TemplateVariables(ddl string) []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	for _, match := range templateVariablePattern.FindAllStringSubmatch(ddl, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			names = append(names, match[1])
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// CheckTemplateVariables returns an *UnresolvedVariablesError if the DDL references variables missing from vars
+func CheckTemplateVariables(ddl string, vars map[string]string) error {
+	var missing []string
+	for _, name := range TemplateVariables(ddl) {
+		if _, ok := vars[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return &UnresolvedVariablesError{Names: missing}
+	}
+	return nil
+}
+
+// RenderTemplate replaces each {{ name }} in the DDL with its value from vars. Values are substituted verbatim, so
+// they must already be valid SQL at the point they're used.
+func RenderTemplate(ddl string, vars map[string]string) (string, error) {
+	if err := CheckTemplateVariables(ddl, vars); err != nil {
+		return "", err
+	}
+
+	return templateVariablePattern.ReplaceAllStringFunc(ddl, func(match string) string {
+		return vars[templateVariablePattern.FindStringSubmatch(match)[1]]
+	}), nil
+}
+
+// ValidateVariableName checks that name can be referenced from a template
+func ValidateVariableName(name string) error {
+	if !variableNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid variable name %q: must start with a letter or underscore and contain only letters, digits and underscores", name)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	tests := []struct {
+		name        string
+		ddl         string
+		vars        map[string]string
+		want        string
+		wantMissing []string
+	}{
+		{
+			name: "no variables",
+			ddl:  "CREATE TABLE users (id INT);",
+			want: "CREATE TABLE users (id INT);",
+		},
+		{
+			name: "variables with and without spaces",
+			ddl:  "GRANT SELECT ON {{schema}}.users TO {{ role }};",
+			vars: map[string]string{"schema": "app", "role": "reader"},
+			want: "GRANT SELECT ON app.users TO reader;",
+		},
+		{
+			name: "repeated variable",
+			ddl:  "CREATE SCHEMA {{ schema }}; SET search_path TO {{ schema }};",
+			vars: map[string]string{"schema": "app"},
+			want: "CREATE SCHEMA app; SET search_path TO app;",
+		},
+		{
+			name:        "unresolved variables",
+			ddl:         "CREATE TABLE t (id INT) TABLESPACE {{ tablespace }}; GRANT ALL ON t TO {{ role }};",
+			vars:        map[string]string{},
+			wantMissing: []string{"role", "tablespace"},
+		},
+		{
+			name: "not a variable",
+			ddl:  "SELECT '{{ 1 }}';",
+			want: "SELECT '{{ 1 }}';",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RenderTemplate(tt.ddl, tt.vars)
+			if tt.wantMissing != nil {
+				var unresolved *UnresolvedVariablesError
+				if !errors.As(err, &unresolved) {
+					t.Fatalf("RenderTemplate() error = %v, want unresolved variables", err)
+				}
+				if !reflect.DeepEqual(unresolved.Names, tt.wantMissing) {
+					t.Errorf("unresolved = %v, want %v", unresolved.Names, tt.wantMissing)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RenderTemplate() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("RenderTemplate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateVariableName(t *testing.T) {
+	for _, name := range []string{"role", "_schema", "table_space2"} {
+		if err := ValidateVariableName(name); err != nil {
+			t.Errorf("ValidateVariableName(%q) error = %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "2fast", "has-dash", "has space"} {
+		if err := ValidateVariableName(name); err == nil {
+			t.Errorf("ValidateVariableName(%q) expected error", name)
+		}
+	}
+}